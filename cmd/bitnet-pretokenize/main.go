@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"bitnet-go/internal/dataset"
+	"bitnet-go/pkg/bitnet"
+)
+
+// batchLines is how many input lines a worker tokenizes at a time.
+const batchLines = 256
+
+// batch is a run of consecutive input lines and, once tokenized, their
+// documents. Batches are numbered so shards come out in input order
+// whatever the worker count.
+type batch struct {
+	seq   int
+	file  string
+	line  int
+	jsonl bool
+	lines []string
+	docs  [][]int32
+	err   error
+}
+
+func main() {
+	var (
+		modelPath    = flag.String("model", "", "Path to GGUF model, tokenizer.json or SentencePiece .model")
+		input        = flag.String("input", "", "Comma-separated corpus files, read in order")
+		format       = flag.String("format", "auto", "Input format: text (one document per line), jsonl, or auto (jsonl for .jsonl/.json files)")
+		field        = flag.String("field", "text", "JSONL field holding the document text")
+		outDir       = flag.String("output", "", "Output directory for shards and manifest.json")
+		shardTokens  = flag.Int("shard-tokens", 100_000_000, "Tokens per shard; documents are never split across shards")
+		dtype        = flag.String("dtype", "auto", "Token type: uint16, uint32, or auto (uint16 when the vocabulary fits)")
+		workers      = flag.Int("workers", runtime.NumCPU(), "Tokenizer goroutines")
+		bos          = flag.String("bos", "auto", "Prepend BOS to each document: auto (model default), on, off")
+		eos          = flag.String("eos", "auto", "Append EOS to each document: auto (model default), on, off")
+		parseSpecial = flag.Bool("parse-special", false, "Map special-token text in the corpus to its ID")
+	)
+	flag.Parse()
+
+	if *modelPath == "" || *input == "" || *outDir == "" {
+		fmt.Fprintln(os.Stderr, "missing required --model, --input or --output")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *shardTokens <= 0 || *workers <= 0 {
+		log.Fatal("--shard-tokens and --workers must be positive")
+	}
+
+	tok, err := bitnet.LoadTokenizer(*modelPath)
+	if err != nil {
+		log.Fatalf("init tokenizer: %v", err)
+	}
+	tokSum, err := dataset.FileSHA256(*modelPath)
+	if err != nil {
+		log.Fatalf("hash tokenizer: %v", err)
+	}
+	opts := bitnet.TokenizeOptions{
+		AddBOS:       parseToggle("bos", *bos),
+		AddEOS:       parseToggle("eos", *eos),
+		ParseSpecial: *parseSpecial,
+	}
+	if opts.AddBOS != nil && *opts.AddBOS && tok.BOS() < 0 {
+		log.Fatalf("--bos on: %s has no BOS token; use --bos auto or off", *modelPath)
+	}
+	if opts.AddEOS != nil && *opts.AddEOS && tok.EOS() < 0 {
+		log.Fatalf("--eos on: %s has no EOS token; use --eos auto or off", *modelPath)
+	}
+
+	width := dataset.WidthFor(tok.VocabSize())
+	switch *dtype {
+	case "auto":
+	case "uint16":
+		if width != 2 {
+			log.Fatalf("vocabulary of %d tokens does not fit uint16", tok.VocabSize())
+		}
+	case "uint32":
+		width = 4
+	default:
+		log.Fatalf("invalid --dtype %q (want auto, uint16 or uint32)", *dtype)
+	}
+
+	inputs := splitList(*input)
+	formats := make([]bool, len(inputs))
+	for i, path := range inputs {
+		switch *format {
+		case "auto":
+			ext := strings.ToLower(filepath.Ext(path))
+			formats[i] = ext == ".jsonl" || ext == ".json"
+		case "text", "jsonl":
+			formats[i] = *format == "jsonl"
+		default:
+			log.Fatalf("invalid --format %q (want auto, text or jsonl)", *format)
+		}
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		log.Fatalf("create output dir: %v", err)
+	}
+
+	jobs := make(chan *batch)
+	results := make(chan *batch)
+	// inFlight bounds the batches read but not yet written.
+	inFlight := make(chan struct{}, 2**workers)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				b.docs, b.err = tokenizeBatch(tok, opts, b, *field)
+				results <- b
+			}
+		}()
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		seq := 0
+		for i, path := range inputs {
+			err := readBatches(path, formats[i], func(b *batch) {
+				inFlight <- struct{}{}
+				b.seq = seq
+				seq++
+				jobs <- b
+			})
+			if err != nil {
+				readErr <- fmt.Errorf("read %s: %w", path, err)
+				return
+			}
+		}
+		readErr <- nil
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	w := &shardSet{dir: *outDir, width: width, limit: uint64(*shardTokens)}
+	pending := make(map[int]*batch)
+	next := 0
+	for b := range results {
+		pending[b.seq] = b
+		for b, ok := pending[next]; ok; b, ok = pending[next] {
+			delete(pending, next)
+			next++
+			<-inFlight
+			if b.err != nil {
+				log.Fatal(b.err)
+			}
+			for _, doc := range b.docs {
+				if err := w.add(doc); err != nil {
+					log.Fatalf("write shard: %v", err)
+				}
+			}
+		}
+	}
+	if err := <-readErr; err != nil {
+		log.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		log.Fatalf("write shard: %v", err)
+	}
+
+	m := dataset.NewManifest(*modelPath, tokSum, tok.VocabSize(), width, w.shards)
+	manifestPath := filepath.Join(*outDir, "manifest.json")
+	if err := dataset.WriteManifest(manifestPath, m); err != nil {
+		log.Fatalf("write manifest: %v", err)
+	}
+	fmt.Printf("manifest=%s shards=%d documents=%d tokens=%d dtype=uint%d\n", manifestPath, len(m.Shards), m.Documents, m.Tokens, 8*width)
+}
+
+// readBatches splits the file at path into batches of lines.
+func readBatches(path string, jsonl bool, emit func(*batch)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 256<<20)
+	b := &batch{file: path, line: 1, jsonl: jsonl}
+	line := 0
+	for sc.Scan() {
+		line++
+		b.lines = append(b.lines, sc.Text())
+		if len(b.lines) == batchLines {
+			emit(b)
+			b = &batch{file: path, line: line + 1, jsonl: jsonl}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(b.lines) > 0 {
+		emit(b)
+	}
+	return nil
+}
+
+// tokenizeBatch encodes each non-empty document of b.
+func tokenizeBatch(tok *bitnet.Tokenizer, opts bitnet.TokenizeOptions, b *batch, field string) ([][]int32, error) {
+	docs := make([][]int32, 0, len(b.lines))
+	for i, line := range b.lines {
+		text := line
+		if b.jsonl {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(line), &obj); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", b.file, b.line+i, err)
+			}
+			raw, ok := obj[field]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: missing field %q", b.file, b.line+i, field)
+			}
+			if err := json.Unmarshal(raw, &text); err != nil {
+				return nil, fmt.Errorf("%s:%d: field %q: %v", b.file, b.line+i, field, err)
+			}
+		}
+		if text == "" {
+			continue
+		}
+		docs = append(docs, tok.Tokenize(text, opts))
+	}
+	return docs, nil
+}
+
+// shardSet writes documents to numbered shards, starting a new shard when
+// the next document would push the current one past limit tokens.
+type shardSet struct {
+	dir    string
+	width  int
+	limit  uint64
+	cur    *dataset.ShardWriter
+	shards []dataset.ShardInfo
+}
+
+func (s *shardSet) add(doc []int32) error {
+	if s.cur != nil && s.cur.Tokens() > 0 && s.cur.Tokens()+uint64(len(doc)) > s.limit {
+		if err := s.close(); err != nil {
+			return err
+		}
+	}
+	if s.cur == nil {
+		name := fmt.Sprintf("shard-%05d.bin", len(s.shards))
+		sw, err := dataset.CreateShard(filepath.Join(s.dir, name), s.width)
+		if err != nil {
+			return err
+		}
+		s.cur = sw
+	}
+	return s.cur.WriteDocument(doc)
+}
+
+func (s *shardSet) close() error {
+	if s.cur == nil {
+		return nil
+	}
+	info, err := s.cur.Close()
+	s.cur = nil
+	if err != nil {
+		return err
+	}
+	s.shards = append(s.shards, info)
+	fmt.Printf("%s tokens=%d documents=%d sha256=%s\n", info.File, info.Tokens, info.Documents, info.SHA256)
+	return nil
+}
+
+func parseToggle(name, v string) *bool {
+	switch v {
+	case "auto":
+		return nil
+	case "on", "off":
+		on := v == "on"
+		return &on
+	default:
+		log.Fatalf("invalid --%s %q (want auto, on or off)", name, v)
+		return nil
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
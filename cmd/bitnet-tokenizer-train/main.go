@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"bitnet-go/internal/gguf"
+	"bitnet-go/internal/tokenizer"
+)
+
+func main() {
+	var (
+		input        = flag.String("input", "", "Comma-separated corpus text files; each line is one training text")
+		output       = flag.String("output", "", "Path of the vocabulary-only GGUF to write")
+		modelType    = flag.String("type", "spm", "Vocabulary type: spm (SentencePiece-style BPE with byte fallback) or bpe (byte-level BPE)")
+		vocabSize    = flag.Int("vocab-size", 32000, "Target vocabulary size, special and byte tokens included")
+		minFrequency = flag.Int("min-frequency", 2, "Fewest occurrences a pair needs to be merged")
+		pre          = flag.String("pre", "llama3", "tokenizer.ggml.pre that splits text for --type bpe")
+		special      = flag.String("special", "", "Comma-separated extra control tokens, e.g. <|eot_id|>")
+		bos          = flag.String("bos", "", "BOS token text (default <s> for spm, <|endoftext|> for bpe)")
+		eos          = flag.String("eos", "", "EOS token text (default </s> for spm, <|endoftext|> for bpe)")
+		unk          = flag.String("unk", "", "Unknown token text for spm (default <unk>)")
+	)
+	flag.Parse()
+
+	if *input == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "missing required --input or --output")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := tokenizer.TrainOptions{
+		VocabSize:     *vocabSize,
+		MinFrequency:  *minFrequency,
+		BOS:           *bos,
+		EOS:           *eos,
+		UNK:           *unk,
+		SpecialTokens: splitList(*special),
+	}
+	switch *modelType {
+	case "spm":
+		opts.Model = "llama"
+	case "bpe":
+		opts.Model = "gpt2"
+		opts.Pre = *pre
+	default:
+		log.Fatalf("unknown --type %q (want spm or bpe)", *modelType)
+	}
+	trainer, err := tokenizer.NewTrainer(opts)
+	if err != nil {
+		log.Fatalf("new trainer: %v", err)
+	}
+
+	lines := 0
+	for _, path := range splitList(*input) {
+		n, err := addFile(trainer, path)
+		if err != nil {
+			log.Fatalf("read corpus %s: %v", path, err)
+		}
+		lines += n
+	}
+
+	info, err := trainer.ModelInfo()
+	if err != nil {
+		log.Fatalf("train: %v", err)
+	}
+	// Check the vocabulary loads before writing it.
+	if _, err := tokenizer.NewFromModelInfo(info); err != nil {
+		log.Fatalf("load trained vocabulary: %v", err)
+	}
+	if err := gguf.WriteKeyValues(*output, info.KeyValues); err != nil {
+		log.Fatalf("write %s: %v", *output, err)
+	}
+	tokens := info.KeyValues["tokenizer.ggml.tokens"].([]string)
+	merges, _ := info.KeyValues["tokenizer.ggml.merges"].([]string)
+	fmt.Printf("output=%s type=%s lines=%d tokens=%d merges=%d\n", *output, *modelType, lines, len(tokens), len(merges))
+	if len(tokens) < *vocabSize {
+		fmt.Fprintf(os.Stderr, "warning: corpus only supports %d of the %d requested tokens\n", len(tokens), *vocabSize)
+	}
+}
+
+func addFile(trainer *tokenizer.Trainer, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 64<<20)
+	n := 0
+	for sc.Scan() {
+		trainer.Add(sc.Text())
+		n++
+	}
+	return n, sc.Err()
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"bitnet-go/pkg/bitnet"
+)
+
+// refStages maps ggml tensor names printed by scripts/ref_trace.cpp to the Go
+// trace stages. Layered names carry a "-<layer>" suffix; ref_trace labels
+// the rotated Q/K "Qcur_rope"/"Kcur_rope".
+var refStages = map[string]bitnet.TraceStage{
+	"inp_embd":      bitnet.TraceEmbed,
+	"attn_norm":     bitnet.TraceAttnNorm,
+	"Qcur":          bitnet.TraceQ,
+	"Kcur":          bitnet.TraceK,
+	"Vcur":          bitnet.TraceV,
+	"Qcur_rope":     bitnet.TraceQRoPE,
+	"Kcur_rope":     bitnet.TraceKRoPE,
+	"attn_o_out":    bitnet.TraceAttnOut,
+	"ffn_norm":      bitnet.TraceFFNNorm,
+	"ffn_gate":      bitnet.TraceFFNGate,
+	"ffn_up":        bitnet.TraceFFNUp,
+	"ffn_down":      bitnet.TraceFFNDown,
+	"result_norm":   bitnet.TraceOutputNorm,
+	"result_output": bitnet.TraceLogits,
+}
+
+// stageOrder is the order stages run within a layer, used to find the first
+// divergence.
+var stageOrder = map[bitnet.TraceStage]int{
+	bitnet.TraceEmbed:      0,
+	bitnet.TraceAttnNorm:   1,
+	bitnet.TraceQ:          2,
+	bitnet.TraceK:          3,
+	bitnet.TraceV:          4,
+	bitnet.TraceQRoPE:      5,
+	bitnet.TraceKRoPE:      6,
+	bitnet.TraceAttnOut:    7,
+	bitnet.TraceFFNNorm:    8,
+	bitnet.TraceFFNGate:    9,
+	bitnet.TraceFFNUp:      10,
+	bitnet.TraceFFNDown:    11,
+	bitnet.TraceOutputNorm: 12,
+	bitnet.TraceLogits:     13,
+}
+
+type stageKey struct {
+	stage bitnet.TraceStage
+	layer int
+}
+
+type diffRow struct {
+	Stage     bitnet.TraceStage `json:"stage"`
+	Layer     int               `json:"layer"`
+	N         int               `json:"n"`
+	RefN      int               `json:"ref_n"`
+	GoN       int               `json:"go_n"`
+	L2        float64           `json:"l2"`
+	MaxAbs    float64           `json:"max_abs"`
+	MaxAbsIdx int               `json:"max_abs_idx"`
+	Cosine    float64           `json:"cosine"`
+	Missing   bool              `json:"missing,omitempty"`
+	Diverged  bool              `json:"diverged"`
+}
+
+type diffReport struct {
+	Pos           int       `json:"pos"`
+	Tol           float64   `json:"tol"`
+	Rows          []diffRow `json:"rows"`
+	FirstDiverged *diffRow  `json:"first_divergent"`
+}
+
+func main() {
+	var (
+		modelPath  = flag.String("model", "", "Path to GGUF model (runs the Go model with tracing)")
+		prompt     = flag.String("prompt", "", "Prompt text")
+		promptFile = flag.String("prompt-file", "", "Path to prompt file (overrides --prompt)")
+		step       = flag.Int("step", 0, "Generation step to trace (0 = last prompt token)")
+		pos        = flag.Int("pos", -1, "Token position to compare (-1 = position of --step)")
+		seed       = flag.Int64("seed", 1, "Seed for generation")
+		preset     = flag.String("preset", "", "Runtime options preset (default: BITNET_* env)")
+		goTrace    = flag.String("go-trace", "", "Compare this JSONL trace instead of running --model")
+		refPath    = flag.String("ref", "", "Reference trace: ref_trace.cpp output (DEBUG_VALUES lines) or JSONL")
+		tol        = flag.Float64("tol", 1e-3, "Max-abs difference above which a stage counts as divergent")
+		jsonOut    = flag.Bool("json", false, "Print the report as JSON")
+	)
+	flag.Parse()
+
+	if *refPath == "" || (*modelPath == "" && *goTrace == "") {
+		fmt.Fprintln(os.Stderr, "missing required --ref and one of --model or --go-trace")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var goEvents []bitnet.TraceEvent
+	var err error
+	if *goTrace != "" {
+		goEvents, err = readTraceFile(*goTrace, -1)
+	} else {
+		text := *prompt
+		if *promptFile != "" {
+			b, rerr := os.ReadFile(*promptFile)
+			if rerr != nil {
+				log.Fatalf("read prompt: %v", rerr)
+			}
+			text = strings.TrimSpace(string(b))
+		}
+		goEvents, err = runGoTrace(*modelPath, text, *seed, *step, *preset)
+	}
+	if err != nil {
+		log.Fatalf("go trace: %v", err)
+	}
+	if len(goEvents) == 0 {
+		log.Fatal("go trace is empty")
+	}
+
+	target := *pos
+	if target < 0 {
+		// Generating step+1 tokens feeds the step's input last, so its
+		// position is the highest one traced.
+		for _, ev := range goEvents {
+			if ev.Pos > target {
+				target = ev.Pos
+			}
+		}
+	}
+
+	refEvents, err := readTraceFile(*refPath, target)
+	if err != nil {
+		log.Fatalf("reference trace: %v", err)
+	}
+	if len(refEvents) == 0 {
+		log.Fatalf("reference trace %s has no values (set BITNET_REF_DEBUG=1 BITNET_REF_DEBUG_VALUES=1)", *refPath)
+	}
+
+	report := diffTraces(refEvents, goEvents, target, *tol)
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatalf("encode report: %v", err)
+		}
+		return
+	}
+	printTable(os.Stdout, report)
+}
+
+func runGoTrace(modelPath, prompt string, seed int64, step int, preset string) ([]bitnet.TraceEvent, error) {
+	opts := bitnet.DefaultRuntimeOptions()
+	if preset != "" {
+		o, err := bitnet.PresetRuntimeOptions(preset)
+		if err != nil {
+			return nil, err
+		}
+		opts = o
+	}
+	rec := &bitnet.TraceRecorder{}
+	opts.Tracer = rec
+	session, err := bitnet.LoadModelWithOptions(context.Background(), modelPath, bitnet.LoadOptions{Runtime: &opts})
+	if err != nil {
+		return nil, fmt.Errorf("load model: %w", err)
+	}
+	if _, err := session.Generate(context.Background(), bitnet.GenerateRequest{
+		Prompt:             prompt,
+		Seed:               seed,
+		MaxTokens:          step + 1,
+		DisableTopKCapture: true,
+	}); err != nil {
+		return nil, fmt.Errorf("generate: %w", err)
+	}
+	return rec.Events(), nil
+}
+
+// readTraceFile loads a JSONL trace or a ref_trace.cpp log. ref_trace output
+// carries no positions, so its events are assigned pos; run it with
+// BITNET_REF_DEBUG_POS=<pos> and BITNET_REF_TOKEN_BY_TOKEN=1 so each tensor
+// holds exactly that token.
+func readTraceFile(path string, pos int) ([]bitnet.TraceEvent, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		return bitnet.ReadTraceJSONL(bytes.NewReader(b))
+	}
+	return parseRefTrace(bytes.NewReader(b), pos)
+}
+
+func parseRefTrace(r io.Reader, pos int) ([]bitnet.TraceEvent, error) {
+	var events []bitnet.TraceEvent
+	seen := map[stageKey]bool{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 1<<20), 1<<28)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "DEBUG_VALUES ") {
+			continue
+		}
+		var name, values string
+		for _, field := range strings.Fields(line)[1:] {
+			if v, ok := strings.CutPrefix(field, "name="); ok {
+				name = v
+			} else if v, ok := strings.CutPrefix(field, "values="); ok {
+				values = v
+			}
+		}
+		stage, layer, ok := refStage(name)
+		if !ok {
+			continue
+		}
+		key := stageKey{stage, layer}
+		if seen[key] {
+			// The reference prints each tensor once per decode; keep the
+			// first, which is the requested position under DEBUG_POS.
+			continue
+		}
+		seen[key] = true
+		vals, err := parseValues(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		events = append(events, bitnet.TraceEvent{Stage: stage, Layer: layer, Pos: pos, Values: vals})
+	}
+	return events, sc.Err()
+}
+
+func refStage(name string) (bitnet.TraceStage, int, bool) {
+	if stage, ok := refStages[name]; ok {
+		return stage, -1, true
+	}
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 {
+		return "", 0, false
+	}
+	layer, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	stage, ok := refStages[name[:i]]
+	return stage, layer, ok
+}
+
+func parseValues(s string) ([]float32, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]float32, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return nil, fmt.Errorf("idx=%d parse %q: %w", i, p, err)
+		}
+		out[i] = float32(v)
+	}
+	return out, nil
+}
+
+func diffTraces(ref, got []bitnet.TraceEvent, pos int, tol float64) diffReport {
+	goByKey := map[stageKey][]float32{}
+	for _, ev := range got {
+		if ev.Pos != pos {
+			continue
+		}
+		key := stageKey{ev.Stage, ev.Layer}
+		if _, dup := goByKey[key]; !dup {
+			goByKey[key] = ev.Values
+		}
+	}
+
+	report := diffReport{Pos: pos, Tol: tol}
+	for _, ev := range ref {
+		if ev.Pos != pos {
+			continue
+		}
+		row := diffRow{Stage: ev.Stage, Layer: ev.Layer, RefN: len(ev.Values)}
+		goVals, ok := goByKey[stageKey{ev.Stage, ev.Layer}]
+		if !ok {
+			row.Missing = true
+		} else {
+			compareValues(&row, ev.Values, goVals)
+			row.Diverged = row.MaxAbs > tol || math.IsNaN(row.MaxAbs)
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		return forwardRank(report.Rows[i]) < forwardRank(report.Rows[j])
+	})
+	for i := range report.Rows {
+		if report.Rows[i].Diverged {
+			report.FirstDiverged = &report.Rows[i]
+			break
+		}
+	}
+	return report
+}
+
+// forwardRank orders rows by when the forward pass produces them: embed,
+// then each layer's stages, then the output head.
+func forwardRank(r diffRow) int {
+	layer := r.Layer
+	switch {
+	case r.Stage == bitnet.TraceEmbed:
+		layer = -1
+	case r.Layer < 0:
+		layer = 1 << 20
+	}
+	return (layer+1)*64 + stageOrder[r.Stage]
+}
+
+// compareValues diffs the common prefix; the reference usually prints only
+// the first BITNET_REF_DEBUG_VALUES_N values.
+func compareValues(row *diffRow, ref, got []float32) {
+	row.GoN = len(got)
+	n := len(ref)
+	if len(got) < n {
+		n = len(got)
+	}
+	row.N = n
+	var sumSq, dot, refSq, goSq float64
+	for i := 0; i < n; i++ {
+		a, b := float64(ref[i]), float64(got[i])
+		d := math.Abs(a - b)
+		if d > row.MaxAbs || math.IsNaN(d) {
+			row.MaxAbs = d
+			row.MaxAbsIdx = i
+		}
+		sumSq += d * d
+		dot += a * b
+		refSq += a * a
+		goSq += b * b
+	}
+	row.L2 = math.Sqrt(sumSq)
+	switch {
+	case refSq == 0 && goSq == 0:
+		row.Cosine = 1
+	case refSq == 0 || goSq == 0:
+		row.Cosine = 0
+	default:
+		row.Cosine = dot / math.Sqrt(refSq*goSq)
+	}
+}
+
+func printTable(w io.Writer, report diffReport) {
+	fmt.Fprintf(w, "pos=%d tol=%g\n", report.Pos, report.Tol)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "layer\tstage\tn\tl2\tmax_abs\tidx\tcosine\t")
+	for _, r := range report.Rows {
+		layer := "-"
+		if r.Layer >= 0 {
+			layer = strconv.Itoa(r.Layer)
+		}
+		if r.Missing {
+			fmt.Fprintf(tw, "%s\t%s\t%d\tmissing in go trace\t\t\t\t\n", layer, r.Stage, r.RefN)
+			continue
+		}
+		mark := ""
+		if r.Diverged {
+			mark = " *"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.6g\t%.6g\t%d\t%.9f%s\t\n", layer, r.Stage, r.N, r.L2, r.MaxAbs, r.MaxAbsIdx, r.Cosine, mark)
+	}
+	_ = tw.Flush()
+	if d := report.FirstDiverged; d != nil {
+		fmt.Fprintf(w, "first_divergent stage=%s layer=%d max_abs=%.6g idx=%d\n", d.Stage, d.Layer, d.MaxAbs, d.MaxAbsIdx)
+	} else {
+		fmt.Fprintln(w, "first_divergent none")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitnet-go/pkg/bitnet"
+)
+
+// refJSONL is a one-layer trace of position 1 with every per-layer stage up
+// to the attention output.
+var refJSONL = []string{
+	`{"stage":"embed","layer":-1,"pos":1,"token":7,"values":[0.1,0.2,0.3]}`,
+	`{"stage":"attn_norm","layer":0,"pos":1,"token":7,"values":[1,1,1]}`,
+	`{"stage":"q","layer":0,"pos":1,"token":7,"values":[1,2,3]}`,
+	`{"stage":"k","layer":0,"pos":1,"token":7,"values":[0.5,-0.5,1]}`,
+	`{"stage":"v","layer":0,"pos":1,"token":7,"values":[2,2,2]}`,
+	`{"stage":"q_rope","layer":0,"pos":1,"token":7,"values":[1,-2,3]}`,
+	`{"stage":"k_rope","layer":0,"pos":1,"token":7,"values":[0.5,0.5,1]}`,
+	`{"stage":"attn_out","layer":0,"pos":1,"token":7,"values":[3,1,4]}`,
+	`{"stage":"logits","layer":-1,"pos":1,"token":7,"values":[9,8,7]}`,
+}
+
+// replaceStage returns lines with the event of stage at layer 0 swapped for
+// repl, or dropped when repl is empty.
+func replaceStage(lines []string, stage, repl string) []string {
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if strings.Contains(l, `"stage":"`+stage+`","layer":0`) {
+			if repl != "" {
+				out = append(out, repl)
+			}
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+func writeTrace(t *testing.T, name string, lines []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDiffTraces(t *testing.T) {
+	// A position-0 event that must not be compared against position 1.
+	earlier := `{"stage":"embed","layer":-1,"pos":0,"token":1,"values":[5,5,5]}`
+	kRopeOff := replaceStage(refJSONL, "k_rope", `{"stage":"k_rope","layer":0,"pos":1,"token":7,"values":[0.5,1,1]}`)
+
+	cases := []struct {
+		name      string
+		ref       []string
+		got       []string
+		wantRows  int
+		wantStage bitnet.TraceStage // first divergent stage, "" for none
+		wantLayer int
+		wantIdx   int
+		missing   bitnet.TraceStage
+	}{
+		{
+			name:     "identical",
+			ref:      refJSONL,
+			got:      append([]string{earlier}, refJSONL...),
+			wantRows: len(refJSONL),
+		},
+		{
+			name: "k_rope diverges before attn_out",
+			ref:  refJSONL,
+			got: replaceStage(kRopeOff, "attn_out",
+				`{"stage":"attn_out","layer":0,"pos":1,"token":7,"values":[3,1,9]}`),
+			wantRows:  len(refJSONL),
+			wantStage: bitnet.TraceKRoPE,
+			wantLayer: 0,
+			wantIdx:   1,
+		},
+		{
+			name:     "q_rope missing from go trace",
+			ref:      refJSONL,
+			got:      replaceStage(refJSONL, "q_rope", ""),
+			wantRows: len(refJSONL),
+			missing:  bitnet.TraceQRoPE,
+		},
+		{
+			name: "ref_trace log names rope stages",
+			ref: []string{
+				"DEBUG name=Qcur-0 n=3 min=1 max=3 mean=2 rms=2",
+				"DEBUG_VALUES name=Qcur-0 values=1,2,3",
+				"DEBUG_VALUES name=Qcur_rope-0 values=1,-2,3.5",
+				"DEBUG_VALUES name=Kcur_rope-0 values=0.5,0.5,1",
+				"DEBUG_VALUES name=Kcur_rope-0 values=0,0,0",
+			},
+			got:       refJSONL,
+			wantRows:  3,
+			wantStage: bitnet.TraceQRoPE,
+			wantLayer: 0,
+			wantIdx:   2,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := readTraceFile(writeTrace(t, "ref", tc.ref), 1)
+			if err != nil {
+				t.Fatalf("read ref: %v", err)
+			}
+			got, err := readTraceFile(writeTrace(t, "go.jsonl", tc.got), -1)
+			if err != nil {
+				t.Fatalf("read go: %v", err)
+			}
+			report := diffTraces(ref, got, 1, 1e-3)
+			if len(report.Rows) != tc.wantRows {
+				t.Fatalf("rows = %d, want %d", len(report.Rows), tc.wantRows)
+			}
+			for i := 1; i < len(report.Rows); i++ {
+				if forwardRank(report.Rows[i-1]) > forwardRank(report.Rows[i]) {
+					t.Fatalf("rows out of forward order at %d: %s before %s", i, report.Rows[i-1].Stage, report.Rows[i].Stage)
+				}
+			}
+			for _, r := range report.Rows {
+				if r.Missing != (r.Stage == tc.missing) {
+					t.Fatalf("stage %s layer %d missing = %v", r.Stage, r.Layer, r.Missing)
+				}
+			}
+			d := report.FirstDiverged
+			if tc.wantStage == "" {
+				if d != nil {
+					t.Fatalf("first divergent = %s layer %d, want none", d.Stage, d.Layer)
+				}
+				return
+			}
+			if d == nil {
+				t.Fatalf("first divergent = none, want %s", tc.wantStage)
+			}
+			if d.Stage != tc.wantStage || d.Layer != tc.wantLayer || d.MaxAbsIdx != tc.wantIdx {
+				t.Fatalf("first divergent = %s layer %d idx %d, want %s layer %d idx %d",
+					d.Stage, d.Layer, d.MaxAbsIdx, tc.wantStage, tc.wantLayer, tc.wantIdx)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"sync"
 
+	"bitnet-go/internal/kernels"
 	"bitnet-go/pkg/bitnet"
 )
 
@@ -24,7 +25,7 @@ func main() {
 		assistant = flag.String("assistant", "", "Prior assistant message (Llama chat template)")
 		chatFile  = flag.String("chat-history", "", "Path to chat history file (role:content per line)")
 		useChat   = flag.Bool("chat-template", false, "Use Llama chat template for system/user/assistant")
-		procs     = flag.Int("procs", 0, "GOMAXPROCS setting (0 = auto: NumCPU-2, min 1)")
+		procs     = flag.Int("procs", 0, "GOMAXPROCS setting (0 = auto: NumCPU-2, evened out across NUMA nodes, min 1)")
 		cpuProf   = flag.String("cpuprofile", "", "Write CPU profile to file")
 		seed      = flag.Int64("seed", 1, "Deterministic seed")
 		maxTokens = flag.Int("max-tokens", 32, "Maximum tokens to generate")
@@ -32,6 +33,14 @@ func main() {
 		temp      = flag.Float64("temp", 0, "Sampling temperature (0 = greedy)")
 		topP      = flag.Float64("top-p", 1, "Top-p nucleus sampling")
 		topK      = flag.Int("top-k", 0, "Top-k sampling (0 = disabled)")
+		kvCache   = flag.String("kv-cache", "", "KV cache storage: f32, f16, q8 (default: BITNET_KV_CACHE or f32)")
+		ctxSize   = flag.Int("ctx-size", 0, "Context window in tokens (0 = model context_length)")
+		ctxShift  = flag.Bool("context-shift", false, "Discard old tokens instead of failing when the context window is full (default: BITNET_CONTEXT_SHIFT)")
+		ctxKeep   = flag.Int("keep", 0, "Tokens at the start of the context to keep during context shift")
+		preset    = flag.String("preset", "", "Runtime options preset: default, cpu_parity_v1, parity_strict (default: BITNET_* env)")
+		tracePath = flag.String("trace", "", "Write per-stage forward-pass tensors to a JSONL file")
+		metricsTo = flag.String("metrics-out", "", "Write Prometheus-format inference metrics to a file after generation")
+		metricsAt = flag.String("metrics-addr", "", "Serve Prometheus-format inference metrics at http://ADDR/metrics while running (e.g. :9090)")
 	)
 	var history chatHistory
 	flag.Var(&history, "chat", "Chat history item (role:content). Repeatable. Roles: system,user,assistant")
@@ -43,11 +52,7 @@ func main() {
 		os.Exit(2)
 	}
 	if *procs == 0 {
-		auto := runtime.NumCPU() - 2
-		if auto < 1 {
-			auto = 1
-		}
-		*procs = auto
+		*procs = autoProcs()
 	}
 	if *procs > 0 {
 		runtime.GOMAXPROCS(*procs)
@@ -69,7 +74,73 @@ func main() {
 		}()
 	}
 
-	session, err := bitnet.LoadModel(context.Background(), *modelPath)
+	var rtOpts *bitnet.RuntimeOptions
+	if *preset != "" {
+		o, err := bitnet.PresetRuntimeOptions(*preset)
+		if err != nil {
+			log.Fatalf("preset: %v", err)
+		}
+		rtOpts = &o
+	}
+	if *tracePath != "" {
+		f, err := os.Create(*tracePath)
+		if err != nil {
+			log.Fatalf("create trace: %v", err)
+		}
+		if rtOpts == nil {
+			o := bitnet.DefaultRuntimeOptions()
+			rtOpts = &o
+		}
+		tracer := bitnet.NewJSONLTracer(f)
+		rtOpts.Tracer = tracer
+		defer func() {
+			if err := tracer.Flush(); err != nil {
+				log.Printf("write trace: %v", err)
+			}
+			_ = f.Close()
+		}()
+	}
+	var metrics bitnet.Metrics
+	var prom *bitnet.PrometheusMetrics
+	if *metricsTo != "" || *metricsAt != "" {
+		prom = bitnet.NewPrometheusMetrics()
+		metrics = prom
+	}
+	if *metricsAt != "" {
+		addr, err := serveMetrics(*metricsAt, prom)
+		if err != nil {
+			log.Fatalf("metrics listener: %v", err)
+		}
+		log.Printf("serving metrics at http://%s/metrics", addr)
+	}
+	if *metricsTo != "" {
+		defer func() {
+			f, err := os.Create(*metricsTo)
+			if err != nil {
+				log.Printf("create metrics: %v", err)
+				return
+			}
+			if err := prom.WriteText(f); err != nil {
+				log.Printf("write metrics: %v", err)
+			}
+			_ = f.Close()
+		}()
+	}
+	// An unset --context-shift leaves BITNET_CONTEXT_SHIFT in charge.
+	var shift *bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "context-shift" {
+			shift = ctxShift
+		}
+	})
+	session, err := bitnet.LoadModelWithOptions(context.Background(), *modelPath, bitnet.LoadOptions{
+		KVCacheType:   bitnet.KVCacheType(*kvCache),
+		ContextLength: *ctxSize,
+		ContextShift:  shift,
+		ContextKeep:   *ctxKeep,
+		Runtime:       rtOpts,
+		Metrics:       metrics,
+	})
 	if err != nil {
 		log.Fatalf("load model: %v", err)
 	}
@@ -166,6 +237,19 @@ func main() {
 	)
 }
 
+// autoProcs leaves two CPUs for the OS and rounds down to a multiple of the
+// NUMA node count so each node gets the same number of kernel workers.
+func autoProcs() int {
+	auto := runtime.NumCPU() - 2
+	if nodes := len(kernels.SystemTopology().Nodes); nodes > 1 && auto >= nodes {
+		auto -= auto % nodes
+	}
+	if auto < 1 {
+		auto = 1
+	}
+	return auto
+}
+
 type chatEntry struct {
 	role    string
 	content string
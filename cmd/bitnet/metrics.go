@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metricsMux serves m at /metrics.
+func metricsMux(m http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	return mux
+}
+
+// serveMetrics serves m at /metrics on addr in the background until the
+// process exits. It returns the bound address, so addr may use port 0.
+func serveMetrics(addr string, m http.Handler) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: metricsMux(m), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics listener: %v", err)
+		}
+	}()
+	return ln.Addr(), nil
+}
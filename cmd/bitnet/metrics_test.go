@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitnet-go/pkg/bitnet"
+)
+
+func TestMetricsMuxServesPrometheusText(t *testing.T) {
+	prom := bitnet.NewPrometheusMetrics()
+	prom.ObserveGenerate(bitnet.GenerateStats{PromptTokens: 3, GeneratedTokens: 4, Decode: time.Second})
+	prom.ObserveKVCache(1024)
+	srv := httptest.NewServer(metricsMux(prom))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read body error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", ct)
+	}
+	for _, want := range []string{
+		"bitnet_requests_total 1\n",
+		"bitnet_prompt_tokens_total 3\n",
+		"bitnet_generated_tokens_total 4\n",
+		"bitnet_kv_cache_bytes 1024\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("GET / error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET / status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestServeMetricsListens(t *testing.T) {
+	prom := bitnet.NewPrometheusMetrics()
+	addr, err := serveMetrics("127.0.0.1:0", prom)
+	if err != nil {
+		t.Fatalf("serveMetrics() error = %v", err)
+	}
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", resp.StatusCode)
+	}
+}
@@ -6,16 +6,21 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
-	"bitnet-go/internal/gguf"
-	"bitnet-go/internal/tokenizer"
+	"bitnet-go/pkg/bitnet"
 )
 
 func main() {
 	var (
-		modelPath  = flag.String("model", "", "Path to GGUF model")
-		prompt     = flag.String("prompt", "", "Prompt text (overrides --prompt-file)")
-		promptFile = flag.String("prompt-file", "", "Path to prompt file")
+		modelPath    = flag.String("model", "", "Path to GGUF model, tokenizer.json or SentencePiece .model")
+		prompt       = flag.String("prompt", "", "Prompt text, or token IDs with --decode (overrides --prompt-file)")
+		promptFile   = flag.String("prompt-file", "", "Path to prompt file")
+		decode       = flag.Bool("decode", false, "Decode token IDs (JSON array or comma/space separated) to text")
+		bos          = flag.String("bos", "auto", "Prepend BOS when encoding: auto (model default), on, off")
+		parseSpecial = flag.Bool("parse-special", false, "Map special-token text such as <|eot_id|> to its ID when encoding")
+		skipSpecial  = flag.Bool("skip-special", false, "Drop control tokens such as BOS/EOS when decoding")
 	)
 	flag.Parse()
 
@@ -39,18 +44,56 @@ func main() {
 		text = string(b)
 	}
 
-	info, err := gguf.ReadModelInfo(*modelPath)
-	if err != nil {
-		log.Fatalf("read gguf model info: %v", err)
-	}
-	tok, err := tokenizer.NewFromModelInfo(info)
+	tok, err := bitnet.LoadTokenizer(*modelPath)
 	if err != nil {
 		log.Fatalf("init tokenizer: %v", err)
 	}
-	ids := tok.Tokenize(text)
+
+	if *decode {
+		ids, err := parseIDs(text)
+		if err != nil {
+			log.Fatalf("parse token ids: %v", err)
+		}
+		fmt.Print(tok.Detokenize(ids, bitnet.DetokenizeOptions{SkipSpecial: *skipSpecial}))
+		return
+	}
+
+	opts := bitnet.TokenizeOptions{ParseSpecial: *parseSpecial}
+	switch *bos {
+	case "auto":
+	case "on", "off":
+		add := *bos == "on"
+		opts.AddBOS = &add
+	default:
+		log.Fatalf("invalid --bos %q (want auto, on or off)", *bos)
+	}
+	ids := tok.Tokenize(text, opts)
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetEscapeHTML(false)
 	if err := enc.Encode(ids); err != nil {
 		log.Fatalf("encode tokens: %v", err)
 	}
 }
+
+// parseIDs accepts the JSON array this tool prints, or IDs separated by
+// commas and/or whitespace.
+func parseIDs(s string) ([]int32, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var ids []int32
+		err := json.Unmarshal([]byte(s), &ids)
+		return ids, err
+	}
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	ids := make([]int32, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseInt(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int32(v))
+	}
+	return ids, nil
+}
@@ -0,0 +1,94 @@
+package dataset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const manifestVersion = 1
+
+// Manifest describes a sharded dataset: the tokenizer it was encoded with
+// and each shard, in reading order.
+type Manifest struct {
+	Version         int         `json:"version"`
+	Tokenizer       string      `json:"tokenizer"`
+	TokenizerSHA256 string      `json:"tokenizer_sha256"`
+	VocabSize       int         `json:"vocab_size"`
+	TokenWidth      int         `json:"token_width"`
+	Tokens          uint64      `json:"tokens"`
+	Documents       uint64      `json:"documents"`
+	Shards          []ShardInfo `json:"shards"`
+}
+
+// ShardInfo is a manifest entry. File is relative to the manifest's
+// directory.
+type ShardInfo struct {
+	File      string `json:"file"`
+	SHA256    string `json:"sha256"`
+	Bytes     int64  `json:"bytes"`
+	Tokens    uint64 `json:"tokens"`
+	Documents uint64 `json:"documents"`
+}
+
+// NewManifest returns a manifest for shards, with the totals filled in.
+func NewManifest(tokenizer, tokenizerSHA256 string, vocabSize, width int, shards []ShardInfo) Manifest {
+	m := Manifest{
+		Version:         manifestVersion,
+		Tokenizer:       tokenizer,
+		TokenizerSHA256: tokenizerSHA256,
+		VocabSize:       vocabSize,
+		TokenWidth:      width,
+		Shards:          shards,
+	}
+	for _, s := range shards {
+		m.Tokens += s.Tokens
+		m.Documents += s.Documents
+	}
+	return m
+}
+
+// ReadManifest reads and checks a manifest written by WriteManifest.
+func ReadManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	if m.Version != manifestVersion {
+		return Manifest{}, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if m.TokenWidth != 2 && m.TokenWidth != 4 {
+		return Manifest{}, fmt.Errorf("unsupported token width %d", m.TokenWidth)
+	}
+	return m, nil
+}
+
+// WriteManifest writes m as indented JSON.
+func WriteManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// FileSHA256 returns the hex SHA-256 of the file at path.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,158 @@
+package dataset
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"path/filepath"
+)
+
+// ReaderOptions controls the order a Reader visits documents in.
+type ReaderOptions struct {
+	// Shuffle visits the shards, and the documents within each shard, in a
+	// permutation drawn from Seed instead of manifest order. The order
+	// depends only on Seed and the manifest.
+	Shuffle bool
+	Seed    uint64
+	// Skip resumes an earlier pass with the same options by skipping its
+	// first Skip documents, the Position it had reached.
+	Skip uint64
+	// Verify checks each shard's size and SHA-256 against the manifest
+	// before reading from it.
+	Verify bool
+}
+
+// Reader returns the documents of a manifest's shards one at a time, in a
+// deterministic order. It holds one shard open at a time.
+type Reader struct {
+	dir      string
+	m        Manifest
+	opts     ReaderOptions
+	order    []int
+	next     int
+	shard    *Shard
+	docOrder []int
+	doc      int
+	pos      uint64
+	buf      []int32
+}
+
+// OpenReader opens the dataset described by the manifest at manifestPath.
+func OpenReader(manifestPath string, opts ReaderOptions) (*Reader, error) {
+	m, err := ReadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{dir: filepath.Dir(manifestPath), m: m, opts: opts}
+	r.order = permutation(len(m.Shards), opts, 0)
+	for r.next < len(r.order) && opts.Skip-r.pos >= m.Shards[r.order[r.next]].Documents {
+		r.pos += m.Shards[r.order[r.next]].Documents
+		r.next++
+	}
+	if r.pos < opts.Skip {
+		if r.next == len(r.order) {
+			return nil, fmt.Errorf("skip %d is past the dataset's %d documents", opts.Skip, m.Documents)
+		}
+		if err := r.openNext(); err != nil {
+			return nil, err
+		}
+		r.doc = int(opts.Skip - r.pos)
+		r.pos = opts.Skip
+	}
+	return r, nil
+}
+
+// permutation returns 0..n-1, shuffled by a PCG stream of opts.Seed when
+// opts.Shuffle is set. The Fisher-Yates loop is spelled out so the order
+// never changes with the Go release.
+func permutation(n int, opts ReaderOptions, stream uint64) []int {
+	p := make([]int, n)
+	for i := range p {
+		p[i] = i
+	}
+	if !opts.Shuffle {
+		return p
+	}
+	src := rand.NewPCG(opts.Seed, stream)
+	for i := n - 1; i > 0; i-- {
+		j := int(src.Uint64() % uint64(i+1))
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
+// openNext closes the current shard and opens the next one in order.
+func (r *Reader) openNext() error {
+	if r.shard != nil {
+		r.shard.Close()
+		r.shard = nil
+	}
+	k := r.order[r.next]
+	r.next++
+	info := r.m.Shards[k]
+	path := filepath.Join(r.dir, info.File)
+	if r.opts.Verify {
+		sum, err := FileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if sum != info.SHA256 {
+			return fmt.Errorf("%s: sha256 %s, manifest has %s", info.File, sum, info.SHA256)
+		}
+	}
+	s, err := OpenShard(path)
+	if err != nil {
+		return err
+	}
+	if s.Width() != r.m.TokenWidth || s.Tokens() != info.Tokens || uint64(s.Documents()) != info.Documents {
+		s.Close()
+		return fmt.Errorf("%s: shard has width %d, %d tokens and %d documents, manifest has %d, %d and %d",
+			info.File, s.Width(), s.Tokens(), s.Documents(), r.m.TokenWidth, info.Tokens, info.Documents)
+	}
+	r.shard = s
+	r.docOrder = permutation(s.Documents(), r.opts, uint64(k)+1)
+	r.doc = 0
+	return nil
+}
+
+// Next returns the next document's tokens, or io.EOF after the last. The
+// slice is reused by the following call.
+func (r *Reader) Next() ([]int32, error) {
+	for r.shard == nil || r.doc >= len(r.docOrder) {
+		if r.next >= len(r.order) {
+			return nil, io.EOF
+		}
+		if err := r.openNext(); err != nil {
+			return nil, err
+		}
+	}
+	ids, err := r.shard.Document(r.docOrder[r.doc], r.buf)
+	if err != nil {
+		return nil, err
+	}
+	r.buf = ids
+	r.doc++
+	r.pos++
+	return ids, nil
+}
+
+// Position returns the number of documents read, counting skipped ones;
+// pass it as ReaderOptions.Skip to resume.
+func (r *Reader) Position() uint64 {
+	return r.pos
+}
+
+// Manifest returns the dataset's manifest.
+func (r *Reader) Manifest() Manifest {
+	return r.m
+}
+
+// Close closes the open shard.
+func (r *Reader) Close() error {
+	if r.shard == nil {
+		return nil
+	}
+	err := r.shard.Close()
+	r.shard = nil
+	return err
+}
@@ -0,0 +1,144 @@
+package dataset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeDataset writes n documents, document i holding the single token i,
+// perShard to a shard, and returns the manifest path.
+func writeDataset(t *testing.T, n, perShard int) string {
+	t.Helper()
+	dir := t.TempDir()
+	var shards []ShardInfo
+	for start := 0; start < n; start += perShard {
+		sw, err := CreateShard(filepath.Join(dir, fmt.Sprintf("shard-%05d.bin", len(shards))), 2)
+		if err != nil {
+			t.Fatalf("CreateShard() error = %v", err)
+		}
+		for i := start; i < min(start+perShard, n); i++ {
+			if err := sw.WriteDocument([]int32{int32(i)}); err != nil {
+				t.Fatalf("WriteDocument() error = %v", err)
+			}
+		}
+		info, err := sw.Close()
+		if err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		shards = append(shards, info)
+	}
+	path := filepath.Join(dir, "manifest.json")
+	if err := WriteManifest(path, NewManifest("tok.gguf", "", 100, 2, shards)); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+	return path
+}
+
+// readAll returns the first token of every document the reader yields.
+func readAll(t *testing.T, path string, opts ReaderOptions) []int {
+	t.Helper()
+	r, err := OpenReader(path, opts)
+	if err != nil {
+		t.Fatalf("OpenReader() error = %v", err)
+	}
+	defer r.Close()
+	var out []int
+	for {
+		ids, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		out = append(out, int(ids[0]))
+	}
+}
+
+func TestReaderManifestOrder(t *testing.T) {
+	path := writeDataset(t, 10, 4)
+	m, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if len(m.Shards) != 3 || m.Documents != 10 || m.Tokens != 10 {
+		t.Fatalf("manifest = %+v, want 3 shards of 10 documents and tokens", m)
+	}
+	got := readAll(t, path, ReaderOptions{Verify: true})
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("documents = %v, want %v", got, want)
+	}
+}
+
+func TestReaderShuffleIsDeterministic(t *testing.T) {
+	path := writeDataset(t, 50, 8)
+	a := readAll(t, path, ReaderOptions{Shuffle: true, Seed: 1})
+	b := readAll(t, path, ReaderOptions{Shuffle: true, Seed: 1})
+	c := readAll(t, path, ReaderOptions{Shuffle: true, Seed: 2})
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same seed gave %v and %v", a, b)
+	}
+	if reflect.DeepEqual(a, c) {
+		t.Fatalf("seeds 1 and 2 gave the same order %v", a)
+	}
+	sorted := append([]int(nil), a...)
+	sort.Ints(sorted)
+	for i, v := range sorted {
+		if v != i {
+			t.Fatalf("shuffled documents %v are not a permutation of 0..49", a)
+		}
+	}
+}
+
+func TestReaderSkipResumes(t *testing.T) {
+	path := writeDataset(t, 23, 5)
+	opts := ReaderOptions{Shuffle: true, Seed: 7}
+	all := readAll(t, path, opts)
+	for _, skip := range []uint64{0, 3, 5, 12, 22, 23} {
+		opts.Skip = skip
+		r, err := OpenReader(path, opts)
+		if err != nil {
+			t.Fatalf("OpenReader(Skip %d) error = %v", skip, err)
+		}
+		if r.Position() != skip {
+			t.Fatalf("Position() = %d, want %d", r.Position(), skip)
+		}
+		r.Close()
+		want := all[skip:]
+		if got := readAll(t, path, opts); len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Fatalf("Skip %d read %v, want %v", skip, got, want)
+		}
+	}
+	opts.Skip = 24
+	if _, err := OpenReader(path, opts); err == nil {
+		t.Fatal("OpenReader(Skip past end) error = nil")
+	}
+}
+
+func TestReaderVerifyDetectsTampering(t *testing.T) {
+	path := writeDataset(t, 4, 4)
+	shard := filepath.Join(filepath.Dir(path), "shard-00000.bin")
+	data, err := os.ReadFile(shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[shardHeaderSize] ^= 1
+	if err := os.WriteFile(shard, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReader(path, ReaderOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("OpenReader() error = %v", err)
+	}
+	defer r.Close()
+	if _, err := r.Next(); err == nil {
+		t.Fatal("Next() on a tampered shard error = nil")
+	}
+}
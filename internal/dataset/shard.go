@@ -0,0 +1,267 @@
+// Package dataset reads and writes pre-tokenized training shards.
+//
+// A shard is a little-endian binary file:
+//
+//	magic "BTOK", u32 version (1), u32 token width in bytes (2 or 4)
+//	tokens: token count × width-byte unsigned token IDs
+//	document ends: document count × u64, the exclusive token index at
+//	which each document ends
+//	u64 token count, u64 document count
+//
+// The counts trail the data so a shard can be hashed as it is written. A
+// manifest (manifest.json) lists the shards in order with their SHA-256.
+package dataset
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	shardMagic       = "BTOK"
+	shardVersion     = 1
+	shardHeaderSize  = 12
+	shardTrailerSize = 16
+)
+
+var ErrInvalidShard = errors.New("invalid token shard")
+
+// WidthFor returns the narrowest token width, 2 or 4 bytes, that holds every
+// ID of a vocabulary of vocabSize tokens.
+func WidthFor(vocabSize int) int {
+	if vocabSize <= 1<<16 {
+		return 2
+	}
+	return 4
+}
+
+// ShardWriter streams documents into one shard file.
+type ShardWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	h       hash.Hash
+	path    string
+	width   int
+	tokens  uint64
+	docEnds []uint64
+	buf     []byte
+	size    int64
+}
+
+// CreateShard creates the shard file at path for tokens width bytes wide.
+func CreateShard(path string, width int) (*ShardWriter, error) {
+	if width != 2 && width != 4 {
+		return nil, fmt.Errorf("unsupported token width %d", width)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	sw := &ShardWriter{f: f, h: sha256.New(), path: path, width: width}
+	sw.w = bufio.NewWriterSize(io.MultiWriter(f, sw.h), 1<<20)
+	var hdr [shardHeaderSize]byte
+	copy(hdr[:], shardMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], shardVersion)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(width))
+	if err := sw.write(hdr[:]); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *ShardWriter) write(p []byte) error {
+	n, err := sw.w.Write(p)
+	sw.size += int64(n)
+	return err
+}
+
+// Tokens returns the number of tokens written so far.
+func (sw *ShardWriter) Tokens() uint64 {
+	return sw.tokens
+}
+
+// Documents returns the number of documents written so far.
+func (sw *ShardWriter) Documents() int {
+	return len(sw.docEnds)
+}
+
+// WriteDocument appends one document. IDs must be non-negative and fit the
+// shard's token width.
+func (sw *ShardWriter) WriteDocument(tokens []int32) error {
+	limit := int64(1)<<(8*sw.width) - 1
+	sw.buf = sw.buf[:0]
+	for _, id := range tokens {
+		if id < 0 || int64(id) > limit {
+			return fmt.Errorf("token %d does not fit in %d bytes", id, sw.width)
+		}
+		if sw.width == 2 {
+			sw.buf = binary.LittleEndian.AppendUint16(sw.buf, uint16(id))
+		} else {
+			sw.buf = binary.LittleEndian.AppendUint32(sw.buf, uint32(id))
+		}
+	}
+	if err := sw.write(sw.buf); err != nil {
+		return err
+	}
+	sw.tokens += uint64(len(tokens))
+	sw.docEnds = append(sw.docEnds, sw.tokens)
+	return nil
+}
+
+// Close writes the document index and trailer, closes the file and returns
+// its manifest entry.
+func (sw *ShardWriter) Close() (ShardInfo, error) {
+	buf := make([]byte, 0, 8*len(sw.docEnds)+shardTrailerSize)
+	for _, end := range sw.docEnds {
+		buf = binary.LittleEndian.AppendUint64(buf, end)
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, sw.tokens)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(sw.docEnds)))
+	err := sw.write(buf)
+	if err == nil {
+		err = sw.w.Flush()
+	}
+	if cerr := sw.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return ShardInfo{}, err
+	}
+	return ShardInfo{
+		File:      filepath.Base(sw.path),
+		SHA256:    hex.EncodeToString(sw.h.Sum(nil)),
+		Bytes:     sw.size,
+		Tokens:    sw.tokens,
+		Documents: uint64(len(sw.docEnds)),
+	}, nil
+}
+
+// Shard is an open shard file. Documents are read on demand; only the
+// document index is held in memory.
+type Shard struct {
+	f       *os.File
+	width   int
+	tokens  uint64
+	docEnds []uint64
+	buf     []byte
+}
+
+// OpenShard opens a shard and loads its document index.
+func OpenShard(path string) (*Shard, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readShardIndex(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func readShardIndex(f *os.File) (*Shard, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+	if size < shardHeaderSize+shardTrailerSize {
+		return nil, ErrInvalidShard
+	}
+	var hdr [shardHeaderSize]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[:4]) != shardMagic {
+		return nil, ErrInvalidShard
+	}
+	if v := binary.LittleEndian.Uint32(hdr[4:]); v != shardVersion {
+		return nil, fmt.Errorf("unsupported shard version %d", v)
+	}
+	width := int(binary.LittleEndian.Uint32(hdr[8:]))
+	if width != 2 && width != 4 {
+		return nil, fmt.Errorf("unsupported token width %d", width)
+	}
+	var tr [shardTrailerSize]byte
+	if _, err := f.ReadAt(tr[:], size-shardTrailerSize); err != nil {
+		return nil, err
+	}
+	tokens := binary.LittleEndian.Uint64(tr[:])
+	docs := binary.LittleEndian.Uint64(tr[8:])
+	body := uint64(size - shardHeaderSize - shardTrailerSize)
+	if tokens > body/uint64(width) || docs > (body-tokens*uint64(width))/8 || tokens*uint64(width)+docs*8 != body {
+		return nil, ErrInvalidShard
+	}
+	index := make([]byte, 8*docs)
+	if _, err := f.ReadAt(index, shardHeaderSize+int64(tokens)*int64(width)); err != nil {
+		return nil, err
+	}
+	s := &Shard{f: f, width: width, tokens: tokens, docEnds: make([]uint64, docs)}
+	prev := uint64(0)
+	for i := range s.docEnds {
+		end := binary.LittleEndian.Uint64(index[8*i:])
+		if end < prev || end > tokens {
+			return nil, ErrInvalidShard
+		}
+		s.docEnds[i], prev = end, end
+	}
+	return s, nil
+}
+
+// Width returns the token width in bytes.
+func (s *Shard) Width() int {
+	return s.width
+}
+
+// Tokens returns the number of tokens in the shard.
+func (s *Shard) Tokens() uint64 {
+	return s.tokens
+}
+
+// Documents returns the number of documents in the shard.
+func (s *Shard) Documents() int {
+	return len(s.docEnds)
+}
+
+// Document reads document i, appending its tokens to dst[:0].
+func (s *Shard) Document(i int, dst []int32) ([]int32, error) {
+	if i < 0 || i >= len(s.docEnds) {
+		return nil, fmt.Errorf("document %d out of range [0, %d)", i, len(s.docEnds))
+	}
+	start := uint64(0)
+	if i > 0 {
+		start = s.docEnds[i-1]
+	}
+	n := int(s.docEnds[i] - start)
+	if cap(s.buf) < n*s.width {
+		s.buf = make([]byte, n*s.width)
+	}
+	raw := s.buf[:n*s.width]
+	if _, err := s.f.ReadAt(raw, shardHeaderSize+int64(start)*int64(s.width)); err != nil {
+		return nil, err
+	}
+	dst = dst[:0]
+	for j := 0; j < n; j++ {
+		if s.width == 2 {
+			dst = append(dst, int32(binary.LittleEndian.Uint16(raw[2*j:])))
+		} else {
+			dst = append(dst, int32(binary.LittleEndian.Uint32(raw[4*j:])))
+		}
+	}
+	return dst, nil
+}
+
+// Close closes the shard file.
+func (s *Shard) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,123 @@
+package dataset
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testDocs = [][]int32{
+	{1, 15043, 3186},
+	{},
+	{1, 2},
+	{65535, 0, 7, 7, 7},
+}
+
+func TestShardRoundTrip(t *testing.T) {
+	for _, width := range []int{2, 4} {
+		path := filepath.Join(t.TempDir(), "shard-00000.bin")
+		sw, err := CreateShard(path, width)
+		if err != nil {
+			t.Fatalf("CreateShard() error = %v", err)
+		}
+		for _, doc := range testDocs {
+			if err := sw.WriteDocument(doc); err != nil {
+				t.Fatalf("WriteDocument() error = %v", err)
+			}
+		}
+		info, err := sw.Close()
+		if err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		sum, err := FileSHA256(path)
+		if err != nil {
+			t.Fatalf("FileSHA256() error = %v", err)
+		}
+		want := ShardInfo{File: "shard-00000.bin", SHA256: sum, Bytes: st.Size(), Tokens: 10, Documents: 4}
+		if info != want {
+			t.Fatalf("width %d: Close() = %+v, want %+v", width, info, want)
+		}
+
+		s, err := OpenShard(path)
+		if err != nil {
+			t.Fatalf("OpenShard() error = %v", err)
+		}
+		if s.Width() != width || s.Tokens() != 10 || s.Documents() != len(testDocs) {
+			t.Fatalf("width %d: shard has width %d, %d tokens, %d documents", width, s.Width(), s.Tokens(), s.Documents())
+		}
+		var buf []int32
+		for i, doc := range testDocs {
+			buf, err = s.Document(i, buf)
+			if err != nil {
+				t.Fatalf("Document(%d) error = %v", i, err)
+			}
+			if len(buf) != len(doc) || len(doc) > 0 && !reflect.DeepEqual(buf, doc) {
+				t.Fatalf("width %d: Document(%d) = %v, want %v", width, i, buf, doc)
+			}
+		}
+		if _, err := s.Document(len(testDocs), nil); err == nil {
+			t.Fatal("Document(out of range) error = nil")
+		}
+		s.Close()
+	}
+}
+
+func TestShardWriterRejectsWideTokens(t *testing.T) {
+	sw, err := CreateShard(filepath.Join(t.TempDir(), "s.bin"), 2)
+	if err != nil {
+		t.Fatalf("CreateShard() error = %v", err)
+	}
+	defer sw.Close()
+	for _, doc := range [][]int32{{65536}, {-1}} {
+		if err := sw.WriteDocument(doc); err == nil {
+			t.Fatalf("WriteDocument(%v) error = nil", doc)
+		}
+	}
+	if _, err := CreateShard(filepath.Join(t.TempDir(), "s3.bin"), 3); err == nil {
+		t.Fatal("CreateShard(width 3) error = nil")
+	}
+}
+
+func TestOpenShardRejectsCorruptFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "s.bin")
+	sw, err := CreateShard(path, 2)
+	if err != nil {
+		t.Fatalf("CreateShard() error = %v", err)
+	}
+	sw.WriteDocument([]int32{1, 2, 3})
+	if _, err := sw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string][]byte{
+		"truncated": data[:len(data)-1],
+		"magic":     append([]byte("GGUF"), data[4:]...),
+		"short":     data[:8],
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, bad, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if s, err := OpenShard(p); err == nil {
+			s.Close()
+			t.Fatalf("OpenShard(%s) error = nil", name)
+		}
+	}
+}
+
+func TestWidthFor(t *testing.T) {
+	for vocab, want := range map[int]int{32000: 2, 65536: 2, 65537: 4, 128256: 4} {
+		if got := WidthFor(vocab); got != want {
+			t.Fatalf("WidthFor(%d) = %d, want %d", vocab, got, want)
+		}
+	}
+}
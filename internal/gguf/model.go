@@ -276,6 +276,14 @@ func readArrayValue(r io.Reader, key string) (any, uint64, bool, error) {
 		return out, n, true, nil
 	}
 
+	if (elemType == valueTypeUint8 || elemType == valueTypeInt8) && key == "tokenizer.ggml.precompiled_charsmap" {
+		out := make([]byte, n)
+		if _, err := io.ReadFull(r, out); err != nil {
+			return nil, 0, false, err
+		}
+		return out, n, true, nil
+	}
+
 	size := valueTypeSize(elemType)
 	if size == 0 {
 		return nil, 0, false, fmt.Errorf("unsupported array element type: %d", elemType)
@@ -12,7 +12,7 @@ func TestDecodeModelInfo(t *testing.T) {
 	writeString(t, buf, "GGUF")
 	writeU32(t, buf, 3) // version
 	writeU64(t, buf, 4) // tensor count
-	writeU64(t, buf, 6) // kv count
+	writeU64(t, buf, 7) // kv count
 
 	// general.architecture = "llama"
 	writeGGUFString(t, buf, "general.architecture")
@@ -37,6 +37,13 @@ func TestDecodeModelInfo(t *testing.T) {
 	writeGGUFString(t, buf, "<s>")
 	writeGGUFString(t, buf, "</s>")
 
+	// tokenizer.ggml.precompiled_charsmap = array[u8]
+	writeGGUFString(t, buf, "tokenizer.ggml.precompiled_charsmap")
+	writeU32(t, buf, valueTypeArray)
+	writeU32(t, buf, valueTypeUint8)
+	writeU64(t, buf, 3)
+	buf.Write([]byte{1, 2, 3})
+
 	// general.quantization_version = u32(2)
 	writeGGUFString(t, buf, "general.quantization_version")
 	writeU32(t, buf, valueTypeUint32)
@@ -67,8 +74,8 @@ func TestDecodeModelInfo(t *testing.T) {
 	if info.TensorCount != 4 {
 		t.Fatalf("TensorCount = %d, want 4", info.TensorCount)
 	}
-	if info.KVCount != 6 {
-		t.Fatalf("KVCount = %d, want 6", info.KVCount)
+	if info.KVCount != 7 {
+		t.Fatalf("KVCount = %d, want 7", info.KVCount)
 	}
 	if got := info.KeyValues["general.architecture"]; got != "llama" {
 		t.Fatalf("general.architecture = %v, want llama", got)
@@ -82,6 +89,9 @@ func TestDecodeModelInfo(t *testing.T) {
 	if got := info.KeyValues["tokenizer.ggml.tokens"]; got == nil {
 		t.Fatalf("tokenizer.ggml.tokens should be captured for tokenizer plumbing")
 	}
+	if got, ok := info.KeyValues["tokenizer.ggml.precompiled_charsmap"].([]byte); !ok || !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Fatalf("tokenizer.ggml.precompiled_charsmap = %v, want [1 2 3]", info.KeyValues["tokenizer.ggml.precompiled_charsmap"])
+	}
 	if len(info.Tensors) != 4 {
 		t.Fatalf("len(Tensors) = %d, want 4", len(info.Tensors))
 	}
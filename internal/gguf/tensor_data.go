@@ -297,6 +297,11 @@ func ReadTensorAsF32FromFile(f *os.File, info ModelInfo, name string) ([]float32
 		return readTensorTQ20AsF32(r, name, count)
 	case GGMLTypeI2_S:
 		return readTensorI2SAsF32(r, name, count)
+	case GGMLTypeTL1, GGMLTypeTL2:
+		// Upstream packs TL1/TL2 weights in the tile order of kernels generated
+		// per model (BM/BK in its kernel_config.ini); the tile sizes are not
+		// stored in the GGUF, so the weights cannot be unpacked here.
+		return nil, fmt.Errorf("tensor %q type=%d (%s) not supported: upstream's tiled tl1/tl2 layout depends on per-model kernel tile sizes that are not stored in the gguf; convert the model to i2_s", name, t.Type, TensorTypeString(t.Type))
 	case GGMLTypeIQ2_XXS:
 		return readTensorIQ2XXSAsF32(r, name, count)
 	case GGMLTypeIQ2_XS:
@@ -361,6 +366,57 @@ func ReadTensorI2SPackedFromFile(f *os.File, info ModelInfo, name string) ([]byt
 	return packed, scale, count, nil
 }
 
+// TQBlockBytes returns the size of one 256-element TQ1_0/TQ2_0 block, or 0
+// for other types.
+func TQBlockBytes(t uint32) int {
+	switch t {
+	case GGMLTypeTQ1_0:
+		return 48 + 4 + 2
+	case GGMLTypeTQ2_0:
+		return 64 + 2
+	default:
+		return 0
+	}
+}
+
+func ReadTensorTQPacked(path string, info ModelInfo, name string) ([]byte, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return ReadTensorTQPackedFromFile(f, info, name)
+}
+
+// ReadTensorTQPackedFromFile returns the raw TQ1_0/TQ2_0 blocks of a tensor,
+// per-block f16 scales included.
+func ReadTensorTQPackedFromFile(f *os.File, info ModelInfo, name string) ([]byte, uint64, error) {
+	t, ok := info.TensorByName(name)
+	if !ok {
+		return nil, 0, fmt.Errorf("tensor not found: %s", name)
+	}
+	blockBytes := TQBlockBytes(t.Type)
+	if blockBytes == 0 {
+		return nil, 0, fmt.Errorf("tensor %q type=%d is not tq1_0/tq2_0", name, t.Type)
+	}
+	count, err := TensorElementCount(t)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count%256 != 0 {
+		return nil, 0, fmt.Errorf("tensor %q %s element count=%d not divisible by 256", name, TensorTypeString(t.Type), count)
+	}
+	if count/256 > uint64(math.MaxInt/blockBytes) {
+		return nil, 0, fmt.Errorf("tensor %q has too many %s blocks", name, TensorTypeString(t.Type))
+	}
+	start := int64(info.TensorDataOffset + t.Offset)
+	packed := make([]byte, int(count/256)*blockBytes)
+	if _, err := f.ReadAt(packed, start); err != nil {
+		return nil, 0, fmt.Errorf("read tensor %q %s blocks: %w", name, TensorTypeString(t.Type), err)
+	}
+	return packed, count, nil
+}
+
 func ReadTensorF16Raw(path string, info ModelInfo, name string) ([]uint16, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -425,7 +481,7 @@ func readTensorTQ10AsF32(r io.Reader, name string, count uint64) ([]float32, err
 			for n := 0; n < 5; n++ {
 				p := pow3[n]
 				for m := 0; m < 32; m++ {
-					q := uint16(qsBytes[j+m]) * p
+					q := uint16(qsBytes[j+m] * uint8(p))
 					xi := (q * 3) >> 8
 					out[outIdx] = float32(int16(xi)-1) * scale
 					outIdx++
@@ -436,7 +492,7 @@ func readTensorTQ10AsF32(r io.Reader, name string, count uint64) ([]float32, err
 			for n := 0; n < 5; n++ {
 				p := pow3[n]
 				for m := 0; m < 16 && j+m < len(qsBytes); m++ {
-					q := uint16(qsBytes[j+m]) * p
+					q := uint16(qsBytes[j+m] * uint8(p))
 					xi := (q * 3) >> 8
 					out[outIdx] = float32(int16(xi)-1) * scale
 					outIdx++
@@ -447,7 +503,7 @@ func readTensorTQ10AsF32(r io.Reader, name string, count uint64) ([]float32, err
 		for n := 0; n < 4; n++ {
 			p := pow3[n]
 			for j := 0; j < len(qhBytes); j++ {
-				q := uint16(qhBytes[j]) * p
+				q := uint16(qhBytes[j] * uint8(p))
 				xi := (q * 3) >> 8
 				out[outIdx] = float32(int16(xi)-1) * scale
 				outIdx++
@@ -6,6 +6,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -488,6 +489,48 @@ func TestReadTensorI2S(t *testing.T) {
 	}
 }
 
+func TestReadTensorTL1TL2Rejected(t *testing.T) {
+	const ne0, ne1 = 28, 2
+	for _, typ := range []uint32{GGMLTypeTL1, GGMLTypeTL2} {
+		if IsTensorTypeSupportedAsF32(typ) {
+			t.Fatalf("IsTensorTypeSupportedAsF32(%s) = true", TensorTypeString(typ))
+		}
+		buf := bytes.NewBuffer(nil)
+		writeString(t, buf, "GGUF")
+		writeU32(t, buf, 3)
+		writeU64(t, buf, 1) // tensor count
+		writeU64(t, buf, 1) // kv count
+
+		writeGGUFString(t, buf, "general.alignment")
+		writeU32(t, buf, valueTypeUint32)
+		writeU32(t, buf, 32)
+
+		writeGGUFString(t, buf, "w")
+		writeU32(t, buf, 2)
+		writeU64(t, buf, ne0)
+		writeU64(t, buf, ne1)
+		writeU32(t, buf, typ)
+		writeU64(t, buf, 0)
+
+		// Upstream writes the tiled 2-bit codes followed by one f32 scale.
+		padTo(t, buf, 32)
+		buf.Write(bytes.Repeat([]byte{0x5a}, ne0*ne1/4))
+		writeF32(t, buf, 0.5)
+
+		path := filepath.Join(t.TempDir(), "tensor_"+TensorTypeString(typ)+".gguf")
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		info, err := ReadModelInfo(path)
+		if err != nil {
+			t.Fatalf("ReadModelInfo() error = %v", err)
+		}
+		if _, err := ReadTensorF32(path, info, "w"); err == nil || !strings.Contains(err.Error(), "tile sizes") {
+			t.Fatalf("ReadTensorF32(%s) error = %v, want not supported", TensorTypeString(typ), err)
+		}
+	}
+}
+
 func TestReadTensorIQ2XXS(t *testing.T) {
 	buf := bytes.NewBuffer(nil)
 	writeString(t, buf, "GGUF")
@@ -1570,3 +1613,70 @@ func writeF64(t *testing.T, buf *bytes.Buffer, v float64) {
 		t.Fatalf("binary.Write(f64) error = %v", err)
 	}
 }
+
+func TestReadTensorTQ10TritsAndPacked(t *testing.T) {
+	// Encode trits the way ggml does: q = sum t_n*3^(4-n), byte = ceil(q*256/243).
+	pack5 := func(trits [5]int) byte {
+		q := 0
+		for _, v := range trits {
+			q = q*3 + v
+		}
+		return byte((q*256 + 242) / 243)
+	}
+	trits := [5]int{2, 0, 1, 2, 1}
+	qs := make([]byte, 48)
+	qs[0] = pack5(trits)
+	qh := []byte{pack5([5]int{0, 2, 1, 1, 0}), 0, 0, 0}
+
+	buf := bytes.NewBuffer(nil)
+	writeString(t, buf, "GGUF")
+	writeU32(t, buf, 3)
+	writeU64(t, buf, 1)
+	writeU64(t, buf, 1)
+	writeGGUFString(t, buf, "general.alignment")
+	writeU32(t, buf, valueTypeUint32)
+	writeU32(t, buf, 32)
+	writeGGUFString(t, buf, "w")
+	writeU32(t, buf, 1)
+	writeU64(t, buf, 256)
+	writeU32(t, buf, GGMLTypeTQ1_0)
+	writeU64(t, buf, 0)
+	padTo(t, buf, 32)
+	buf.Write(qs)
+	buf.Write(qh)
+	writeU16(t, buf, 0x4000) // d = 2.0
+
+	path := filepath.Join(t.TempDir(), "tensor_tq1_0_trits.gguf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	info, err := ReadModelInfo(path)
+	if err != nil {
+		t.Fatalf("ReadModelInfo() error = %v", err)
+	}
+	got, err := ReadTensorF32(path, info, "w")
+	if err != nil {
+		t.Fatalf("ReadTensorF32() error = %v", err)
+	}
+	for n, tr := range trits {
+		if want := float32(tr-1) * 2; got[n*32] != want {
+			t.Fatalf("got[%d] = %v, want %v", n*32, got[n*32], want)
+		}
+	}
+	for n, tr := range []int{0, 2, 1, 1} {
+		if want := float32(tr-1) * 2; got[240+n*4] != want {
+			t.Fatalf("got[%d] = %v, want %v", 240+n*4, got[240+n*4], want)
+		}
+	}
+
+	packed, count, err := ReadTensorTQPacked(path, info, "w")
+	if err != nil {
+		t.Fatalf("ReadTensorTQPacked() error = %v", err)
+	}
+	if count != 256 || len(packed) != TQBlockBytes(GGMLTypeTQ1_0) {
+		t.Fatalf("packed len=%d count=%d", len(packed), count)
+	}
+	if packed[0] != qs[0] || packed[48] != qh[0] || packed[52] != 0x00 || packed[53] != 0x40 {
+		t.Fatalf("packed block mismatch: % x", packed)
+	}
+}
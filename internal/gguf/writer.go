@@ -0,0 +1,147 @@
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// WriteKeyValues writes a GGUF file holding kv and no tensors, such as a
+// vocabulary-only tokenizer file.
+func WriteKeyValues(path string, kv map[string]any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := EncodeKeyValues(bw, kv); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// EncodeKeyValues writes a version 3 GGUF header and kv, sorted by key, with
+// no tensors. Values use the Go types DecodeModelInfo returns: the scalar
+// integer, float, bool and string types, and []string, []float32, []int32 and
+// []byte arrays.
+func EncodeKeyValues(w io.Writer, kv map[string]any) error {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ew := &errWriter{w: w}
+	ew.write([]byte("GGUF"))
+	ew.le(uint32(3))
+	ew.le(uint64(0))
+	ew.le(uint64(len(keys)))
+	for _, k := range keys {
+		ew.str(k)
+		if err := ew.value(kv[k]); err != nil {
+			return fmt.Errorf("write kv %q: %w", k, err)
+		}
+	}
+	return ew.err
+}
+
+// errWriter keeps the first write error so encoding can run straight
+// through and check once.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
+func (w *errWriter) le(v any) {
+	if w.err == nil {
+		w.err = binary.Write(w.w, binary.LittleEndian, v)
+	}
+}
+
+func (w *errWriter) str(s string) {
+	w.le(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *errWriter) array(elemType uint32, n int) {
+	w.le(uint32(valueTypeArray))
+	w.le(elemType)
+	w.le(uint64(n))
+}
+
+func (w *errWriter) value(v any) error {
+	switch x := v.(type) {
+	case uint8:
+		w.le(uint32(valueTypeUint8))
+		w.le(x)
+	case int8:
+		w.le(uint32(valueTypeInt8))
+		w.le(x)
+	case uint16:
+		w.le(uint32(valueTypeUint16))
+		w.le(x)
+	case int16:
+		w.le(uint32(valueTypeInt16))
+		w.le(x)
+	case uint32:
+		w.le(uint32(valueTypeUint32))
+		w.le(x)
+	case int32:
+		w.le(uint32(valueTypeInt32))
+		w.le(x)
+	case float32:
+		w.le(uint32(valueTypeFloat32))
+		w.le(math.Float32bits(x))
+	case bool:
+		w.le(uint32(valueTypeBool))
+		var b uint8
+		if x {
+			b = 1
+		}
+		w.le(b)
+	case string:
+		w.le(uint32(valueTypeString))
+		w.str(x)
+	case uint64:
+		w.le(uint32(valueTypeUint64))
+		w.le(x)
+	case int64:
+		w.le(uint32(valueTypeInt64))
+		w.le(x)
+	case float64:
+		w.le(uint32(valueTypeFloat64))
+		w.le(math.Float64bits(x))
+	case []string:
+		w.array(valueTypeString, len(x))
+		for _, s := range x {
+			w.str(s)
+		}
+	case []float32:
+		w.array(valueTypeFloat32, len(x))
+		w.le(x)
+	case []int32:
+		w.array(valueTypeInt32, len(x))
+		w.le(x)
+	case []byte:
+		w.array(valueTypeUint8, len(x))
+		w.write(x)
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
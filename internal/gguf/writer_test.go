@@ -0,0 +1,66 @@
+package gguf
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeKeyValuesRoundTrip(t *testing.T) {
+	kv := map[string]any{
+		"general.architecture":                "llama",
+		"general.alignment":                   uint32(64),
+		"tokenizer.ggml.add_bos_token":        true,
+		"tokenizer.ggml.bos_token_id":         uint32(1),
+		"tokenizer.ggml.tokens":               []string{"<unk>", "<s>", "▁a"},
+		"tokenizer.ggml.scores":               []float32{0, 0, -1.5},
+		"tokenizer.ggml.token_type":           []int32{2, 3, 1},
+		"tokenizer.ggml.merges":               []string{"▁ a"},
+		"tokenizer.ggml.precompiled_charsmap": []byte{1, 2, 3},
+		"test.i8":                             int8(-3),
+		"test.u16":                            uint16(7),
+		"test.i64":                            int64(-9),
+		"test.f64":                            1.25,
+	}
+	var buf bytes.Buffer
+	if err := EncodeKeyValues(&buf, kv); err != nil {
+		t.Fatalf("EncodeKeyValues() error = %v", err)
+	}
+	info, err := DecodeModelInfo(&buf)
+	if err != nil {
+		t.Fatalf("DecodeModelInfo() error = %v", err)
+	}
+	if info.Version != 3 || info.TensorCount != 0 || info.KVCount != uint64(len(kv)) {
+		t.Fatalf("header = %+v, want version 3, 0 tensors, %d kv", info.Header, len(kv))
+	}
+	for k, want := range kv {
+		if got := info.KeyValues[k]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, want %#v", k, got, want)
+		}
+	}
+	if info.Alignment != 64 {
+		t.Fatalf("Alignment = %d, want 64", info.Alignment)
+	}
+}
+
+func TestWriteKeyValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vocab.gguf")
+	if err := WriteKeyValues(path, map[string]any{"tokenizer.ggml.model": "gpt2"}); err != nil {
+		t.Fatalf("WriteKeyValues() error = %v", err)
+	}
+	info, err := ReadModelInfo(path)
+	if err != nil {
+		t.Fatalf("ReadModelInfo() error = %v", err)
+	}
+	if got := info.KeyValues["tokenizer.ggml.model"]; got != "gpt2" {
+		t.Fatalf("tokenizer.ggml.model = %v, want gpt2", got)
+	}
+}
+
+func TestEncodeKeyValuesUnsupportedType(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeKeyValues(&buf, map[string]any{"bad": struct{}{}}); err == nil {
+		t.Fatal("expected error")
+	}
+}
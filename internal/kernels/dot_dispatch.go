@@ -0,0 +1,75 @@
+package kernels
+
+var (
+	dotF32x8Impl       = dotF32x8Generic
+	dotF32x8ScaledImpl = dotF32x8ScaledGeneric
+)
+
+// DotF32x8 returns the dot product of a and b accumulated in eight float32
+// lanes, reduced as ((l0+l1)+(l2+l3)) + ((l4+l5)+(l6+l7)), with the tail past
+// the last multiple of 8 added sequentially. The order is fixed so every
+// implementation returns the same bits.
+func DotF32x8(a, b []float32) float32 {
+	return dotF32x8Impl(a, b)
+}
+
+// DotF32x8Scaled is DotF32x8 with b[i] replaced by b[i]*scale.
+func DotF32x8Scaled(a, b []float32, scale float32) float32 {
+	return dotF32x8ScaledImpl(a, b, scale)
+}
+
+// The float32 conversions below stop the compiler from fusing the
+// multiply-adds on FMA targets (arm64, GOAMD64=v3), which would change the
+// rounding relative to the SIMD kernels.
+
+func dotF32x8Generic(a, b []float32) float32 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	var sum0, sum1, sum2, sum3 float32
+	var sum4, sum5, sum6, sum7 float32
+	i := 0
+	for ; i+7 < n; i += 8 {
+		sum0 += float32(a[i] * b[i])
+		sum1 += float32(a[i+1] * b[i+1])
+		sum2 += float32(a[i+2] * b[i+2])
+		sum3 += float32(a[i+3] * b[i+3])
+		sum4 += float32(a[i+4] * b[i+4])
+		sum5 += float32(a[i+5] * b[i+5])
+		sum6 += float32(a[i+6] * b[i+6])
+		sum7 += float32(a[i+7] * b[i+7])
+	}
+	sum := (sum0 + sum1) + (sum2 + sum3)
+	sum += (sum4 + sum5) + (sum6 + sum7)
+	for ; i < n; i++ {
+		sum += float32(a[i] * b[i])
+	}
+	return sum
+}
+
+func dotF32x8ScaledGeneric(a, b []float32, scale float32) float32 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	var sum0, sum1, sum2, sum3 float32
+	var sum4, sum5, sum6, sum7 float32
+	i := 0
+	for ; i+7 < n; i += 8 {
+		sum0 += float32(a[i] * (b[i] * scale))
+		sum1 += float32(a[i+1] * (b[i+1] * scale))
+		sum2 += float32(a[i+2] * (b[i+2] * scale))
+		sum3 += float32(a[i+3] * (b[i+3] * scale))
+		sum4 += float32(a[i+4] * (b[i+4] * scale))
+		sum5 += float32(a[i+5] * (b[i+5] * scale))
+		sum6 += float32(a[i+6] * (b[i+6] * scale))
+		sum7 += float32(a[i+7] * (b[i+7] * scale))
+	}
+	sum := (sum0 + sum1) + (sum2 + sum3)
+	sum += (sum4 + sum5) + (sum6 + sum7)
+	for ; i < n; i++ {
+		sum += float32(a[i] * (b[i] * scale))
+	}
+	return sum
+}
@@ -0,0 +1,82 @@
+//go:build amd64 && cgo
+
+package kernels
+
+import "testing"
+
+func requireAVX512VNNI(t *testing.T) {
+	t.Helper()
+	if !hasAVX512VNNI {
+		t.Skip("CPU lacks AVX-512 VNNI")
+	}
+}
+
+func TestMatVecI2SI8SAVX512MatchesRef(t *testing.T) {
+	requireAVX512VNNI(t)
+	const weightScale, actScale = float32(1.3), float32(17.75)
+	for _, shape := range [][2]int{{256, 64}, {512, 7}, {128, 3}, {200, 5}} {
+		rows, cols := shape[0], shape[1]
+
+		packed, vec, actSum := makeI2SI8SCase(rows, cols, rows, int64(rows+cols))
+		want := make([]float32, cols)
+		got := make([]float32, cols)
+		MatVecTI2SI8SRef(want, packed, rows, cols, vec, weightScale, actScale)
+		matVecTI2SI8SAVX512(got, packed, rows, cols, vec, weightScale, actScale, actSum)
+		for c := range want {
+			if got[c] != want[c] {
+				t.Fatalf("T %dx%d col %d = %v, want %v", rows, cols, c, got[c], want[c])
+			}
+		}
+
+		packed, vec, actSum = makeI2SI8SCase(rows, cols, cols, int64(rows*cols))
+		want = make([]float32, rows)
+		got = make([]float32, rows)
+		MatVecI2SI8SRef(want, packed, rows, cols, vec, weightScale, actScale)
+		matVecI2SI8SAVX512(got, packed, rows, cols, vec, weightScale, actScale, actSum)
+		for r := range want {
+			if got[r] != want[r] {
+				t.Fatalf("%dx%d row %d = %v, want %v", rows, cols, r, got[r], want[r])
+			}
+		}
+	}
+}
+
+// Raw code 3 and arbitrary act_sum values must agree with the AVX2 tier.
+func TestMatVecI2SI8SAVX512MatchesAVX2(t *testing.T) {
+	requireAVX512VNNI(t)
+	const rows, cols = 384, 29
+	packed, vec, _ := makeI2SI8SCase(rows, cols, rows, 5)
+	for i := 0; i < len(packed); i += 3 {
+		packed[i] |= 0x0f
+	}
+	want := make([]float32, cols)
+	got := make([]float32, cols)
+	matVecTI2SI8SAVX2(want, packed, rows, cols, vec, 0.5, 9, 31)
+	matVecTI2SI8SAVX512(got, packed, rows, cols, vec, 0.5, 9, 31)
+	for c := range want {
+		if got[c] != want[c] {
+			t.Fatalf("T col %d = %v, want %v", c, got[c], want[c])
+		}
+	}
+
+	vec = vec[:cols]
+	want = make([]float32, rows)
+	got = make([]float32, rows)
+	matVecI2SI8SAVX2(want, packed, rows, cols, vec, 0.5, 9, -4)
+	matVecI2SI8SAVX512(got, packed, rows, cols, vec, 0.5, 9, -4)
+	for r := range want {
+		if got[r] != want[r] {
+			t.Fatalf("row %d = %v, want %v", r, got[r], want[r])
+		}
+	}
+
+	part := make([]float32, rows)
+	if !matVecI2SI8SAVX512Range(part, packed, rows, cols, vec, 0.5, 9, -4, 0, cols) {
+		t.Fatal("full-width range rejected")
+	}
+	for r := range want {
+		if part[r] != want[r] {
+			t.Fatalf("range row %d = %v, want %v", r, part[r], want[r])
+		}
+	}
+}
@@ -0,0 +1,145 @@
+#include <stdint.h>
+#include <immintrin.h>
+
+// AVX-512 VNNI i2_s x i8_s kernels. Same contract as i2s_i8s_avx2.c: raw
+// 2-bit codes (0..3) are multiplied by the activations with vpdpbusd, which
+// is exact (no saturation) for these ranges, and the act_sum correction is
+// applied once per output. The functions carry their own target attribute
+// so the rest of the package is not compiled for AVX-512.
+
+#define BITNET_AVX512_TARGET __attribute__((target("avx512f,avx512bw,avx512vl,avx512vnni")))
+
+int bitnet_has_avx512_vnni(void) {
+#if defined(__GNUC__)
+    __builtin_cpu_init();
+    return __builtin_cpu_supports("avx512f") && __builtin_cpu_supports("avx512bw") &&
+           __builtin_cpu_supports("avx512vl") && __builtin_cpu_supports("avx512vnni");
+#else
+    return 0;
+#endif
+}
+
+static int32_t i2s_code_scalar(const unsigned char *packed, int idx) {
+    const unsigned char b = packed[(idx / 128) * 32 + (idx % 32)];
+    return (b >> (6 - 2 * ((idx % 128) / 32))) & 0x3;
+}
+
+BITNET_AVX512_TARGET
+void matvec_t_i2s_i8s_avx512(float *dst, const unsigned char *packed, int rows, int cols, const signed char *vec, float weight_scale, float act_scale, int act_sum) {
+    if (rows <= 0 || cols <= 0) {
+        return;
+    }
+    const float scale = (act_scale == 0.0f) ? 0.0f : (weight_scale / act_scale);
+    if (rows % 128 != 0) {
+        for (int c = 0; c < cols; c++) {
+            int32_t sum = 0;
+            for (int r = 0; r < rows; r++) {
+                sum += i2s_code_scalar(packed, r + rows * c) * (int32_t)vec[r];
+            }
+            dst[c] = (float)(sum - act_sum) * scale;
+        }
+        return;
+    }
+    const int blocks = rows / 128;
+    const __m512i mask = _mm512_set1_epi8(3);
+    // Low 256 bits take group 0 (>>6) or 2 (>>2), high bits group 1 (>>4) or 3 (>>0),
+    // so each zmm lines up with 64 contiguous activations.
+    const __m512i shift01 = _mm512_inserti64x4(_mm512_set1_epi16(6), _mm256_set1_epi16(4), 1);
+    const __m512i shift23 = _mm512_inserti64x4(_mm512_set1_epi16(2), _mm256_set1_epi16(0), 1);
+    for (int c = 0; c < cols; c++) {
+        const unsigned char *p = packed + (size_t)c * blocks * 32;
+        __m512i acc = _mm512_setzero_si512();
+        for (int b = 0; b < blocks; b++) {
+            const __m512i raw = _mm512_broadcast_i64x4(_mm256_loadu_si256((const __m256i *)(p + b * 32)));
+            const __m512i c01 = _mm512_and_si512(_mm512_srlv_epi16(raw, shift01), mask);
+            const __m512i c23 = _mm512_and_si512(_mm512_srlv_epi16(raw, shift23), mask);
+            acc = _mm512_dpbusd_epi32(acc, c01, _mm512_loadu_si512((const void *)(vec + b * 128)));
+            acc = _mm512_dpbusd_epi32(acc, c23, _mm512_loadu_si512((const void *)(vec + b * 128 + 64)));
+        }
+        dst[c] = (float)(_mm512_reduce_add_epi32(acc) - act_sum) * scale;
+    }
+}
+
+// Lane j of the 4-column interleave for quarter k covers these block bytes.
+static const int g_quarter_gp[4][8] = {
+    {0, 1, 2, 3, 16, 17, 18, 19},
+    {4, 5, 6, 7, 20, 21, 22, 23},
+    {8, 9, 10, 11, 24, 25, 26, 27},
+    {12, 13, 14, 15, 28, 29, 30, 31},
+};
+
+BITNET_AVX512_TARGET
+void matvec_i2s_i8s_avx512(float *dst, const unsigned char *packed, int rows, int cols, const signed char *vec, float weight_scale, float act_scale, int act_sum) {
+    if (rows <= 0 || cols <= 0) {
+        return;
+    }
+    const float scale = (act_scale == 0.0f) ? 0.0f : (weight_scale / act_scale);
+    if (rows % 128 != 0) {
+        for (int r = 0; r < rows; r++) {
+            int32_t sum = 0;
+            for (int c = 0; c < cols; c++) {
+                sum += i2s_code_scalar(packed, r + rows * c) * (int32_t)vec[c];
+            }
+            dst[r] = (float)(sum - act_sum) * scale;
+        }
+        return;
+    }
+    const size_t stride = (size_t)(rows / 128) * 32;
+    const __m256i mask = _mm256_set1_epi8(3);
+    for (int rb = 0; rb < rows; rb += 128) {
+        // acc[g][k]: code group g (rows g*32..g*32+31), interleave quarter k.
+        __m256i acc[4][4];
+        for (int g = 0; g < 4; g++) {
+            for (int k = 0; k < 4; k++) {
+                acc[g][k] = _mm256_setzero_si256();
+            }
+        }
+        const unsigned char *base = packed + (size_t)(rb / 128) * 32;
+        for (int c = 0; c < cols; c += 4) {
+            // Four columns at a time: bytes of one row land in one dword so
+            // vpdpbusd sums code*vec over the four columns. Missing columns
+            // reuse the last block with a zero activation.
+            const int n = (cols - c < 4) ? cols - c : 4;
+            const unsigned char *pc[4];
+            int8_t v4[4] = {0, 0, 0, 0};
+            for (int j = 0; j < 4; j++) {
+                const int cj = c + (j < n ? j : n - 1);
+                pc[j] = base + (size_t)cj * stride;
+                if (j < n) {
+                    v4[j] = vec[cj];
+                }
+            }
+            int32_t vword;
+            __builtin_memcpy(&vword, v4, sizeof(vword));
+            const __m256i vv = _mm256_set1_epi32(vword);
+            const __m256i p0 = _mm256_loadu_si256((const __m256i *)pc[0]);
+            const __m256i p1 = _mm256_loadu_si256((const __m256i *)pc[1]);
+            const __m256i p2 = _mm256_loadu_si256((const __m256i *)pc[2]);
+            const __m256i p3 = _mm256_loadu_si256((const __m256i *)pc[3]);
+            const __m256i t01lo = _mm256_unpacklo_epi8(p0, p1);
+            const __m256i t01hi = _mm256_unpackhi_epi8(p0, p1);
+            const __m256i t23lo = _mm256_unpacklo_epi8(p2, p3);
+            const __m256i t23hi = _mm256_unpackhi_epi8(p2, p3);
+            __m256i q[4];
+            q[0] = _mm256_unpacklo_epi16(t01lo, t23lo);
+            q[1] = _mm256_unpackhi_epi16(t01lo, t23lo);
+            q[2] = _mm256_unpacklo_epi16(t01hi, t23hi);
+            q[3] = _mm256_unpackhi_epi16(t01hi, t23hi);
+            for (int k = 0; k < 4; k++) {
+                acc[0][k] = _mm256_dpbusd_epi32(acc[0][k], _mm256_and_si256(_mm256_srli_epi16(q[k], 6), mask), vv);
+                acc[1][k] = _mm256_dpbusd_epi32(acc[1][k], _mm256_and_si256(_mm256_srli_epi16(q[k], 4), mask), vv);
+                acc[2][k] = _mm256_dpbusd_epi32(acc[2][k], _mm256_and_si256(_mm256_srli_epi16(q[k], 2), mask), vv);
+                acc[3][k] = _mm256_dpbusd_epi32(acc[3][k], _mm256_and_si256(q[k], mask), vv);
+            }
+        }
+        for (int g = 0; g < 4; g++) {
+            for (int k = 0; k < 4; k++) {
+                int32_t lanes[8];
+                _mm256_storeu_si256((__m256i *)lanes, acc[g][k]);
+                for (int j = 0; j < 8; j++) {
+                    dst[rb + g * 32 + g_quarter_gp[k][j]] = (float)(lanes[j] - act_sum) * scale;
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,105 @@
+//go:build amd64 || arm64
+
+package kernels
+
+import "bitnet-go/internal/kernels/simd"
+
+// The assembly i2s x i8s kernels mirror i2s_i8s_avx2.c exactly: raw 2-bit
+// codes are multiplied by the activations in int32, and the act_sum
+// correction and scale are applied once per output.
+
+func i2sI8SSIMDScale(weightScale, actScale float32) float32 {
+	if actScale == 0 {
+		return 0
+	}
+	return weightScale / actScale
+}
+
+func i2sI8SSIMDShapeOK(packed []byte, rows, cols, dstLen, dstNeed, vecLen, vecNeed int) bool {
+	if rows <= 0 || cols <= 0 {
+		return false
+	}
+	if dstLen < dstNeed || vecLen < vecNeed {
+		return false
+	}
+	return len(packed) >= i2sPackedLen(rows*cols)
+}
+
+func matVecTI2SI8SSIMD(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32) {
+	if !i2sI8SSIMDShapeOK(packed, rows, cols, len(dst), cols, len(vec), rows) {
+		return
+	}
+	matVecTI2SI8SSIMDCols(dst, packed, rows, vec, i2sI8SSIMDScale(weightScale, actScale), actSum, 0, cols)
+}
+
+func matVecTI2SI8SSIMDRange(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32, cStart, cEnd int) bool {
+	if cStart < 0 || cEnd > cols || cStart >= cEnd || rows%128 != 0 {
+		return false
+	}
+	if !i2sI8SSIMDShapeOK(packed, rows, cols, len(dst), cols, len(vec), rows) {
+		return false
+	}
+	matVecTI2SI8SSIMDCols(dst, packed, rows, vec, i2sI8SSIMDScale(weightScale, actScale), actSum, cStart, cEnd)
+	return true
+}
+
+func matVecTI2SI8SSIMDCols(dst []float32, packed []byte, rows int, vec []int8, scale float32, actSum int32, cStart, cEnd int) {
+	if rows%128 != 0 {
+		for c := cStart; c < cEnd; c++ {
+			var sum int32
+			for r := 0; r < rows; r++ {
+				sum += int32(i2sPackedAt(packed, r+rows*c)) * int32(vec[r])
+			}
+			dst[c] = float32(sum-actSum) * scale
+		}
+		return
+	}
+	colBytes := rows / 128 * 32
+	vec = vec[:rows]
+	for c := cStart; c < cEnd; c++ {
+		sum := simd.I2SI8SDot(packed[c*colBytes:(c+1)*colBytes], vec)
+		dst[c] = float32(sum-actSum) * scale
+	}
+}
+
+func matVecI2SI8SSIMD(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32) {
+	if !i2sI8SSIMDShapeOK(packed, rows, cols, len(dst), rows, len(vec), cols) {
+		return
+	}
+	matVecI2SI8SSIMDCols(dst, packed, rows, vec, i2sI8SSIMDScale(weightScale, actScale), actSum, 0, cols)
+}
+
+func matVecI2SI8SSIMDRange(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32, cStart, cEnd int) bool {
+	if cStart < 0 || cEnd > cols || cStart >= cEnd || rows%128 != 0 {
+		return false
+	}
+	if !i2sI8SSIMDShapeOK(packed, rows, cols, len(dst), rows, len(vec), cols) {
+		return false
+	}
+	matVecI2SI8SSIMDCols(dst, packed, rows, vec, i2sI8SSIMDScale(weightScale, actScale), actSum, cStart, cEnd)
+	return true
+}
+
+// matVecI2SI8SSIMDCols writes dst[0:rows] from columns [cStart, cEnd) only,
+// as the range kernels do for the partial sums of a column split.
+func matVecI2SI8SSIMDCols(dst []float32, packed []byte, rows int, vec []int8, scale float32, actSum int32, cStart, cEnd int) {
+	if rows%128 != 0 {
+		for r := 0; r < rows; r++ {
+			var sum int32
+			for c := cStart; c < cEnd; c++ {
+				sum += int32(i2sPackedAt(packed, r+rows*c)) * int32(vec[c])
+			}
+			dst[r] = float32(sum-actSum) * scale
+		}
+		return
+	}
+	stride := rows / 128 * 32
+	var sums [128]int32
+	for rb := 0; rb < rows; rb += 128 {
+		sums = [128]int32{}
+		simd.I2SI8SAccum(&sums, packed[cStart*stride+rb/128*32:], stride, vec[cStart:cEnd])
+		for i := 0; i < 128; i++ {
+			dst[rb+i] = float32(sums[i]-actSum) * scale
+		}
+	}
+}
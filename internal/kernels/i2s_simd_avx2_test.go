@@ -0,0 +1,39 @@
+//go:build amd64 && cgo
+
+package kernels
+
+import "testing"
+
+// The assembly kernels replace the C AVX2 ones in cgo-free builds, so they
+// must agree bit for bit, including the act_sum correction and codes of 3.
+func TestMatVecI2SI8SSIMDMatchesAVX2(t *testing.T) {
+	requireSIMD(t)
+	const rows, cols = 512, 48
+	const weightScale, actScale = float32(0.83), float32(41.25)
+
+	packed, vec, _ := makeI2SI8SCase(rows, cols, rows, 21)
+	// Exercise the raw code 3, which the reference maps to zero.
+	for i := 0; i < len(packed); i += 5 {
+		packed[i] |= 0xc0
+	}
+	want := make([]float32, cols)
+	got := make([]float32, cols)
+	matVecTI2SI8SAVX2(want, packed, rows, cols, vec, weightScale, actScale, 123)
+	matVecTI2SI8SSIMD(got, packed, rows, cols, vec, weightScale, actScale, 123)
+	for c := range want {
+		if got[c] != want[c] {
+			t.Fatalf("T col %d = %v, want %v", c, got[c], want[c])
+		}
+	}
+
+	vec = vec[:cols]
+	want = make([]float32, rows)
+	got = make([]float32, rows)
+	matVecI2SI8SAVX2(want, packed, rows, cols, vec, weightScale, actScale, -77)
+	matVecI2SI8SSIMD(got, packed, rows, cols, vec, weightScale, actScale, -77)
+	for r := range want {
+		if got[r] != want[r] {
+			t.Fatalf("row %d = %v, want %v", r, got[r], want[r])
+		}
+	}
+}
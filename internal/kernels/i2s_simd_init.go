@@ -0,0 +1,17 @@
+//go:build arm64 || (amd64 && !cgo)
+
+package kernels
+
+import "os"
+
+// Without cgo (and on arm64, which has no C i2s x i8s kernel) the assembly
+// kernels are the fast i2s x i8s path.
+func init() {
+	if !useSIMD() || os.Getenv("BITNET_I2S_I8S_DISABLE_FAST") == "1" {
+		return
+	}
+	matVecI2SI8SFast = matVecI2SI8SSIMD
+	matVecI2SI8SFastRange = matVecI2SI8SSIMDRange
+	matVecTI2SI8SFast = matVecTI2SI8SSIMD
+	matVecTI2SI8SFastRange = matVecTI2SI8SSIMDRange
+}
@@ -0,0 +1,147 @@
+//go:build amd64 || arm64
+
+package kernels
+
+import (
+	"math/rand"
+	"testing"
+
+	"bitnet-go/internal/kernels/simd"
+)
+
+func requireSIMD(t *testing.T) {
+	t.Helper()
+	if !simd.Available {
+		t.Skip("assembly kernels not available on this CPU")
+	}
+}
+
+// makeI2SI8SCase returns a random ternary i2_s matrix, an i8_s vector of
+// length n and the vector's sum, so the act_sum correction is exact.
+func makeI2SI8SCase(rows, cols, n int, seed int64) ([]byte, []int8, int32) {
+	rng := rand.New(rand.NewSource(seed))
+	vals := make([]int, rows*cols)
+	for i := range vals {
+		vals[i] = rng.Intn(3) - 1
+	}
+	vec := make([]int8, n)
+	var sum int32
+	for i := range vec {
+		vec[i] = int8(rng.Intn(256) - 128)
+		sum += int32(vec[i])
+	}
+	return packI2SQuant(vals), vec, sum
+}
+
+func TestMatVecI2SI8SSIMDMatchesRef(t *testing.T) {
+	requireSIMD(t)
+	const weightScale, actScale = float32(1.7), float32(23.5)
+	for _, shape := range [][2]int{{256, 96}, {384, 7}, {200, 9}} {
+		rows, cols := shape[0], shape[1]
+
+		packed, vec, actSum := makeI2SI8SCase(rows, cols, rows, int64(rows))
+		want := make([]float32, cols)
+		got := make([]float32, cols)
+		MatVecTI2SI8SRef(want, packed, rows, cols, vec, weightScale, actScale)
+		matVecTI2SI8SSIMD(got, packed, rows, cols, vec, weightScale, actScale, actSum)
+		for c := range want {
+			if got[c] != want[c] {
+				t.Fatalf("T %dx%d col %d = %v, want %v", rows, cols, c, got[c], want[c])
+			}
+		}
+
+		packed, vec, actSum = makeI2SI8SCase(rows, cols, cols, int64(cols))
+		want = make([]float32, rows)
+		got = make([]float32, rows)
+		MatVecI2SI8SRef(want, packed, rows, cols, vec, weightScale, actScale)
+		matVecI2SI8SSIMD(got, packed, rows, cols, vec, weightScale, actScale, actSum)
+		for r := range want {
+			if got[r] != want[r] {
+				t.Fatalf("%dx%d row %d = %v, want %v", rows, cols, r, got[r], want[r])
+			}
+		}
+	}
+}
+
+func TestMatVecI2SI8SSIMDRangeMatchesFull(t *testing.T) {
+	requireSIMD(t)
+	const rows, cols = 256, 40
+	packed, vec, actSum := makeI2SI8SCase(rows, cols, rows, 11)
+
+	want := make([]float32, cols)
+	got := make([]float32, cols)
+	matVecTI2SI8SSIMD(want, packed, rows, cols, vec, 1, 3, actSum)
+	for _, span := range [][2]int{{0, 13}, {13, 29}, {29, cols}} {
+		if !matVecTI2SI8SSIMDRange(got, packed, rows, cols, vec, 1, 3, actSum, span[0], span[1]) {
+			t.Fatalf("T range %v rejected", span)
+		}
+	}
+	for c := range want {
+		if got[c] != want[c] {
+			t.Fatalf("T range col %d = %v, want %v", c, got[c], want[c])
+		}
+	}
+
+	// Column chunks of the non-transposed kernel must sum to the full result.
+	packed, vec, _ = makeI2SI8SCase(rows, cols, cols, 12)
+	full := make([]float32, rows)
+	matVecI2SI8SSIMD(full, packed, rows, cols, vec, 1, 1, 0)
+	sum := make([]float32, rows)
+	part := make([]float32, rows)
+	for _, span := range [][2]int{{0, 17}, {17, cols}} {
+		if !matVecI2SI8SSIMDRange(part, packed, rows, cols, vec, 1, 1, 0, span[0], span[1]) {
+			t.Fatalf("range %v rejected", span)
+		}
+		for r := range sum {
+			sum[r] += part[r]
+		}
+	}
+	for r := range full {
+		if sum[r] != full[r] {
+			t.Fatalf("range row %d = %v, want %v", r, sum[r], full[r])
+		}
+	}
+}
+
+func TestSIMDDispatchMatchesGeneric(t *testing.T) {
+	requireSIMD(t)
+	rng := rand.New(rand.NewSource(5))
+	for _, n := range []int{1, 7, 64, 2560, 2563} {
+		x := make([]float32, n)
+		w := make([]float32, n)
+		for i := range x {
+			x[i] = float32(rng.NormFloat64())
+			w[i] = 1 + float32(rng.NormFloat64())*0.1
+		}
+
+		wantQ := make([]int8, n)
+		gotQ := make([]int8, n)
+		wantScale, wantSum := quantizeRowI8SGeneric(wantQ, x)
+		gotScale, gotSum := quantizeRowI8SSIMD(gotQ, x)
+		if gotScale != wantScale || gotSum != wantSum {
+			t.Fatalf("n=%d quantize scale/sum = %v/%d, want %v/%d", n, gotScale, gotSum, wantScale, wantSum)
+		}
+		for i := range wantQ {
+			if gotQ[i] != wantQ[i] {
+				t.Fatalf("n=%d quantize[%d] = %d, want %d", n, i, gotQ[i], wantQ[i])
+			}
+		}
+
+		wantN := make([]float32, n)
+		gotN := make([]float32, n)
+		rmsNormOpt(wantN, x, w, 1e-5)
+		rmsNormSIMD(gotN, x, w, 1e-5)
+		for i := range wantN {
+			if gotN[i] != wantN[i] {
+				t.Fatalf("n=%d rmsnorm[%d] = %v, want %v", n, i, gotN[i], wantN[i])
+			}
+		}
+
+		if got, want := simd.DotF32x8(x, w), dotF32x8Generic(x, w); got != want {
+			t.Fatalf("n=%d dot = %v, want %v", n, got, want)
+		}
+		if got, want := simd.DotF32x8Scaled(x, w, 0.3), dotF32x8ScaledGeneric(x, w, 0.3); got != want {
+			t.Fatalf("n=%d scaled dot = %v, want %v", n, got, want)
+		}
+	}
+}
@@ -1,10 +1,5 @@
 package kernels
 
-import (
-	"runtime"
-	"sync"
-)
-
 var (
 	matVecTParMinRows = envIntArch("BITNET_MATVECT_PAR_MIN_ROWS", 512)
 	matVecTParMinCols = envIntArch("BITNET_MATVECT_PAR_MIN_COLS", 8192)
@@ -81,42 +76,31 @@ func matVecTOpt(dst, mat []float32, rows, cols int, vec []float32) {
 	if !matchGGML() && rows >= matVecTParMinRows && cols >= matVecTParMinCols {
 		workers := matVecTParWorkers
 		if workers <= 0 {
-			workers = runtime.GOMAXPROCS(0)
+			workers = DefaultScheduler().Workers()
 		}
 		if workers > cols {
 			workers = cols
 		}
 		if workers > 1 {
 			chunk := (cols + workers - 1) / workers
-			var wg sync.WaitGroup
-			wg.Add(workers)
-			for w := 0; w < workers; w++ {
-				start := w * chunk
-				end := start + chunk
-				if end > cols {
-					end = cols
-				}
-				go func(start, end int) {
-					defer wg.Done()
-					for c := start; c < end; c++ {
-						base := rows * c
-						var sum0, sum1, sum2, sum3 float64
-						r := 0
-						for ; r+3 < rows; r += 4 {
-							sum0 += float64(mat[base+r]) * float64(vec[r])
-							sum1 += float64(mat[base+r+1]) * float64(vec[r+1])
-							sum2 += float64(mat[base+r+2]) * float64(vec[r+2])
-							sum3 += float64(mat[base+r+3]) * float64(vec[r+3])
-						}
-						sum := sum0 + sum1 + sum2 + sum3
-						for ; r < rows; r++ {
-							sum += float64(mat[base+r]) * float64(vec[r])
-						}
-						dst[c] = float32(sum)
+			DefaultScheduler().ParallelRanges(cols, chunk, func(start, end int) {
+				for c := start; c < end; c++ {
+					base := rows * c
+					var sum0, sum1, sum2, sum3 float64
+					r := 0
+					for ; r+3 < rows; r += 4 {
+						sum0 += float64(mat[base+r]) * float64(vec[r])
+						sum1 += float64(mat[base+r+1]) * float64(vec[r+1])
+						sum2 += float64(mat[base+r+2]) * float64(vec[r+2])
+						sum3 += float64(mat[base+r+3]) * float64(vec[r+3])
 					}
-				}(start, end)
-			}
-			wg.Wait()
+					sum := sum0 + sum1 + sum2 + sum3
+					for ; r < rows; r++ {
+						sum += float64(mat[base+r]) * float64(vec[r])
+					}
+					dst[c] = float32(sum)
+				}
+			})
 			return
 		}
 	}
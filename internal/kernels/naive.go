@@ -89,6 +89,54 @@ func MulSiluInto(dst, gate, up []float32) {
 	}
 }
 
+// GeluInto computes dst[i] = gelu(x[i]) using the tanh approximation from ggml.
+func GeluInto(dst, x []float32) {
+	n := len(dst)
+	if len(x) < n {
+		n = len(x)
+	}
+	const sqrt2OverPi = 0.79788456080286535587989211986876
+	for i := 0; i < n; i++ {
+		v := float64(x[i])
+		dst[i] = float32(0.5 * v * (1 + math.Tanh(sqrt2OverPi*v*(1+0.044715*v*v))))
+	}
+}
+
+// LayerNormInto computes dst = (x - mean) / sqrt(var + eps) * weight + bias.
+// A nil bias is treated as zero.
+func LayerNormInto(dst, x, weight, bias []float32, eps float32) {
+	n := len(dst)
+	if len(x) < n {
+		n = len(x)
+	}
+	if len(weight) < n {
+		n = len(weight)
+	}
+	if n == 0 {
+		return
+	}
+	var mean float64
+	for i := 0; i < n; i++ {
+		mean += float64(x[i])
+	}
+	mean /= float64(n)
+	var variance float64
+	for i := 0; i < n; i++ {
+		d := float64(x[i]) - mean
+		variance += d * d
+	}
+	variance /= float64(n)
+	inv := 1.0 / math.Sqrt(variance+float64(eps))
+	for i := 0; i < n; i++ {
+		v := float32((float64(x[i]) - mean) * inv)
+		v *= weight[i]
+		if i < len(bias) {
+			v += bias[i]
+		}
+		dst[i] = v
+	}
+}
+
 // MatVec computes dst = mat * vec where mat is GGML column-major [rows][cols]
 // with contiguous dimension ne0=rows.
 func MatVec(dst, mat []float32, rows, cols int, vec []float32) {
@@ -193,6 +241,46 @@ func Float16ToFloat32(h uint16) float32 {
 	}
 }
 
+// Float32ToFloat16 converts f to IEEE half precision with round-to-nearest-even,
+// matching ggml_fp32_to_fp16 for finite inputs.
+func Float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16((bits >> 16) & 0x8000)
+	exp := int((bits >> 23) & 0xff)
+	mant := bits & 0x007fffff
+
+	if exp == 0xff {
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return sign | 0x7c00
+	}
+	if e <= 0 {
+		if e < -10 {
+			return sign
+		}
+		mant |= 0x00800000
+		shift := uint32(14 - e)
+		half := uint32(1) << (shift - 1)
+		rounded := mant >> shift
+		rem := mant & ((uint32(1) << shift) - 1)
+		if rem > half || (rem == half && rounded&1 == 1) {
+			rounded++
+		}
+		return sign | uint16(rounded)
+	}
+	out := uint32(e)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && out&1 == 1) {
+		out++
+	}
+	return sign | uint16(out)
+}
+
 func matVecGeneric(dst, mat []float32, rows, cols int, vec []float32) {
 	if rows <= 0 || cols <= 0 {
 		return
@@ -91,6 +91,34 @@ func TestRMSNormInto(t *testing.T) {
 	}
 }
 
+func TestLayerNormInto(t *testing.T) {
+	dst := make([]float32, 4)
+	x := []float32{1, 2, 3, 4}
+	w := []float32{1, 1, 2, 2}
+	b := []float32{0, 0, 0, 1}
+	LayerNormInto(dst, x, w, b, 0)
+	// mean=2.5, var=1.25
+	want := []float32{-1.3416408, -0.4472136, 0.8944272, 3.6832816}
+	for i := range want {
+		d := dst[i] - want[i]
+		if d < -1e-5 || d > 1e-5 {
+			t.Fatalf("dst[%d] = %f, want %f", i, dst[i], want[i])
+		}
+	}
+}
+
+func TestGeluInto(t *testing.T) {
+	dst := make([]float32, 3)
+	GeluInto(dst, []float32{-3, 0, 1})
+	want := []float32{-0.0036373, 0, 0.8411920}
+	for i := range want {
+		d := dst[i] - want[i]
+		if d < -1e-5 || d > 1e-5 {
+			t.Fatalf("dst[%d] = %f, want %f", i, dst[i], want[i])
+		}
+	}
+}
+
 func TestRMSNormIntoMatchesOpt(t *testing.T) {
 	dstA := make([]float32, 16)
 	dstB := make([]float32, 16)
@@ -315,3 +343,31 @@ func packI2S(vals []int) []byte {
 	}
 	return packed
 }
+
+func TestFloat32ToFloat16RoundTrip(t *testing.T) {
+	for h := 0; h < 0x10000; h++ {
+		exp := (h >> 10) & 0x1f
+		if exp == 0x1f && h&0x03ff != 0 {
+			continue // NaN payloads are not preserved.
+		}
+		f := Float16ToFloat32(uint16(h))
+		if got := Float32ToFloat16(f); got != uint16(h) {
+			t.Fatalf("Float32ToFloat16(%g) = %#04x, want %#04x", f, got, h)
+		}
+	}
+	cases := []struct {
+		in   float32
+		want uint16
+	}{
+		{1 + 2.0/4096, 0x3c00},  // halfway, round to even
+		{1 + 6.0/4096, 0x3c02},  // halfway, round to even
+		{70000, 0x7c00},         // overflow to +inf
+		{-1e-10, 0x8000},        // underflow to -0
+		{5.9604645e-08, 0x0001}, // smallest subnormal
+	}
+	for _, tc := range cases {
+		if got := Float32ToFloat16(tc.in); got != tc.want {
+			t.Fatalf("Float32ToFloat16(%g) = %#04x, want %#04x", tc.in, got, tc.want)
+		}
+	}
+}
@@ -5,9 +5,15 @@ import (
 	"sync"
 )
 
+var quantizeRowI8SImpl = quantizeRowI8SGeneric
+
 // QuantizeRowI8S quantizes src into dst using i8_s rules and returns the
 // dequantization scale and sum of quantized values.
 func QuantizeRowI8S(dst []int8, src []float32) (scale float32, sum int32) {
+	return quantizeRowI8SImpl(dst, src)
+}
+
+func quantizeRowI8SGeneric(dst []int8, src []float32) (scale float32, sum int32) {
 	n := len(src)
 	if len(dst) < n {
 		n = len(dst)
@@ -32,7 +38,9 @@ func QuantizeRowI8S(dst []int8, src []float32) (scale float32, sum int32) {
 
 	scale = 127.0 / float32(maxAbs)
 	for i := 0; i < n; i++ {
-		q := nearestInt(src[i] * scale)
+		// The conversion keeps the product rounded before nearestInt adds
+		// its bias, so FMA targets match amd64 and the SIMD kernels.
+		q := nearestInt(float32(src[i] * scale))
 		if q < -128 {
 			q = -128
 		} else if q > 127 {
@@ -271,33 +279,15 @@ func matVecI2SI8SFastParallel(dst []float32, packed []byte, rows, cols int, vec
 	partials := acquireI2SPartialBuf(rows * numParts)
 	defer releaseI2SPartialBuf(partials)
 	outs := make([][]float32, numParts)
-	starts := make([]int, numParts)
-	ends := make([]int, numParts)
-	for i := 0; i < numParts; i++ {
-		start := i * chunk
-		end := start + chunk
-		if end > cols {
-			end = cols
-		}
-		starts[i] = start
-		ends[i] = end
-		base := i * rows
-		outs[i] = partials[base : base+rows]
-	}
-	var wg sync.WaitGroup
-	wg.Add(numParts)
-	for i := 0; i < numParts; i++ {
-		start := starts[i]
-		end := ends[i]
-		out := outs[i]
-		go func(start, end int, out []float32) {
-			defer wg.Done()
-			if !matVecI2SI8SFastRange(out, packed, rows, cols, vec, weightScale, actScale, 0, start, end) {
-				matVecI2SI8SRangeCols(out, packed, rows, cols, vec, weightScale, actScale, 0, start, end)
-			}
-		}(start, end, out)
+	for i := range outs {
+		outs[i] = partials[i*rows : (i+1)*rows]
 	}
-	wg.Wait()
+	DefaultScheduler().ParallelRanges(cols, chunk, func(start, end int) {
+		out := outs[start/chunk]
+		if !matVecI2SI8SFastRange(out, packed, rows, cols, vec, weightScale, actScale, 0, start, end) {
+			matVecI2SI8SRangeCols(out, packed, rows, cols, vec, weightScale, actScale, 0, start, end)
+		}
+	})
 
 	copy(dst, outs[0])
 	for i := 1; i < numParts; i++ {
@@ -566,30 +556,9 @@ func matVecI2SI8SParallel(dst []float32, packed []byte, rows, cols int, vec []in
 	if chunk < 1 {
 		chunk = 1
 	}
-	wg := acquireI2SI8SWG()
-	for start := 0; start < rows; start += chunk {
-		end := start + chunk
-		if end > rows {
-			end = rows
-		}
-		wg.Add(1)
-		submitI2SI8STask(i2sI8STask{
-			transposed: false,
-			dst:        dst,
-			packed:     packed,
-			rows:       rows,
-			cols:       cols,
-			vec:        vec,
-			weight:     weightScale,
-			act:        actScale,
-			actSum:     actSum,
-			start:      start,
-			end:        end,
-			wg:         wg,
-		})
-	}
-	wg.Wait()
-	releaseI2SI8SWG(wg)
+	runI2SI8SRanges(rows, chunk, func(start, end int) {
+		matVecI2SI8SRange(dst, packed, rows, cols, vec, weightScale, actScale, actSum, start, end)
+	})
 }
 
 func matVecI2SI8SRange(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32, rStart, rEnd int) {
@@ -653,30 +622,9 @@ func matVecTI2SI8SParallel(dst []float32, packed []byte, rows, cols int, vec []i
 	if chunk < 1 {
 		chunk = 1
 	}
-	wg := acquireI2SI8SWG()
-	for start := 0; start < cols; start += chunk {
-		end := start + chunk
-		if end > cols {
-			end = cols
-		}
-		wg.Add(1)
-		submitI2SI8STask(i2sI8STask{
-			transposed: true,
-			dst:        dst,
-			packed:     packed,
-			rows:       rows,
-			cols:       cols,
-			vec:        vec,
-			weight:     weightScale,
-			act:        actScale,
-			actSum:     actSum,
-			start:      start,
-			end:        end,
-			wg:         wg,
-		})
-	}
-	wg.Wait()
-	releaseI2SI8SWG(wg)
+	runI2SI8SRanges(cols, chunk, func(start, end int) {
+		matVecTI2SI8SRange(dst, packed, rows, cols, vec, weightScale, actScale, actSum, start, end)
+	})
 }
 
 func matVecTI2SI8SRange(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32, cStart, cEnd int) {
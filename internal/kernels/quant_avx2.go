@@ -25,6 +25,13 @@ func init() {
 	if os.Getenv("BITNET_I2S_I8S_DISABLE_FAST") == "1" {
 		return
 	}
+	if useAVX512VNNI() {
+		matVecI2SI8SFast = matVecI2SI8SAVX512
+		matVecI2SI8SFastRange = matVecI2SI8SAVX512Range
+		matVecTI2SI8SFast = matVecTI2SI8SAVX512
+		matVecTI2SI8SFastRange = matVecTI2SI8SAVX512Range
+		return
+	}
 	if os.Getenv("BITNET_FORCE_AVX2") == "1" || C.bitnet_has_avx2() != 0 {
 		matVecI2SI8SFast = matVecI2SI8SAVX2
 		matVecI2SI8SFastRange = matVecI2SI8SAVX2Range
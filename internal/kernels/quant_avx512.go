@@ -0,0 +1,125 @@
+//go:build amd64 && cgo
+
+package kernels
+
+/*
+#include <stdint.h>
+int bitnet_has_avx512_vnni(void);
+void matvec_t_i2s_i8s_avx512(float *dst, const unsigned char *packed, int rows, int cols, const signed char *vec, float weight_scale, float act_scale, int act_sum);
+void matvec_i2s_i8s_avx512(float *dst, const unsigned char *packed, int rows, int cols, const signed char *vec, float weight_scale, float act_scale, int act_sum);
+*/
+import "C"
+import (
+	"os"
+	"unsafe"
+)
+
+// useAVX512VNNI picks the AVX-512 VNNI i2_s+i8_s tier. BITNET_FORCE_AVX512=1
+// selects it without detection; BITNET_FORCE_AVX2=1 or
+// BITNET_I2S_I8S_DISABLE_AVX512=1 keep the AVX2 tier on VNNI hardware.
+func useAVX512VNNI() bool {
+	if os.Getenv("BITNET_FORCE_AVX512") == "1" {
+		return true
+	}
+	if os.Getenv("BITNET_FORCE_AVX2") == "1" || os.Getenv("BITNET_I2S_I8S_DISABLE_AVX512") == "1" {
+		return false
+	}
+	return hasAVX512VNNI
+}
+
+var hasAVX512VNNI = C.bitnet_has_avx512_vnni() != 0
+
+func matVecTI2SI8SAVX512(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32) {
+	if rows <= 0 || cols <= 0 {
+		return
+	}
+	if len(dst) < cols || len(vec) < rows {
+		return
+	}
+	if rows*cols == 0 || len(packed) < i2sPackedLen(rows*cols) {
+		return
+	}
+	C.matvec_t_i2s_i8s_avx512(
+		(*C.float)(unsafe.Pointer(&dst[0])),
+		(*C.uchar)(unsafe.Pointer(&packed[0])),
+		C.int(rows),
+		C.int(cols),
+		(*C.schar)(unsafe.Pointer(&vec[0])),
+		C.float(weightScale),
+		C.float(actScale),
+		C.int(actSum),
+	)
+}
+
+func matVecI2SI8SAVX512(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32) {
+	if rows <= 0 || cols <= 0 {
+		return
+	}
+	if len(dst) < rows || len(vec) < cols {
+		return
+	}
+	if rows*cols == 0 || len(packed) < i2sPackedLen(rows*cols) {
+		return
+	}
+	C.matvec_i2s_i8s_avx512(
+		(*C.float)(unsafe.Pointer(&dst[0])),
+		(*C.uchar)(unsafe.Pointer(&packed[0])),
+		C.int(rows),
+		C.int(cols),
+		(*C.schar)(unsafe.Pointer(&vec[0])),
+		C.float(weightScale),
+		C.float(actScale),
+		C.int(actSum),
+	)
+}
+
+func matVecI2SI8SAVX512Range(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32, cStart, cEnd int) bool {
+	byteOffset, ok := i2sI8SRangeOffset(packed, rows, cols, len(dst), rows, len(vec), cols, cStart, cEnd)
+	if !ok {
+		return false
+	}
+	C.matvec_i2s_i8s_avx512(
+		(*C.float)(unsafe.Pointer(&dst[0])),
+		(*C.uchar)(unsafe.Pointer(&packed[byteOffset])),
+		C.int(rows),
+		C.int(cEnd-cStart),
+		(*C.schar)(unsafe.Pointer(&vec[cStart])),
+		C.float(weightScale),
+		C.float(actScale),
+		C.int(actSum),
+	)
+	return true
+}
+
+func matVecTI2SI8SAVX512Range(dst []float32, packed []byte, rows, cols int, vec []int8, weightScale, actScale float32, actSum int32, cStart, cEnd int) bool {
+	byteOffset, ok := i2sI8SRangeOffset(packed, rows, cols, len(dst), cols, len(vec), rows, cStart, cEnd)
+	if !ok {
+		return false
+	}
+	C.matvec_t_i2s_i8s_avx512(
+		(*C.float)(unsafe.Pointer(&dst[cStart])),
+		(*C.uchar)(unsafe.Pointer(&packed[byteOffset])),
+		C.int(rows),
+		C.int(cEnd-cStart),
+		(*C.schar)(unsafe.Pointer(&vec[0])),
+		C.float(weightScale),
+		C.float(actScale),
+		C.int(actSum),
+	)
+	return true
+}
+
+// i2sI8SRangeOffset validates a column range and returns the byte offset of
+// column cStart, which is block-aligned only when rows%128 == 0.
+func i2sI8SRangeOffset(packed []byte, rows, cols, dstLen, dstNeed, vecLen, vecNeed, cStart, cEnd int) (int, bool) {
+	if rows <= 0 || cols <= 0 || cStart < 0 || cEnd > cols || cStart >= cEnd {
+		return 0, false
+	}
+	if dstLen < dstNeed || vecLen < vecNeed {
+		return 0, false
+	}
+	if len(packed) < i2sPackedLen(rows*cols) || rows%128 != 0 {
+		return 0, false
+	}
+	return cStart * rows / 128 * 32, true
+}
@@ -202,3 +202,27 @@ func BenchmarkQuantizeRowI8S(b *testing.B) {
 		QuantizeRowI8S(dst, src)
 	}
 }
+
+func BenchmarkMatVecTTQI8S(b *testing.B) {
+	const rows, cols = 2560, 2560
+	vec := make([]int8, rows)
+	for i := range vec {
+		vec[i] = int8(i%255 - 127)
+	}
+	tq1, _ := makeTQ1Packed(rows, cols, 1)
+	tq2, _ := makeTQ2Packed(rows, cols, 2)
+	dst := make([]float32, cols)
+
+	b.Run("tq1_0", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			MatVecTTQ1I8S(dst, tq1, rows, cols, vec, 1.0)
+		}
+	})
+	b.Run("tq2_0", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			MatVecTTQ2I8S(dst, tq2, rows, cols, vec, 1.0)
+		}
+	})
+}
@@ -1,79 +1,25 @@
 package kernels
 
-import (
-	"os"
-	"runtime"
-	"sync"
-)
-
-type i2sI8STask struct {
-	transposed bool
-	dst        []float32
-	packed     []byte
-	rows       int
-	cols       int
-	vec        []int8
-	weight     float32
-	act        float32
-	actSum     int32
-	start      int
-	end        int
-	wg         *sync.WaitGroup
-}
+import "os"
 
 var i2sI8SPoolEnabled = os.Getenv("BITNET_I2S_I8S_POOL") != "0"
-var i2sI8SPoolWorkers = envInt("BITNET_I2S_I8S_POOL_WORKERS", 0)
-
-var (
-	i2sI8SPoolOnce sync.Once
-	i2sI8SPoolCh   chan i2sI8STask
-	i2sI8SWGPool   = sync.Pool{
-		New: func() any {
-			return new(sync.WaitGroup)
-		},
-	}
-)
 
-func initI2SI8SPool() {
-	workers := i2sI8SPoolWorkers
-	if workers <= 0 {
-		workers = runtime.GOMAXPROCS(0)
-	}
-	if workers < 1 {
-		workers = 1
-	}
-	i2sI8SPoolCh = make(chan i2sI8STask, workers*2)
-	for i := 0; i < workers; i++ {
-		go func() {
-			for task := range i2sI8SPoolCh {
-				runI2SI8STask(task)
-			}
-		}()
-	}
-}
-
-func runI2SI8STask(task i2sI8STask) {
-	if task.transposed {
-		matVecTI2SI8SRange(task.dst, task.packed, task.rows, task.cols, task.vec, task.weight, task.act, task.actSum, task.start, task.end)
-	} else {
-		matVecI2SI8SRange(task.dst, task.packed, task.rows, task.cols, task.vec, task.weight, task.act, task.actSum, task.start, task.end)
-	}
-	task.wg.Done()
-}
+// i2sI8SPoolWorkers is the legacy worker-count knob; DefaultScheduler falls
+// back to it when BITNET_SCHED_WORKERS is unset.
+var i2sI8SPoolWorkers = envInt("BITNET_I2S_I8S_POOL_WORKERS", 0)
 
-func submitI2SI8STask(task i2sI8STask) {
+// runI2SI8SRanges runs fn over [0, n) in chunk-sized ranges on the shared
+// scheduler, or sequentially on the caller when BITNET_I2S_I8S_POOL=0.
+func runI2SI8SRanges(n, chunk int, fn func(start, end int)) {
 	if !i2sI8SPoolEnabled {
-		runI2SI8STask(task)
+		for start := 0; start < n; start += chunk {
+			end := start + chunk
+			if end > n {
+				end = n
+			}
+			fn(start, end)
+		}
 		return
 	}
-	i2sI8SPoolOnce.Do(initI2SI8SPool)
-	i2sI8SPoolCh <- task
-}
-
-func acquireI2SI8SWG() *sync.WaitGroup {
-	return i2sI8SWGPool.Get().(*sync.WaitGroup)
-}
-
-func releaseI2SI8SWG(wg *sync.WaitGroup) {
-	i2sI8SWGPool.Put(wg)
+	DefaultScheduler().ParallelRanges(n, chunk, fn)
 }
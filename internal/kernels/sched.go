@@ -0,0 +1,366 @@
+package kernels
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Scheduler runs kernel work on a fixed set of workers grouped by NUMA node.
+// A range [0, n) is cut into chunks and each node owns a contiguous share of
+// the chunks, proportional to its workers, so the same node always touches
+// the same slice of a weight matrix (see PlaceByNode). The caller of a
+// parallel call runs its own node's unstarted chunks and, until the call
+// finishes, whatever else is queued for that node, so nested calls from
+// inside a chunk cannot deadlock the pool and no chunk leaves its node.
+type Scheduler struct {
+	topo    CPUTopology
+	nodes   []*schedNode
+	workers int
+	pin     bool
+	once    sync.Once
+	// cpuNode maps a CPU to the index of its node.
+	cpuNode map[int]int
+	// threads maps a worker's OS thread ID to its node index.
+	threads sync.Map
+}
+
+type schedNode struct {
+	index   int
+	cpus    []int
+	workers int
+	// base is the number of workers on earlier nodes.
+	base  int
+	batch chan *schedBatch
+}
+
+type schedBatch struct {
+	fn        func(start, end int)
+	n, chunk  int
+	cursor    []atomic.Int64
+	limit     []int64
+	remaining atomic.Int64
+	done      chan struct{}
+}
+
+var (
+	defaultSchedOnce sync.Once
+	defaultSched     *Scheduler
+)
+
+// DefaultScheduler returns the process-wide scheduler shared by the matvec
+// kernels, attention and FFN. It uses BITNET_SCHED_WORKERS workers (default
+// GOMAXPROCS at first use) over the host topology; BITNET_SCHED_PIN=1/0
+// forces thread pinning on or off (default: on with more than one node).
+func DefaultScheduler() *Scheduler {
+	defaultSchedOnce.Do(func() {
+		workers := envInt("BITNET_SCHED_WORKERS", i2sI8SPoolWorkers)
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		topo := SystemTopology()
+		pin := len(topo.Nodes) > 1
+		switch os.Getenv("BITNET_SCHED_PIN") {
+		case "1":
+			pin = true
+		case "0":
+			pin = false
+		}
+		defaultSched = NewScheduler(topo, workers, pin)
+	})
+	return defaultSched
+}
+
+// NewScheduler spreads workers over the nodes of topo in proportion to their
+// CPUs. With fewer workers than nodes the topology is treated as flat. When
+// pin is set, each worker locks its OS thread to its node's CPUs (Linux
+// only). Workers start on first use.
+func NewScheduler(topo CPUTopology, workers int, pin bool) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	if len(topo.Nodes) == 0 || topo.NumCPUs() == 0 {
+		topo = FlatTopology(workers)
+	}
+	if workers < len(topo.Nodes) {
+		var cpus []int
+		for _, node := range topo.Nodes {
+			cpus = append(cpus, node.CPUs...)
+		}
+		topo = CPUTopology{Nodes: []NUMANode{{ID: topo.Nodes[0].ID, CPUs: cpus}}}
+	}
+	s := &Scheduler{topo: topo, workers: workers, pin: pin, cpuNode: make(map[int]int)}
+	total := topo.NumCPUs()
+	assigned := 0
+	cpusSeen := 0
+	for _, node := range topo.Nodes {
+		cpusSeen += len(node.CPUs)
+		// Cumulative rounding keeps the total exact.
+		upto := (workers*cpusSeen + total/2) / total
+		n := upto - assigned
+		if n < 1 {
+			n = 1
+		}
+		if assigned+n > workers {
+			n = workers - assigned
+		}
+		if n <= 0 {
+			continue
+		}
+		s.nodes = append(s.nodes, &schedNode{
+			index:   len(s.nodes),
+			cpus:    node.CPUs,
+			workers: n,
+			base:    assigned,
+			batch:   make(chan *schedBatch, n*4),
+		})
+		for _, cpu := range node.CPUs {
+			s.cpuNode[cpu] = len(s.nodes) - 1
+		}
+		assigned += n
+	}
+	if assigned < workers {
+		s.nodes[len(s.nodes)-1].workers += workers - assigned
+	}
+	return s
+}
+
+// Workers returns the number of worker goroutines.
+func (s *Scheduler) Workers() int {
+	return s.workers
+}
+
+// Nodes returns the number of NUMA nodes work is partitioned over.
+func (s *Scheduler) Nodes() int {
+	return len(s.nodes)
+}
+
+// Topology returns the topology the scheduler was built from.
+func (s *Scheduler) Topology() CPUTopology {
+	return s.topo
+}
+
+func (s *Scheduler) start() {
+	s.once.Do(func() {
+		for _, node := range s.nodes {
+			for i := 0; i < node.workers; i++ {
+				go s.worker(node)
+			}
+		}
+	})
+}
+
+func (s *Scheduler) worker(node *schedNode) {
+	if s.pin || len(s.nodes) > 1 {
+		// A locked thread lets nested calls find the worker's node.
+		runtime.LockOSThread()
+	}
+	if s.pin {
+		pinThread(node.cpus)
+	}
+	if len(s.nodes) > 1 {
+		if tid := threadID(); tid >= 0 {
+			s.threads.Store(tid, node.index)
+		}
+	}
+	for b := range node.batch {
+		b.runNode(node.index)
+	}
+}
+
+// callerNode returns the node whose chunks the calling goroutine runs: a
+// worker's own node, otherwise the node of the CPU it is on. ok is false
+// when neither is known (both lookups are Linux only).
+func (s *Scheduler) callerNode() (k int, ok bool) {
+	if len(s.nodes) == 1 {
+		return 0, true
+	}
+	if tid := threadID(); tid >= 0 {
+		if v, found := s.threads.Load(tid); found {
+			return v.(int), true
+		}
+	}
+	k, ok = s.cpuNode[currentCPU()]
+	return k, ok
+}
+
+// nodeShare returns the half-open share [lo, hi) of n units owned by node k.
+func (s *Scheduler) nodeShare(k, n int) (int, int) {
+	node := s.nodes[k]
+	lo := n * node.base / s.workers
+	hi := n * (node.base + node.workers) / s.workers
+	return lo, hi
+}
+
+// ParallelRanges runs fn over [0, n) in chunks of chunk elements and returns
+// when all have finished. Chunks run concurrently on the workers and the
+// calling goroutine; with one worker or a single chunk fn runs inline.
+func (s *Scheduler) ParallelRanges(n, chunk int, fn func(start, end int)) {
+	if n <= 0 {
+		return
+	}
+	if chunk <= 0 || chunk >= n || s.workers <= 1 {
+		fn(0, n)
+		return
+	}
+	parts := (n + chunk - 1) / chunk
+	b := &schedBatch{
+		fn:     fn,
+		n:      n,
+		chunk:  chunk,
+		cursor: make([]atomic.Int64, len(s.nodes)),
+		limit:  make([]int64, len(s.nodes)),
+		done:   make(chan struct{}),
+	}
+	b.remaining.Store(int64(parts))
+	for k := range s.nodes {
+		lo, hi := s.nodeShare(k, parts)
+		b.cursor[k].Store(int64(lo))
+		b.limit[k] = int64(hi)
+	}
+	s.start()
+	var stranded []int
+	for k, node := range s.nodes {
+		lo, hi := s.nodeShare(k, parts)
+		wake := hi - lo
+		if wake > node.workers {
+			wake = node.workers
+		}
+		queued := false
+		for i := 0; i < wake; i++ {
+			select {
+			case node.batch <- b:
+				queued = true
+			default:
+				// Queue full: busy workers of the node pick the chunks
+				// up once one of its wakeups is through.
+			}
+		}
+		if wake > 0 && !queued {
+			// No worker of the node will see b.
+			stranded = append(stranded, k)
+		}
+	}
+	own, ok := s.callerNode()
+	if !ok {
+		for k := range s.nodes {
+			b.runNode(k)
+		}
+		<-b.done
+		return
+	}
+	b.runNode(own)
+	for _, k := range stranded {
+		b.runNode(k)
+	}
+	s.wait(own, b)
+}
+
+// wait blocks until b finishes, meanwhile running node k's chunks of the
+// batches queued for it, so a worker blocked in a nested call keeps serving
+// its node without taking other nodes' work.
+func (s *Scheduler) wait(k int, b *schedBatch) {
+	node := s.nodes[k]
+	for {
+		select {
+		case <-b.done:
+			return
+		case other := <-node.batch:
+			other.runNode(k)
+		}
+	}
+}
+
+// Do runs fns concurrently and returns when all have finished.
+func (s *Scheduler) Do(fns ...func()) {
+	s.ParallelRanges(len(fns), 1, func(start, end int) {
+		for i := start; i < end; i++ {
+			fns[i]()
+		}
+	})
+}
+
+// PlaceByNode returns a copy of buf whose pages were first touched by the
+// workers of the node that owns each share, so a later ParallelRanges over
+// the same data (e.g. columns of a transposed weight) reads node-local
+// memory. It returns buf itself when there is a single node.
+func (s *Scheduler) PlaceByNode(buf []byte) []byte {
+	if len(s.nodes) <= 1 || len(buf) == 0 {
+		return buf
+	}
+	out := make([]byte, len(buf))
+	s.onEachNode(len(buf), func(lo, hi int) {
+		copy(out[lo:hi], buf[lo:hi])
+	})
+	return out
+}
+
+// Prefault reads one byte per page of buf from the owning node's workers.
+// For memory-mapped weights this faults the page cache in on that node.
+func (s *Scheduler) Prefault(buf []byte) {
+	if len(s.nodes) <= 1 || len(buf) == 0 {
+		return
+	}
+	const pageSize = 4096
+	var sink atomic.Uint32
+	s.onEachNode(len(buf), func(lo, hi int) {
+		var acc byte
+		for i := lo; i < hi; i += pageSize {
+			acc += buf[i]
+		}
+		sink.Add(uint32(acc))
+	})
+}
+
+// onEachNode runs fn on a worker of each node with that node's share of n.
+// Unlike ParallelRanges the caller does not steal the work, since where it
+// runs is the point.
+func (s *Scheduler) onEachNode(n int, fn func(lo, hi int)) {
+	s.start()
+	var wg sync.WaitGroup
+	for k, node := range s.nodes {
+		lo, hi := s.nodeShare(k, n)
+		if lo >= hi {
+			continue
+		}
+		b := &schedBatch{
+			fn:     func(int, int) { fn(lo, hi) },
+			n:      1,
+			chunk:  1,
+			cursor: make([]atomic.Int64, len(s.nodes)),
+			limit:  make([]int64, len(s.nodes)),
+			done:   make(chan struct{}),
+		}
+		b.limit[k] = 1
+		b.remaining.Store(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Block rather than run the share here: only the node's
+			// workers touch its pages first.
+			node.batch <- b
+			<-b.done
+		}()
+	}
+	wg.Wait()
+}
+
+// runNode claims and runs node k's unstarted chunks of b.
+func (b *schedBatch) runNode(k int) {
+	for {
+		i := b.cursor[k].Add(1) - 1
+		if i >= b.limit[k] {
+			return
+		}
+		start := int(i) * b.chunk
+		end := start + b.chunk
+		if end > b.n {
+			end = b.n
+		}
+		b.fn(start, end)
+		if b.remaining.Add(-1) == 0 {
+			close(b.done)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package kernels
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// threadID returns the calling OS thread's ID.
+func threadID() int {
+	return syscall.Gettid()
+}
+
+// currentCPU returns the CPU the calling thread is running on, or -1.
+func currentCPU() int {
+	var cpu uint32
+	if _, _, errno := syscall.RawSyscall(sysGetcpu, uintptr(unsafe.Pointer(&cpu)), 0, 0); errno != 0 {
+		return -1
+	}
+	return int(cpu)
+}
@@ -0,0 +1,4 @@
+package kernels
+
+// syscall has no SYS_GETCPU for linux/amd64.
+const sysGetcpu = 309
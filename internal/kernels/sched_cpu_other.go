@@ -0,0 +1,7 @@
+//go:build !linux
+
+package kernels
+
+func threadID() int { return -1 }
+
+func currentCPU() int { return -1 }
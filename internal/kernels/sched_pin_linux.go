@@ -0,0 +1,27 @@
+//go:build linux
+
+package kernels
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// pinThread restricts the calling OS thread to cpus. Failures (e.g. a
+// cpuset that excludes them) leave the thread unpinned.
+func pinThread(cpus []int) {
+	if len(cpus) == 0 {
+		return
+	}
+	maxCPU := 0
+	for _, cpu := range cpus {
+		if cpu > maxCPU {
+			maxCPU = cpu
+		}
+	}
+	mask := make([]uint64, maxCPU/64+1)
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	_, _, _ = syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+}
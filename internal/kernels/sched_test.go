@@ -0,0 +1,195 @@
+package kernels
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	got, err := parseCPUList("0-3,8,10-11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 3, 8, 10, 11}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{"x", "3-1", "1-", "-2"} {
+		if _, err := parseCPUList(bad); err == nil {
+			t.Fatalf("parseCPUList(%q): expected error", bad)
+		}
+	}
+}
+
+func writeSysfs(t *testing.T, root, rel, content string) {
+	t.Helper()
+	path := filepath.Join(root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadCPUTopology(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, "online", "0-3\n")
+	for cpu, node := range []string{"node0", "node1", "node0", "node1"} {
+		dir := filepath.Join(root, "cpu"+string(rune('0'+cpu)), node)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	topo, err := ReadCPUTopology(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CPUTopology{Nodes: []NUMANode{{ID: 0, CPUs: []int{0, 2}}, {ID: 1, CPUs: []int{1, 3}}}}
+	if !reflect.DeepEqual(topo, want) {
+		t.Fatalf("got %+v, want %+v", topo, want)
+	}
+}
+
+func TestReadCPUTopologyPackageFallback(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, "online", "0-2")
+	writeSysfs(t, root, "cpu0/topology/physical_package_id", "0\n")
+	writeSysfs(t, root, "cpu1/topology/physical_package_id", "1\n")
+	writeSysfs(t, root, "cpu2/topology/physical_package_id", "1\n")
+	topo, err := ReadCPUTopology(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CPUTopology{Nodes: []NUMANode{{ID: 0, CPUs: []int{0}}, {ID: 1, CPUs: []int{1, 2}}}}
+	if !reflect.DeepEqual(topo, want) {
+		t.Fatalf("got %+v, want %+v", topo, want)
+	}
+	if _, err := ReadCPUTopology(filepath.Join(root, "missing")); err == nil {
+		t.Fatal("expected error for missing sysfs root")
+	}
+}
+
+func twoNodeTopology() CPUTopology {
+	return CPUTopology{Nodes: []NUMANode{{ID: 0, CPUs: []int{0, 1, 2, 3}}, {ID: 1, CPUs: []int{4, 5, 6, 7}}}}
+}
+
+func TestNewSchedulerWorkers(t *testing.T) {
+	cases := []struct {
+		topo    CPUTopology
+		workers int
+		want    []int
+	}{
+		{twoNodeTopology(), 8, []int{4, 4}},
+		{twoNodeTopology(), 5, []int{3, 2}},
+		{twoNodeTopology(), 1, []int{1}},
+		{CPUTopology{Nodes: []NUMANode{{ID: 0, CPUs: []int{0}}, {ID: 1, CPUs: []int{1, 2, 3}}}}, 4, []int{1, 3}},
+		{CPUTopology{}, 3, []int{3}},
+	}
+	for _, tc := range cases {
+		s := NewScheduler(tc.topo, tc.workers, false)
+		var got []int
+		for _, node := range s.nodes {
+			got = append(got, node.workers)
+		}
+		if !reflect.DeepEqual(got, tc.want) || s.Workers() != tc.workers {
+			t.Fatalf("NewScheduler(%+v, %d): workers per node %v, want %v", tc.topo, tc.workers, got, tc.want)
+		}
+	}
+}
+
+func TestSchedulerParallelRanges(t *testing.T) {
+	s := NewScheduler(twoNodeTopology(), 6, false)
+	for _, tc := range []struct{ n, chunk int }{{1000, 7}, {64, 64}, {5, 1}, {3, 10}} {
+		hits := make([]atomic.Int32, tc.n)
+		s.ParallelRanges(tc.n, tc.chunk, func(start, end int) {
+			if end-start > tc.chunk {
+				t.Errorf("range [%d,%d) larger than chunk %d", start, end, tc.chunk)
+			}
+			for i := start; i < end; i++ {
+				hits[i].Add(1)
+			}
+		})
+		for i := range hits {
+			if got := hits[i].Load(); got != 1 {
+				t.Fatalf("n=%d chunk=%d: index %d ran %d times", tc.n, tc.chunk, i, got)
+			}
+		}
+	}
+}
+
+func TestSchedulerNested(t *testing.T) {
+	s := NewScheduler(twoNodeTopology(), 4, false)
+	var total atomic.Int64
+	s.ParallelRanges(32, 1, func(start, end int) {
+		s.ParallelRanges(100, 10, func(start, end int) {
+			total.Add(int64(end - start))
+		})
+	})
+	if got := total.Load(); got != 3200 {
+		t.Fatalf("nested total = %d, want 3200", got)
+	}
+	var a, b atomic.Bool
+	s.Do(func() { a.Store(true) }, func() { b.Store(true) })
+	if !a.Load() || !b.Load() {
+		t.Fatal("Do skipped a function")
+	}
+}
+
+func TestSchedulerKeepsChunksOnNode(t *testing.T) {
+	s := NewScheduler(twoNodeTopology(), 4, false)
+	if _, ok := s.callerNode(); !ok {
+		t.Skip("caller node unknown on this platform")
+	}
+	const n = 64
+	for iter := 0; iter < 20; iter++ {
+		// runner[i] is the node of the worker that ran chunk i, or -1 for
+		// the calling goroutine.
+		var runner [n]atomic.Int32
+		s.ParallelRanges(n, 1, func(start, end int) {
+			who := int32(-1)
+			if v, ok := s.threads.Load(threadID()); ok {
+				who = int32(v.(int))
+			}
+			runner[start].Store(who)
+		})
+		callerNode := -1
+		for i := range runner {
+			owner := 0
+			if lo, _ := s.nodeShare(1, n); i >= lo {
+				owner = 1
+			}
+			who := int(runner[i].Load())
+			if who < 0 {
+				if callerNode >= 0 && callerNode != owner {
+					t.Fatalf("caller ran chunks of nodes %d and %d", callerNode, owner)
+				}
+				callerNode = owner
+				continue
+			}
+			if who != owner {
+				t.Fatalf("chunk %d of node %d ran on a worker of node %d", i, owner, who)
+			}
+		}
+	}
+}
+
+func TestSchedulerPlaceByNode(t *testing.T) {
+	buf := make([]byte, 3*4096+17)
+	for i := range buf {
+		buf[i] = byte(i * 31)
+	}
+	flat := NewScheduler(FlatTopology(4), 4, false)
+	if got := flat.PlaceByNode(buf); &got[0] != &buf[0] {
+		t.Fatal("single-node PlaceByNode copied the buffer")
+	}
+	s := NewScheduler(twoNodeTopology(), 4, false)
+	got := s.PlaceByNode(buf)
+	if &got[0] == &buf[0] || !bytes.Equal(got, buf) {
+		t.Fatal("PlaceByNode did not return an equal copy")
+	}
+	s.Prefault(buf)
+}
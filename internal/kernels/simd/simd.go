@@ -0,0 +1,157 @@
+// Package simd holds Go-assembly (AVX2 on amd64, NEON on arm64) versions of
+// the hot kernels so builds with CGO_ENABLED=0 are not limited to the generic
+// Go loops. It lives outside internal/kernels because a package using cgo
+// cannot contain Go assembly.
+//
+// Every function produces bit-identical results to the scalar Go code it
+// replaces: integer kernels are exact, and float kernels keep the same lane
+// layout and reduction order and never fuse multiply-adds. The Go tails
+// convert each product to float32 for the same reason: without it arm64
+// (and GOAMD64=v3) compiles them to fused multiply-adds.
+//
+// The functions may only be called when Available is true.
+package simd
+
+import "math"
+
+// Available reports whether the assembly kernels can run on this CPU: AVX2
+// on amd64 and ASIMD (always present) on arm64.
+var Available = available()
+
+// I2SI8SDot returns sum(code_i * vec_i) over the len(packed)/32 i2_s blocks
+// in packed, where code_i is the raw 2-bit code (0..3, not re-centred).
+// vec must hold 128 values per block.
+func I2SI8SDot(packed []byte, vec []int8) int32 {
+	blocks := len(packed) / 32
+	if blocks == 0 {
+		return 0
+	}
+	_ = vec[blocks*128-1]
+	return i2sI8SDot(&packed[0], &vec[0], blocks)
+}
+
+// I2SI8SAccum adds code*vec[c] into sums for the 128 rows of one i2_s row
+// block, for every column c in vec. Column c's 32-byte block starts at
+// packed[c*stride].
+func I2SI8SAccum(sums *[128]int32, packed []byte, stride int, vec []int8) {
+	cols := len(vec)
+	if cols == 0 {
+		return
+	}
+	_ = packed[(cols-1)*stride+31]
+	i2sI8SAccum(&sums[0], &packed[0], stride, &vec[0], cols, 6, 4)
+	i2sI8SAccum(&sums[64], &packed[0], stride, &vec[0], cols, 2, 0)
+}
+
+// MaxAbs returns max(|x_i|), ignoring NaNs, or 0 for an empty slice.
+func MaxAbs(x []float32) float32 {
+	n := len(x) &^ 7
+	var m float32
+	if n > 0 {
+		m = maxAbsF32(&x[0], n)
+	}
+	for _, v := range x[n:] {
+		if v < 0 {
+			v = -v
+		}
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// QuantizeI8S stores clamp(nearestInt(x_i*scale), -128, 127) into dst using
+// ggml's nearest_int rounding and returns the sum of the stored values.
+func QuantizeI8S(dst []int8, x []float32, scale float32) int32 {
+	n := len(x)
+	if len(dst) < n {
+		n = len(dst)
+	}
+	aligned := n &^ 7
+	var sum int32
+	if aligned > 0 {
+		sum = quantizeI8S(&dst[0], &x[0], aligned, scale)
+	}
+	for i := aligned; i < n; i++ {
+		q := nearestInt(float32(x[i] * scale))
+		if q < -128 {
+			q = -128
+		} else if q > 127 {
+			q = 127
+		}
+		dst[i] = int8(q)
+		sum += int32(q)
+	}
+	return sum
+}
+
+// SumSquares4 accumulates float64(x_i)^2 into four lanes, lane i%4, over
+// the first n = len(x)&^3 elements, and returns the lanes and n.
+func SumSquares4(x []float32) (lanes [4]float64, n int) {
+	n = len(x) &^ 3
+	if n > 0 {
+		sumSquaresF64x4(&x[0], n, &lanes)
+	}
+	return lanes, n
+}
+
+// MulScale computes dst_i = x_i * s * w_i, rounding after each multiply.
+func MulScale(dst, x, w []float32, s float32) {
+	n := len(dst)
+	if len(x) < n {
+		n = len(x)
+	}
+	if len(w) < n {
+		n = len(w)
+	}
+	aligned := n &^ 7
+	if aligned > 0 {
+		mulScaleF32(&dst[0], &x[0], &w[0], aligned, s)
+	}
+	for i := aligned; i < n; i++ {
+		dst[i] = x[i] * s * w[i]
+	}
+}
+
+// DotF32x8 returns the dot product of a and b accumulated in eight float32
+// lanes (lane i%8) reduced as ((l0+l1)+(l2+l3)) + ((l4+l5)+(l6+l7)), with the
+// tail beyond the last multiple of 8 added sequentially.
+func DotF32x8(a, b []float32) float32 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	aligned := n &^ 7
+	var sum float32
+	if aligned > 0 {
+		sum = dotF32x8(&a[0], &b[0], aligned)
+	}
+	for i := aligned; i < n; i++ {
+		sum += float32(a[i] * b[i])
+	}
+	return sum
+}
+
+// DotF32x8Scaled is DotF32x8 with b_i replaced by b_i*s.
+func DotF32x8Scaled(a, b []float32, s float32) float32 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	aligned := n &^ 7
+	var sum float32
+	if aligned > 0 {
+		sum = dotF32x8Scaled(&a[0], &b[0], aligned, s)
+	}
+	for i := aligned; i < n; i++ {
+		sum += float32(a[i] * (b[i] * s))
+	}
+	return sum
+}
+
+func nearestInt(fval float32) int32 {
+	const bias = 12582912.0
+	val := fval + float32(bias)
+	return int32(math.Float32bits(val)&0x007fffff) - 0x00400000
+}
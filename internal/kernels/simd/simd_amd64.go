@@ -0,0 +1,48 @@
+package simd
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+func xgetbv() (eax, edx uint32)
+
+// available follows golang.org/x/sys/cpu: OSXSAVE and AVX in leaf 1, YMM
+// state enabled in XCR0, and AVX2 in leaf 7.
+func available() bool {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 7 {
+		return false
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	const osxsave = 1 << 27
+	const avx = 1 << 28
+	if ecx1&osxsave == 0 || ecx1&avx == 0 {
+		return false
+	}
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	return ebx7&(1<<5) != 0
+}
+
+//go:noescape
+func i2sI8SDot(packed *byte, vec *int8, blocks int) int32
+
+//go:noescape
+func i2sI8SAccum(sums *int32, packed *byte, stride int, vec *int8, cols int, shiftA, shiftB uint64)
+
+//go:noescape
+func maxAbsF32(x *float32, n int) float32
+
+//go:noescape
+func quantizeI8S(dst *int8, x *float32, n int, scale float32) int32
+
+//go:noescape
+func sumSquaresF64x4(x *float32, n int, lanes *[4]float64)
+
+//go:noescape
+func mulScaleF32(dst, x, w *float32, n int, s float32)
+
+//go:noescape
+func dotF32x8(a, b *float32, n int) float32
+
+//go:noescape
+func dotF32x8Scaled(a, b *float32, n int, s float32) float32
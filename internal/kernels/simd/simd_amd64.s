@@ -0,0 +1,322 @@
+#include "textflag.h"
+
+DATA mask3<>+0(SB)/8, $0x0303030303030303
+DATA mask3<>+8(SB)/8, $0x0303030303030303
+DATA mask3<>+16(SB)/8, $0x0303030303030303
+DATA mask3<>+24(SB)/8, $0x0303030303030303
+GLOBL mask3<>(SB), RODATA|NOPTR, $32
+
+DATA ones16<>+0(SB)/8, $0x0001000100010001
+DATA ones16<>+8(SB)/8, $0x0001000100010001
+DATA ones16<>+16(SB)/8, $0x0001000100010001
+DATA ones16<>+24(SB)/8, $0x0001000100010001
+GLOBL ones16<>(SB), RODATA|NOPTR, $32
+
+DATA absMask<>+0(SB)/4, $0x7fffffff
+GLOBL absMask<>(SB), RODATA|NOPTR, $4
+
+DATA roundBias<>+0(SB)/4, $0x4b400000
+GLOBL roundBias<>(SB), RODATA|NOPTR, $4
+
+DATA mantMask<>+0(SB)/4, $0x007fffff
+GLOBL mantMask<>(SB), RODATA|NOPTR, $4
+
+DATA mantHalf<>+0(SB)/4, $0x00400000
+GLOBL mantHalf<>(SB), RODATA|NOPTR, $4
+
+DATA i8Max<>+0(SB)/4, $127
+GLOBL i8Max<>(SB), RODATA|NOPTR, $4
+
+DATA i8Min<>+0(SB)/4, $-128
+GLOBL i8Min<>(SB), RODATA|NOPTR, $4
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// Horizontal int32 sum of Y0 into AX. Clobbers X1.
+#define HSUM_EPI32_Y0 \
+	VEXTRACTI128 $1, Y0, X1; \
+	VPADDD       X1, X0, X0; \
+	VPSHUFD      $0x4e, X0, X1; \
+	VPADDD       X1, X0, X0; \
+	VPSHUFD      $0xb1, X0, X1; \
+	VPADDD       X1, X0, X0; \
+	VMOVD        X0, AX
+
+// One 32-row group of an i2_s block: codes = (Y8 >> shift) & 3, dotted with
+// 32 activations via maddubs (codes are unsigned, activations signed).
+#define I2S_GROUP(shift, off) \
+	VPSRLW     $shift, Y8, Y2; \
+	VPAND      Y14, Y2, Y2; \
+	VPMADDUBSW off(DI), Y2, Y2; \
+	VPMADDWD   Y15, Y2, Y2; \
+	VPADDD     Y2, Y0, Y0
+
+// func i2sI8SDot(packed *byte, vec *int8, blocks int) int32
+TEXT ·i2sI8SDot(SB), NOSPLIT, $0-28
+	MOVQ    packed+0(FP), SI
+	MOVQ    vec+8(FP), DI
+	MOVQ    blocks+16(FP), CX
+	VMOVDQU mask3<>(SB), Y14
+	VMOVDQU ones16<>(SB), Y15
+	VPXOR   Y0, Y0, Y0
+
+dotLoop:
+	VMOVDQU (SI), Y8
+	I2S_GROUP(6, 0)
+	I2S_GROUP(4, 32)
+	I2S_GROUP(2, 64)
+	VPAND      Y14, Y8, Y2
+	VPMADDUBSW 96(DI), Y2, Y2
+	VPMADDWD   Y15, Y2, Y2
+	VPADDD     Y2, Y0, Y0
+	ADDQ       $32, SI
+	ADDQ       $128, DI
+	DECQ       CX
+	JNZ        dotLoop
+
+	HSUM_EPI32_Y0
+	MOVL AX, ret+24(FP)
+	VZEROUPPER
+	RET
+
+// Widen 8 codes at off(SP) to int32, scale by Y9 and add into acc.
+#define ACCUM8(off, acc) \
+	VPMOVZXBD off(SP), Y11; \
+	VPMULLD   Y9, Y11, Y11; \
+	VPADDD    Y11, acc, acc
+
+// func i2sI8SAccum(sums *int32, packed *byte, stride int, vec *int8, cols int, shiftA, shiftB uint64)
+TEXT ·i2sI8SAccum(SB), NOSPLIT, $32-56
+	MOVQ    sums+0(FP), R8
+	MOVQ    packed+8(FP), SI
+	MOVQ    stride+16(FP), R9
+	MOVQ    vec+24(FP), DI
+	MOVQ    cols+32(FP), CX
+	VMOVQ   shiftA+40(FP), X12
+	VMOVQ   shiftB+48(FP), X13
+	VMOVDQU mask3<>(SB), Y14
+	VPXOR   Y0, Y0, Y0
+	VPXOR   Y1, Y1, Y1
+	VPXOR   Y2, Y2, Y2
+	VPXOR   Y3, Y3, Y3
+	VPXOR   Y4, Y4, Y4
+	VPXOR   Y5, Y5, Y5
+	VPXOR   Y6, Y6, Y6
+	VPXOR   Y7, Y7, Y7
+
+accumLoop:
+	MOVBLSX      (DI), AX
+	VMOVD        AX, X9
+	VPBROADCASTD X9, Y9
+	VMOVDQU      (SI), Y8
+
+	VPSRLW  X12, Y8, Y10
+	VPAND   Y14, Y10, Y10
+	VMOVDQU Y10, (SP)
+	ACCUM8(0, Y0)
+	ACCUM8(8, Y1)
+	ACCUM8(16, Y2)
+	ACCUM8(24, Y3)
+
+	VPSRLW  X13, Y8, Y10
+	VPAND   Y14, Y10, Y10
+	VMOVDQU Y10, (SP)
+	ACCUM8(0, Y4)
+	ACCUM8(8, Y5)
+	ACCUM8(16, Y6)
+	ACCUM8(24, Y7)
+
+	ADDQ R9, SI
+	INCQ DI
+	DECQ CX
+	JNZ  accumLoop
+
+	VPADDD  (R8), Y0, Y0
+	VMOVDQU Y0, (R8)
+	VPADDD  32(R8), Y1, Y1
+	VMOVDQU Y1, 32(R8)
+	VPADDD  64(R8), Y2, Y2
+	VMOVDQU Y2, 64(R8)
+	VPADDD  96(R8), Y3, Y3
+	VMOVDQU Y3, 96(R8)
+	VPADDD  128(R8), Y4, Y4
+	VMOVDQU Y4, 128(R8)
+	VPADDD  160(R8), Y5, Y5
+	VMOVDQU Y5, 160(R8)
+	VPADDD  192(R8), Y6, Y6
+	VMOVDQU Y6, 192(R8)
+	VPADDD  224(R8), Y7, Y7
+	VMOVDQU Y7, 224(R8)
+	VZEROUPPER
+	RET
+
+// func maxAbsF32(x *float32, n int) float32
+TEXT ·maxAbsF32(SB), NOSPLIT, $0-20
+	MOVQ         x+0(FP), SI
+	MOVQ         n+8(FP), CX
+	VBROADCASTSS absMask<>(SB), Y15
+	VXORPS       Y0, Y0, Y0
+
+maxLoop:
+	// VMAXPS returns its second source (the accumulator) when either
+	// operand is NaN, so NaNs are skipped like the scalar v > max test.
+	VANDPS (SI), Y15, Y1
+	VMAXPS Y0, Y1, Y0
+	ADDQ   $32, SI
+	SUBQ   $8, CX
+	JNZ    maxLoop
+
+	VEXTRACTF128 $1, Y0, X1
+	VMAXPS       X1, X0, X0
+	VPERMILPS    $0x4e, X0, X1
+	VMAXPS       X1, X0, X0
+	VPERMILPS    $0xb1, X0, X1
+	VMAXPS       X1, X0, X0
+	VMOVSS       X0, ret+16(FP)
+	VZEROUPPER
+	RET
+
+// func quantizeI8S(dst *int8, x *float32, n int, scale float32) int32
+TEXT ·quantizeI8S(SB), NOSPLIT, $0-36
+	MOVQ         dst+0(FP), DI
+	MOVQ         x+8(FP), SI
+	MOVQ         n+16(FP), CX
+	VBROADCASTSS scale+24(FP), Y15
+	VBROADCASTSS roundBias<>(SB), Y14
+	VPBROADCASTD mantMask<>(SB), Y13
+	VPBROADCASTD mantHalf<>(SB), Y12
+	VPBROADCASTD i8Max<>(SB), Y11
+	VPBROADCASTD i8Min<>(SB), Y10
+	VPXOR        Y0, Y0, Y0
+
+quantLoop:
+	// nearest_int: the mantissa of x*scale + 1.5*2^23 holds the rounded
+	// value offset by 2^22.
+	VMULPS       (SI), Y15, Y1
+	VADDPS       Y14, Y1, Y1
+	VPAND        Y13, Y1, Y1
+	VPSUBD       Y12, Y1, Y1
+	VPMINSD      Y11, Y1, Y1
+	VPMAXSD      Y10, Y1, Y1
+	VPADDD       Y1, Y0, Y0
+	VEXTRACTI128 $1, Y1, X2
+	VPACKSSDW    X2, X1, X1
+	VPACKSSWB    X1, X1, X1
+	VMOVQ        X1, (DI)
+	ADDQ         $32, SI
+	ADDQ         $8, DI
+	SUBQ         $8, CX
+	JNZ          quantLoop
+
+	HSUM_EPI32_Y0
+	MOVL AX, ret+32(FP)
+	VZEROUPPER
+	RET
+
+// func sumSquaresF64x4(x *float32, n int, lanes *[4]float64)
+TEXT ·sumSquaresF64x4(SB), NOSPLIT, $0-24
+	MOVQ   x+0(FP), SI
+	MOVQ   n+8(FP), CX
+	MOVQ   lanes+16(FP), DI
+	VXORPD Y0, Y0, Y0
+
+sqLoop:
+	VCVTPS2PD (SI), Y1
+	VMULPD    Y1, Y1, Y1
+	VADDPD    Y1, Y0, Y0
+	ADDQ      $16, SI
+	SUBQ      $4, CX
+	JNZ       sqLoop
+
+	VMOVUPD Y0, (DI)
+	VZEROUPPER
+	RET
+
+// func mulScaleF32(dst, x, w *float32, n int, s float32)
+TEXT ·mulScaleF32(SB), NOSPLIT, $0-36
+	MOVQ         dst+0(FP), DI
+	MOVQ         x+8(FP), SI
+	MOVQ         w+16(FP), DX
+	MOVQ         n+24(FP), CX
+	VBROADCASTSS s+32(FP), Y15
+
+mulLoop:
+	VMULPS  (SI), Y15, Y1
+	VMULPS  (DX), Y1, Y1
+	VMOVUPS Y1, (DI)
+	ADDQ    $32, SI
+	ADDQ    $32, DX
+	ADDQ    $32, DI
+	SUBQ    $8, CX
+	JNZ     mulLoop
+
+	VZEROUPPER
+	RET
+
+// Reduce the eight lanes of Y0 as ((l0+l1)+(l2+l3)) + ((l4+l5)+(l6+l7)).
+#define HSUM_PS_Y0 \
+	VEXTRACTF128 $1, Y0, X1; \
+	VHADDPS      X1, X0, X0; \
+	VHADDPS      X0, X0, X0; \
+	VMOVSHDUP    X0, X1; \
+	VADDSS       X1, X0, X0
+
+// func dotF32x8(a, b *float32, n int) float32
+TEXT ·dotF32x8(SB), NOSPLIT, $0-28
+	MOVQ   a+0(FP), SI
+	MOVQ   b+8(FP), DI
+	MOVQ   n+16(FP), CX
+	VXORPS Y0, Y0, Y0
+
+dotF32Loop:
+	VMOVUPS (SI), Y1
+	VMULPS  (DI), Y1, Y1
+	VADDPS  Y1, Y0, Y0
+	ADDQ    $32, SI
+	ADDQ    $32, DI
+	SUBQ    $8, CX
+	JNZ     dotF32Loop
+
+	HSUM_PS_Y0
+	VMOVSS X0, ret+24(FP)
+	VZEROUPPER
+	RET
+
+// func dotF32x8Scaled(a, b *float32, n int, s float32) float32
+TEXT ·dotF32x8Scaled(SB), NOSPLIT, $0-36
+	MOVQ         a+0(FP), SI
+	MOVQ         b+8(FP), DI
+	MOVQ         n+16(FP), CX
+	VBROADCASTSS s+24(FP), Y15
+	VXORPS       Y0, Y0, Y0
+
+dotScaledLoop:
+	VMULPS (DI), Y15, Y2
+	VMULPS (SI), Y2, Y1
+	VADDPS Y1, Y0, Y0
+	ADDQ   $32, SI
+	ADDQ   $32, DI
+	SUBQ   $8, CX
+	JNZ    dotScaledLoop
+
+	HSUM_PS_Y0
+	VMOVSS X0, ret+32(FP)
+	VZEROUPPER
+	RET
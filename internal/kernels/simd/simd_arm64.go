@@ -0,0 +1,28 @@
+package simd
+
+// ASIMD is part of the ARMv8-A baseline, so there is nothing to probe.
+func available() bool { return true }
+
+//go:noescape
+func i2sI8SDot(packed *byte, vec *int8, blocks int) int32
+
+//go:noescape
+func i2sI8SAccum(sums *int32, packed *byte, stride int, vec *int8, cols int, shiftA, shiftB uint64)
+
+//go:noescape
+func maxAbsF32(x *float32, n int) float32
+
+//go:noescape
+func quantizeI8S(dst *int8, x *float32, n int, scale float32) int32
+
+//go:noescape
+func sumSquaresF64x4(x *float32, n int, lanes *[4]float64)
+
+//go:noescape
+func mulScaleF32(dst, x, w *float32, n int, s float32)
+
+//go:noescape
+func dotF32x8(a, b *float32, n int) float32
+
+//go:noescape
+func dotF32x8Scaled(a, b *float32, n int, s float32) float32
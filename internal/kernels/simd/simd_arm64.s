@@ -0,0 +1,333 @@
+#include "textflag.h"
+
+// The Go assembler has no mnemonics for most arithmetic ASIMD instructions,
+// so those are emitted as WORDs with the instruction in the trailing comment.
+
+// func i2sI8SDot(packed *byte, vec *int8, blocks int) int32
+TEXT ·i2sI8SDot(SB), NOSPLIT, $0-28
+	MOVD  packed+0(FP), R0
+	MOVD  vec+8(FP), R1
+	MOVD  blocks+16(FP), R2
+	VMOVI $3, V31.B16
+	VEOR  V20.B16, V20.B16, V20.B16
+	VEOR  V21.B16, V21.B16, V21.B16
+	VEOR  V22.B16, V22.B16, V22.B16
+	VEOR  V23.B16, V23.B16, V23.B16
+
+dotLoop:
+	VLD1.P 32(R0), [V0.B16, V1.B16]
+	VLD1.P 64(R1), [V2.B16, V3.B16, V4.B16, V5.B16]
+	VLD1.P 64(R1), [V6.B16, V7.B16, V8.B16, V9.B16]
+
+	VUSHR $6, V0.B16, V10.B16
+	VUSHR $6, V1.B16, V11.B16
+	WORD $0x0e22c14c // smull v12.8h, v10.8b, v2.8b
+	WORD $0x4e22c14d // smull2 v13.8h, v10.16b, v2.16b
+	WORD $0x0e23c16e // smull v14.8h, v11.8b, v3.8b
+	WORD $0x4e23c16f // smull2 v15.8h, v11.16b, v3.16b
+	WORD $0x4e606994 // sadalp v20.4s, v12.8h
+	WORD $0x4e6069b5 // sadalp v21.4s, v13.8h
+	WORD $0x4e6069d6 // sadalp v22.4s, v14.8h
+	WORD $0x4e6069f7 // sadalp v23.4s, v15.8h
+
+	VUSHR $4, V0.B16, V10.B16
+	VUSHR $4, V1.B16, V11.B16
+	VAND  V31.B16, V10.B16, V10.B16
+	VAND  V31.B16, V11.B16, V11.B16
+	WORD $0x0e24c14c // smull v12.8h, v10.8b, v4.8b
+	WORD $0x4e24c14d // smull2 v13.8h, v10.16b, v4.16b
+	WORD $0x0e25c16e // smull v14.8h, v11.8b, v5.8b
+	WORD $0x4e25c16f // smull2 v15.8h, v11.16b, v5.16b
+	WORD $0x4e606994 // sadalp v20.4s, v12.8h
+	WORD $0x4e6069b5 // sadalp v21.4s, v13.8h
+	WORD $0x4e6069d6 // sadalp v22.4s, v14.8h
+	WORD $0x4e6069f7 // sadalp v23.4s, v15.8h
+
+	VUSHR $2, V0.B16, V10.B16
+	VUSHR $2, V1.B16, V11.B16
+	VAND  V31.B16, V10.B16, V10.B16
+	VAND  V31.B16, V11.B16, V11.B16
+	WORD $0x0e26c14c // smull v12.8h, v10.8b, v6.8b
+	WORD $0x4e26c14d // smull2 v13.8h, v10.16b, v6.16b
+	WORD $0x0e27c16e // smull v14.8h, v11.8b, v7.8b
+	WORD $0x4e27c16f // smull2 v15.8h, v11.16b, v7.16b
+	WORD $0x4e606994 // sadalp v20.4s, v12.8h
+	WORD $0x4e6069b5 // sadalp v21.4s, v13.8h
+	WORD $0x4e6069d6 // sadalp v22.4s, v14.8h
+	WORD $0x4e6069f7 // sadalp v23.4s, v15.8h
+
+	VAND V31.B16, V0.B16, V10.B16
+	VAND V31.B16, V1.B16, V11.B16
+	WORD $0x0e28c14c // smull v12.8h, v10.8b, v8.8b
+	WORD $0x4e28c14d // smull2 v13.8h, v10.16b, v8.16b
+	WORD $0x0e29c16e // smull v14.8h, v11.8b, v9.8b
+	WORD $0x4e29c16f // smull2 v15.8h, v11.16b, v9.16b
+	WORD $0x4e606994 // sadalp v20.4s, v12.8h
+	WORD $0x4e6069b5 // sadalp v21.4s, v13.8h
+	WORD $0x4e6069d6 // sadalp v22.4s, v14.8h
+	WORD $0x4e6069f7 // sadalp v23.4s, v15.8h
+
+	SUBS $1, R2, R2
+	BNE  dotLoop
+
+	VADD  V21.S4, V20.S4, V20.S4
+	VADD  V23.S4, V22.S4, V22.S4
+	VADD  V22.S4, V20.S4, V20.S4
+	VADDV V20.S4, V20
+	VMOV  V20.S[0], R3
+	MOVW  R3, ret+24(FP)
+	RET
+
+// func i2sI8SAccum(sums *int32, packed *byte, stride int, vec *int8, cols int, shiftA, shiftB uint64)
+//
+// The 64 sums live in V12..V27 for the whole column loop.
+TEXT ·i2sI8SAccum(SB), NOSPLIT, $0-56
+	MOVD  sums+0(FP), R0
+	MOVD  packed+8(FP), R1
+	MOVD  stride+16(FP), R2
+	MOVD  vec+24(FP), R3
+	MOVD  cols+32(FP), R4
+	MOVD  shiftA+40(FP), R5
+	MOVD  shiftB+48(FP), R6
+	NEG   R5, R5
+	NEG   R6, R6
+	VDUP  R5, V28.B16
+	VDUP  R6, V29.B16
+	VMOVI $3, V30.B16
+
+	MOVD   R0, R7
+	VLD1.P 64(R7), [V12.S4, V13.S4, V14.S4, V15.S4]
+	VLD1.P 64(R7), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VLD1.P 64(R7), [V20.S4, V21.S4, V22.S4, V23.S4]
+	VLD1   (R7), [V24.S4, V25.S4, V26.S4, V27.S4]
+
+accLoop:
+	VLD1  (R1), [V0.B16, V1.B16]
+	ADD   R2, R1, R1
+	MOVB.P 1(R3), R8
+	VDUP  R8, V2.H8
+	WORD $0x6e3c4403 // ushl v3.16b, v0.16b, v28.16b
+	WORD $0x6e3c4424 // ushl v4.16b, v1.16b, v28.16b
+	WORD $0x6e3d4405 // ushl v5.16b, v0.16b, v29.16b
+	WORD $0x6e3d4426 // ushl v6.16b, v1.16b, v29.16b
+	VAND  V30.B16, V3.B16, V3.B16
+	VAND  V30.B16, V4.B16, V4.B16
+	VAND  V30.B16, V5.B16, V5.B16
+	VAND  V30.B16, V6.B16, V6.B16
+
+	VUXTL  V3.B8, V7.H8
+	VUXTL2 V3.B16, V8.H8
+	WORD $0x0e6280ec // smlal v12.4s, v7.4h, v2.4h
+	WORD $0x4e6280ed // smlal2 v13.4s, v7.8h, v2.8h
+	WORD $0x0e62810e // smlal v14.4s, v8.4h, v2.4h
+	WORD $0x4e62810f // smlal2 v15.4s, v8.8h, v2.8h
+	VUXTL  V4.B8, V7.H8
+	VUXTL2 V4.B16, V8.H8
+	WORD $0x0e6280f0 // smlal v16.4s, v7.4h, v2.4h
+	WORD $0x4e6280f1 // smlal2 v17.4s, v7.8h, v2.8h
+	WORD $0x0e628112 // smlal v18.4s, v8.4h, v2.4h
+	WORD $0x4e628113 // smlal2 v19.4s, v8.8h, v2.8h
+	VUXTL  V5.B8, V7.H8
+	VUXTL2 V5.B16, V8.H8
+	WORD $0x0e6280f4 // smlal v20.4s, v7.4h, v2.4h
+	WORD $0x4e6280f5 // smlal2 v21.4s, v7.8h, v2.8h
+	WORD $0x0e628116 // smlal v22.4s, v8.4h, v2.4h
+	WORD $0x4e628117 // smlal2 v23.4s, v8.8h, v2.8h
+	VUXTL  V6.B8, V7.H8
+	VUXTL2 V6.B16, V8.H8
+	WORD $0x0e6280f8 // smlal v24.4s, v7.4h, v2.4h
+	WORD $0x4e6280f9 // smlal2 v25.4s, v7.8h, v2.8h
+	WORD $0x0e62811a // smlal v26.4s, v8.4h, v2.4h
+	WORD $0x4e62811b // smlal2 v27.4s, v8.8h, v2.8h
+
+	SUBS $1, R4, R4
+	BNE  accLoop
+
+	VST1.P [V12.S4, V13.S4, V14.S4, V15.S4], 64(R0)
+	VST1.P [V16.S4, V17.S4, V18.S4, V19.S4], 64(R0)
+	VST1.P [V20.S4, V21.S4, V22.S4, V23.S4], 64(R0)
+	VST1   [V24.S4, V25.S4, V26.S4, V27.S4], (R0)
+	RET
+
+// func maxAbsF32(x *float32, n int) float32
+//
+// A compare-and-select keeps the accumulator whenever |x| is NaN, matching
+// the scalar `if v > m` loop.
+TEXT ·maxAbsF32(SB), NOSPLIT, $0-20
+	MOVD x+0(FP), R0
+	MOVD n+8(FP), R1
+	LSR  $3, R1, R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+
+maxLoop:
+	VLD1.P 32(R0), [V2.S4, V3.S4]
+	WORD $0x4ea0f842 // fabs v2.4s, v2.4s
+	WORD $0x4ea0f863 // fabs v3.4s, v3.4s
+	WORD $0x6ea0e444 // fcmgt v4.4s, v2.4s, v0.4s
+	WORD $0x6ea1e465 // fcmgt v5.4s, v3.4s, v1.4s
+	WORD $0x6ea41c40 // bit v0.16b, v2.16b, v4.16b
+	WORD $0x6ea51c61 // bit v1.16b, v3.16b, v5.16b
+	SUBS $1, R1, R1
+	BNE  maxLoop
+
+	WORD $0x4e21f400 // fmax v0.4s, v0.4s, v1.4s
+	WORD $0x6e30f800 // fmaxv s0, v0.4s
+	FMOVS F0, ret+16(FP)
+	RET
+
+// func quantizeI8S(dst *int8, x *float32, n int, scale float32) int32
+TEXT ·quantizeI8S(SB), NOSPLIT, $0-36
+	MOVD  dst+0(FP), R0
+	MOVD  x+8(FP), R1
+	MOVD  n+16(FP), R2
+	FMOVS scale+24(FP), F31
+	LSR   $3, R2, R2
+	MOVW  $0x4b400000, R3
+	VDUP  R3, V30.S4
+	MOVW  $0x007fffff, R3
+	VDUP  R3, V29.S4
+	MOVW  $0x00400000, R3
+	VDUP  R3, V28.S4
+	MOVW  $127, R3
+	VDUP  R3, V27.S4
+	MOVW  $-128, R3
+	VDUP  R3, V26.S4
+	VEOR  V24.B16, V24.B16, V24.B16
+	VEOR  V25.B16, V25.B16, V25.B16
+
+quantLoop:
+	VLD1.P 32(R1), [V0.S4, V1.S4]
+	WORD $0x4f9f9000 // fmul v0.4s, v0.4s, v31.s[0]
+	WORD $0x4f9f9021 // fmul v1.4s, v1.4s, v31.s[0]
+	WORD $0x4e3ed400 // fadd v0.4s, v0.4s, v30.4s
+	WORD $0x4e3ed421 // fadd v1.4s, v1.4s, v30.4s
+	VAND V29.B16, V0.B16, V0.B16
+	VAND V29.B16, V1.B16, V1.B16
+	VSUB V28.S4, V0.S4, V0.S4
+	VSUB V28.S4, V1.S4, V1.S4
+	WORD $0x4ebb6c00 // smin v0.4s, v0.4s, v27.4s
+	WORD $0x4ebb6c21 // smin v1.4s, v1.4s, v27.4s
+	WORD $0x4eba6400 // smax v0.4s, v0.4s, v26.4s
+	WORD $0x4eba6421 // smax v1.4s, v1.4s, v26.4s
+	VADD V0.S4, V24.S4, V24.S4
+	VADD V1.S4, V25.S4, V25.S4
+	WORD $0x0e612802 // xtn v2.4h, v0.4s
+	WORD $0x4e612822 // xtn2 v2.8h, v1.4s
+	WORD $0x0e212842 // xtn v2.8b, v2.8h
+	VST1.P [V2.B8], 8(R0)
+	SUBS $1, R2, R2
+	BNE  quantLoop
+
+	VADD  V25.S4, V24.S4, V24.S4
+	VADDV V24.S4, V24
+	VMOV  V24.S[0], R3
+	MOVW  R3, ret+32(FP)
+	RET
+
+// func sumSquaresF64x4(x *float32, n int, lanes *[4]float64)
+TEXT ·sumSquaresF64x4(SB), NOSPLIT, $0-24
+	MOVD x+0(FP), R0
+	MOVD n+8(FP), R1
+	MOVD lanes+16(FP), R2
+	LSR  $2, R1, R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+
+sqLoop:
+	VLD1.P 16(R0), [V2.S4]
+	WORD $0x0e617843 // fcvtl v3.2d, v2.2s
+	WORD $0x4e617844 // fcvtl2 v4.2d, v2.4s
+	WORD $0x6e63dc63 // fmul v3.2d, v3.2d, v3.2d
+	WORD $0x6e64dc84 // fmul v4.2d, v4.2d, v4.2d
+	WORD $0x4e63d400 // fadd v0.2d, v0.2d, v3.2d
+	WORD $0x4e64d421 // fadd v1.2d, v1.2d, v4.2d
+	SUBS $1, R1, R1
+	BNE  sqLoop
+
+	VST1 [V0.D2, V1.D2], (R2)
+	RET
+
+// func mulScaleF32(dst, x, w *float32, n int, s float32)
+TEXT ·mulScaleF32(SB), NOSPLIT, $0-36
+	MOVD  dst+0(FP), R0
+	MOVD  x+8(FP), R1
+	MOVD  w+16(FP), R2
+	MOVD  n+24(FP), R3
+	FMOVS s+32(FP), F31
+	LSR   $3, R3, R3
+
+mulLoop:
+	VLD1.P 32(R1), [V0.S4, V1.S4]
+	VLD1.P 32(R2), [V2.S4, V3.S4]
+	WORD $0x4f9f9000 // fmul v0.4s, v0.4s, v31.s[0]
+	WORD $0x4f9f9021 // fmul v1.4s, v1.4s, v31.s[0]
+	WORD $0x6e22dc00 // fmul v0.4s, v0.4s, v2.4s
+	WORD $0x6e23dc21 // fmul v1.4s, v1.4s, v3.4s
+	VST1.P [V0.S4, V1.S4], 32(R0)
+	SUBS $1, R3, R3
+	BNE  mulLoop
+	RET
+
+// Reduce lanes 0-3 in V0 and 4-7 in V1 to ((l0+l1)+(l2+l3)) +
+// ((l4+l5)+(l6+l7)) in F0:
+//	faddp v0.4s, v0.4s, v0.4s
+//	faddp s0, v0.2s
+//	faddp v1.4s, v1.4s, v1.4s
+//	faddp s1, v1.2s
+//	fadd  s0, s0, s1
+#define REDUCE_F32x8 \
+	WORD $0x6e20d400; \
+	WORD $0x7e30d800; \
+	WORD $0x6e21d421; \
+	WORD $0x7e30d821; \
+	WORD $0x1e212800
+
+// func dotF32x8(a, b *float32, n int) float32
+TEXT ·dotF32x8(SB), NOSPLIT, $0-28
+	MOVD a+0(FP), R0
+	MOVD b+8(FP), R1
+	MOVD n+16(FP), R2
+	LSR  $3, R2, R2
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+
+f32DotLoop:
+	VLD1.P 32(R0), [V2.S4, V3.S4]
+	VLD1.P 32(R1), [V4.S4, V5.S4]
+	WORD $0x6e24dc42 // fmul v2.4s, v2.4s, v4.4s
+	WORD $0x6e25dc63 // fmul v3.4s, v3.4s, v5.4s
+	WORD $0x4e22d400 // fadd v0.4s, v0.4s, v2.4s
+	WORD $0x4e23d421 // fadd v1.4s, v1.4s, v3.4s
+	SUBS $1, R2, R2
+	BNE  f32DotLoop
+
+	REDUCE_F32x8
+	FMOVS F0, ret+24(FP)
+	RET
+
+// func dotF32x8Scaled(a, b *float32, n int, s float32) float32
+TEXT ·dotF32x8Scaled(SB), NOSPLIT, $0-36
+	MOVD  a+0(FP), R0
+	MOVD  b+8(FP), R1
+	MOVD  n+16(FP), R2
+	FMOVS s+24(FP), F31
+	LSR   $3, R2, R2
+	VEOR  V0.B16, V0.B16, V0.B16
+	VEOR  V1.B16, V1.B16, V1.B16
+
+f32DotScaledLoop:
+	VLD1.P 32(R0), [V2.S4, V3.S4]
+	VLD1.P 32(R1), [V4.S4, V5.S4]
+	WORD $0x4f9f9084 // fmul v4.4s, v4.4s, v31.s[0]
+	WORD $0x4f9f90a5 // fmul v5.4s, v5.4s, v31.s[0]
+	WORD $0x6e24dc42 // fmul v2.4s, v2.4s, v4.4s
+	WORD $0x6e25dc63 // fmul v3.4s, v3.4s, v5.4s
+	WORD $0x4e22d400 // fadd v0.4s, v0.4s, v2.4s
+	WORD $0x4e23d421 // fadd v1.4s, v1.4s, v3.4s
+	SUBS $1, R2, R2
+	BNE  f32DotScaledLoop
+
+	REDUCE_F32x8
+	FMOVS F0, ret+32(FP)
+	RET
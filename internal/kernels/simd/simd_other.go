@@ -0,0 +1,98 @@
+//go:build !amd64 && !arm64
+
+package simd
+
+import "unsafe"
+
+func available() bool { return false }
+
+// The scalar bodies below only keep the package buildable; Available is
+// false so callers never reach them.
+
+func i2sI8SDot(packed *byte, vec *int8, blocks int) int32 {
+	p := unsafe.Slice(packed, blocks*32)
+	v := unsafe.Slice(vec, blocks*128)
+	var sum int32
+	for b := 0; b < blocks; b++ {
+		for j := 0; j < 32; j++ {
+			x := p[b*32+j]
+			sum += int32(x>>6&3) * int32(v[b*128+j])
+			sum += int32(x>>4&3) * int32(v[b*128+32+j])
+			sum += int32(x>>2&3) * int32(v[b*128+64+j])
+			sum += int32(x&3) * int32(v[b*128+96+j])
+		}
+	}
+	return sum
+}
+
+func i2sI8SAccum(sums *int32, packed *byte, stride int, vec *int8, cols int, shiftA, shiftB uint64) {
+	s := unsafe.Slice(sums, 64)
+	p := unsafe.Slice(packed, (cols-1)*stride+32)
+	v := unsafe.Slice(vec, cols)
+	for c := 0; c < cols; c++ {
+		a := int32(v[c])
+		for j := 0; j < 32; j++ {
+			x := p[c*stride+j]
+			s[j] += int32(x>>shiftA&3) * a
+			s[32+j] += int32(x>>shiftB&3) * a
+		}
+	}
+}
+
+func maxAbsF32(x *float32, n int) float32 {
+	var m float32
+	for _, v := range unsafe.Slice(x, n) {
+		if v < 0 {
+			v = -v
+		}
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+func quantizeI8S(dst *int8, x *float32, n int, scale float32) int32 {
+	d := unsafe.Slice(dst, n)
+	var sum int32
+	for i, v := range unsafe.Slice(x, n) {
+		q := nearestInt(v * scale)
+		if q < -128 {
+			q = -128
+		} else if q > 127 {
+			q = 127
+		}
+		d[i] = int8(q)
+		sum += q
+	}
+	return sum
+}
+
+func sumSquaresF64x4(x *float32, n int, lanes *[4]float64) {
+	for i, v := range unsafe.Slice(x, n) {
+		lanes[i&3] += float64(v) * float64(v)
+	}
+}
+
+func mulScaleF32(dst, x, w *float32, n int, s float32) {
+	d := unsafe.Slice(dst, n)
+	xs := unsafe.Slice(x, n)
+	ws := unsafe.Slice(w, n)
+	for i := range d {
+		d[i] = xs[i] * s * ws[i]
+	}
+}
+
+func dotF32x8(a, b *float32, n int) float32 {
+	return dotF32x8Scaled(a, b, n, 1)
+}
+
+func dotF32x8Scaled(a, b *float32, n int, s float32) float32 {
+	as := unsafe.Slice(a, n)
+	bs := unsafe.Slice(b, n)
+	var l [8]float32
+	for i := range as {
+		l[i&7] += as[i] * (bs[i] * s)
+	}
+	return ((l[0] + l[1]) + (l[2] + l[3])) + ((l[4] + l[5]) + (l[6] + l[7]))
+}
@@ -0,0 +1,210 @@
+package simd
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func requireAvailable(t *testing.T) {
+	t.Helper()
+	if !Available {
+		t.Skip("assembly kernels not available on this CPU")
+	}
+}
+
+func randVec(rng *rand.Rand, n int) []int8 {
+	v := make([]int8, n)
+	for i := range v {
+		v[i] = int8(rng.Intn(256) - 128)
+	}
+	return v
+}
+
+func randF32(rng *rand.Rand, n int) []float32 {
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64() * 3)
+	}
+	return v
+}
+
+func TestI2SI8SDot(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(1))
+	for _, blocks := range []int{1, 2, 7, 20} {
+		packed := make([]byte, blocks*32)
+		rng.Read(packed)
+		vec := randVec(rng, blocks*128)
+		var want int32
+		for b := 0; b < blocks; b++ {
+			for j := 0; j < 32; j++ {
+				x := packed[b*32+j]
+				want += int32(x>>6&3)*int32(vec[b*128+j]) +
+					int32(x>>4&3)*int32(vec[b*128+32+j]) +
+					int32(x>>2&3)*int32(vec[b*128+64+j]) +
+					int32(x&3)*int32(vec[b*128+96+j])
+			}
+		}
+		if got := I2SI8SDot(packed, vec); got != want {
+			t.Fatalf("blocks=%d: got %d, want %d", blocks, got, want)
+		}
+	}
+}
+
+func TestI2SI8SAccum(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(2))
+	const stride = 96
+	for _, cols := range []int{1, 5, 64} {
+		packed := make([]byte, (cols-1)*stride+32)
+		rng.Read(packed)
+		vec := randVec(rng, cols)
+		var got, want [128]int32
+		for i := range got {
+			got[i] = int32(i)
+			want[i] = int32(i)
+		}
+		for c := 0; c < cols; c++ {
+			for j := 0; j < 32; j++ {
+				x := packed[c*stride+j]
+				a := int32(vec[c])
+				want[j] += int32(x>>6&3) * a
+				want[32+j] += int32(x>>4&3) * a
+				want[64+j] += int32(x>>2&3) * a
+				want[96+j] += int32(x&3) * a
+			}
+		}
+		I2SI8SAccum(&got, packed, stride, vec)
+		if got != want {
+			t.Fatalf("cols=%d: got %v, want %v", cols, got, want)
+		}
+	}
+}
+
+func TestMaxAbs(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(3))
+	for _, n := range []int{0, 3, 8, 37, 256} {
+		x := randF32(rng, n)
+		if n > 10 {
+			x[5] = float32(math.NaN())
+			x[n-2] = -40
+		}
+		var want float32
+		for _, v := range x {
+			if v < 0 {
+				v = -v
+			}
+			if v > want {
+				want = v
+			}
+		}
+		if got := MaxAbs(x); got != want {
+			t.Fatalf("n=%d: got %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestQuantizeI8S(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(4))
+	for _, n := range []int{1, 8, 45, 2560} {
+		x := randF32(rng, n)
+		// Overshoot the range so clamping is exercised.
+		scale := float32(60)
+		got := make([]int8, n)
+		want := make([]int8, n)
+		var wantSum int32
+		for i, v := range x {
+			q := nearestInt(v * scale)
+			if q < -128 {
+				q = -128
+			} else if q > 127 {
+				q = 127
+			}
+			want[i] = int8(q)
+			wantSum += q
+		}
+		sum := QuantizeI8S(got, x, scale)
+		if sum != wantSum {
+			t.Fatalf("n=%d: sum %d, want %d", n, sum, wantSum)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("n=%d: dst[%d] = %d, want %d (x=%v)", n, i, got[i], want[i], x[i])
+			}
+		}
+	}
+}
+
+func TestSumSquares4(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(5))
+	for _, n := range []int{2, 4, 13, 2560} {
+		x := randF32(rng, n)
+		var want [4]float64
+		wantN := n &^ 3
+		for i := 0; i < wantN; i++ {
+			want[i&3] += float64(x[i]) * float64(x[i])
+		}
+		got, gotN := SumSquares4(x)
+		if got != want || gotN != wantN {
+			t.Fatalf("n=%d: got %v/%d, want %v/%d", n, got, gotN, want, wantN)
+		}
+	}
+}
+
+func TestMulScale(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(6))
+	for _, n := range []int{3, 16, 61} {
+		x := randF32(rng, n)
+		w := randF32(rng, n)
+		s := float32(0.377)
+		got := make([]float32, n)
+		MulScale(got, x, w, s)
+		for i := range got {
+			if want := x[i] * s * w[i]; got[i] != want {
+				t.Fatalf("n=%d: dst[%d] = %v, want %v", n, i, got[i], want)
+			}
+		}
+	}
+}
+
+func TestDotF32x8(t *testing.T) {
+	requireAvailable(t)
+	rng := rand.New(rand.NewSource(7))
+	ref := func(a, b []float32, s float32, scaled bool) float32 {
+		var l [8]float32
+		n := len(a) &^ 7
+		for i := 0; i < n; i++ {
+			bv := b[i]
+			if scaled {
+				bv *= s
+			}
+			l[i&7] += a[i] * bv
+		}
+		sum := (l[0] + l[1]) + (l[2] + l[3])
+		sum += (l[4] + l[5]) + (l[6] + l[7])
+		for i := n; i < len(a); i++ {
+			bv := b[i]
+			if scaled {
+				bv *= s
+			}
+			sum += a[i] * bv
+		}
+		return sum
+	}
+	for _, n := range []int{5, 8, 64, 131} {
+		a := randF32(rng, n)
+		b := randF32(rng, n)
+		if got, want := DotF32x8(a, b), ref(a, b, 1, false); got != want {
+			t.Fatalf("n=%d: DotF32x8 = %v, want %v", n, got, want)
+		}
+		const s = float32(0.125)
+		if got, want := DotF32x8Scaled(a, b, s), ref(a, b, s, true); got != want {
+			t.Fatalf("n=%d: DotF32x8Scaled = %v, want %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//go:build amd64 || arm64
+
+package kernels
+
+import (
+	"math"
+	"os"
+
+	"bitnet-go/internal/kernels/simd"
+)
+
+// useSIMD reports whether the Go-assembly kernels should be installed.
+// BITNET_DISABLE_ASM=1 keeps the pure-Go paths, e.g. to bisect a mismatch.
+func useSIMD() bool {
+	return simd.Available && os.Getenv("BITNET_DISABLE_ASM") != "1"
+}
+
+func init() {
+	if !useSIMD() {
+		return
+	}
+	quantizeRowI8SImpl = quantizeRowI8SSIMD
+	dotF32x8Impl = simd.DotF32x8
+	dotF32x8ScaledImpl = simd.DotF32x8Scaled
+	if !parityStrict() {
+		rmsNormImpl = rmsNormSIMD
+	}
+}
+
+func quantizeRowI8SSIMD(dst []int8, src []float32) (scale float32, sum int32) {
+	n := len(src)
+	if len(dst) < n {
+		n = len(dst)
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	maxAbs := float64(simd.MaxAbs(src[:n]))
+	if maxAbs < 1e-5 {
+		maxAbs = 1e-5
+	}
+	scale = 127.0 / float32(maxAbs)
+	return scale, simd.QuantizeI8S(dst[:n], src[:n], scale)
+}
+
+// rmsNormSIMD matches rmsNormOpt bit for bit: the same four float64 lanes
+// for the sum of squares and the same x*inv*weight product.
+func rmsNormSIMD(dst, x, weight []float32, eps float32) {
+	n := len(dst)
+	if len(x) < n {
+		n = len(x)
+	}
+	if len(weight) < n {
+		n = len(weight)
+	}
+	if n == 0 {
+		return
+	}
+	var inv float32
+	if matchGGML() {
+		var sum float32
+		for i := 0; i < n; i++ {
+			v := x[i]
+			sum += v * v
+		}
+		inv = float32(1.0 / math.Sqrt(float64(sum)/float64(n)+float64(eps)))
+	} else {
+		lanes, i := simd.SumSquares4(x[:n])
+		sum := lanes[0] + lanes[1] + lanes[2] + lanes[3]
+		for ; i < n; i++ {
+			v := float64(x[i])
+			sum += v * v
+		}
+		inv = float32(1.0 / math.Sqrt(sum/float64(n)+float64(eps)))
+	}
+	simd.MulScale(dst[:n], x[:n], weight[:n], inv)
+}
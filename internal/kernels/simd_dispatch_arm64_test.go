@@ -0,0 +1,97 @@
+package kernels
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"bitnet-go/internal/kernels/simd"
+)
+
+func TestNEONInstalledByDefault(t *testing.T) {
+	if os.Getenv("BITNET_DISABLE_ASM") == "1" {
+		t.Skip("BITNET_DISABLE_ASM=1")
+	}
+	if !useSIMD() {
+		t.Fatal("useSIMD() = false on arm64, want the NEON kernels installed")
+	}
+}
+
+// TestNEONMatchesGenericTails sweeps every tail length around the 4- and
+// 8-lane loops, from unaligned starts, against the pure-Go kernels.
+func TestNEONMatchesGenericTails(t *testing.T) {
+	requireSIMD(t)
+	rng := rand.New(rand.NewSource(11))
+	buf := make([]float32, 300)
+	wbuf := make([]float32, 300)
+	for i := range buf {
+		buf[i] = float32(rng.NormFloat64() * 2)
+		wbuf[i] = 1 + float32(rng.NormFloat64())*0.1
+	}
+	for off := 0; off < 4; off++ {
+		for n := 1; n <= 70; n++ {
+			x := buf[off : off+n]
+			w := wbuf[off : off+n]
+
+			wantQ := make([]int8, n)
+			gotQ := make([]int8, n)
+			wantScale, wantSum := quantizeRowI8SGeneric(wantQ, x)
+			gotScale, gotSum := quantizeRowI8SSIMD(gotQ, x)
+			if gotScale != wantScale || gotSum != wantSum {
+				t.Fatalf("off=%d n=%d quantize scale/sum = %v/%d, want %v/%d", off, n, gotScale, gotSum, wantScale, wantSum)
+			}
+			for i := range wantQ {
+				if gotQ[i] != wantQ[i] {
+					t.Fatalf("off=%d n=%d quantize[%d] = %d, want %d", off, n, i, gotQ[i], wantQ[i])
+				}
+			}
+
+			wantN := make([]float32, n)
+			gotN := make([]float32, n)
+			rmsNormOpt(wantN, x, w, 1e-5)
+			rmsNormSIMD(gotN, x, w, 1e-5)
+			for i := range wantN {
+				if gotN[i] != wantN[i] {
+					t.Fatalf("off=%d n=%d rmsnorm[%d] = %v, want %v", off, n, i, gotN[i], wantN[i])
+				}
+			}
+
+			if got, want := simd.DotF32x8(x, w), dotF32x8Generic(x, w); got != want {
+				t.Fatalf("off=%d n=%d dot = %v, want %v", off, n, got, want)
+			}
+			if got, want := simd.DotF32x8Scaled(x, w, 0.3), dotF32x8ScaledGeneric(x, w, 0.3); got != want {
+				t.Fatalf("off=%d n=%d scaled dot = %v, want %v", off, n, got, want)
+			}
+		}
+	}
+}
+
+func TestNEONI2SI8SShapes(t *testing.T) {
+	requireSIMD(t)
+	const weightScale, actScale = float32(0.9), float32(41)
+	for _, rows := range []int{128, 130, 255, 512} {
+		for _, cols := range []int{1, 3, 17} {
+			packed, vec, actSum := makeI2SI8SCase(rows, cols, rows, int64(rows*cols))
+			want := make([]float32, cols)
+			got := make([]float32, cols)
+			MatVecTI2SI8SRef(want, packed, rows, cols, vec, weightScale, actScale)
+			matVecTI2SI8SSIMD(got, packed, rows, cols, vec, weightScale, actScale, actSum)
+			for c := range want {
+				if got[c] != want[c] {
+					t.Fatalf("T %dx%d col %d = %v, want %v", rows, cols, c, got[c], want[c])
+				}
+			}
+
+			packed, vec, actSum = makeI2SI8SCase(rows, cols, cols, int64(rows+cols))
+			want = make([]float32, rows)
+			got = make([]float32, rows)
+			MatVecI2SI8SRef(want, packed, rows, cols, vec, weightScale, actScale)
+			matVecI2SI8SSIMD(got, packed, rows, cols, vec, weightScale, actScale, actSum)
+			for r := range want {
+				if got[r] != want[r] {
+					t.Fatalf("%dx%d row %d = %v, want %v", rows, cols, r, got[r], want[r])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package kernels
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NUMANode is one memory node and the online CPUs attached to it.
+type NUMANode struct {
+	ID   int
+	CPUs []int
+}
+
+// CPUTopology is the NUMA layout the kernel scheduler partitions work by.
+type CPUTopology struct {
+	Nodes []NUMANode
+}
+
+// NumCPUs returns the number of CPUs across all nodes.
+func (t CPUTopology) NumCPUs() int {
+	n := 0
+	for _, node := range t.Nodes {
+		n += len(node.CPUs)
+	}
+	return n
+}
+
+// FlatTopology returns a single node holding CPUs 0..n-1, the layout used
+// when sysfs is unavailable.
+func FlatTopology(n int) CPUTopology {
+	if n < 1 {
+		n = 1
+	}
+	cpus := make([]int, n)
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return CPUTopology{Nodes: []NUMANode{{ID: 0, CPUs: cpus}}}
+}
+
+// ReadCPUTopology reads the online CPUs under root (normally
+// /sys/devices/system/cpu) and groups them by the nodeN link in each cpuN
+// directory. Kernels without NUMA support expose no links; their CPUs are
+// grouped by physical package instead, which matches nodes on common
+// dual-socket hosts.
+func ReadCPUTopology(root string) (CPUTopology, error) {
+	raw, err := os.ReadFile(filepath.Join(root, "online"))
+	if err != nil {
+		return CPUTopology{}, err
+	}
+	cpus, err := parseCPUList(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return CPUTopology{}, fmt.Errorf("parse %s/online: %w", root, err)
+	}
+	if len(cpus) == 0 {
+		return CPUTopology{}, fmt.Errorf("%s/online lists no CPUs", root)
+	}
+	byNode := make(map[int][]int)
+	for _, cpu := range cpus {
+		id := cpuNodeID(filepath.Join(root, "cpu"+strconv.Itoa(cpu)))
+		byNode[id] = append(byNode[id], cpu)
+	}
+	ids := make([]int, 0, len(byNode))
+	for id := range byNode {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	topo := CPUTopology{Nodes: make([]NUMANode, 0, len(ids))}
+	for _, id := range ids {
+		topo.Nodes = append(topo.Nodes, NUMANode{ID: id, CPUs: byNode[id]})
+	}
+	return topo, nil
+}
+
+func cpuNodeID(dir string) int {
+	if matches, _ := filepath.Glob(filepath.Join(dir, "node[0-9]*")); len(matches) > 0 {
+		if id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(matches[0]), "node")); err == nil {
+			return id
+		}
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "topology", "physical_package_id"))
+	if err != nil {
+		return 0
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// parseCPUList parses the sysfs list format, e.g. "0-3,8,10-11".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	if s == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, err
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("bad cpu range %q", part)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// SystemTopology returns the host topology, falling back to a flat layout
+// of runtime.NumCPU CPUs off Linux or when sysfs cannot be read.
+func SystemTopology() CPUTopology {
+	if runtime.GOOS == "linux" {
+		if topo, err := ReadCPUTopology("/sys/devices/system/cpu"); err == nil {
+			return topo
+		}
+	}
+	return FlatTopology(runtime.NumCPU())
+}
@@ -0,0 +1,240 @@
+package kernels
+
+// TQ1_0 and TQ2_0 store ternary weights in 256-element blocks, each with its
+// own f16 scale. A matrix is GGML [rows][cols] with rows (the input dim)
+// contiguous, so column c is blocks [c*rows/256, (c+1)*rows/256).
+const (
+	TQBlockElems  = 256
+	TQ1BlockBytes = 48 + 4 + 2 // qs, qh, d
+	TQ2BlockBytes = 64 + 2     // qs, d
+)
+
+var matVecTTQ1I8SFast func(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int)
+var matVecTTQ2I8SFast func(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int)
+var tqParallelColsMin = envIntArch("BITNET_TQ_PAR_COLS_MIN", 512)
+
+// tq1Trits decodes one TQ1_0 byte into its five base-3 digits minus one. The
+// first four also decode qh bytes.
+var tq1Trits = func() [256][5]int8 {
+	var table [256][5]int8
+	pow3 := [5]uint16{1, 3, 9, 27, 81}
+	for b := 0; b < 256; b++ {
+		for n, p := range pow3 {
+			q := uint8(uint16(b) * p)
+			table[b][n] = int8((uint16(q)*3)>>8) - 1
+		}
+	}
+	return table
+}()
+
+// decodeTQ1Block expands a TQ1_0 block into 256 values in {-1,0,1}.
+func decodeTQ1Block(dst *[TQBlockElems]int8, blk []byte) {
+	qs := blk[:48]
+	qh := blk[48:52]
+	for m := 0; m < 32; m++ {
+		t := &tq1Trits[qs[m]]
+		dst[m] = t[0]
+		dst[32+m] = t[1]
+		dst[64+m] = t[2]
+		dst[96+m] = t[3]
+		dst[128+m] = t[4]
+	}
+	for m := 0; m < 16; m++ {
+		t := &tq1Trits[qs[32+m]]
+		dst[160+m] = t[0]
+		dst[176+m] = t[1]
+		dst[192+m] = t[2]
+		dst[208+m] = t[3]
+		dst[224+m] = t[4]
+	}
+	for j := 0; j < 4; j++ {
+		t := &tq1Trits[qh[j]]
+		dst[240+j] = t[0]
+		dst[244+j] = t[1]
+		dst[248+j] = t[2]
+		dst[252+j] = t[3]
+	}
+}
+
+// decodeTQ2Block expands a TQ2_0 block into 256 values in {-1,0,1}.
+func decodeTQ2Block(dst *[TQBlockElems]int8, blk []byte) {
+	for j := 0; j < 2; j++ {
+		qs := blk[j*32 : j*32+32]
+		out := dst[j*128 : j*128+128]
+		for m := 0; m < 32; m++ {
+			b := qs[m]
+			out[m] = int8(b&3) - 1
+			out[32+m] = int8((b>>2)&3) - 1
+			out[64+m] = int8((b>>4)&3) - 1
+			out[96+m] = int8(b>>6) - 1
+		}
+	}
+}
+
+func dotI8x256(w *[TQBlockElems]int8, vec []int8) int32 {
+	vec = vec[:TQBlockElems]
+	var sum int32
+	for i := 0; i < TQBlockElems; i += 8 {
+		sum += int32(w[i])*int32(vec[i]) +
+			int32(w[i+1])*int32(vec[i+1]) +
+			int32(w[i+2])*int32(vec[i+2]) +
+			int32(w[i+3])*int32(vec[i+3]) +
+			int32(w[i+4])*int32(vec[i+4]) +
+			int32(w[i+5])*int32(vec[i+5]) +
+			int32(w[i+6])*int32(vec[i+6]) +
+			int32(w[i+7])*int32(vec[i+7])
+	}
+	return sum
+}
+
+func tqBlockScale(blk []byte) float32 {
+	n := len(blk)
+	return Float16ToFloat32(uint16(blk[n-2]) | uint16(blk[n-1])<<8)
+}
+
+func tqShapeOK(dst []float32, packed []byte, rows, cols int, vec []int8, blockBytes int) bool {
+	if rows <= 0 || cols <= 0 || rows%TQBlockElems != 0 {
+		return false
+	}
+	if len(dst) < cols || len(vec) < rows {
+		return false
+	}
+	return len(packed) >= rows/TQBlockElems*cols*blockBytes
+}
+
+// MatVecTTQ1I8S computes dst = transpose(mat) * vec for a TQ1_0 matrix and an
+// i8_s-quantized vec: dst[c] = sum_b d_b * dot(block_b, vec_b) / actScale.
+// rows must be a multiple of 256.
+func MatVecTTQ1I8S(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32) {
+	if !tqShapeOK(dst, packed, rows, cols, vec, TQ1BlockBytes) {
+		return
+	}
+	matVecTTQI8S(dst, packed, rows, cols, vec, actScale, matVecTTQ1I8SFast, matVecTTQ1I8SGeneric)
+}
+
+// MatVecTTQ2I8S is MatVecTTQ1I8S for TQ2_0 matrices.
+func MatVecTTQ2I8S(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32) {
+	if !tqShapeOK(dst, packed, rows, cols, vec, TQ2BlockBytes) {
+		return
+	}
+	matVecTTQI8S(dst, packed, rows, cols, vec, actScale, matVecTTQ2I8SFast, matVecTTQ2I8SGeneric)
+}
+
+type tqRangeFunc func(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int)
+
+func matVecTTQI8S(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, fast, generic tqRangeFunc) {
+	kernel := generic
+	if fast != nil {
+		kernel = fast
+	}
+	parallelCols(cols, tqParallelColsMin, func(start, end int) {
+		kernel(dst, packed, rows, cols, vec, actScale, start, end)
+	})
+}
+
+// parallelCols runs fn over [0, cols) split into matVecThreads ranges on the
+// shared scheduler, or inline when threads <= 1 or cols < minCols.
+func parallelCols(cols, minCols int, fn func(start, end int)) {
+	threads := matVecThreads()
+	if threads <= 1 || cols < minCols {
+		fn(0, cols)
+		return
+	}
+	if threads > cols {
+		threads = cols
+	}
+	DefaultScheduler().ParallelRanges(cols, (cols+threads-1)/threads, fn)
+}
+
+func matVecTTQ1I8SGeneric(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int) {
+	matVecTTQRangeGeneric(dst, packed, rows, vec, actScale, cStart, cEnd, TQ1BlockBytes, decodeTQ1Block)
+}
+
+func matVecTTQ2I8SGeneric(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int) {
+	matVecTTQRangeGeneric(dst, packed, rows, vec, actScale, cStart, cEnd, TQ2BlockBytes, decodeTQ2Block)
+}
+
+func matVecTTQRangeGeneric(dst []float32, packed []byte, rows int, vec []int8, actScale float32, cStart, cEnd, blockBytes int, decode func(*[TQBlockElems]int8, []byte)) {
+	blocks := rows / TQBlockElems
+	var w [TQBlockElems]int8
+	for c := cStart; c < cEnd; c++ {
+		base := c * blocks * blockBytes
+		var sum float32
+		for b := 0; b < blocks; b++ {
+			blk := packed[base+b*blockBytes : base+(b+1)*blockBytes]
+			decode(&w, blk)
+			sum += tqBlockScale(blk) * float32(dotI8x256(&w, vec[b*TQBlockElems:]))
+		}
+		dst[c] = sum / actScale
+	}
+}
+
+// MatVecTTQ1I8SRef is the element-wise reference for MatVecTTQ1I8S.
+func MatVecTTQ1I8SRef(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32) {
+	if !tqShapeOK(dst, packed, rows, cols, vec, TQ1BlockBytes) {
+		return
+	}
+	pow3 := [6]uint16{1, 3, 9, 27, 81, 243}
+	trit := func(b byte, n int) int32 {
+		q := uint8(uint16(b) * pow3[n])
+		return int32((uint16(q)*3)>>8) - 1
+	}
+	blocks := rows / TQBlockElems
+	for c := 0; c < cols; c++ {
+		var sum float32
+		for b := 0; b < blocks; b++ {
+			blk := packed[(c*blocks+b)*TQ1BlockBytes:]
+			v := vec[b*TQBlockElems:]
+			var dot int32
+			i := 0
+			for j := 0; j < 48; j += 32 {
+				width := 32
+				if j == 32 {
+					width = 16
+				}
+				for n := 0; n < 5; n++ {
+					for m := 0; m < width; m++ {
+						dot += trit(blk[j+m], n) * int32(v[i])
+						i++
+					}
+				}
+			}
+			for n := 0; n < 4; n++ {
+				for j := 0; j < 4; j++ {
+					dot += trit(blk[48+j], n) * int32(v[i])
+					i++
+				}
+			}
+			sum += tqBlockScale(blk[:TQ1BlockBytes]) * float32(dot)
+		}
+		dst[c] = sum / actScale
+	}
+}
+
+// MatVecTTQ2I8SRef is the element-wise reference for MatVecTTQ2I8S.
+func MatVecTTQ2I8SRef(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32) {
+	if !tqShapeOK(dst, packed, rows, cols, vec, TQ2BlockBytes) {
+		return
+	}
+	blocks := rows / TQBlockElems
+	for c := 0; c < cols; c++ {
+		var sum float32
+		for b := 0; b < blocks; b++ {
+			blk := packed[(c*blocks+b)*TQ2BlockBytes:]
+			v := vec[b*TQBlockElems:]
+			var dot int32
+			i := 0
+			for j := 0; j < 64; j += 32 {
+				for l := 0; l < 4; l++ {
+					for m := 0; m < 32; m++ {
+						q := int32((blk[j+m]>>(2*l))&3) - 1
+						dot += q * int32(v[i])
+						i++
+					}
+				}
+			}
+			sum += tqBlockScale(blk[:TQ2BlockBytes]) * float32(dot)
+		}
+		dst[c] = sum / actScale
+	}
+}
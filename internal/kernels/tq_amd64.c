@@ -0,0 +1,141 @@
+#include <stdint.h>
+#include <string.h>
+#include <immintrin.h>
+
+static float tq_f16_to_f32(uint16_t h) {
+    uint32_t sign = (uint32_t)(h & 0x8000) << 16;
+    int exp = (h >> 10) & 0x1f;
+    uint32_t mant = h & 0x03ff;
+    uint32_t bits;
+    if (exp == 0) {
+        if (mant == 0) {
+            bits = sign;
+        } else {
+            int32_t e = 113;
+            while ((mant & 0x0400) == 0) {
+                mant <<= 1;
+                e--;
+            }
+            mant &= 0x03ff;
+            bits = sign | ((uint32_t)e << 23) | (mant << 13);
+        }
+    } else if (exp == 0x1f) {
+        bits = sign | 0x7f800000 | (mant << 13);
+    } else {
+        bits = sign | ((uint32_t)(exp + 112) << 23) | (mant << 13);
+    }
+    float f;
+    memcpy(&f, &bits, sizeof(f));
+    return f;
+}
+
+static int g_tq1_table_init = 0;
+static int8_t g_tq1_trits[256][5];
+
+static void init_tq1_table(void) {
+    if (g_tq1_table_init) {
+        return;
+    }
+    static const uint16_t pow3[5] = {1, 3, 9, 27, 81};
+    for (int b = 0; b < 256; b++) {
+        for (int n = 0; n < 5; n++) {
+            uint8_t q = (uint8_t)(b * pow3[n]);
+            g_tq1_trits[b][n] = (int8_t)(((uint16_t)q * 3) >> 8) - 1;
+        }
+    }
+    g_tq1_table_init = 1;
+}
+
+static inline int32_t hsum_epi32_avx2(__m256i v) {
+    __m128i s = _mm_add_epi32(_mm256_castsi256_si128(v), _mm256_extracti128_si256(v, 1));
+    s = _mm_add_epi32(s, _mm_shuffle_epi32(s, _MM_SHUFFLE(2, 3, 0, 1)));
+    s = _mm_add_epi32(s, _mm_shuffle_epi32(s, _MM_SHUFFLE(1, 0, 3, 2)));
+    return _mm_cvtsi128_si32(s);
+}
+
+static inline int32_t dot_i8x256_avx2(const int8_t *a, const int8_t *b) {
+    __m256i acc = _mm256_setzero_si256();
+    for (int i = 0; i < 256; i += 16) {
+        __m256i va = _mm256_cvtepi8_epi16(_mm_loadu_si128((const __m128i *)(a + i)));
+        __m256i vb = _mm256_cvtepi8_epi16(_mm_loadu_si128((const __m128i *)(b + i)));
+        acc = _mm256_add_epi32(acc, _mm256_madd_epi16(va, vb));
+    }
+    return hsum_epi32_avx2(acc);
+}
+
+static void decode_tq1_block(int8_t *dst, const uint8_t *blk) {
+    const uint8_t *qs = blk;
+    const uint8_t *qh = blk + 48;
+    for (int m = 0; m < 32; m++) {
+        const int8_t *t = g_tq1_trits[qs[m]];
+        dst[m] = t[0];
+        dst[32 + m] = t[1];
+        dst[64 + m] = t[2];
+        dst[96 + m] = t[3];
+        dst[128 + m] = t[4];
+    }
+    for (int m = 0; m < 16; m++) {
+        const int8_t *t = g_tq1_trits[qs[32 + m]];
+        dst[160 + m] = t[0];
+        dst[176 + m] = t[1];
+        dst[192 + m] = t[2];
+        dst[208 + m] = t[3];
+        dst[224 + m] = t[4];
+    }
+    for (int j = 0; j < 4; j++) {
+        const int8_t *t = g_tq1_trits[qh[j]];
+        dst[240 + j] = t[0];
+        dst[244 + j] = t[1];
+        dst[248 + j] = t[2];
+        dst[252 + j] = t[3];
+    }
+}
+
+void matvec_t_tq1_i8s_avx2(float *dst, const uint8_t *packed, int rows, int c_start, int c_end, const int8_t *vec, float act_scale) {
+    init_tq1_table();
+    const int blocks = rows / 256;
+    const int block_bytes = 54;
+    int8_t w[256];
+    for (int c = c_start; c < c_end; c++) {
+        const uint8_t *blk = packed + (size_t)c * blocks * block_bytes;
+        float sum = 0.0f;
+        for (int b = 0; b < blocks; b++, blk += block_bytes) {
+            decode_tq1_block(w, blk);
+            const int32_t dot = dot_i8x256_avx2(w, vec + b * 256);
+            const float d = tq_f16_to_f32((uint16_t)(blk[52] | (blk[53] << 8)));
+            sum += d * (float)dot;
+        }
+        dst[c] = sum / act_scale;
+    }
+}
+
+// TQ2_0 codes are unsigned 0..2, so each block dot is
+// maddubs(q, v) - maddubs(1, v) = sum (q-1)*v without decoding to int8.
+void matvec_t_tq2_i8s_avx2(float *dst, const uint8_t *packed, int rows, int c_start, int c_end, const int8_t *vec, float act_scale) {
+    const int blocks = rows / 256;
+    const int block_bytes = 66;
+    const __m256i mask = _mm256_set1_epi8(3);
+    const __m256i ones8 = _mm256_set1_epi8(1);
+    const __m256i ones16 = _mm256_set1_epi16(1);
+    for (int c = c_start; c < c_end; c++) {
+        const uint8_t *blk = packed + (size_t)c * blocks * block_bytes;
+        float sum = 0.0f;
+        for (int b = 0; b < blocks; b++, blk += block_bytes) {
+            const int8_t *v = vec + b * 256;
+            __m256i acc = _mm256_setzero_si256();
+            for (int j = 0; j < 2; j++) {
+                const __m256i qs = _mm256_loadu_si256((const __m256i *)(blk + j * 32));
+                for (int l = 0; l < 4; l++) {
+                    const __m256i q = _mm256_and_si256(_mm256_srli_epi16(qs, 2 * l), mask);
+                    const __m256i a = _mm256_loadu_si256((const __m256i *)(v + j * 128 + l * 32));
+                    const __m256i p = _mm256_sub_epi16(_mm256_maddubs_epi16(q, a), _mm256_maddubs_epi16(ones8, a));
+                    acc = _mm256_add_epi32(acc, _mm256_madd_epi16(p, ones16));
+                }
+            }
+            const int32_t dot = hsum_epi32_avx2(acc);
+            const float d = tq_f16_to_f32((uint16_t)(blk[64] | (blk[65] << 8)));
+            sum += d * (float)dot;
+        }
+        dst[c] = sum / act_scale;
+    }
+}
@@ -0,0 +1,50 @@
+//go:build amd64 && cgo
+
+package kernels
+
+/*
+#cgo CFLAGS: -mavx2
+#include <stdint.h>
+int bitnet_has_avx2();
+void matvec_t_tq1_i8s_avx2(float *dst, const uint8_t *packed, int rows, int c_start, int c_end, const int8_t *vec, float act_scale);
+void matvec_t_tq2_i8s_avx2(float *dst, const uint8_t *packed, int rows, int c_start, int c_end, const int8_t *vec, float act_scale);
+*/
+import "C"
+import (
+	"os"
+	"unsafe"
+)
+
+func init() {
+	if os.Getenv("BITNET_TQ_DISABLE_FAST") == "1" {
+		return
+	}
+	if os.Getenv("BITNET_FORCE_AVX2") == "1" || C.bitnet_has_avx2() != 0 {
+		matVecTTQ1I8SFast = matVecTTQ1I8SAVX2
+		matVecTTQ2I8SFast = matVecTTQ2I8SAVX2
+	}
+}
+
+func matVecTTQ1I8SAVX2(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int) {
+	C.matvec_t_tq1_i8s_avx2(
+		(*C.float)(unsafe.Pointer(&dst[0])),
+		(*C.uint8_t)(unsafe.Pointer(&packed[0])),
+		C.int(rows),
+		C.int(cStart),
+		C.int(cEnd),
+		(*C.int8_t)(unsafe.Pointer(&vec[0])),
+		C.float(actScale),
+	)
+}
+
+func matVecTTQ2I8SAVX2(dst []float32, packed []byte, rows, cols int, vec []int8, actScale float32, cStart, cEnd int) {
+	C.matvec_t_tq2_i8s_avx2(
+		(*C.float)(unsafe.Pointer(&dst[0])),
+		(*C.uint8_t)(unsafe.Pointer(&packed[0])),
+		C.int(rows),
+		C.int(cStart),
+		C.int(cEnd),
+		(*C.int8_t)(unsafe.Pointer(&vec[0])),
+		C.float(actScale),
+	)
+}
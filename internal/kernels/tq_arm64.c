@@ -0,0 +1,143 @@
+#include <stdint.h>
+#include <string.h>
+#if defined(__aarch64__) && defined(__ARM_NEON)
+#include <arm_neon.h>
+#endif
+
+static float tq_f16_to_f32(uint16_t h) {
+    uint32_t sign = (uint32_t)(h & 0x8000) << 16;
+    int exp = (h >> 10) & 0x1f;
+    uint32_t mant = h & 0x03ff;
+    uint32_t bits;
+    if (exp == 0) {
+        if (mant == 0) {
+            bits = sign;
+        } else {
+            int32_t e = 113;
+            while ((mant & 0x0400) == 0) {
+                mant <<= 1;
+                e--;
+            }
+            mant &= 0x03ff;
+            bits = sign | ((uint32_t)e << 23) | (mant << 13);
+        }
+    } else if (exp == 0x1f) {
+        bits = sign | 0x7f800000 | (mant << 13);
+    } else {
+        bits = sign | ((uint32_t)(exp + 112) << 23) | (mant << 13);
+    }
+    float f;
+    memcpy(&f, &bits, sizeof(f));
+    return f;
+}
+
+static int g_tq1_table_init = 0;
+static int8_t g_tq1_trits[256][5];
+
+static void init_tq1_table(void) {
+    if (g_tq1_table_init) {
+        return;
+    }
+    static const uint16_t pow3[5] = {1, 3, 9, 27, 81};
+    for (int b = 0; b < 256; b++) {
+        for (int n = 0; n < 5; n++) {
+            uint8_t q = (uint8_t)(b * pow3[n]);
+            g_tq1_trits[b][n] = (int8_t)(((uint16_t)q * 3) >> 8) - 1;
+        }
+    }
+    g_tq1_table_init = 1;
+}
+
+static inline int32_t dot_i8x256(const int8_t *a, const int8_t *b) {
+#if defined(__aarch64__) && defined(__ARM_NEON)
+    int32x4_t acc = vdupq_n_s32(0);
+    for (int i = 0; i < 256; i += 16) {
+        int8x16_t va = vld1q_s8(a + i);
+        int8x16_t vb = vld1q_s8(b + i);
+        acc = vpadalq_s16(acc, vmull_s8(vget_low_s8(va), vget_low_s8(vb)));
+        acc = vpadalq_s16(acc, vmull_s8(vget_high_s8(va), vget_high_s8(vb)));
+    }
+    return vaddvq_s32(acc);
+#else
+    int32_t sum = 0;
+    for (int i = 0; i < 256; i++) {
+        sum += (int32_t)a[i] * (int32_t)b[i];
+    }
+    return sum;
+#endif
+}
+
+static void decode_tq1_block(int8_t *dst, const uint8_t *blk) {
+    const uint8_t *qs = blk;
+    const uint8_t *qh = blk + 48;
+    for (int m = 0; m < 32; m++) {
+        const int8_t *t = g_tq1_trits[qs[m]];
+        dst[m] = t[0];
+        dst[32 + m] = t[1];
+        dst[64 + m] = t[2];
+        dst[96 + m] = t[3];
+        dst[128 + m] = t[4];
+    }
+    for (int m = 0; m < 16; m++) {
+        const int8_t *t = g_tq1_trits[qs[32 + m]];
+        dst[160 + m] = t[0];
+        dst[176 + m] = t[1];
+        dst[192 + m] = t[2];
+        dst[208 + m] = t[3];
+        dst[224 + m] = t[4];
+    }
+    for (int j = 0; j < 4; j++) {
+        const int8_t *t = g_tq1_trits[qh[j]];
+        dst[240 + j] = t[0];
+        dst[244 + j] = t[1];
+        dst[248 + j] = t[2];
+        dst[252 + j] = t[3];
+    }
+}
+
+static void decode_tq2_block(int8_t *dst, const uint8_t *blk) {
+    for (int j = 0; j < 2; j++) {
+        const uint8_t *qs = blk + j * 32;
+        int8_t *out = dst + j * 128;
+        for (int m = 0; m < 32; m++) {
+            const uint8_t b = qs[m];
+            out[m] = (int8_t)(b & 3) - 1;
+            out[32 + m] = (int8_t)((b >> 2) & 3) - 1;
+            out[64 + m] = (int8_t)((b >> 4) & 3) - 1;
+            out[96 + m] = (int8_t)(b >> 6) - 1;
+        }
+    }
+}
+
+void matvec_t_tq1_i8s_cgo(float *dst, const uint8_t *packed, int rows, int c_start, int c_end, const int8_t *vec, float act_scale) {
+    init_tq1_table();
+    const int blocks = rows / 256;
+    const int block_bytes = 54;
+    int8_t w[256];
+    for (int c = c_start; c < c_end; c++) {
+        const uint8_t *blk = packed + (size_t)c * blocks * block_bytes;
+        float sum = 0.0f;
+        for (int b = 0; b < blocks; b++, blk += block_bytes) {
+            decode_tq1_block(w, blk);
+            const float d = tq_f16_to_f32((uint16_t)(blk[52] | (blk[53] << 8)));
+            sum += d * (float)dot_i8x256(w, vec + b * 256);
+        }
+        dst[c] = sum / act_scale;
+    }
+}
+
+void matvec_t_tq2_i8s_cgo(float *dst, const uint8_t *packed, int rows, int c_start, int c_end, const int8_t *vec, float act_scale) {
+    const int blocks = rows / 256;
+    const int block_bytes = 66;
+    int8_t w[256];
+    for (int c = c_start; c < c_end; c++) {
+        const uint8_t *blk = packed + (size_t)c * blocks * block_bytes;
+        float sum = 0.0f;
+        for (int b = 0; b < blocks; b++, blk += block_bytes) {
+            decode_tq2_block(w, blk);
+            const float d = tq_f16_to_f32((uint16_t)(blk[64] | (blk[65] << 8)));
+            sum += d * (float)dot_i8x256(w, vec + b * 256);
+        }
+        dst[c] = sum / act_scale;
+    }
+}
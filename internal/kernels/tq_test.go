@@ -0,0 +1,166 @@
+package kernels
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// makeTQ1Packed packs random trits into TQ1_0 blocks using ggml's base-3
+// encoding and returns the packed bytes plus the dequantized matrix.
+func makeTQ1Packed(rows, cols int, seed int64) ([]byte, []float32) {
+	rng := rand.New(rand.NewSource(seed))
+	blocks := rows / TQBlockElems
+	packed := make([]byte, 0, blocks*cols*TQ1BlockBytes)
+	deq := make([]float32, rows*cols)
+	for c := 0; c < cols; c++ {
+		for b := 0; b < blocks; b++ {
+			var q [TQBlockElems]int
+			for i := range q {
+				q[i] = rng.Intn(3)
+			}
+			var blk [TQ1BlockBytes]byte
+			pack5 := func(vals []int) byte {
+				var v int
+				for _, x := range vals {
+					v = v*3 + x
+				}
+				for n := len(vals); n < 5; n++ {
+					v *= 3
+				}
+				return byte((v*256 + 242) / 243)
+			}
+			for m := 0; m < 32; m++ {
+				blk[m] = pack5([]int{q[m], q[32+m], q[64+m], q[96+m], q[128+m]})
+			}
+			for m := 0; m < 16; m++ {
+				blk[32+m] = pack5([]int{q[160+m], q[176+m], q[192+m], q[208+m], q[224+m]})
+			}
+			for j := 0; j < 4; j++ {
+				blk[48+j] = pack5([]int{q[240+j], q[244+j], q[248+j], q[252+j]})
+			}
+			scale := tqTestScale(rng)
+			blk[52], blk[53] = byte(scale), byte(scale>>8)
+			d := Float16ToFloat32(scale)
+			for i, v := range q {
+				deq[b*TQBlockElems+i+rows*c] = d * float32(v-1)
+			}
+			packed = append(packed, blk[:]...)
+		}
+	}
+	return packed, deq
+}
+
+// makeTQ2Packed is makeTQ1Packed for TQ2_0.
+func makeTQ2Packed(rows, cols int, seed int64) ([]byte, []float32) {
+	rng := rand.New(rand.NewSource(seed))
+	blocks := rows / TQBlockElems
+	packed := make([]byte, 0, blocks*cols*TQ2BlockBytes)
+	deq := make([]float32, rows*cols)
+	for c := 0; c < cols; c++ {
+		for b := 0; b < blocks; b++ {
+			var q [TQBlockElems]int
+			for i := range q {
+				q[i] = rng.Intn(3)
+			}
+			var blk [TQ2BlockBytes]byte
+			for j := 0; j < 2; j++ {
+				for l := 0; l < 4; l++ {
+					for m := 0; m < 32; m++ {
+						blk[j*32+m] |= byte(q[j*128+l*32+m]) << (2 * l)
+					}
+				}
+			}
+			scale := tqTestScale(rng)
+			blk[64], blk[65] = byte(scale), byte(scale>>8)
+			d := Float16ToFloat32(scale)
+			for i, v := range q {
+				deq[b*TQBlockElems+i+rows*c] = d * float32(v-1)
+			}
+			packed = append(packed, blk[:]...)
+		}
+	}
+	return packed, deq
+}
+
+func tqTestScale(rng *rand.Rand) uint16 {
+	scales := []uint16{0x3c00, 0x3800, 0x2e66, 0x4100}
+	return scales[rng.Intn(len(scales))]
+}
+
+func tqTestVec(rows int) []int8 {
+	rng := rand.New(rand.NewSource(7))
+	vec := make([]int8, rows)
+	for i := range vec {
+		vec[i] = int8(rng.Intn(255) - 127)
+	}
+	return vec
+}
+
+func tqClose(a, b float32) bool {
+	diff := math.Abs(float64(a - b))
+	return diff <= 1e-4*math.Max(1, math.Abs(float64(b)))
+}
+
+func TestMatVecTTQI8SMatchesRef(t *testing.T) {
+	const rows, cols = 512, 24
+	vec := tqTestVec(rows)
+	const actScale = float32(3.5)
+	cases := []struct {
+		name    string
+		packed  []byte
+		deq     []float32
+		kernel  func([]float32, []byte, int, int, []int8, float32)
+		ref     func([]float32, []byte, int, int, []int8, float32)
+		generic tqRangeFunc
+	}{
+		{"tq1_0", nil, nil, MatVecTTQ1I8S, MatVecTTQ1I8SRef, matVecTTQ1I8SGeneric},
+		{"tq2_0", nil, nil, MatVecTTQ2I8S, MatVecTTQ2I8SRef, matVecTTQ2I8SGeneric},
+	}
+	cases[0].packed, cases[0].deq = makeTQ1Packed(rows, cols, 1)
+	cases[1].packed, cases[1].deq = makeTQ2Packed(rows, cols, 2)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := make([]float32, cols)
+			tc.ref(want, tc.packed, rows, cols, vec, actScale)
+
+			// The reference must agree with a plain f32 matvec over the
+			// dequantized weights.
+			for c := 0; c < cols; c++ {
+				var sum float64
+				for r := 0; r < rows; r++ {
+					sum += float64(tc.deq[r+rows*c]) * float64(vec[r])
+				}
+				if got := float32(sum / float64(actScale)); !tqClose(want[c], got) {
+					t.Fatalf("ref col %d = %f, dequant = %f", c, want[c], got)
+				}
+			}
+
+			generic := make([]float32, cols)
+			tc.generic(generic, tc.packed, rows, cols, vec, actScale, 0, cols)
+			for c := range want {
+				if generic[c] != want[c] {
+					t.Fatalf("generic col %d = %f, want %f", c, generic[c], want[c])
+				}
+			}
+
+			got := make([]float32, cols)
+			tc.kernel(got, tc.packed, rows, cols, vec, actScale)
+			for c := range want {
+				if !tqClose(got[c], want[c]) {
+					t.Fatalf("kernel col %d = %f, want %f", c, got[c], want[c])
+				}
+			}
+		})
+	}
+}
+
+func TestMatVecTTQI8SRejectsBadShape(t *testing.T) {
+	packed, _ := makeTQ2Packed(256, 2, 3)
+	dst := []float32{42, 42}
+	MatVecTTQ2I8S(dst, packed, 200, 2, tqTestVec(256), 1)
+	if dst[0] != 42 || dst[1] != 42 {
+		t.Fatalf("dst modified for rows not a multiple of 256: %v", dst)
+	}
+}
@@ -0,0 +1,120 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bitnet-go/internal/gguf"
+)
+
+// architecture is one decoder family the runtime can execute. Implementations
+// are registered under their general.architecture name and share the generic
+// prefill/shift/sample loop in runForwardArchitecture.
+type architecture interface {
+	// loadBlock reads weights and hyperparameters. found=false means the file
+	// does not carry this architecture's tensors.
+	loadBlock(info gguf.ModelInfo, loader *modelTensorLoader) (block *tensorBlock, found bool, err error)
+	// layerCount reports how many per-layer states the block needs.
+	layerCount(block *tensorBlock) int
+	// ensureLayerState sizes the scratch and KV cache for one layer.
+	ensureLayerState(block *tensorBlock, st *llamaLayerState, layer, maxSeq int, kvType KVCacheType)
+	// step runs one token at pos through every layer, filling logits when
+	// computeLogits is set. An error stops generation and is returned from
+	// Generate.
+	step(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) error
+}
+
+// architectureForwarder is implemented by architectures that bring their own
+// generation loop instead of runForwardArchitecture.
+type architectureForwarder interface {
+	forward(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig, opts forwardOptions) error
+}
+
+var architectures = map[string]architecture{}
+
+// registerArchitecture makes a available under each name. It is meant to be
+// called from init functions and panics on duplicates.
+func registerArchitecture(a architecture, names ...string) {
+	for _, name := range names {
+		if _, dup := architectures[name]; dup {
+			panic("runtime: duplicate architecture " + name)
+		}
+		architectures[name] = a
+	}
+}
+
+func lookupArchitecture(name string) (architecture, bool) {
+	a, ok := architectures[name]
+	return a, ok
+}
+
+func registeredArchitectures() []string {
+	names := make([]string, 0, len(architectures))
+	for name := range architectures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrUnsupportedArchitecture is returned when general.architecture names a
+// family with no registered implementation.
+type ErrUnsupportedArchitecture struct {
+	Architecture string
+	Supported    []string
+}
+
+func (e *ErrUnsupportedArchitecture) Error() string {
+	return fmt.Sprintf("unsupported architecture %q (supported: %s)", e.Architecture, strings.Join(e.Supported, ", "))
+}
+
+func runForwardArchitecture(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig, opts forwardOptions) error {
+	a := block.arch
+	if f, ok := a.(architectureForwarder); ok {
+		return f.forward(block, seed, promptTokens, out, topk, forceTokens, cfg, opts)
+	}
+	return runForwardKVStack(block, a.layerCount(block), seed, promptTokens, out, topk, forceTokens, cfg, opts,
+		func(st *llamaLayerState, i, maxSeq int) {
+			a.ensureLayerState(block, st, i, maxSeq, opts.kvCacheType)
+		},
+		a.step)
+}
+
+// llamaArchitecture covers the llama-layout stacks (separate q/k/v, gated
+// FFN, RMSNorm). Variants differ only in RoPE layout, FFN activation and
+// whether the LM head is tied to the token embeddings.
+type llamaArchitecture struct {
+	ropeNeox   bool
+	ffnUseSilu bool
+	tiedOutput bool
+}
+
+// defaultArchitecture loads files that omit general.architecture.
+var defaultArchitecture architecture = llamaArchitecture{}
+
+func init() {
+	registerArchitecture(llamaArchitecture{ffnUseSilu: true}, "llama")
+	registerArchitecture(llamaArchitecture{ropeNeox: true, tiedOutput: true}, "bitnet-b1.58", "bitnet", "bitnet-25")
+	registerArchitecture(llamaArchitecture{ropeNeox: true, ffnUseSilu: true}, "qwen2")
+}
+
+func (a llamaArchitecture) loadBlock(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBlock, bool, error) {
+	return loadLlamaStack(info, loader, a)
+}
+
+func (llamaArchitecture) layerCount(block *tensorBlock) int { return len(block.layers) }
+
+func (llamaArchitecture) ensureLayerState(block *tensorBlock, st *llamaLayerState, layer, maxSeq int, kvType KVCacheType) {
+	ensureLlamaLayerState(st, block.layers[layer], block.hiddenDim, maxSeq, block.attnHeads, block.kvHeads, kvType)
+}
+
+func (llamaArchitecture) step(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) error {
+	runLlamaStackStep(block, states, token, pos, x, n1, n2, logits, computeLogits)
+	return nil
+}
+
+func (llamaArchitecture) forward(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig, opts forwardOptions) error {
+	runForwardLlamaStack(block, seed, promptTokens, out, topk, forceTokens, cfg, opts)
+	return nil
+}
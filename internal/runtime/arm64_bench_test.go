@@ -8,6 +8,7 @@ import (
 )
 
 func BenchmarkCausalAttentionMultiHeadIntoCompareArm64(b *testing.B) {
+	opts := testRuntimeOptions()
 	type cfg struct {
 		steps int
 		heads int
@@ -41,7 +42,7 @@ func BenchmarkCausalAttentionMultiHeadIntoCompareArm64(b *testing.B) {
 				b.SetBytes(int64((len(q) + len(keys) + len(values) + len(scores) + len(dst)) * 4))
 				b.ResetTimer()
 				for i := 0; i < b.N; i++ {
-					causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
+					causalAttentionMultiHeadIntoGeneric(opts, dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
 				}
 			})
 
@@ -50,7 +51,7 @@ func BenchmarkCausalAttentionMultiHeadIntoCompareArm64(b *testing.B) {
 				b.SetBytes(int64((len(q) + len(keys) + len(values) + len(scores) + len(dst)) * 4))
 				b.ResetTimer()
 				for i := 0; i < b.N; i++ {
-					causalAttentionMultiHeadInto(dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
+					causalAttentionMultiHeadInto(opts, dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
 				}
 			})
 		})
@@ -3,9 +3,6 @@
 package runtime
 
 func init() {
-	if debugParityStrict {
-		return
-	}
 	causalAttentionMultiHeadIntoImpl = causalAttentionMultiHeadIntoOptimized
 	storeCacheVectorImpl = storeCacheVectorOpt
 	storeCacheVectorVImpl = storeCacheVectorVOpt
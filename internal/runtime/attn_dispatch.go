@@ -4,11 +4,16 @@ import "fmt"
 
 var causalAttentionMultiHeadIntoImpl = causalAttentionMultiHeadIntoGeneric
 
-func causalAttentionMultiHeadInto(dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
-	causalAttentionMultiHeadIntoImpl(dst, scores, q, keys, values, steps, qHeads, kvHeads, kStepDim, vStepDim, pos)
+func causalAttentionMultiHeadInto(opts *RuntimeOptions, dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
+	impl := causalAttentionMultiHeadIntoImpl
+	if opts.ParityStrict {
+		// Parity-strict sessions stay on the portable kernel.
+		impl = causalAttentionMultiHeadIntoGeneric
+	}
+	impl(opts, dst, scores, q, keys, values, steps, qHeads, kvHeads, kStepDim, vStepDim, pos)
 	if debugAttnRef && shouldDebug(pos) {
 		ref := make([]float32, len(dst))
-		causalAttentionMultiHeadIntoReference(ref, q, keys, values, steps, qHeads, kvHeads, kStepDim, vStepDim)
+		causalAttentionMultiHeadIntoReference(opts, ref, q, keys, values, steps, qHeads, kvHeads, kStepDim, vStepDim)
 		debugVecDiff(fmt.Sprintf("attn_ref.diff.pos=%d", pos), dst, ref)
 	}
 }
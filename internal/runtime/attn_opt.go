@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"math"
 	"os"
+
+	"bitnet-go/internal/kernels"
 )
 
-func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
+func causalAttentionMultiHeadIntoOptimized(opts *RuntimeOptions, dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
 	for i := range dst {
 		dst[i] = 0
 	}
@@ -45,7 +47,7 @@ func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float3
 		return
 	}
 
-	for h := 0; h < qHeads; h++ {
+	forEachAttnHead(opts, qHeads, steps, func(h int) {
 		qBase := h * headDim
 		qh := q[qBase : qBase+headDim]
 		kvHead := h * kvHeads / qHeads
@@ -56,9 +58,9 @@ func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float3
 		for i := 0; i < steps; i++ {
 			kb := i*kStepDim + kBase
 			var sum float32
-			if strictKQEnabledForCurrentLayer() {
-				sum = dotKQStrictForCurrentLayer(qh, keys[kb:kb+headDim])
-			} else if debugFastKQ {
+			if opts.StrictKQ {
+				sum = opts.dotKQStrict(qh, keys[kb:kb+headDim])
+			} else if opts.FastKQDot {
 				sum = dotF32FastN(keys, kb, qh, 0, headDim)
 			} else {
 				for j := 0; j < headDim; j++ {
@@ -72,12 +74,12 @@ func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float3
 			}
 		}
 
-		sum := softmaxInPlace(scores[scoreBase:scoreBase+steps], maxScore)
+		sum := softmaxInPlace(opts, scores[scoreBase:scoreBase+steps], maxScore)
 		if sum == 0 {
-			continue
+			return
 		}
 		inv := 1 / sum
-		if debugStrictAttention {
+		if opts.StrictAttention {
 			for i := 0; i < steps; i++ {
 				idx := scoreBase + i
 				scores[idx] *= inv
@@ -95,7 +97,7 @@ func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float3
 						fmt.Fprint(os.Stderr, ",")
 					}
 					val := scores[scoreBase+i]
-					if !debugStrictAttention {
+					if !opts.StrictAttention {
 						val *= inv
 					}
 					fmt.Fprintf(os.Stderr, "%.9g", val)
@@ -104,21 +106,21 @@ func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float3
 				debugSoftmaxPrinted = true
 			}
 		}
-		if debugStrictAttention {
+		if opts.StrictAttention {
 			vHeadBase := kvHead * headDim * maxSeq
 			weights := scores[scoreBase : scoreBase+steps]
 			for j := 0; j < headDim; j++ {
 				rowBase := vHeadBase + j*maxSeq
 				dst[qBase+j] += dotF32GGML(weights, values[rowBase:rowBase+steps])
 			}
-			continue
+			return
 		}
 
 		weights := scores[scoreBase : scoreBase+steps]
 		vHeadBase := kvHead * headDim * maxSeq
 		for j := 0; j < headDim; j++ {
 			rowBase := vHeadBase + j*maxSeq
-			if debugAttnF64 {
+			if opts.AttnF64 {
 				row := values[rowBase : rowBase+steps]
 				var sum64 float64
 				for i := 0; i < steps; i++ {
@@ -129,10 +131,10 @@ func causalAttentionMultiHeadIntoOptimized(dst, scores, q, keys, values []float3
 				dst[qBase+j] += dotF32FastNScaled(values, rowBase, weights, 0, steps, inv)
 			}
 		}
-	}
+	})
 }
 
-func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
+func causalAttentionMultiHeadIntoRowMajor(opts *RuntimeOptions, dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
 	for i := range dst {
 		dst[i] = 0
 	}
@@ -171,7 +173,7 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 		return
 	}
 
-	for h := 0; h < qHeads; h++ {
+	forEachAttnHead(opts, qHeads, steps, func(h int) {
 		qBase := h * headDim
 		qh := q[qBase : qBase+headDim]
 		kvHead := h * kvHeads / qHeads
@@ -182,9 +184,9 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 		for i := 0; i < steps; i++ {
 			kb := i*kStepDim + kBase
 			var sum float32
-			if strictKQEnabledForCurrentLayer() {
-				sum = dotKQStrictForCurrentLayer(qh, keys[kb:kb+headDim])
-			} else if debugFastKQ {
+			if opts.StrictKQ {
+				sum = opts.dotKQStrict(qh, keys[kb:kb+headDim])
+			} else if opts.FastKQDot {
 				sum = dotF32FastN(keys, kb, qh, 0, headDim)
 			} else {
 				for j := 0; j < headDim; j++ {
@@ -198,12 +200,12 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 			}
 		}
 
-		sum := softmaxInPlace(scores[scoreBase:scoreBase+steps], maxScore)
+		sum := softmaxInPlace(opts, scores[scoreBase:scoreBase+steps], maxScore)
 		if sum == 0 {
-			continue
+			return
 		}
 		inv := 1 / sum
-		if debugStrictAttention {
+		if opts.StrictAttention {
 			weights := scores[scoreBase : scoreBase+steps]
 			for j := 0; j < headDim; j++ {
 				var acc float32
@@ -214,7 +216,7 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 				}
 				dst[qBase+j] += acc
 			}
-			continue
+			return
 		}
 		if debugValues && h == 0 && shouldDebug(pos) && !debugSoftmaxPrinted {
 			limit := steps
@@ -237,7 +239,7 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 
 		weights := scores[scoreBase : scoreBase+steps]
 		vHeadBase := kvHead * maxSeq * headDim
-		if debugAttnF64 {
+		if opts.AttnF64 {
 			for j := 0; j < headDim; j++ {
 				var sum64 float64
 				for i := 0; i < steps; i++ {
@@ -246,7 +248,7 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 				}
 				dst[qBase+j] += float32(sum64)
 			}
-			continue
+			return
 		}
 		dstHead := dst[qBase : qBase+headDim]
 		i := 0
@@ -260,7 +262,25 @@ func causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, values []float32
 			rowBase := vHeadBase + i*headDim
 			accumWeightedRow(dstHead, values[rowBase:rowBase+headDim], weights[i]*inv)
 		}
+	})
+}
+
+// forEachAttnHead runs head for every query head, spreading heads over the
+// kernel scheduler once the context reaches AttnParallelMinSteps. Heads write
+// disjoint slices of scores and dst, so the result does not depend on the
+// split.
+func forEachAttnHead(opts *RuntimeOptions, heads, steps int, head func(h int)) {
+	if opts.AttnParallelMinSteps <= 0 || steps < opts.AttnParallelMinSteps || heads < 2 {
+		for h := 0; h < heads; h++ {
+			head(h)
+		}
+		return
 	}
+	kernels.DefaultScheduler().ParallelRanges(heads, 1, func(start, end int) {
+		for h := start; h < end; h++ {
+			head(h)
+		}
+	})
 }
 
 func accumWeightedRow(dst, row []float32, w float32) {
@@ -309,115 +329,231 @@ func accumWeightedRow2(dst, row0, row1 []float32, w0, w1 float32) {
 }
 
 func dotF32Fast(a, b []float32) float32 {
-	n := len(a)
-	if len(b) < n {
-		n = len(b)
-	}
-	var sum0, sum1, sum2, sum3 float32
-	var sum4, sum5, sum6, sum7 float32
-	i := 0
-	for ; i+7 < n; i += 8 {
-		sum0 += a[i] * b[i]
-		sum1 += a[i+1] * b[i+1]
-		sum2 += a[i+2] * b[i+2]
-		sum3 += a[i+3] * b[i+3]
-		sum4 += a[i+4] * b[i+4]
-		sum5 += a[i+5] * b[i+5]
-		sum6 += a[i+6] * b[i+6]
-		sum7 += a[i+7] * b[i+7]
-	}
-	sum := (sum0 + sum1) + (sum2 + sum3)
-	sum += (sum4 + sum5) + (sum6 + sum7)
-	for ; i < n; i++ {
-		sum += a[i] * b[i]
-	}
-	return sum
+	return kernels.DotF32x8(a, b)
 }
 
 // dotF32FastN computes dot product for the first n elements starting at aOff/bOff.
 // Callers are responsible for bounds; this is a hot-path helper.
 func dotF32FastN(a []float32, aOff int, b []float32, bOff int, n int) float32 {
+	n = clampDotSpan(len(a), aOff, len(b), bOff, n)
 	if n <= 0 {
 		return 0
 	}
-	if aOff < 0 || bOff < 0 {
+	return kernels.DotF32x8(a[aOff:aOff+n], b[bOff:bOff+n])
+}
+
+func dotF32FastNScaled(a []float32, aOff int, b []float32, bOff int, n int, scale float32) float32 {
+	n = clampDotSpan(len(a), aOff, len(b), bOff, n)
+	if n <= 0 {
 		return 0
 	}
-	if aOff+n > len(a) {
-		n = len(a) - aOff
+	return kernels.DotF32x8Scaled(a[aOff:aOff+n], b[bOff:bOff+n], scale)
+}
+
+func clampDotSpan(aLen, aOff, bLen, bOff, n int) int {
+	if n <= 0 || aOff < 0 || bOff < 0 {
+		return 0
 	}
-	if bOff+n > len(b) {
-		n = len(b) - bOff
+	if aOff+n > aLen {
+		n = aLen - aOff
 	}
-	if n <= 0 {
-		return 0
+	if bOff+n > bLen {
+		n = bLen - bOff
 	}
-	var sum0, sum1, sum2, sum3 float32
-	var sum4, sum5, sum6, sum7 float32
-	i := 0
-	for ; i+7 < n; i += 8 {
-		sum0 += a[aOff+i] * b[bOff+i]
-		sum1 += a[aOff+i+1] * b[bOff+i+1]
-		sum2 += a[aOff+i+2] * b[bOff+i+2]
-		sum3 += a[aOff+i+3] * b[bOff+i+3]
-		sum4 += a[aOff+i+4] * b[bOff+i+4]
-		sum5 += a[aOff+i+5] * b[bOff+i+5]
-		sum6 += a[aOff+i+6] * b[bOff+i+6]
-		sum7 += a[aOff+i+7] * b[bOff+i+7]
+	return n
+}
+
+func dotF64(a, b []float32) float32 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
 	}
-	sum := (sum0 + sum1) + (sum2 + sum3)
-	sum += (sum4 + sum5) + (sum6 + sum7)
-	for ; i < n; i++ {
-		sum += a[aOff+i] * b[bOff+i]
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += float64(a[i]) * float64(b[i])
 	}
-	return sum
+	return float32(sum)
 }
 
-func dotF32FastNScaled(a []float32, aOff int, b []float32, bOff int, n int, scale float32) float32 {
-	if n <= 0 {
-		return 0
+// causalAttentionMultiHeadIntoF16 reads K and V from f16 caches laid out
+// [pos][kStepDim] and [pos][vStepDim].
+func causalAttentionMultiHeadIntoF16(opts *RuntimeOptions, dst, scores, q []float32, keys, values []uint16, steps, qHeads, kvHeads, kStepDim, vStepDim int) {
+	for i := range dst {
+		dst[i] = 0
 	}
-	if aOff < 0 || bOff < 0 {
-		return 0
+	qHeads, kvHeads, headDim, ok := quantAttentionDims(len(q), len(scores), steps, qHeads, kvHeads, kStepDim, vStepDim)
+	if !ok || len(keys) < steps*kStepDim || len(values) < steps*vStepDim {
+		return
 	}
-	if aOff+n > len(a) {
-		n = len(a) - aOff
+	table := kvF16Table()
+	scale := float32(1.0 / math.Sqrt(float64(headDim)))
+	for h := 0; h < qHeads; h++ {
+		qBase := h * headDim
+		qh := q[qBase : qBase+headDim]
+		kvHead := h * kvHeads / qHeads
+		kBase := kvHead * headDim
+		maxScore := float32(-math.MaxFloat32)
+		scoreBase := h * steps
+		for i := 0; i < steps; i++ {
+			kb := i*kStepDim + kBase
+			s := dotF32F16(qh, keys[kb:kb+headDim], table) * scale
+			scores[scoreBase+i] = s
+			if s > maxScore {
+				maxScore = s
+			}
+		}
+		sum := softmaxInPlace(opts, scores[scoreBase:scoreBase+steps], maxScore)
+		if sum == 0 {
+			continue
+		}
+		inv := 1 / sum
+		dstHead := dst[qBase : qBase+headDim]
+		for i := 0; i < steps; i++ {
+			vb := i*vStepDim + kBase
+			accumWeightedRowF16(dstHead, values[vb:vb+headDim], scores[scoreBase+i]*inv, table)
+		}
 	}
-	if bOff+n > len(b) {
-		n = len(b) - bOff
+}
+
+// causalAttentionMultiHeadIntoQ8 reads K and V from int8 caches laid out
+// [pos][kStepDim] and [pos][vStepDim] with per-head scales laid out [pos][kvHeads].
+func causalAttentionMultiHeadIntoQ8(opts *RuntimeOptions, dst, scores, q []float32, keys, values []int8, keyScales, valueScales []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int) {
+	for i := range dst {
+		dst[i] = 0
 	}
-	if n <= 0 {
-		return 0
+	qHeads, kvHeads, headDim, ok := quantAttentionDims(len(q), len(scores), steps, qHeads, kvHeads, kStepDim, vStepDim)
+	if !ok || len(keys) < steps*kStepDim || len(values) < steps*vStepDim {
+		return
+	}
+	if len(keyScales) < steps*kvHeads || len(valueScales) < steps*kvHeads {
+		return
+	}
+	scale := float32(1.0 / math.Sqrt(float64(headDim)))
+	for h := 0; h < qHeads; h++ {
+		qBase := h * headDim
+		qh := q[qBase : qBase+headDim]
+		kvHead := h * kvHeads / qHeads
+		kBase := kvHead * headDim
+		maxScore := float32(-math.MaxFloat32)
+		scoreBase := h * steps
+		for i := 0; i < steps; i++ {
+			kb := i*kStepDim + kBase
+			s := dotF32I8(qh, keys[kb:kb+headDim]) * keyScales[i*kvHeads+kvHead] * scale
+			scores[scoreBase+i] = s
+			if s > maxScore {
+				maxScore = s
+			}
+		}
+		sum := softmaxInPlace(opts, scores[scoreBase:scoreBase+steps], maxScore)
+		if sum == 0 {
+			continue
+		}
+		inv := 1 / sum
+		dstHead := dst[qBase : qBase+headDim]
+		for i := 0; i < steps; i++ {
+			vb := i*vStepDim + kBase
+			accumWeightedRowI8(dstHead, values[vb:vb+headDim], scores[scoreBase+i]*inv*valueScales[i*kvHeads+kvHead])
+		}
+	}
+}
+
+func quantAttentionDims(qLen, scoresLen, steps, qHeads, kvHeads, kStepDim, vStepDim int) (int, int, int, bool) {
+	if steps <= 0 || qLen == 0 {
+		return 0, 0, 0, false
+	}
+	if qHeads <= 0 {
+		qHeads = 1
+	}
+	if kvHeads <= 0 {
+		kvHeads = qHeads
+	}
+	if qLen%qHeads != 0 {
+		qHeads = 1
+		kvHeads = 1
+	}
+	if kStepDim <= 0 || vStepDim <= 0 || kStepDim%kvHeads != 0 || vStepDim%kvHeads != 0 {
+		return 0, 0, 0, false
+	}
+	headDim := qLen / qHeads
+	if headDim == 0 || scoresLen < steps*qHeads {
+		return 0, 0, 0, false
+	}
+	if kStepDim/kvHeads != headDim || vStepDim/kvHeads != headDim {
+		return 0, 0, 0, false
+	}
+	return qHeads, kvHeads, headDim, true
+}
+
+func dotF32F16(a []float32, b []uint16, table *[1 << 16]float32) float32 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
 	}
 	var sum0, sum1, sum2, sum3 float32
-	var sum4, sum5, sum6, sum7 float32
 	i := 0
-	for ; i+7 < n; i += 8 {
-		sum0 += a[aOff+i] * (b[bOff+i] * scale)
-		sum1 += a[aOff+i+1] * (b[bOff+i+1] * scale)
-		sum2 += a[aOff+i+2] * (b[bOff+i+2] * scale)
-		sum3 += a[aOff+i+3] * (b[bOff+i+3] * scale)
-		sum4 += a[aOff+i+4] * (b[bOff+i+4] * scale)
-		sum5 += a[aOff+i+5] * (b[bOff+i+5] * scale)
-		sum6 += a[aOff+i+6] * (b[bOff+i+6] * scale)
-		sum7 += a[aOff+i+7] * (b[bOff+i+7] * scale)
+	for ; i+3 < n; i += 4 {
+		sum0 += a[i] * table[b[i]]
+		sum1 += a[i+1] * table[b[i+1]]
+		sum2 += a[i+2] * table[b[i+2]]
+		sum3 += a[i+3] * table[b[i+3]]
 	}
 	sum := (sum0 + sum1) + (sum2 + sum3)
-	sum += (sum4 + sum5) + (sum6 + sum7)
 	for ; i < n; i++ {
-		sum += a[aOff+i] * (b[bOff+i] * scale)
+		sum += a[i] * table[b[i]]
 	}
 	return sum
 }
 
-func dotF64(a, b []float32) float32 {
+func dotF32I8(a []float32, b []int8) float32 {
 	n := len(a)
 	if len(b) < n {
 		n = len(b)
 	}
-	var sum float64
-	for i := 0; i < n; i++ {
-		sum += float64(a[i]) * float64(b[i])
+	var sum0, sum1, sum2, sum3 float32
+	i := 0
+	for ; i+3 < n; i += 4 {
+		sum0 += a[i] * float32(b[i])
+		sum1 += a[i+1] * float32(b[i+1])
+		sum2 += a[i+2] * float32(b[i+2])
+		sum3 += a[i+3] * float32(b[i+3])
+	}
+	sum := (sum0 + sum1) + (sum2 + sum3)
+	for ; i < n; i++ {
+		sum += a[i] * float32(b[i])
+	}
+	return sum
+}
+
+func accumWeightedRowF16(dst []float32, row []uint16, w float32, table *[1 << 16]float32) {
+	n := len(dst)
+	if len(row) < n {
+		n = len(row)
+	}
+	i := 0
+	for ; i+3 < n; i += 4 {
+		dst[i] += table[row[i]] * w
+		dst[i+1] += table[row[i+1]] * w
+		dst[i+2] += table[row[i+2]] * w
+		dst[i+3] += table[row[i+3]] * w
+	}
+	for ; i < n; i++ {
+		dst[i] += table[row[i]] * w
+	}
+}
+
+func accumWeightedRowI8(dst []float32, row []int8, w float32) {
+	n := len(dst)
+	if len(row) < n {
+		n = len(row)
+	}
+	i := 0
+	for ; i+3 < n; i += 4 {
+		dst[i] += float32(row[i]) * w
+		dst[i+1] += float32(row[i+1]) * w
+		dst[i+2] += float32(row[i+2]) * w
+		dst[i+3] += float32(row[i+3]) * w
+	}
+	for ; i < n; i++ {
+		dst[i] += float32(row[i]) * w
 	}
-	return float32(sum)
 }
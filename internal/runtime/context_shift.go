@@ -0,0 +1,129 @@
+package runtime
+
+import "fmt"
+
+// ErrContextLength is returned when a request does not fit the context window
+// and context shifting is disabled.
+type ErrContextLength struct {
+	PromptTokens  int
+	MaxTokens     int
+	ContextLength int
+}
+
+func (e *ErrContextLength) Error() string {
+	return fmt.Sprintf("prompt (%d tokens) + max tokens (%d) exceeds context length %d; shorten the request or enable context shift (BITNET_CONTEXT_SHIFT=1)",
+		e.PromptTokens, e.MaxTokens, e.ContextLength)
+}
+
+func checkContextLength(promptTokens, maxTokens, ctxLen int, shift bool) error {
+	if ctxLen <= 0 || shift {
+		return nil
+	}
+	if promptTokens+maxTokens > ctxLen {
+		return &ErrContextLength{PromptTokens: promptTokens, MaxTokens: maxTokens, ContextLength: ctxLen}
+	}
+	return nil
+}
+
+// contextShifter frees KV cache room once a sequence reaches the window limit
+// by discarding half of the tokens after the first keep positions, mirroring
+// llama.cpp's context shift.
+type contextShifter struct {
+	block   *tensorBlock
+	states  []llamaLayerState
+	maxSeq  int
+	keep    int
+	enabled bool
+	shifts  int
+	// rowMajorV reports whether f32 V caches use [head][pos][dim].
+	rowMajorV bool
+}
+
+// room returns the position to write next, shifting the cache first when pos
+// would overflow it.
+func (c *contextShifter) room(pos int) int {
+	if !c.enabled || pos < c.maxSeq {
+		return pos
+	}
+	keep := c.keep
+	if keep > pos-1 {
+		keep = pos - 1
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	discard := (pos - keep) / 2
+	if discard < 1 {
+		discard = 1
+	}
+	for i := range c.states {
+		shiftLlamaKVCache(c.block, &c.states[i], keep, discard, pos, c.rowMajorV)
+	}
+	c.shifts++
+	return pos - discard
+}
+
+// shiftLlamaKVCache drops cache positions [keep, keep+discard) of one layer,
+// moves [keep+discard, used) down by discard and re-rotates the moved keys so
+// their RoPE phase matches the new positions.
+func shiftLlamaKVCache(block *tensorBlock, st *llamaLayerState, keep, discard, used int, rowMajorV bool) {
+	kdim := len(st.k)
+	vdim := len(st.v)
+	if kdim == 0 || vdim == 0 || discard <= 0 || keep+discard > used {
+		return
+	}
+	kvHeads := block.kvHeads
+	if kvHeads <= 0 || kdim%kvHeads != 0 || vdim%kvHeads != 0 {
+		kvHeads = 1
+	}
+	rotate := func(row []float32) {
+		applyRoPEInPlace(row, -discard, kvHeads, block.ropeFreqBase, block.ropeScale, block.ropeScalingType, block.ropeDim, block.ropeNeox, block.ropeYarnBetaFast, block.ropeYarnBetaSlow, block.ropeYarnExtFactor, block.ropeYarnAttnFactor)
+	}
+	moved := used - keep - discard
+
+	switch st.kvType {
+	case KVCacheF16:
+		row := make([]float32, kdim)
+		table := kvF16Table()
+		for p := keep; p < keep+moved; p++ {
+			src := st.keysF16[(p+discard)*kdim : (p+discard+1)*kdim]
+			for i, h := range src {
+				row[i] = table[h]
+			}
+			rotate(row)
+			storeCacheVectorF16(st.keysF16, p, row)
+		}
+		copy(st.valuesF16[keep*vdim:], st.valuesF16[(keep+discard)*vdim:used*vdim])
+	case KVCacheQ8:
+		row := make([]float32, kdim)
+		headDim := kdim / kvHeads
+		for p := keep; p < keep+moved; p++ {
+			src := p + discard
+			for i := range row {
+				row[i] = float32(st.keysQ8[src*kdim+i]) * st.keyScales[src*kvHeads+i/headDim]
+			}
+			rotate(row)
+			storeCacheVectorQ8(st.keysQ8, st.keyScales, p, row, kvHeads)
+		}
+		copy(st.valuesQ8[keep*vdim:], st.valuesQ8[(keep+discard)*vdim:used*vdim])
+		copy(st.valueScales[keep*kvHeads:], st.valueScales[(keep+discard)*kvHeads:used*kvHeads])
+	default:
+		copy(st.keys[keep*kdim:], st.keys[(keep+discard)*kdim:used*kdim])
+		for p := keep; p < keep+moved; p++ {
+			rotate(st.keys[p*kdim : (p+1)*kdim])
+		}
+		maxSeq := len(st.values) / vdim
+		headDim := vdim / kvHeads
+		for h := 0; h < kvHeads; h++ {
+			if rowMajorV {
+				base := h * maxSeq * headDim
+				copy(st.values[base+keep*headDim:], st.values[base+(keep+discard)*headDim:base+used*headDim])
+				continue
+			}
+			for d := 0; d < headDim; d++ {
+				base := h*headDim*maxSeq + d*maxSeq
+				copy(st.values[base+keep:], st.values[base+keep+discard:base+used])
+			}
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package runtime
+
+import (
+	"fmt"
+
+	"bitnet-go/internal/gguf"
+	"bitnet-go/internal/kernels"
+)
+
+// falconLayer is one Falcon decoder block: fused QKV attention and a GELU MLP
+// that both read the LayerNorm'd residual and are added back in parallel.
+type falconLayer struct {
+	attnNorm      []float32
+	attnNormBias  []float32
+	attnNorm2     []float32
+	attnNorm2Bias []float32
+	attnQKV       linearWeight
+	attnOut       linearWeight
+	ffnUp         linearWeight
+	ffnDown       linearWeight
+	qDim          int
+	kvDim         int
+}
+
+func loadFalconStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBlock, bool, error) {
+	if _, ok := info.TensorByName("blk.0.attn_qkv.weight"); !ok {
+		return nil, false, nil
+	}
+
+	embInfo, ok := info.TensorByName("token_embd.weight")
+	if !ok {
+		return nil, false, fmt.Errorf("missing tensor: token_embd.weight")
+	}
+	if len(embInfo.Dimensions) != 2 {
+		return nil, false, fmt.Errorf("token_embd.weight: expected 2 dims, got %d", len(embInfo.Dimensions))
+	}
+	hidden := int(embInfo.Dimensions[0])
+	vocab := int(embInfo.Dimensions[1])
+	if hidden <= 0 || vocab <= 0 {
+		return nil, false, fmt.Errorf("token_embd.weight invalid dims: %v", embInfo.Dimensions)
+	}
+
+	b := &tensorBlock{
+		hiddenDim:     hidden,
+		vocabDim:      vocab,
+		tokenEmbdRows: hidden,
+		tokenEmbdCols: vocab,
+		tokenEmbdType: embInfo.Type,
+		rmsEps:        firstFloat32(info.KeyValues["falcon.attention.layer_norm_epsilon"], 1e-5),
+		attnHeads:     int(firstUint32(info.KeyValues["falcon.attention.head_count"])),
+		kvHeads:       int(firstUint32(info.KeyValues["falcon.attention.head_count_kv"])),
+		ropeFreqBase:  firstFloat32(info.KeyValues["falcon.rope.freq_base"], 10000),
+		ropeScale:     1,
+		ropeDim:       int(firstUint32(info.KeyValues["falcon.rope.dimension_count"])),
+		// Falcon rotates the two halves of each head (GPT-NeoX style).
+		ropeNeox:           true,
+		ropeYarnAttnFactor: 1,
+	}
+	if b.attnHeads <= 0 {
+		b.attnHeads = 1
+	}
+	if b.kvHeads <= 0 {
+		b.kvHeads = b.attnHeads
+	}
+	if b.ropeFreqBase <= 0 {
+		b.ropeFreqBase = 10000
+	}
+	if hidden%b.attnHeads != 0 {
+		return nil, false, fmt.Errorf("falcon hidden=%d not divisible by head_count=%d", hidden, b.attnHeads)
+	}
+
+	var err error
+	if b.tokenEmbd, err = loader.readTensorAsF32("token_embd.weight"); err != nil {
+		return nil, false, err
+	}
+	if b.outputNorm, err = loader.readTensorAsF32("output_norm.weight"); err != nil {
+		return nil, false, err
+	}
+	if len(b.outputNorm) != hidden {
+		return nil, false, fmt.Errorf("output_norm.weight len=%d want=%d", len(b.outputNorm), hidden)
+	}
+	if b.outputNormBias, err = readOptionalVector(info, loader, "output_norm.bias", hidden); err != nil {
+		return nil, false, err
+	}
+	if outInfo, ok := info.TensorByName("output.weight"); ok {
+		if b.outputWeight, b.outputRows, b.outputCols, b.outputTransposed, err = loadLinearTensor(info, loader, "output.weight", hidden); err != nil {
+			return nil, false, err
+		}
+		b.outputWeightType = outInfo.Type
+	} else {
+		b.outputWeight = b.tokenEmbd
+		b.outputRows = hidden
+		b.outputCols = vocab
+		b.outputTransposed = true
+		b.outputWeightType = gguf.GGMLTypeF32
+	}
+	if b.outputWeightType == gguf.GGMLTypeI2_S {
+		if b.outputWeightPacked, b.outputWeightScale, _, err = loader.readTensorI2SPacked("output.weight"); err != nil {
+			return nil, false, err
+		}
+	}
+
+	headDim := hidden / b.attnHeads
+	for idx := 0; ; idx++ {
+		prefix := fmt.Sprintf("blk.%d.", idx)
+		if _, ok := info.TensorByName(prefix + "attn_qkv.weight"); !ok {
+			break
+		}
+		layer, err := loadFalconLayer(info, loader, prefix, hidden, b.attnHeads*headDim, b.kvHeads*headDim)
+		if err != nil {
+			return nil, false, err
+		}
+		b.falconLayers = append(b.falconLayers, layer)
+	}
+	if len(b.falconLayers) == 0 {
+		return nil, false, nil
+	}
+	return b, true, nil
+}
+
+func loadFalconLayer(info gguf.ModelInfo, loader *modelTensorLoader, prefix string, hidden, qDim, kvDim int) (falconLayer, error) {
+	l := falconLayer{qDim: qDim, kvDim: kvDim}
+	var err error
+	if l.attnNorm, err = loader.readTensorAsF32(prefix + "attn_norm.weight"); err != nil {
+		return falconLayer{}, err
+	}
+	if len(l.attnNorm) != hidden {
+		return falconLayer{}, fmt.Errorf("%sattn_norm.weight len=%d want=%d", prefix, len(l.attnNorm), hidden)
+	}
+	if l.attnNormBias, err = readOptionalVector(info, loader, prefix+"attn_norm.bias", hidden); err != nil {
+		return falconLayer{}, err
+	}
+	// Falcon-40B style blocks carry a second LayerNorm feeding the MLP.
+	if l.attnNorm2, err = readOptionalVector(info, loader, prefix+"attn_norm_2.weight", hidden); err != nil {
+		return falconLayer{}, err
+	}
+	if l.attnNorm2Bias, err = readOptionalVector(info, loader, prefix+"attn_norm_2.bias", hidden); err != nil {
+		return falconLayer{}, err
+	}
+	if l.attnQKV, err = loadLinearWeight(info, loader, prefix+"attn_qkv.weight", hidden); err != nil {
+		return falconLayer{}, err
+	}
+	if got := linearOutputLen(l.attnQKV); got != qDim+2*kvDim {
+		return falconLayer{}, fmt.Errorf("%sattn_qkv.weight output dim=%d want=%d", prefix, got, qDim+2*kvDim)
+	}
+	if l.attnOut, err = loadLinearWeight(info, loader, prefix+"attn_output.weight", qDim); err != nil {
+		return falconLayer{}, err
+	}
+	if linearOutputLen(l.attnOut) != hidden {
+		return falconLayer{}, fmt.Errorf("%sattn_output.weight output dim=%d want=%d", prefix, linearOutputLen(l.attnOut), hidden)
+	}
+	if l.ffnUp, err = loadLinearWeight(info, loader, prefix+"ffn_up.weight", hidden); err != nil {
+		return falconLayer{}, err
+	}
+	if l.ffnDown, err = loadLinearWeight(info, loader, prefix+"ffn_down.weight", linearOutputLen(l.ffnUp)); err != nil {
+		return falconLayer{}, err
+	}
+	if linearOutputLen(l.ffnDown) != hidden {
+		return falconLayer{}, fmt.Errorf("%sffn_down.weight output dim=%d want=%d", prefix, linearOutputLen(l.ffnDown), hidden)
+	}
+	return l, nil
+}
+
+func readOptionalVector(info gguf.ModelInfo, loader *modelTensorLoader, name string, n int) ([]float32, error) {
+	if _, ok := info.TensorByName(name); !ok {
+		return nil, nil
+	}
+	v, err := loader.readTensorAsF32(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != n {
+		return nil, fmt.Errorf("%s len=%d want=%d", name, len(v), n)
+	}
+	return v, nil
+}
+
+// ensureFusedQKVLayerState sizes a layer state for blocks with a fused QKV
+// projection and a single (non-gated) MLP input.
+func ensureFusedQKVLayerState(st *llamaLayerState, qDim, kvDim, ffnDim, hiddenDim, maxSeq, heads, kvHeads int, kvType KVCacheType) {
+	// Reuse the llama state sizing for q/k/v, attention and KV cache buffers.
+	ensureLlamaLayerState(st, llamaLayer{
+		attnQ:   linearWeight{rows: qDim},
+		attnK:   linearWeight{rows: kvDim},
+		attnV:   linearWeight{rows: kvDim},
+		ffnGate: linearWeight{rows: ffnDim},
+		ffnUp:   linearWeight{rows: ffnDim},
+	}, hiddenDim, maxSeq, heads, kvHeads, kvType)
+	st.qkv = resizeF32(st.qkv, qDim+2*kvDim)
+}
+
+func ensureFalconLayerState(st *llamaLayerState, layer falconLayer, hiddenDim, maxSeq, heads, kvHeads int, kvType KVCacheType) {
+	ensureFusedQKVLayerState(st, layer.qDim, layer.kvDim, linearOutputLen(layer.ffnUp), hiddenDim, maxSeq, heads, kvHeads, kvType)
+}
+
+// falconArchitecture runs Falcon's fused-QKV, parallel attention+MLP blocks.
+type falconArchitecture struct{}
+
+func init() {
+	registerArchitecture(falconArchitecture{}, "falcon")
+}
+
+func (falconArchitecture) loadBlock(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBlock, bool, error) {
+	return loadFalconStack(info, loader)
+}
+
+func (falconArchitecture) layerCount(block *tensorBlock) int { return len(block.falconLayers) }
+
+func (falconArchitecture) ensureLayerState(block *tensorBlock, st *llamaLayerState, layer, maxSeq int, kvType KVCacheType) {
+	ensureFalconLayerState(st, block.falconLayers[layer], block.hiddenDim, maxSeq, block.attnHeads, block.kvHeads, kvType)
+}
+
+func (falconArchitecture) step(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) error {
+	runFalconStep(block, states, token, pos, x, n1, n2, logits, computeLogits)
+	return nil
+}
+
+// runForwardKVStack runs prefill, context shifting and sampling for any
+// architecture without its own loop, calling ensure to size each layer's
+// state and step to run one token through all layers. It stops at the first
+// step error and returns it.
+func runForwardKVStack(
+	block *tensorBlock,
+	layers int,
+	seed int64,
+	promptTokens []int32,
+	out []int32,
+	topk *topKWriter,
+	forceTokens []int32,
+	cfg samplingConfig,
+	opts forwardOptions,
+	ensure func(st *llamaLayerState, i, maxSeq int),
+	step func(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) error,
+) error {
+	if len(out) == 0 {
+		return nil
+	}
+	maxSeq := len(promptTokens) + len(out)
+	shiftCtx := opts.contextShift && opts.contextLength > 0 && maxSeq > opts.contextLength
+	if shiftCtx {
+		maxSeq = opts.contextLength
+	}
+	if maxSeq < 1 {
+		maxSeq = 1
+	}
+
+	states := make([]llamaLayerState, layers)
+	for i := range states {
+		ensure(&states[i], i, maxSeq)
+	}
+	opts.stats.acquireKV(states)
+	defer opts.stats.releaseKV()
+	x := make([]float32, block.hiddenDim)
+	n1 := make([]float32, block.hiddenDim)
+	n2 := make([]float32, block.hiddenDim)
+	logits := make([]float32, block.vocabDim)
+	probs := make([]float32, block.vocabDim)
+	idx := make([]int, block.vocabDim)
+	for i := range idx {
+		idx[i] = i
+	}
+	var topkEntries []TopKEntry
+	var topkProbs []float32
+	if cfg.topK > 0 {
+		k := cfg.topK
+		if k > block.vocabDim {
+			k = block.vocabDim
+		}
+		topkEntries = make([]TopKEntry, k)
+		topkProbs = make([]float32, k)
+	}
+
+	shifter := contextShifter{
+		block:     block,
+		states:    states,
+		maxSeq:    maxSeq,
+		keep:      opts.contextKeep,
+		enabled:   shiftCtx,
+		rowMajorV: true,
+	}
+	currentToken := seedToken(seed, block.vocabDim)
+	pos := 0
+	if len(promptTokens) > 0 {
+		currentToken = promptTokens[len(promptTokens)-1]
+		for _, tok := range promptTokens[:len(promptTokens)-1] {
+			pos = shifter.room(pos)
+			if err := step(block, states, tok, pos, x, n1, n2, logits, false); err != nil {
+				return err
+			}
+			pos++
+		}
+	}
+
+	sampler := newSampler(seed)
+	for i := range out {
+		pos = shifter.room(pos)
+		if err := step(block, states, currentToken, pos, x, n1, n2, logits, true); err != nil {
+			return err
+		}
+		pos++
+		if topk != nil {
+			topk.append(i, logits)
+		}
+		next := sampleLogitsWithScratch(block.opts, logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
+		if i < len(forceTokens) {
+			next = int(forceTokens[i])
+		}
+		opts.stats.tokenSampled()
+		if next < 0 {
+			out[i] = 0
+			currentToken = 0
+			continue
+		}
+		out[i] = int32(next)
+		currentToken = out[i]
+	}
+	return nil
+}
+
+// runFalconStep runs one token through the parallel attention/MLP blocks:
+// x += attn(ln(x)) + mlp(ln2(x)), where ln2 falls back to ln when the layer
+// has no second norm.
+func runFalconStep(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) {
+	if !embedToken(x, block, token) {
+		fillTokenVector(x, token)
+	}
+	trace := newTraceStep(block.opts, pos, token)
+	trace.emit(TraceEmbed, -1, x)
+	for i := range block.falconLayers {
+		layer := &block.falconLayers[i]
+		st := &states[i]
+
+		kernels.LayerNormInto(n1, x, layer.attnNorm, layer.attnNormBias, block.rmsEps)
+		ffnIn := n1
+		if len(layer.attnNorm2) > 0 {
+			kernels.LayerNormInto(n2, x, layer.attnNorm2, layer.attnNorm2Bias, block.rmsEps)
+			ffnIn = n2
+		}
+		trace.emit(TraceAttnNorm, i, n1)
+		trace.emit(TraceFFNNorm, i, ffnIn)
+
+		linearApplyIntoWeight(block.opts, st.qkv, layer.attnQKV, n1)
+		copy(st.q, st.qkv[:layer.qDim])
+		copy(st.k, st.qkv[layer.qDim:layer.qDim+layer.kvDim])
+		copy(st.v, st.qkv[layer.qDim+layer.kvDim:])
+		trace.emit(TraceQ, i, st.q)
+		trace.emit(TraceK, i, st.k)
+		trace.emit(TraceV, i, st.v)
+		applyRoPEInPlace(st.q, pos, block.attnHeads, block.ropeFreqBase, block.ropeScale, block.ropeScalingType, block.ropeDim, block.ropeNeox, block.ropeYarnBetaFast, block.ropeYarnBetaSlow, block.ropeYarnExtFactor, block.ropeYarnAttnFactor)
+		applyRoPEInPlace(st.k, pos, block.kvHeads, block.ropeFreqBase, block.ropeScale, block.ropeScalingType, block.ropeDim, block.ropeNeox, block.ropeYarnBetaFast, block.ropeYarnBetaSlow, block.ropeYarnExtFactor, block.ropeYarnAttnFactor)
+		trace.emit(TraceQRoPE, i, st.q)
+		trace.emit(TraceKRoPE, i, st.k)
+		attendKVCache(st, block, pos)
+		linearApplyIntoWeight(block.opts, st.attnOut, layer.attnOut, st.attnAcc)
+		trace.emit(TraceAttnOut, i, st.attnOut)
+
+		linearApplyIntoWeight(block.opts, st.up, layer.ffnUp, ffnIn)
+		trace.emit(TraceFFNUp, i, st.up)
+		kernels.GeluInto(st.ffnAct, st.up)
+		linearApplyIntoWeight(block.opts, st.ffnDown, layer.ffnDown, st.ffnAct)
+		trace.emit(TraceFFNDown, i, st.ffnDown)
+
+		for j := range x {
+			x[j] += st.attnOut[j] + st.ffnDown[j]
+		}
+	}
+	if !computeLogits {
+		return
+	}
+	kernels.LayerNormInto(n1, x, block.outputNorm, block.outputNormBias, block.rmsEps)
+	trace.emit(TraceOutputNorm, -1, n1)
+	linearApplyIntoWeight(block.opts, logits, blockOutputWeight(block), n1)
+	trace.emit(TraceLogits, -1, logits)
+}
+
+func blockOutputWeight(block *tensorBlock) linearWeight {
+	return linearWeight{
+		data:       block.outputWeight,
+		dataF16:    block.outputWeightF16,
+		rows:       block.outputRows,
+		cols:       block.outputCols,
+		transposed: block.outputTransposed,
+		qtype:      block.outputWeightType,
+		i2sPacked:  block.outputWeightPacked,
+		i2sScale:   block.outputWeightScale,
+	}
+}
+
+// attendKVCache appends st.k/st.v at pos to the layer cache in its configured
+// format and writes causal attention over positions [0, pos] into st.attnAcc.
+// f32 caches use the row-major V layout.
+func attendKVCache(st *llamaLayerState, block *tensorBlock, pos int) {
+	kdim, vdim := len(st.k), len(st.v)
+	switch st.kvType {
+	case KVCacheF16:
+		storeCacheVectorF16(st.keysF16, pos, st.k)
+		storeCacheVectorF16(st.valuesF16, pos, st.v)
+		causalAttentionMultiHeadIntoF16(block.opts, st.attnAcc, st.scores, st.q, st.keysF16, st.valuesF16, pos+1, block.attnHeads, block.kvHeads, kdim, vdim)
+	case KVCacheQ8:
+		storeCacheVectorQ8(st.keysQ8, st.keyScales, pos, st.k, block.kvHeads)
+		storeCacheVectorQ8(st.valuesQ8, st.valueScales, pos, st.v, block.kvHeads)
+		causalAttentionMultiHeadIntoQ8(block.opts, st.attnAcc, st.scores, st.q, st.keysQ8, st.valuesQ8, st.keyScales, st.valueScales, pos+1, block.attnHeads, block.kvHeads, kdim, vdim)
+	default:
+		storeCacheVector(st.keys, pos, st.k)
+		storeCacheVectorVRowMajor(st.values, pos, st.v, block.kvHeads)
+		causalAttentionMultiHeadIntoRowMajor(block.opts, st.attnAcc, st.scores, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, kdim, vdim, pos)
+	}
+}
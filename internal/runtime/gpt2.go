@@ -0,0 +1,255 @@
+package runtime
+
+import (
+	"fmt"
+
+	"bitnet-go/internal/gguf"
+	"bitnet-go/internal/kernels"
+)
+
+// gpt2Layer is one pre-LayerNorm GPT-2 block. Every projection carries a bias.
+type gpt2Layer struct {
+	attnNorm     []float32
+	attnNormBias []float32
+	attnQKV      linearWeight
+	attnQKVBias  []float32
+	attnOut      linearWeight
+	attnOutBias  []float32
+	ffnNorm      []float32
+	ffnNormBias  []float32
+	ffnUp        linearWeight
+	ffnUpBias    []float32
+	ffnDown      linearWeight
+	ffnDownBias  []float32
+	qDim         int
+}
+
+func loadGPT2Stack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBlock, bool, error) {
+	if _, ok := info.TensorByName("blk.0.attn_qkv.weight"); !ok {
+		return nil, false, nil
+	}
+
+	embInfo, ok := info.TensorByName("token_embd.weight")
+	if !ok {
+		return nil, false, fmt.Errorf("missing tensor: token_embd.weight")
+	}
+	if len(embInfo.Dimensions) != 2 {
+		return nil, false, fmt.Errorf("token_embd.weight: expected 2 dims, got %d", len(embInfo.Dimensions))
+	}
+	hidden := int(embInfo.Dimensions[0])
+	vocab := int(embInfo.Dimensions[1])
+	if hidden <= 0 || vocab <= 0 {
+		return nil, false, fmt.Errorf("token_embd.weight invalid dims: %v", embInfo.Dimensions)
+	}
+	posInfo, ok := info.TensorByName("position_embd.weight")
+	if !ok {
+		return nil, false, fmt.Errorf("missing tensor: position_embd.weight")
+	}
+	if len(posInfo.Dimensions) != 2 || int(posInfo.Dimensions[0]) != hidden {
+		return nil, false, fmt.Errorf("position_embd.weight invalid dims: %v", posInfo.Dimensions)
+	}
+
+	b := &tensorBlock{
+		hiddenDim:        hidden,
+		vocabDim:         vocab,
+		tokenEmbdRows:    hidden,
+		tokenEmbdCols:    vocab,
+		tokenEmbdType:    embInfo.Type,
+		positionEmbdRows: int(posInfo.Dimensions[1]),
+		rmsEps:           firstFloat32(info.KeyValues["gpt2.attention.layer_norm_epsilon"], 1e-5),
+		attnHeads:        int(firstUint32(info.KeyValues["gpt2.attention.head_count"])),
+	}
+	if b.attnHeads <= 0 {
+		b.attnHeads = 1
+	}
+	b.kvHeads = b.attnHeads
+	if hidden%b.attnHeads != 0 {
+		return nil, false, fmt.Errorf("gpt2 hidden=%d not divisible by head_count=%d", hidden, b.attnHeads)
+	}
+
+	var err error
+	if b.tokenEmbd, err = loader.readTensorAsF32("token_embd.weight"); err != nil {
+		return nil, false, err
+	}
+	if b.positionEmbd, err = loader.readTensorAsF32("position_embd.weight"); err != nil {
+		return nil, false, err
+	}
+	if b.outputNorm, err = loader.readTensorAsF32("output_norm.weight"); err != nil {
+		return nil, false, err
+	}
+	if len(b.outputNorm) != hidden {
+		return nil, false, fmt.Errorf("output_norm.weight len=%d want=%d", len(b.outputNorm), hidden)
+	}
+	if b.outputNormBias, err = readOptionalVector(info, loader, "output_norm.bias", hidden); err != nil {
+		return nil, false, err
+	}
+	if outInfo, ok := info.TensorByName("output.weight"); ok {
+		if b.outputWeight, b.outputRows, b.outputCols, b.outputTransposed, err = loadLinearTensor(info, loader, "output.weight", hidden); err != nil {
+			return nil, false, err
+		}
+		b.outputWeightType = outInfo.Type
+	} else {
+		// GPT-2 ties the LM head to the token embeddings.
+		b.outputWeight = b.tokenEmbd
+		b.outputRows = hidden
+		b.outputCols = vocab
+		b.outputTransposed = true
+		b.outputWeightType = gguf.GGMLTypeF32
+	}
+	if b.outputWeightType == gguf.GGMLTypeI2_S {
+		if b.outputWeightPacked, b.outputWeightScale, _, err = loader.readTensorI2SPacked("output.weight"); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for idx := 0; ; idx++ {
+		prefix := fmt.Sprintf("blk.%d.", idx)
+		if _, ok := info.TensorByName(prefix + "attn_qkv.weight"); !ok {
+			break
+		}
+		layer, err := loadGPT2Layer(info, loader, prefix, hidden)
+		if err != nil {
+			return nil, false, err
+		}
+		b.gpt2Layers = append(b.gpt2Layers, layer)
+	}
+	if len(b.gpt2Layers) == 0 {
+		return nil, false, nil
+	}
+	return b, true, nil
+}
+
+func loadGPT2Layer(info gguf.ModelInfo, loader *modelTensorLoader, prefix string, hidden int) (gpt2Layer, error) {
+	l := gpt2Layer{qDim: hidden}
+	var err error
+	if l.attnNorm, err = loader.readTensorAsF32(prefix + "attn_norm.weight"); err != nil {
+		return gpt2Layer{}, err
+	}
+	if len(l.attnNorm) != hidden {
+		return gpt2Layer{}, fmt.Errorf("%sattn_norm.weight len=%d want=%d", prefix, len(l.attnNorm), hidden)
+	}
+	if l.attnNormBias, err = readOptionalVector(info, loader, prefix+"attn_norm.bias", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	if l.attnQKV, err = loadLinearWeight(info, loader, prefix+"attn_qkv.weight", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	if got := linearOutputLen(l.attnQKV); got != 3*hidden {
+		return gpt2Layer{}, fmt.Errorf("%sattn_qkv.weight output dim=%d want=%d", prefix, got, 3*hidden)
+	}
+	if l.attnQKVBias, err = readOptionalVector(info, loader, prefix+"attn_qkv.bias", 3*hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	if l.attnOut, err = loadLinearWeight(info, loader, prefix+"attn_output.weight", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	if linearOutputLen(l.attnOut) != hidden {
+		return gpt2Layer{}, fmt.Errorf("%sattn_output.weight output dim=%d want=%d", prefix, linearOutputLen(l.attnOut), hidden)
+	}
+	if l.attnOutBias, err = readOptionalVector(info, loader, prefix+"attn_output.bias", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	if l.ffnNorm, err = loader.readTensorAsF32(prefix + "ffn_norm.weight"); err != nil {
+		return gpt2Layer{}, err
+	}
+	if len(l.ffnNorm) != hidden {
+		return gpt2Layer{}, fmt.Errorf("%sffn_norm.weight len=%d want=%d", prefix, len(l.ffnNorm), hidden)
+	}
+	if l.ffnNormBias, err = readOptionalVector(info, loader, prefix+"ffn_norm.bias", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	if l.ffnUp, err = loadLinearWeight(info, loader, prefix+"ffn_up.weight", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	ffnDim := linearOutputLen(l.ffnUp)
+	if l.ffnUpBias, err = readOptionalVector(info, loader, prefix+"ffn_up.bias", ffnDim); err != nil {
+		return gpt2Layer{}, err
+	}
+	if l.ffnDown, err = loadLinearWeight(info, loader, prefix+"ffn_down.weight", ffnDim); err != nil {
+		return gpt2Layer{}, err
+	}
+	if linearOutputLen(l.ffnDown) != hidden {
+		return gpt2Layer{}, fmt.Errorf("%sffn_down.weight output dim=%d want=%d", prefix, linearOutputLen(l.ffnDown), hidden)
+	}
+	if l.ffnDownBias, err = readOptionalVector(info, loader, prefix+"ffn_down.bias", hidden); err != nil {
+		return gpt2Layer{}, err
+	}
+	return l, nil
+}
+
+// gpt2Architecture runs GPT-2's learned-position, pre-LayerNorm blocks.
+type gpt2Architecture struct{}
+
+func init() {
+	registerArchitecture(gpt2Architecture{}, "gpt2")
+}
+
+func (gpt2Architecture) loadBlock(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBlock, bool, error) {
+	return loadGPT2Stack(info, loader)
+}
+
+func (gpt2Architecture) layerCount(block *tensorBlock) int { return len(block.gpt2Layers) }
+
+func (gpt2Architecture) ensureLayerState(block *tensorBlock, st *llamaLayerState, layer, maxSeq int, kvType KVCacheType) {
+	l := block.gpt2Layers[layer]
+	ensureFusedQKVLayerState(st, l.qDim, l.qDim, linearOutputLen(l.ffnUp), block.hiddenDim, maxSeq, block.attnHeads, block.kvHeads, kvType)
+}
+
+func (gpt2Architecture) step(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) error {
+	return runGPT2Step(block, states, token, pos, x, n1, n2, logits, computeLogits)
+}
+
+// runGPT2Step runs one token through the sequential blocks:
+// x += attn(ln1(x)); x += mlp(ln2(x)), with x = tok_embd + pos_embd.
+// Positions past position_embd's rows have no embedding and fail.
+func runGPT2Step(block *tensorBlock, states []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) error {
+	if pos < 0 || pos >= block.positionEmbdRows {
+		return fmt.Errorf("gpt2: position %d outside the model's %d position embeddings", pos, block.positionEmbdRows)
+	}
+	if !embedToken(x, block, token) {
+		fillTokenVector(x, token)
+	}
+	h := block.hiddenDim
+	kernels.AddScaled(x, block.positionEmbd[pos*h:(pos+1)*h], 1)
+	trace := newTraceStep(block.opts, pos, token)
+	trace.emit(TraceEmbed, -1, x)
+	for i := range block.gpt2Layers {
+		layer := &block.gpt2Layers[i]
+		st := &states[i]
+
+		kernels.LayerNormInto(n1, x, layer.attnNorm, layer.attnNormBias, block.rmsEps)
+		trace.emit(TraceAttnNorm, i, n1)
+		linearApplyIntoWeight(block.opts, st.qkv, layer.attnQKV, n1)
+		addBiasInPlace(st.qkv, layer.attnQKVBias)
+		copy(st.q, st.qkv[:layer.qDim])
+		copy(st.k, st.qkv[layer.qDim:2*layer.qDim])
+		copy(st.v, st.qkv[2*layer.qDim:])
+		trace.emit(TraceQ, i, st.q)
+		trace.emit(TraceK, i, st.k)
+		trace.emit(TraceV, i, st.v)
+		attendKVCache(st, block, pos)
+		linearApplyIntoWeight(block.opts, st.attnOut, layer.attnOut, st.attnAcc)
+		addBiasInPlace(st.attnOut, layer.attnOutBias)
+		trace.emit(TraceAttnOut, i, st.attnOut)
+		kernels.AddScaled(x, st.attnOut, 1)
+
+		kernels.LayerNormInto(n2, x, layer.ffnNorm, layer.ffnNormBias, block.rmsEps)
+		trace.emit(TraceFFNNorm, i, n2)
+		linearApplyIntoWeight(block.opts, st.up, layer.ffnUp, n2)
+		addBiasInPlace(st.up, layer.ffnUpBias)
+		trace.emit(TraceFFNUp, i, st.up)
+		kernels.GeluInto(st.ffnAct, st.up)
+		linearApplyIntoWeight(block.opts, st.ffnDown, layer.ffnDown, st.ffnAct)
+		addBiasInPlace(st.ffnDown, layer.ffnDownBias)
+		trace.emit(TraceFFNDown, i, st.ffnDown)
+		kernels.AddScaled(x, st.ffnDown, 1)
+	}
+	if !computeLogits {
+		return nil
+	}
+	kernels.LayerNormInto(n1, x, block.outputNorm, block.outputNormBias, block.rmsEps)
+	trace.emit(TraceOutputNorm, -1, n1)
+	linearApplyIntoWeight(block.opts, logits, blockOutputWeight(block), n1)
+	trace.emit(TraceLogits, -1, logits)
+	return nil
+}
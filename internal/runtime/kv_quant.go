@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"bitnet-go/internal/kernels"
+)
+
+// KVCacheType selects the storage format of the per-layer attention K/V cache.
+type KVCacheType string
+
+const (
+	KVCacheF32 KVCacheType = "f32"
+	KVCacheF16 KVCacheType = "f16"
+	// KVCacheQ8 stores int8 values with one float32 scale per kv head and position.
+	KVCacheQ8 KVCacheType = "q8"
+)
+
+// ParseKVCacheType accepts f32/f16/q8 (and int8 as an alias for q8); empty means f32.
+func ParseKVCacheType(v string) (KVCacheType, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "f32", "fp32":
+		return KVCacheF32, nil
+	case "f16", "fp16":
+		return KVCacheF16, nil
+	case "q8", "q8_0", "int8", "i8":
+		return KVCacheQ8, nil
+	default:
+		return "", fmt.Errorf("unknown kv cache type %q (want f32, f16 or q8)", v)
+	}
+}
+
+var kvF16Table = sync.OnceValue(func() *[1 << 16]float32 {
+	var t [1 << 16]float32
+	for i := range t {
+		t[i] = kernels.Float16ToFloat32(uint16(i))
+	}
+	return &t
+})
+
+// storeCacheVectorF16 stores vec at row pos of a [pos][len(vec)] f16 cache.
+func storeCacheVectorF16(cache []uint16, pos int, vec []float32) {
+	base := pos * len(vec)
+	if pos < 0 || base+len(vec) > len(cache) {
+		return
+	}
+	row := cache[base : base+len(vec)]
+	for i, v := range vec {
+		row[i] = kernels.Float32ToFloat16(v)
+	}
+}
+
+// storeCacheVectorQ8 quantizes vec per kv head (absmax/127) into row pos of a
+// [pos][len(vec)] int8 cache; scales is laid out [pos][kvHeads].
+func storeCacheVectorQ8(cache []int8, scales []float32, pos int, vec []float32, kvHeads int) {
+	if kvHeads <= 0 || len(vec) == 0 {
+		return
+	}
+	if len(vec)%kvHeads != 0 {
+		kvHeads = 1
+	}
+	headDim := len(vec) / kvHeads
+	base := pos * len(vec)
+	if pos < 0 || base+len(vec) > len(cache) || (pos+1)*kvHeads > len(scales) {
+		return
+	}
+	for h := 0; h < kvHeads; h++ {
+		src := vec[h*headDim : (h+1)*headDim]
+		dst := cache[base+h*headDim : base+(h+1)*headDim]
+		var amax float32
+		for _, v := range src {
+			if a := float32(math.Abs(float64(v))); a > amax {
+				amax = a
+			}
+		}
+		scale := amax / 127
+		scales[pos*kvHeads+h] = scale
+		if scale == 0 {
+			for i := range dst {
+				dst[i] = 0
+			}
+			continue
+		}
+		inv := 1 / scale
+		for i, v := range src {
+			q := nearestIntKV(v * inv)
+			if q > 127 {
+				q = 127
+			} else if q < -127 {
+				q = -127
+			}
+			dst[i] = int8(q)
+		}
+	}
+}
+
+func nearestIntKV(v float32) int {
+	if v >= 0 {
+		return int(v + 0.5)
+	}
+	return -int(-v + 0.5)
+}
+
+// kvCacheBytes reports the storage used by one layer's K/V cache.
+func kvCacheBytes(st *llamaLayerState) int {
+	switch st.kvType {
+	case KVCacheF16:
+		return 2 * (len(st.keysF16) + len(st.valuesF16))
+	case KVCacheQ8:
+		return len(st.keysQ8) + len(st.valuesQ8) + 4*(len(st.keyScales)+len(st.valueScales))
+	default:
+		return 4 * (len(st.keys) + len(st.values))
+	}
+}
@@ -0,0 +1,162 @@
+package runtime
+
+import "time"
+
+// Observer receives inference measurements from a Runtime. It is called on
+// the generation goroutines, so implementations must be safe for concurrent
+// use.
+type Observer interface {
+	// ObserveGenerate is called once per completed Generate call.
+	ObserveGenerate(s GenerateStats)
+	// ObserveStep is called after each sampled token of the llama stack with
+	// that step's time breakdown.
+	ObserveStep(s StepStats)
+	// ObserveKVCache is called with +bytes when a request's KV cache is
+	// acquired and with -bytes when it is released.
+	ObserveKVCache(deltaBytes int64)
+}
+
+// CacheResult reports how a lookup in one of the Runtime's caches went.
+type CacheResult uint8
+
+const (
+	// CacheBypass means the cache was disabled or not applicable.
+	CacheBypass CacheResult = iota
+	CacheHit
+	CacheMiss
+)
+
+// GenerateStats summarizes one Generate call.
+type GenerateStats struct {
+	PromptTokens    int
+	GeneratedTokens int
+	// Prefill runs from the start of the forward pass to the first sampled
+	// token; Decode covers the remaining tokens.
+	Prefill time.Duration
+	Decode  time.Duration
+	// FirstToken runs from the Generate call, including tokenization, to the
+	// first sampled token.
+	FirstToken time.Duration
+	Total      time.Duration
+	// KVCacheBytes is the size of the KV cache the request held.
+	KVCacheBytes int64
+	PromptCache  CacheResult
+	DecodeCache  CacheResult
+}
+
+// StepStats is the time one decode step spent in each part of the forward
+// pass. Embed+Attn+FFN+Output covers the layer stack; Sample and TopK are
+// spent after the logits are ready.
+type StepStats struct {
+	Embed  time.Duration
+	Attn   time.Duration
+	FFN    time.Duration
+	Output time.Duration
+	Sample time.Duration
+	TopK   time.Duration
+	Total  time.Duration
+}
+
+// forwardStats collects the timings of one request. A nil *forwardStats
+// ignores every call, so forward loops use it unconditionally.
+type forwardStats struct {
+	obs          Observer
+	start        time.Time
+	forwardStart time.Time
+	firstToken   time.Time
+	end          time.Time
+	kvBytes      int64
+}
+
+func newForwardStats(obs Observer, start time.Time) *forwardStats {
+	if obs == nil {
+		return nil
+	}
+	return &forwardStats{obs: obs, start: start}
+}
+
+func (s *forwardStats) observing() bool { return s != nil }
+
+func (s *forwardStats) beginForward() {
+	if s != nil {
+		s.forwardStart = time.Now()
+	}
+}
+
+func (s *forwardStats) tokenSampled() {
+	if s != nil && s.firstToken.IsZero() {
+		s.firstToken = time.Now()
+	}
+}
+
+func (s *forwardStats) endForward() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	// Paths without a per-token loop produce every token at once.
+	if s.firstToken.IsZero() {
+		s.firstToken = s.end
+	}
+}
+
+// acquireKV reports the KV cache held by states until releaseKV.
+func (s *forwardStats) acquireKV(states []llamaLayerState) {
+	if s == nil {
+		return
+	}
+	s.kvBytes = 0
+	for i := range states {
+		s.kvBytes += int64(kvCacheBytes(&states[i]))
+	}
+	s.obs.ObserveKVCache(s.kvBytes)
+}
+
+func (s *forwardStats) releaseKV() {
+	if s == nil || s.kvBytes == 0 {
+		return
+	}
+	s.obs.ObserveKVCache(-s.kvBytes)
+}
+
+func (s *forwardStats) observeStep(st StepStats) {
+	if s != nil {
+		s.obs.ObserveStep(st)
+	}
+}
+
+func (s *forwardStats) report(promptTokens, generated int, promptCache, decodeCache CacheResult) {
+	if s == nil {
+		return
+	}
+	if s.end.IsZero() {
+		s.endForward()
+	}
+	if s.forwardStart.IsZero() {
+		s.forwardStart = s.start
+	}
+	s.obs.ObserveGenerate(GenerateStats{
+		PromptTokens:    promptTokens,
+		GeneratedTokens: generated,
+		Prefill:         s.firstToken.Sub(s.forwardStart),
+		Decode:          s.end.Sub(s.firstToken),
+		FirstToken:      s.firstToken.Sub(s.start),
+		Total:           time.Since(s.start),
+		KVCacheBytes:    s.kvBytes,
+		PromptCache:     promptCache,
+		DecodeCache:     decodeCache,
+	})
+}
+
+// since returns the time spent between the prev snapshot and p.
+func (p *llamaStepProfile) since(prev llamaStepProfile) StepStats {
+	return StepStats{
+		Embed:  p.embed - prev.embed,
+		Attn:   p.attn - prev.attn,
+		FFN:    p.ffn - prev.ffn,
+		Output: p.output - prev.output,
+		Sample: p.sample - prev.sample,
+		TopK:   p.topkCapture - prev.topkCapture,
+		Total:  p.stepTotal - prev.stepTotal,
+	}
+}
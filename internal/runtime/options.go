@@ -0,0 +1,383 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Named RuntimeOptions presets. BITNET_PARITY_PROFILE selects one of these for
+// the environment defaults.
+const (
+	PresetDefault      = "default"
+	PresetCPUParityV1  = "cpu_parity_v1"
+	PresetParityStrict = "parity_strict"
+)
+
+// RuntimeOptions holds the numerics, kernel, sampling and cache knobs of one
+// Runtime. Every field defaults to a BITNET_* environment variable (see
+// DefaultRuntimeOptions), so two runtimes in one process can run with
+// different settings. Diagnostic dumps (BITNET_DEBUG_*, BITNET_DRIFT_*) remain
+// process-wide.
+//
+// Fields with a LayerMax (<0 = all layers) apply to layers up to it; stages
+// outside the layer stack always get them.
+type RuntimeOptions struct {
+	// Preset records the preset the options started from, if any.
+	Preset string
+
+	// ParityStrict (BITNET_PARITY_STRICT) routes attention through the
+	// reference path and disables the fast kernels. It implies StrictKQ and
+	// I2SFloat and TQFloat and clears FastExpf, FastKQDot, FastVDot and FastQKVCol.
+	ParityStrict bool
+	// StrictKQ (BITNET_STRICT_KQ) computes K·Q with ggml's accumulation order
+	// for layers up to StrictKQLayerMax (BITNET_STRICT_KQ_LAYER_MAX, <0 = all).
+	StrictKQ         bool
+	StrictKQLayerMax int
+	// StrictKQMode (BITNET_STRICT_KQ_MODE) is "ggml", "naive" or "f64".
+	StrictKQMode string
+	// StrictExpf (BITNET_STRICT_EXPF) uses ggml's expf in softmax for layers up
+	// to StrictExpfLayerMax (BITNET_STRICT_EXPF_LAYER_MAX, <0 = all).
+	StrictExpf         bool
+	StrictExpfLayerMax int
+	// StrictAttention (BITNET_STRICT_ATTENTION) normalizes weights before the
+	// V dot, matching ggml.
+	StrictAttention bool
+	FastExpf        bool // BITNET_FAST_EXPF
+	AttnF64         bool // BITNET_ATTN_F64
+	FastKQDot       bool // BITNET_FAST_KQ_DOT (default on)
+	FastVDot        bool // BITNET_FAST_V_DOT (default on)
+	// AttnParallelMinSteps (BITNET_ATTN_PAR_MIN_STEPS) is the context length
+	// from which attention heads run on the kernel scheduler; 0 disables it.
+	AttnParallelMinSteps int
+
+	KVRowMajor         bool // BITNET_KV_ROWMAJOR (default on)
+	FastQKVCol         bool // BITNET_FAST_QKV_COL
+	QKVFusedMax        int  // BITNET_QKV_FUSED_MAX
+	I2SFloat           bool // BITNET_I2S_F32: run i2_s weights in float instead of int8
+	I2SPretransposeMax int  // BITNET_I2S_PRETRANSPOSE_MAX
+	TQFloat            bool // BITNET_TQ_F32: dequantize tq1_0/tq2_0 weights to float at load
+	FFNShareI2SQuant   bool // BITNET_FFN_SHARE_I2S_QUANT (default on)
+	FFNShareI2SDown    bool // BITNET_FFN_SHARE_I2S_DOWN (default on)
+	FFNParGateUp       bool // BITNET_FFN_PAR_GATE_UP
+	F16TokenEmbd       bool // BITNET_USE_F16_TOKEN_EMBD
+	MmapI2S            bool // BITNET_MMAP_I2S
+	FastGreedyArgmax   bool // BITNET_FAST_GREEDY_ARGMAX
+
+	DisableTopK    bool // BITNET_DISABLE_TOPK
+	TopPHeapCap    int  // BITNET_TOPP_HEAP_CAP
+	TopPSortPrefix int  // BITNET_TOPP_SORT_PREFIX
+	TopPPrefilterK int  // BITNET_TOPP_PREFILTER_K
+
+	PromptCacheCap       int // BITNET_PROMPT_CACHE_CAP
+	DecodeCacheCap       int // BITNET_DECODE_CACHE_CAP
+	DecodeCacheMaxTokens int // BITNET_DECODE_CACHE_MAX_TOKENS
+
+	KVCacheType   KVCacheType // BITNET_KV_CACHE
+	ContextLength int         // BITNET_CONTEXT_SIZE; 0 uses the model's context_length
+	ContextShift  bool        // BITNET_CONTEXT_SHIFT
+	ContextKeep   int         // BITNET_CONTEXT_KEEP
+
+	// Parity diagnostics that swap one stage for its reference path.
+	StrictAttentionRef bool // BITNET_STRICT_ATTENTION_REF
+	StrictFFNRef       bool // BITNET_STRICT_FFN_REF
+	StrictFFNActF64    bool // BITNET_STRICT_FFN_ACT_F64
+	StrictVRef         bool // BITNET_STRICT_V_REF
+	StrictVRefLayerMax int  // BITNET_STRICT_V_REF_LAYER_MAX
+	StrictQF32         bool // BITNET_STRICT_Q_F32
+	StrictQF32LayerMax int  // BITNET_STRICT_Q_F32_LAYER_MAX
+	// StrictQF32Heads (BITNET_STRICT_Q_F32_HEADS, or BITNET_STRICT_Q_F32_HEAD
+	// for one head) limits StrictQF32 to these query heads; empty means all.
+	StrictQF32Heads    []int
+	StrictKF32         bool // BITNET_STRICT_K_F32
+	StrictKF32LayerMax int  // BITNET_STRICT_K_F32_LAYER_MAX
+	StrictVF32         bool // BITNET_STRICT_V_F32
+	StrictVF32LayerMax int  // BITNET_STRICT_V_F32_LAYER_MAX
+
+	// i2_s diagnostics for drift analysis.
+	I2SDisableActSum  bool // BITNET_I2S_DISABLE_ACTSUM
+	I2SInvertActScale bool // BITNET_I2S_INVERT_ACT_SCALE
+	I2SForceQuant     bool // BITNET_I2S_FORCE_Q: quantize activations even with I2SFloat
+	I2SRefDot         bool // BITNET_I2S_REF_DOT
+	I2SRefOnce        bool // BITNET_I2S_REF_ONCE
+	I2SMatvecRef      bool // BITNET_DEBUG_I2S_MATVEC_REF
+	I2SMap3To1        bool // BITNET_I2S_MAP3_TO1
+	I2SAltLayout      bool // BITNET_I2S_ALT_LAYOUT
+	I2SScalar         bool // BITNET_I2S_SCALAR
+
+	// Tracer, if set, receives intermediate tensors at each forward stage.
+	Tracer Tracer
+	// Observer, if set, receives request timings, per-step profiles and KV
+	// cache usage.
+	Observer Observer
+}
+
+// baseRuntimeOptions are the built-in defaults, before presets or env.
+func baseRuntimeOptions() RuntimeOptions {
+	return RuntimeOptions{
+		StrictKQLayerMax:     -1,
+		StrictKQMode:         "ggml",
+		StrictExpfLayerMax:   -1,
+		FastKQDot:            true,
+		FastVDot:             true,
+		KVRowMajor:           true,
+		QKVFusedMax:          512 * 512,
+		FFNShareI2SQuant:     true,
+		FFNShareI2SDown:      true,
+		AttnParallelMinSteps: 256,
+		PromptCacheCap:       128,
+		DecodeCacheCap:       256,
+		DecodeCacheMaxTokens: 64,
+		KVCacheType:          KVCacheF32,
+		StrictVRefLayerMax:   -1,
+		StrictQF32LayerMax:   -1,
+		StrictKF32LayerMax:   -1,
+		StrictVF32LayerMax:   -1,
+	}
+}
+
+var runtimePresets = map[string]func(*RuntimeOptions){
+	PresetDefault: func(*RuntimeOptions) {},
+	PresetCPUParityV1: func(o *RuntimeOptions) {
+		o.StrictKQ = true
+		o.StrictKQLayerMax = 12
+		o.StrictExpf = true
+		o.StrictExpfLayerMax = 0
+	},
+	PresetParityStrict: func(o *RuntimeOptions) {
+		o.ParityStrict = true
+	},
+}
+
+// RuntimePresets lists the preset names accepted by ApplyPreset.
+func RuntimePresets() []string {
+	names := make([]string, 0, len(runtimePresets))
+	for name := range runtimePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyPreset overlays the named preset onto o.
+func (o *RuntimeOptions) ApplyPreset(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	apply, ok := runtimePresets[name]
+	if !ok {
+		return fmt.Errorf("unknown runtime preset %q (available: %s)", name, strings.Join(RuntimePresets(), ", "))
+	}
+	apply(o)
+	o.Preset = name
+	return nil
+}
+
+// PresetRuntimeOptions returns the built-in defaults with the named preset
+// applied, ignoring the environment.
+func PresetRuntimeOptions(name string) (RuntimeOptions, error) {
+	o := baseRuntimeOptions()
+	if err := o.ApplyPreset(name); err != nil {
+		return RuntimeOptions{}, err
+	}
+	return o.resolve(), nil
+}
+
+// DefaultRuntimeOptions returns the options implied by the environment:
+// built-in defaults, then the BITNET_PARITY_PROFILE preset, then any
+// explicitly set BITNET_* variable.
+func DefaultRuntimeOptions() RuntimeOptions {
+	o := baseRuntimeOptions()
+	if p := strings.TrimSpace(os.Getenv("BITNET_PARITY_PROFILE")); p != "" {
+		// Unknown profiles are ignored, as they were before presets existed.
+		_ = o.ApplyPreset(p)
+	}
+
+	envOn("BITNET_PARITY_STRICT", &o.ParityStrict)
+	envOn("BITNET_STRICT_KQ", &o.StrictKQ)
+	envInt("BITNET_STRICT_KQ_LAYER_MAX", &o.StrictKQLayerMax)
+	if v, ok := os.LookupEnv("BITNET_STRICT_KQ_MODE"); ok {
+		o.StrictKQMode = parseStrictKQMode(v)
+	}
+	envOn("BITNET_STRICT_EXPF", &o.StrictExpf)
+	envInt("BITNET_STRICT_EXPF_LAYER_MAX", &o.StrictExpfLayerMax)
+	envOn("BITNET_STRICT_ATTENTION", &o.StrictAttention)
+	envOn("BITNET_FAST_EXPF", &o.FastExpf)
+	envOn("BITNET_ATTN_F64", &o.AttnF64)
+	envNotOff("BITNET_FAST_KQ_DOT", &o.FastKQDot)
+	envNotOff("BITNET_FAST_V_DOT", &o.FastVDot)
+	envInt("BITNET_ATTN_PAR_MIN_STEPS", &o.AttnParallelMinSteps)
+
+	envNotOff("BITNET_KV_ROWMAJOR", &o.KVRowMajor)
+	envOn("BITNET_FAST_QKV_COL", &o.FastQKVCol)
+	envInt("BITNET_QKV_FUSED_MAX", &o.QKVFusedMax)
+	envOn("BITNET_I2S_F32", &o.I2SFloat)
+	envInt("BITNET_I2S_PRETRANSPOSE_MAX", &o.I2SPretransposeMax)
+	envOn("BITNET_TQ_F32", &o.TQFloat)
+	envNotOff("BITNET_FFN_SHARE_I2S_QUANT", &o.FFNShareI2SQuant)
+	envNotOff("BITNET_FFN_SHARE_I2S_DOWN", &o.FFNShareI2SDown)
+	envOn("BITNET_FFN_PAR_GATE_UP", &o.FFNParGateUp)
+	envOn("BITNET_USE_F16_TOKEN_EMBD", &o.F16TokenEmbd)
+	envOn("BITNET_MMAP_I2S", &o.MmapI2S)
+	envOn("BITNET_FAST_GREEDY_ARGMAX", &o.FastGreedyArgmax)
+
+	envOn("BITNET_DISABLE_TOPK", &o.DisableTopK)
+	envInt("BITNET_TOPP_HEAP_CAP", &o.TopPHeapCap)
+	envInt("BITNET_TOPP_SORT_PREFIX", &o.TopPSortPrefix)
+	envInt("BITNET_TOPP_PREFILTER_K", &o.TopPPrefilterK)
+
+	envInt("BITNET_PROMPT_CACHE_CAP", &o.PromptCacheCap)
+	envInt("BITNET_DECODE_CACHE_CAP", &o.DecodeCacheCap)
+	envInt("BITNET_DECODE_CACHE_MAX_TOKENS", &o.DecodeCacheMaxTokens)
+
+	if v, ok := os.LookupEnv("BITNET_KV_CACHE"); ok {
+		if t, err := ParseKVCacheType(v); err == nil {
+			o.KVCacheType = t
+		}
+	}
+	envInt("BITNET_CONTEXT_SIZE", &o.ContextLength)
+	envOn("BITNET_CONTEXT_SHIFT", &o.ContextShift)
+	envInt("BITNET_CONTEXT_KEEP", &o.ContextKeep)
+
+	envOn("BITNET_STRICT_ATTENTION_REF", &o.StrictAttentionRef)
+	envOn("BITNET_STRICT_FFN_REF", &o.StrictFFNRef)
+	envOn("BITNET_STRICT_FFN_ACT_F64", &o.StrictFFNActF64)
+	envOn("BITNET_STRICT_V_REF", &o.StrictVRef)
+	envInt("BITNET_STRICT_V_REF_LAYER_MAX", &o.StrictVRefLayerMax)
+	envOn("BITNET_STRICT_Q_F32", &o.StrictQF32)
+	envInt("BITNET_STRICT_Q_F32_LAYER_MAX", &o.StrictQF32LayerMax)
+	o.StrictQF32Heads = parseStrictQF32Heads(os.Getenv("BITNET_STRICT_Q_F32_HEADS"))
+	if len(o.StrictQF32Heads) == 0 {
+		head := -1
+		envInt("BITNET_STRICT_Q_F32_HEAD", &head)
+		if head >= 0 {
+			o.StrictQF32Heads = []int{head}
+		}
+	}
+	envOn("BITNET_STRICT_K_F32", &o.StrictKF32)
+	envInt("BITNET_STRICT_K_F32_LAYER_MAX", &o.StrictKF32LayerMax)
+	envOn("BITNET_STRICT_V_F32", &o.StrictVF32)
+	envInt("BITNET_STRICT_V_F32_LAYER_MAX", &o.StrictVF32LayerMax)
+
+	envOn("BITNET_I2S_DISABLE_ACTSUM", &o.I2SDisableActSum)
+	envOn("BITNET_I2S_INVERT_ACT_SCALE", &o.I2SInvertActScale)
+	envOn("BITNET_I2S_FORCE_Q", &o.I2SForceQuant)
+	envOn("BITNET_I2S_REF_DOT", &o.I2SRefDot)
+	envOn("BITNET_I2S_REF_ONCE", &o.I2SRefOnce)
+	envOn("BITNET_DEBUG_I2S_MATVEC_REF", &o.I2SMatvecRef)
+	envOn("BITNET_I2S_MAP3_TO1", &o.I2SMap3To1)
+	envOn("BITNET_I2S_ALT_LAYOUT", &o.I2SAltLayout)
+	envOn("BITNET_I2S_SCALAR", &o.I2SScalar)
+	return o.resolve()
+}
+
+// resolve applies the implications of ParityStrict.
+func (o RuntimeOptions) resolve() RuntimeOptions {
+	if o.StrictKQMode == "" {
+		o.StrictKQMode = "ggml"
+	}
+	if o.ParityStrict {
+		o.StrictKQ = true
+		o.I2SFloat = true
+		o.TQFloat = true
+		o.FastExpf = false
+		o.FastKQDot = false
+		o.FastVDot = false
+		o.FastQKVCol = false
+	}
+	return o
+}
+
+func envOn(key string, dst *bool) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v == "1"
+	}
+}
+
+func envNotOff(key string, dst *bool) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v != "0"
+	}
+}
+
+func envInt(key string, dst *int) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		*dst = n
+	}
+}
+
+// forLayer returns a copy of o for the layer-th block of the layer stack,
+// with each layer-limited flag cleared past its LayerMax.
+func (o *RuntimeOptions) forLayer(layer int) RuntimeOptions {
+	l := *o
+	l.StrictKQ = o.StrictKQ && layerWithin(layer, o.StrictKQLayerMax)
+	l.StrictExpf = o.StrictExpf && layerWithin(layer, o.StrictExpfLayerMax)
+	l.StrictVRef = o.StrictVRef && layerWithin(layer, o.StrictVRefLayerMax)
+	l.StrictQF32 = o.StrictQF32 && layerWithin(layer, o.StrictQF32LayerMax)
+	l.StrictKF32 = o.StrictKF32 && layerWithin(layer, o.StrictKF32LayerMax)
+	l.StrictVF32 = o.StrictVF32 && layerWithin(layer, o.StrictVF32LayerMax)
+	return l
+}
+
+// perLayer returns forLayer for each of n layers.
+func (o *RuntimeOptions) perLayer(n int) []RuntimeOptions {
+	out := make([]RuntimeOptions, n)
+	for i := range out {
+		out[i] = o.forLayer(i)
+	}
+	return out
+}
+
+func layerWithin(layer, layerMax int) bool {
+	return layerMax < 0 || layer <= layerMax
+}
+
+// i2sQuantFastPath reports whether no i2_s diagnostic needs the slow
+// quantized matvec path.
+func (o *RuntimeOptions) i2sQuantFastPath() bool {
+	return !o.I2SRefOnce && !o.I2SRefDot && !o.I2SMap3To1 && !o.I2SAltLayout && !o.I2SScalar && !o.I2SMatvecRef
+}
+
+// i2sActAdjust applies the i2_s activation diagnostics to a quantized row's
+// scale and sum.
+func (o *RuntimeOptions) i2sActAdjust(actScale float32, actSum int32) (float32, int32) {
+	if o.I2SDisableActSum {
+		actSum = 0
+	}
+	if o.I2SInvertActScale && actScale != 0 {
+		actScale = 1 / actScale
+	}
+	return actScale, actSum
+}
+
+// dotKQStrict computes q·k in the accumulation order selected by StrictKQMode.
+func (o *RuntimeOptions) dotKQStrict(a, b []float32) float32 {
+	switch o.StrictKQMode {
+	case "naive":
+		n := len(a)
+		if len(b) < n {
+			n = len(b)
+		}
+		var sum float32
+		for i := 0; i < n; i++ {
+			sum += a[i] * b[i]
+		}
+		return sum
+	case "f64":
+		n := len(a)
+		if len(b) < n {
+			n = len(b)
+		}
+		var sum float64
+		for i := 0; i < n; i++ {
+			sum += float64(a[i]) * float64(b[i])
+		}
+		return float32(sum)
+	default:
+		return dotF32GGML(a, b)
+	}
+}
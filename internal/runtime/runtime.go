@@ -10,7 +10,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"bitnet-go/internal/gguf"
@@ -91,8 +90,13 @@ func (w *topKWriter) result() []TopKStep {
 
 type Runtime struct {
 	meta             Metadata
+	opts             RuntimeOptions
 	tokenizer        *tokenizer.Tokenizer
 	block            *tensorBlock
+	kvCacheType      KVCacheType
+	contextLength    int
+	contextShift     bool
+	contextKeep      int
 	promptCacheMu    sync.RWMutex
 	promptTokenCache map[string][]int32
 	promptCacheOrder []string
@@ -115,42 +119,6 @@ type decodeCacheEntry struct {
 	text   string
 }
 
-const parityProfileCPUV1 = "cpu_parity_v1"
-
-var parityProfile = strings.ToLower(strings.TrimSpace(os.Getenv("BITNET_PARITY_PROFILE")))
-
-func parityProfileBool(key string, fallback bool) bool {
-	if v, ok := os.LookupEnv(key); ok {
-		return v == "1"
-	}
-	if parityProfile == parityProfileCPUV1 {
-		switch key {
-		case "BITNET_STRICT_KQ", "BITNET_STRICT_EXPF":
-			return true
-		}
-	}
-	return fallback
-}
-
-func parityProfileInt(key string, fallback int) int {
-	if v, ok := os.LookupEnv(key); ok {
-		n, err := strconv.Atoi(v)
-		if err != nil {
-			return fallback
-		}
-		return n
-	}
-	if parityProfile == parityProfileCPUV1 {
-		switch key {
-		case "BITNET_STRICT_KQ_LAYER_MAX":
-			return 12
-		case "BITNET_STRICT_EXPF_LAYER_MAX":
-			return 0
-		}
-	}
-	return fallback
-}
-
 var debugStep0 = os.Getenv("BITNET_DEBUG_STEP0") == "1"
 var disableFFN = os.Getenv("BITNET_DISABLE_FFN") == "1"
 var disableAttn = os.Getenv("BITNET_DISABLE_ATTN") == "1"
@@ -167,62 +135,14 @@ var debugPos = parseDebugPos(os.Getenv("BITNET_DEBUG_POS"))
 var debugPosOffset = parseDebugPosOffset(os.Getenv("BITNET_DEBUG_POS_OFFSET"))
 var debugTokens = parseDebugTokens(os.Getenv("BITNET_DEBUG_TOKENS"))
 var debugSoftmaxPrinted bool
-var debugParityStrict = os.Getenv("BITNET_PARITY_STRICT") == "1"
-var debugStrictAttention = os.Getenv("BITNET_STRICT_ATTENTION") == "1"
-var debugStrictExpf = parityProfileBool("BITNET_STRICT_EXPF", false)
-var strictExpfLayerMax = parityProfileInt("BITNET_STRICT_EXPF_LAYER_MAX", -1)
-var debugStrictVRef = os.Getenv("BITNET_STRICT_V_REF") == "1"
-var strictVRefLayerMax = parseEnvInt("BITNET_STRICT_V_REF_LAYER_MAX", -1)
-var debugStrictQF32 = os.Getenv("BITNET_STRICT_Q_F32") == "1"
-var strictQF32LayerMax = parseEnvInt("BITNET_STRICT_Q_F32_LAYER_MAX", -1)
-var strictQF32Head = parseEnvInt("BITNET_STRICT_Q_F32_HEAD", -1)
-var strictQF32Heads = parseStrictQF32Heads(os.Getenv("BITNET_STRICT_Q_F32_HEADS"))
-var debugStrictKF32 = os.Getenv("BITNET_STRICT_K_F32") == "1"
-var strictKF32LayerMax = parseEnvInt("BITNET_STRICT_K_F32_LAYER_MAX", -1)
-var debugStrictVF32 = os.Getenv("BITNET_STRICT_V_F32") == "1"
-var strictVF32LayerMax = parseEnvInt("BITNET_STRICT_V_F32_LAYER_MAX", -1)
-var debugFastExpf = os.Getenv("BITNET_FAST_EXPF") == "1" && !debugParityStrict
-var debugAttnF64 = os.Getenv("BITNET_ATTN_F64") == "1"
-var debugStrictKQ = parityProfileBool("BITNET_STRICT_KQ", false) || debugParityStrict
-var strictKQLayerMax = parityProfileInt("BITNET_STRICT_KQ_LAYER_MAX", -1)
-var strictKQMode = parseStrictKQMode(os.Getenv("BITNET_STRICT_KQ_MODE"))
-var strictKQCurrentLayer atomic.Int32
-var debugFastKQ = os.Getenv("BITNET_FAST_KQ_DOT") != "0" && !debugParityStrict
-var debugFastV = os.Getenv("BITNET_FAST_V_DOT") != "0" && !debugParityStrict
-var debugKVRowMajor = os.Getenv("BITNET_KV_ROWMAJOR") != "0"
-var debugFastQKVCol = os.Getenv("BITNET_FAST_QKV_COL") == "1" && !debugParityStrict
-var debugQKVFusedMax = parseEnvInt("BITNET_QKV_FUSED_MAX", 512*512)
-var debugStrictAttnRef = os.Getenv("BITNET_STRICT_ATTENTION_REF") == "1"
-var debugStrictFFNRef = os.Getenv("BITNET_STRICT_FFN_REF") == "1"
-var debugStrictFFNActF64 = os.Getenv("BITNET_STRICT_FFN_ACT_F64") == "1"
-var debugMatchGGML = os.Getenv("BITNET_MATCH_GGML") == "1" || debugParityStrict
 var debugAttnRef = os.Getenv("BITNET_DEBUG_ATTN_REF") == "1"
 var debugFFNRef = os.Getenv("BITNET_DEBUG_FFN_REF") == "1"
 var debugFfnActRef = os.Getenv("BITNET_DEBUG_FFN_ACT_REF") == "1"
 var debugFFNRefF32 = os.Getenv("BITNET_DEBUG_FFN_REF_F32") == "1"
 var debugEmbedRowMajor = os.Getenv("BITNET_DEBUG_EMBD_ROW_MAJOR") == "1"
 var debugStep0Printed bool
-var debugI2SDisableActSum = os.Getenv("BITNET_I2S_DISABLE_ACTSUM") == "1"
-var debugI2SInvertActScale = os.Getenv("BITNET_I2S_INVERT_ACT_SCALE") == "1"
-var debugI2SFloat = os.Getenv("BITNET_I2S_F32") == "1" || debugParityStrict
-var debugI2SForceQuant = os.Getenv("BITNET_I2S_FORCE_Q") == "1"
-var debugI2SPretransposeMax = parseEnvInt("BITNET_I2S_PRETRANSPOSE_MAX", 0)
-var debugI2SRefDot = os.Getenv("BITNET_I2S_REF_DOT") == "1"
-var debugI2SMatvecRef = os.Getenv("BITNET_DEBUG_I2S_MATVEC_REF") == "1"
 var debugI2SMatvecPrinted bool
-var i2sQuantFastPath = !debugI2SRefOnce && !debugI2SRefDot && !debugI2SMap3To1 && !debugI2SAltLayout && !debugI2SScalar && !debugI2SMatvecRef
-var debugI2SRefOnce = os.Getenv("BITNET_I2S_REF_ONCE") == "1"
 var debugI2SRefOncePrinted bool
-var debugI2SMap3To1 = os.Getenv("BITNET_I2S_MAP3_TO1") == "1"
-var debugI2SAltLayout = os.Getenv("BITNET_I2S_ALT_LAYOUT") == "1"
-var debugI2SScalar = os.Getenv("BITNET_I2S_SCALAR") == "1"
-var disableTopK = os.Getenv("BITNET_DISABLE_TOPK") == "1"
-var topPHeapCap = parseEnvInt("BITNET_TOPP_HEAP_CAP", 0)
-var topPSortPrefix = parseEnvInt("BITNET_TOPP_SORT_PREFIX", 0)
-var topPPrefilterK = parseEnvInt("BITNET_TOPP_PREFILTER_K", 0)
-var promptCacheCapDefault = parseEnvInt("BITNET_PROMPT_CACHE_CAP", 128)
-var decodeCacheCapDefault = parseEnvInt("BITNET_DECODE_CACHE_CAP", 256)
-var decodeCacheMaxTokensDefault = parseEnvInt("BITNET_DECODE_CACHE_MAX_TOKENS", 64)
 var profileLoad = os.Getenv("BITNET_PROFILE_LOAD") == "1"
 var profileStep = os.Getenv("BITNET_PROFILE_STEP") == "1"
 var driftTraceStep = parseEnvInt("BITNET_DRIFT_TRACE_STEP", -1)
@@ -240,22 +160,12 @@ var driftVWeightAudit = os.Getenv("BITNET_DRIFT_V_WEIGHT_AUDIT") == "1"
 var driftVMatvecAB = os.Getenv("BITNET_DRIFT_V_MATVEC_AB") == "1"
 var driftAttnAccRef = os.Getenv("BITNET_DRIFT_ATTN_ACC_REF") == "1"
 var driftAttnOutRefF32 = os.Getenv("BITNET_DRIFT_ATTN_OUT_REF_F32") == "1"
-var ffnShareI2SQuant = os.Getenv("BITNET_FFN_SHARE_I2S_QUANT") != "0"
-var ffnShareI2SDown = os.Getenv("BITNET_FFN_SHARE_I2S_DOWN") != "0"
-var ffnParGateUp = os.Getenv("BITNET_FFN_PAR_GATE_UP") == "1"
-var useF16TokenEmbd = os.Getenv("BITNET_USE_F16_TOKEN_EMBD") == "1"
-var fastGreedyArgmax = os.Getenv("BITNET_FAST_GREEDY_ARGMAX") == "1"
-var useMmapI2S = os.Getenv("BITNET_MMAP_I2S") == "1"
 var i8ScratchPool = sync.Pool{
 	New: func() any {
 		return make([]int8, 0)
 	},
 }
 
-func init() {
-	strictKQCurrentLayer.Store(-1)
-}
-
 type samplingConfig struct {
 	temp float32
 	topP float32
@@ -311,20 +221,6 @@ func parseDebugPos(v string) int {
 	return n
 }
 
-func strictKQEnabledForCurrentLayer() bool {
-	if !debugStrictKQ {
-		return false
-	}
-	if strictKQLayerMax < 0 {
-		return true
-	}
-	layer := int(strictKQCurrentLayer.Load())
-	if layer < 0 {
-		return true
-	}
-	return layer <= strictKQLayerMax
-}
-
 func parseStrictKQMode(v string) string {
 	switch strings.TrimSpace(strings.ToLower(v)) {
 	case "", "ggml":
@@ -338,77 +234,8 @@ func parseStrictKQMode(v string) string {
 	}
 }
 
-func dotKQStrictForCurrentLayer(a, b []float32) float32 {
-	switch strictKQMode {
-	case "naive":
-		n := len(a)
-		if len(b) < n {
-			n = len(b)
-		}
-		var sum float32
-		for i := 0; i < n; i++ {
-			sum += a[i] * b[i]
-		}
-		return sum
-	case "f64":
-		n := len(a)
-		if len(b) < n {
-			n = len(b)
-		}
-		var sum float64
-		for i := 0; i < n; i++ {
-			sum += float64(a[i]) * float64(b[i])
-		}
-		return float32(sum)
-	default:
-		return dotF32GGML(a, b)
-	}
-}
-
-func strictExpfEnabledForCurrentLayer() bool {
-	if !debugStrictExpf {
-		return false
-	}
-	if strictExpfLayerMax < 0 {
-		return true
-	}
-	layer := int(strictKQCurrentLayer.Load())
-	if layer < 0 {
-		return true
-	}
-	return layer <= strictExpfLayerMax
-}
-
-func strictVRefEnabledForCurrentLayer() bool {
-	if !debugStrictVRef {
-		return false
-	}
-	if strictVRefLayerMax < 0 {
-		return true
-	}
-	layer := int(strictKQCurrentLayer.Load())
-	if layer < 0 {
-		return true
-	}
-	return layer <= strictVRefLayerMax
-}
-
-func strictQF32EnabledForCurrentLayer() bool {
-	if !debugStrictQF32 {
-		return false
-	}
-	if strictQF32LayerMax < 0 {
-		return true
-	}
-	layer := int(strictKQCurrentLayer.Load())
-	if layer < 0 {
-		return true
-	}
-	return layer <= strictQF32LayerMax
-}
-
-func parseStrictQF32Heads(v string) map[int]struct{} {
-	out := make(map[int]struct{})
+func parseStrictQF32Heads(v string) []int {
+	var out []int
 	v = strings.TrimSpace(v)
 	if v == "" {
 		return out
@@ -419,10 +246,10 @@ func parseStrictQF32Heads(v string) map[int]struct{} {
 			continue
 		}
 		n, err := strconv.Atoi(part)
-		if err != nil || n < 0 {
+		if err != nil || n < 0 || slices.Contains(out, n) {
 			continue
 		}
-		out[n] = struct{}{}
+		out = append(out, n)
 	}
 	return out
 }
@@ -448,34 +275,6 @@ func parseEnvIntCSV(key string) []int {
 	return out
 }
 
-func strictKF32EnabledForCurrentLayer() bool {
-	if !debugStrictKF32 {
-		return false
-	}
-	if strictKF32LayerMax < 0 {
-		return true
-	}
-	layer := int(strictKQCurrentLayer.Load())
-	if layer < 0 {
-		return true
-	}
-	return layer <= strictKF32LayerMax
-}
-
-func strictVF32EnabledForCurrentLayer() bool {
-	if !debugStrictVF32 {
-		return false
-	}
-	if strictVF32LayerMax < 0 {
-		return true
-	}
-	layer := int(strictKQCurrentLayer.Load())
-	if layer < 0 {
-		return true
-	}
-	return layer <= strictVF32LayerMax
-}
-
 func parseDebugPosOffset(v string) int {
 	if v == "" {
 		return 0
@@ -557,14 +356,6 @@ func parseEnvInt(key string, fallback int) int {
 	return n
 }
 
-func setTopPSortPrefixForTest(v int) func() {
-	old := topPSortPrefix
-	topPSortPrefix = v
-	return func() {
-		topPSortPrefix = old
-	}
-}
-
 func shouldDebug(pos int) bool {
 	if !debugStep0 || debugStep0Printed {
 		return false
@@ -575,7 +366,62 @@ func shouldDebug(pos int) bool {
 	return pos == 0
 }
 
-func New(_ context.Context, modelPath string) (*Runtime, error) {
+// Options configures a Runtime at creation time. Zero values fall back to the
+// BITNET_* environment defaults.
+type Options struct {
+	KVCacheType KVCacheType
+	// ContextLength caps the context window; 0 uses the model's context_length.
+	ContextLength int
+	// ContextShift discards old tokens instead of failing when a request
+	// outgrows the context window; nil follows BITNET_CONTEXT_SHIFT.
+	// ContextKeep tokens at the start of the sequence (e.g. a system
+	// prompt) are never discarded.
+	ContextShift *bool
+	ContextKeep  int
+	// Runtime selects numerics, kernels and cache sizes for this session;
+	// nil uses DefaultRuntimeOptions.
+	Runtime *RuntimeOptions
+}
+
+// forwardOptions carries per-session settings into the forward pass.
+type forwardOptions struct {
+	kvCacheType   KVCacheType
+	contextLength int
+	contextShift  bool
+	contextKeep   int
+	// stats is nil unless RuntimeOptions.Observer is set.
+	stats *forwardStats
+}
+
+func New(ctx context.Context, modelPath string) (*Runtime, error) {
+	return NewWithOptions(ctx, modelPath, Options{})
+}
+
+func NewWithOptions(_ context.Context, modelPath string, opts Options) (*Runtime, error) {
+	rtOpts := DefaultRuntimeOptions()
+	if opts.Runtime != nil {
+		rtOpts = opts.Runtime.resolve()
+	}
+	kvType := rtOpts.KVCacheType
+	if opts.KVCacheType != "" {
+		t, err := ParseKVCacheType(string(opts.KVCacheType))
+		if err != nil {
+			return nil, err
+		}
+		kvType = t
+	}
+	ctxSize := rtOpts.ContextLength
+	if opts.ContextLength > 0 {
+		ctxSize = opts.ContextLength
+	}
+	ctxKeep := rtOpts.ContextKeep
+	if opts.ContextKeep > 0 {
+		ctxKeep = opts.ContextKeep
+	}
+	ctxShift := rtOpts.ContextShift
+	if opts.ContextShift != nil {
+		ctxShift = *opts.ContextShift
+	}
 	t0 := time.Now()
 	info, err := gguf.ReadModelInfo(modelPath)
 	tInfo := time.Since(t0)
@@ -589,7 +435,7 @@ func New(_ context.Context, modelPath string) (*Runtime, error) {
 			Version:     h.Version,
 			TensorCount: h.TensorCount,
 			KVCount:     h.KVCount,
-		}}, nil
+		}, opts: rtOpts, kvCacheType: kvType, contextLength: ctxSize, contextShift: ctxShift, contextKeep: ctxKeep}, nil
 	}
 
 	arch, _ := info.KeyValues["general.architecture"].(string)
@@ -598,6 +444,7 @@ func New(_ context.Context, modelPath string) (*Runtime, error) {
 		info.KeyValues["llama.context_length"],
 		info.KeyValues["falcon.context_length"],
 		info.KeyValues["gpt2.context_length"],
+		info.KeyValues[arch+".context_length"],
 	)
 	vocab := firstUint32(
 		info.KeyValues["bitnet-b1.58.vocab_size"],
@@ -608,20 +455,41 @@ func New(_ context.Context, modelPath string) (*Runtime, error) {
 	)
 
 	tTokStart := time.Now()
-	tok, _ := tokenizer.NewFromModelInfo(info)
+	tok, err := tokenizer.NewFromModelInfo(info)
+	if _, hasVocab := info.KeyValues["tokenizer.ggml.tokens"]; err != nil && hasVocab {
+		// A vocab that cannot be loaded as the model specifies (e.g. an
+		// unknown tokenizer.ggml.pre) would encode prompts to the wrong IDs.
+		return nil, fmt.Errorf("load tokenizer: %w", err)
+	}
 	tTok := time.Since(tTokStart)
 	tBlockStart := time.Now()
-	block, err := loadTensorBlock(modelPath, info)
+	block, err := loadTensorBlock(modelPath, info, &rtOpts)
 	if err != nil {
 		return nil, err
 	}
 	tBlock := time.Since(tBlockStart)
+	if ctxSize <= 0 {
+		ctxSize = int(ctxLen)
+	}
+	if block != nil && block.positionEmbdRows > 0 {
+		// Learned absolute positions end at position_embd's rows, and the
+		// cached keys carry no rotary phase a shift could re-rotate.
+		if ctxShift {
+			return nil, fmt.Errorf("architecture %q: context shift needs rotary positions, but the model has learned position embeddings", arch)
+		}
+		if opts.ContextLength > block.positionEmbdRows {
+			return nil, fmt.Errorf("context length %d exceeds the model's %d position embeddings", opts.ContextLength, block.positionEmbdRows)
+		}
+		if ctxSize <= 0 || ctxSize > block.positionEmbdRows {
+			ctxSize = block.positionEmbdRows
+		}
+	}
 	if profileLoad {
 		fmt.Fprintf(os.Stderr, "load_profile model=%s read_model_info=%s tokenizer=%s tensor_block=%s total=%s\n",
 			modelPath, tInfo, tTok, tBlock, time.Since(t0))
 	}
 
-	return &Runtime{
+	r := &Runtime{
 		meta: Metadata{
 			Path:          modelPath,
 			Version:       info.Version,
@@ -631,40 +499,62 @@ func New(_ context.Context, modelPath string) (*Runtime, error) {
 			ContextLength: ctxLen,
 			VocabSize:     vocab,
 		},
+		opts:             rtOpts,
 		tokenizer:        tok,
 		block:            block,
+		kvCacheType:      kvType,
+		contextLength:    ctxSize,
+		contextShift:     ctxShift,
+		contextKeep:      ctxKeep,
 		promptTokenCache: make(map[string][]int32),
-		promptCacheCap:   promptCacheCapDefault,
+		promptCacheCap:   rtOpts.PromptCacheCap,
 		decodeTextCache:  make(map[decodeCacheKey][]decodeCacheEntry),
-		decodeCacheCap:   decodeCacheCapDefault,
-		decodeCacheMax:   decodeCacheMaxTokensDefault,
-	}, nil
+		decodeCacheCap:   rtOpts.DecodeCacheCap,
+		decodeCacheMax:   rtOpts.DecodeCacheMaxTokens,
+	}
+	if r.block != nil {
+		// Point the block at the Runtime's copy so both see one set of options.
+		r.block.opts = &r.opts
+		r.block.layerOpts = r.opts.perLayer(len(r.block.layers))
+	}
+	return r, nil
+}
+
+// Options returns the runtime options the session was created with.
+func (r *Runtime) Options() RuntimeOptions {
+	return r.opts
 }
 
 func (r *Runtime) Metadata() Metadata {
 	return r.meta
 }
 
-func (r *Runtime) promptTokens(prompt string) []int32 {
+// Tokenizer returns the model's tokenizer, or nil if the GGUF has no
+// usable tokenizer metadata.
+func (r *Runtime) Tokenizer() *tokenizer.Tokenizer {
+	return r.tokenizer
+}
+
+func (r *Runtime) promptTokens(prompt string) ([]int32, CacheResult) {
 	if r.tokenizer == nil {
-		return nil
+		return nil, CacheBypass
 	}
 	if r.promptCacheCap > 0 {
 		r.promptCacheMu.RLock()
 		if tok, ok := r.promptTokenCache[prompt]; ok {
 			r.promptCacheMu.RUnlock()
-			return tok
+			return tok, CacheHit
 		}
 		r.promptCacheMu.RUnlock()
 	}
 	tok := r.tokenizer.Tokenize(prompt)
 	if r.promptCacheCap <= 0 {
-		return tok
+		return tok, CacheBypass
 	}
 	r.promptCacheMu.Lock()
 	if existing, ok := r.promptTokenCache[prompt]; ok {
 		r.promptCacheMu.Unlock()
-		return existing
+		return existing, CacheHit
 	}
 	for len(r.promptCacheOrder) >= r.promptCacheCap {
 		evict := r.promptCacheOrder[0]
@@ -674,7 +564,7 @@ func (r *Runtime) promptTokens(prompt string) []int32 {
 	r.promptTokenCache[prompt] = tok
 	r.promptCacheOrder = append(r.promptCacheOrder, prompt)
 	r.promptCacheMu.Unlock()
-	return tok
+	return tok, CacheMiss
 }
 
 func firstUint32(values ...any) uint32 {
@@ -712,14 +602,22 @@ func (r *Runtime) Generate(_ context.Context, req GenerateRequest) (struct {
 		}{}, nil
 	}
 
-	promptTokens := r.promptTokens(req.Prompt)
+	start := time.Now()
+	promptTokens, promptCache := r.promptTokens(req.Prompt)
+	if err := checkContextLength(len(promptTokens), req.MaxTokens, r.contextLength, r.contextShift); err != nil {
+		return struct {
+			TokenIDs []int32
+			Text     string
+			TopK     []TopKStep
+		}{}, err
+	}
 
 	// Phase-2 stepping stone: minimal forward loop with naive kernels and
 	// procedural weights. If model carries bitnet_go.* f32 tensors, use a first
 	// tensor-backed block path instead.
 	tokens := make([]int32, req.MaxTokens)
 	var topkWriter *topKWriter
-	if !disableTopK && !req.DisableTopKCapture {
+	if !r.opts.DisableTopK && !req.DisableTopKCapture {
 		topkWriter = newTopKWriter(req.MaxTokens, 5)
 	}
 	cfg := samplingConfig{
@@ -729,11 +627,29 @@ func (r *Runtime) Generate(_ context.Context, req GenerateRequest) (struct {
 	}
 	cfg.normalize()
 	forceTokens := forceTokensFromEnv()
+	stats := newForwardStats(r.opts.Observer, start)
+	stats.beginForward()
 	if r.block != nil {
-		runForwardTensorBlock(r.block, req.Seed, promptTokens, tokens, topkWriter, forceTokens, cfg)
+		err := runForwardTensorBlock(r.block, req.Seed, promptTokens, tokens, topkWriter, forceTokens, cfg, forwardOptions{
+			kvCacheType:   r.kvCacheType,
+			contextLength: r.contextLength,
+			contextShift:  r.contextShift,
+			contextKeep:   r.contextKeep,
+			stats:         stats,
+		})
+		if err != nil {
+			return struct {
+				TokenIDs []int32
+				Text     string
+				TopK     []TopKStep
+			}{}, err
+		}
 	} else {
-		runForwardStub(r.meta.VocabSize, req.Seed, promptTokens, tokens, topkWriter, cfg)
+		runForwardStub(&r.opts, r.meta.VocabSize, req.Seed, promptTokens, tokens, topkWriter, cfg)
 	}
+	stats.endForward()
+	text, decodeCache := r.decodeTokens(tokens)
+	stats.report(len(promptTokens), len(tokens), promptCache, decodeCache)
 
 	return struct {
 		TokenIDs []int32
@@ -741,17 +657,17 @@ func (r *Runtime) Generate(_ context.Context, req GenerateRequest) (struct {
 		TopK     []TopKStep
 	}{
 		TokenIDs: tokens,
-		Text:     req.Prompt + r.decodeTokens(tokens),
+		Text:     req.Prompt + text,
 		TopK:     topkWriter.result(),
 	}, nil
 }
 
-func (r *Runtime) decodeTokens(tokens []int32) string {
+func (r *Runtime) decodeTokens(tokens []int32) (string, CacheResult) {
 	if r.tokenizer == nil {
-		return ""
+		return "", CacheBypass
 	}
 	if len(tokens) == 0 || r.decodeCacheCap <= 0 || r.decodeCacheMax <= 0 || len(tokens) > r.decodeCacheMax {
-		return r.tokenizer.Decode(tokens)
+		return r.tokenizer.Decode(tokens), CacheBypass
 	}
 	key := makeDecodeCacheKey(tokens)
 	r.decodeCacheMu.RLock()
@@ -760,7 +676,7 @@ func (r *Runtime) decodeTokens(tokens []int32) string {
 			if slices.Equal(bucket[i].tokens, tokens) {
 				text := bucket[i].text
 				r.decodeCacheMu.RUnlock()
-				return text
+				return text, CacheHit
 			}
 		}
 	}
@@ -774,7 +690,7 @@ func (r *Runtime) decodeTokens(tokens []int32) string {
 			if slices.Equal(bucket[i].tokens, tokens) {
 				text = bucket[i].text
 				r.decodeCacheMu.Unlock()
-				return text
+				return text, CacheHit
 			}
 		}
 	}
@@ -790,7 +706,7 @@ func (r *Runtime) decodeTokens(tokens []int32) string {
 	})
 	r.decodeCacheOrder = append(r.decodeCacheOrder, key)
 	r.decodeCacheMu.Unlock()
-	return text
+	return text, CacheMiss
 }
 
 func makeDecodeCacheKey(tokens []int32) decodeCacheKey {
@@ -812,6 +728,9 @@ type tensorBlock struct {
 	hiddenDim            int
 	vocabDim             int
 	mode                 tensorBlockMode
+	arch                 architecture
+	opts                 *RuntimeOptions
+	layerOpts            []RuntimeOptions
 	attnHeads            int
 	kvHeads              int
 	ropeFreqBase         float32
@@ -844,14 +763,20 @@ type tensorBlock struct {
 	rmsEps               float32
 	ffnUseSilu           bool
 	layers               []llamaLayer
+	outputNormBias       []float32
+	falconLayers         []falconLayer
+	positionEmbd         []float32
+	positionEmbdRows     int
+	gpt2Layers           []gpt2Layer
 }
 
+// tensorBlockMode selects the forward pass of the minimal blocks loaded from
+// files without general.architecture. Architecture blocks dispatch on arch.
 type tensorBlockMode int
 
 const (
 	tensorBlockModeProjection tensorBlockMode = iota + 1
 	tensorBlockModeEmbeddingOutput
-	tensorBlockModeLlamaStack
 )
 
 type llamaLayer struct {
@@ -866,6 +791,9 @@ type llamaLayer struct {
 	ffnGate         linearWeight
 	ffnUp           linearWeight
 	ffnDown         linearWeight
+	attnQBias       []float32
+	attnKBias       []float32
+	attnVBias       []float32
 	debugAttnQF32   []float32
 	debugAttnKF32   []float32
 	debugAttnVF32   []float32
@@ -884,21 +812,23 @@ type linearWeight struct {
 	qtype      uint32
 	i2sPacked  []byte
 	i2sScale   float32
+	tqPacked   []byte
 }
 
 type modelTensorLoader struct {
 	info     gguf.ModelInfo
+	opts     *RuntimeOptions
 	f        *os.File
 	mmapData []byte
 }
 
-func newModelTensorLoader(path string, info gguf.ModelInfo) (*modelTensorLoader, error) {
+func newModelTensorLoader(path string, info gguf.ModelInfo, opts *RuntimeOptions) (*modelTensorLoader, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	l := &modelTensorLoader{info: info, f: f}
-	if useMmapI2S {
+	l := &modelTensorLoader{info: info, opts: opts, f: f}
+	if opts.MmapI2S {
 		if data, err := mmapReadOnly(f); err == nil {
 			l.mmapData = data
 		}
@@ -943,25 +873,67 @@ func (l *modelTensorLoader) readTensorI2SPacked(name string) ([]byte, float32, u
 	return gguf.ReadTensorI2SPackedFromFile(l.f, l.info, name)
 }
 
+func (l *modelTensorLoader) readTensorTQPacked(name string) ([]byte, error) {
+	if len(l.mmapData) > 0 {
+		t, ok := l.info.TensorByName(name)
+		if !ok {
+			return nil, fmt.Errorf("tensor not found: %s", name)
+		}
+		blockBytes := gguf.TQBlockBytes(t.Type)
+		if blockBytes == 0 {
+			return nil, fmt.Errorf("tensor %q type=%d is not tq1_0/tq2_0", name, t.Type)
+		}
+		count, err := gguf.TensorElementCount(t)
+		if err != nil {
+			return nil, err
+		}
+		start := int(l.info.TensorDataOffset + t.Offset)
+		end := start + int(count/256)*blockBytes
+		if start < 0 || end < start || end > len(l.mmapData) {
+			return nil, fmt.Errorf("tensor %q mmap bounds out of range", name)
+		}
+		return l.mmapData[start:end], nil
+	}
+	packed, _, err := gguf.ReadTensorTQPackedFromFile(l.f, l.info, name)
+	return packed, err
+}
+
 func (l *modelTensorLoader) readTensorF16Raw(name string) ([]uint16, error) {
 	return gguf.ReadTensorF16RawFromFile(l.f, l.info, name)
 }
 
-func loadTensorBlock(path string, info gguf.ModelInfo) (*tensorBlock, error) {
-	loader, err := newModelTensorLoader(path, info)
+func loadTensorBlock(path string, info gguf.ModelInfo, opts *RuntimeOptions) (*tensorBlock, error) {
+	loader, err := newModelTensorLoader(path, info, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer loader.close()
 
-	block, found, err := loadLlamaStack(info, loader)
+	name, _ := info.KeyValues["general.architecture"].(string)
+	arch, ok := lookupArchitecture(name)
+	if !ok {
+		if name != "" {
+			return nil, &ErrUnsupportedArchitecture{Architecture: name, Supported: registeredArchitectures()}
+		}
+		arch = defaultArchitecture
+	}
+	block, found, err := arch.loadBlock(info, loader)
 	if err != nil {
 		return nil, err
 	}
 	if found {
+		block.arch = arch
 		return block, nil
 	}
+	if name != "" {
+		if len(info.Tensors) == 0 {
+			// Vocabulary-only files run on the stub generator.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("architecture %q: missing blk.0 layer tensors", name)
+	}
 
+	// Files without general.architecture may carry the minimal test blocks.
 	block, found, err = loadProjectionBlock(info, loader)
 	if err != nil {
 		return nil, err
@@ -1067,7 +1039,7 @@ func loadEmbeddingOutputBlock(info gguf.ModelInfo, loader *modelTensorLoader) (*
 		return nil, fmt.Errorf("%s has invalid dims %v", tokenEmbdName, embInfo.Dimensions)
 	}
 
-	if embInfo.Type == gguf.GGMLTypeF16 && useF16TokenEmbd {
+	if embInfo.Type == gguf.GGMLTypeF16 && loader.opts.F16TokenEmbd {
 		tokenEmbdF16, err := loader.readTensorF16Raw(tokenEmbdName)
 		if err != nil {
 			return nil, err
@@ -1127,13 +1099,13 @@ func loadEmbeddingOutputBlock(info gguf.ModelInfo, loader *modelTensorLoader) (*
 	return emb, nil
 }
 
-func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBlock, bool, error) {
+func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader, spec llamaArchitecture) (*tensorBlock, bool, error) {
 	if _, ok := info.TensorByName("blk.0.attn_q.weight"); !ok {
 		return nil, false, nil
 	}
 	arch, _ := info.KeyValues["general.architecture"].(string)
-	useTokEmbOut := arch == "bitnet-b1.58" || arch == "bitnet" || arch == "bitnet-25"
-	ropeNeox := arch == "bitnet-b1.58" || arch == "bitnet" || arch == "bitnet-25"
+	// Llama-family GGUFs (e.g. qwen2) prefix hyperparameters with their architecture.
+	archKV := func(suffix string) any { return info.KeyValues[arch+"."+suffix] }
 
 	embInfo, ok := info.TensorByName("token_embd.weight")
 	if !ok {
@@ -1149,7 +1121,6 @@ func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBloc
 	}
 
 	b := &tensorBlock{
-		mode:          tensorBlockModeLlamaStack,
 		hiddenDim:     hidden,
 		vocabDim:      vocab,
 		tokenEmbdRows: hidden,
@@ -1159,19 +1130,23 @@ func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBloc
 			float32(1e-5),
 			info.KeyValues["llama.attention.layer_norm_rms_epsilon"],
 			info.KeyValues["bitnet-b1.58.attention.layer_norm_rms_epsilon"],
+			archKV("attention.layer_norm_rms_epsilon"),
 		),
 		attnHeads: int(firstUint32(
 			info.KeyValues["llama.attention.head_count"],
 			info.KeyValues["bitnet-b1.58.attention.head_count"],
+			archKV("attention.head_count"),
 		)),
 		kvHeads: int(firstUint32(
 			info.KeyValues["llama.attention.head_count_kv"],
 			info.KeyValues["bitnet-b1.58.attention.head_count_kv"],
+			archKV("attention.head_count_kv"),
 		)),
 		ropeFreqBase: firstFloat32From(
 			float32(10000),
 			info.KeyValues["llama.rope.freq_base"],
 			info.KeyValues["bitnet-b1.58.rope.freq_base"],
+			archKV("rope.freq_base"),
 		),
 		ropeScale: firstFloat32(info.KeyValues["llama.rope.scaling.factor"], 1.0),
 		ropeScalingType: firstString(
@@ -1181,14 +1156,15 @@ func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBloc
 		ropeDim: int(firstUint32(
 			info.KeyValues["llama.rope.dimension_count"],
 			info.KeyValues["bitnet-b1.58.rope.dimension_count"],
+			archKV("rope.dimension_count"),
 		)),
-		ropeNeox:           ropeNeox,
+		ropeNeox:           spec.ropeNeox,
 		ropeYarnBetaFast:   firstFloat32(info.KeyValues["llama.rope.scaling.beta_fast"], 0),
 		ropeYarnBetaSlow:   firstFloat32(info.KeyValues["llama.rope.scaling.beta_slow"], 0),
 		ropeYarnOrigCtx:    firstFloat32(info.KeyValues["llama.rope.scaling.original_context_length"], 0),
 		ropeYarnExtFactor:  firstFloat32(info.KeyValues["llama.rope.scaling.ext_factor"], 0),
 		ropeYarnAttnFactor: firstFloat32(info.KeyValues["llama.rope.scaling.attn_factor"], 1.0),
-		ffnUseSilu:         arch == "llama",
+		ffnUseSilu:         spec.ffnUseSilu,
 	}
 	if b.attnHeads <= 0 {
 		b.attnHeads = 1
@@ -1210,7 +1186,7 @@ func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBloc
 	}
 
 	var err error
-	if embInfo.Type == gguf.GGMLTypeF16 && useF16TokenEmbd {
+	if embInfo.Type == gguf.GGMLTypeF16 && loader.opts.F16TokenEmbd {
 		if b.tokenEmbdF16, err = loader.readTensorF16Raw("token_embd.weight"); err != nil {
 			return nil, false, err
 		}
@@ -1223,7 +1199,7 @@ func loadLlamaStack(info gguf.ModelInfo, loader *modelTensorLoader) (*tensorBloc
 	if len(b.outputNorm) != hidden {
 		return nil, false, fmt.Errorf("output_norm.weight len=%d want=%d", len(b.outputNorm), hidden)
 	}
-	if useTokEmbOut {
+	if spec.tiedOutput {
 		b.outputWeight = b.tokenEmbd
 		b.outputWeightF16 = b.tokenEmbdF16
 		b.outputRows = hidden
@@ -1329,6 +1305,15 @@ func loadLlamaLayer(info gguf.ModelInfo, loader *modelTensorLoader, layerIdx int
 	if qHeadDim != kHeadDim || qHeadDim != vHeadDim {
 		return llamaLayer{}, fmt.Errorf("%shead dims mismatch q=%d k=%d v=%d", prefix, qHeadDim, kHeadDim, vHeadDim)
 	}
+	if l.attnQBias, err = readOptionalVector(info, loader, prefix+"attn_q.bias", qDim); err != nil {
+		return llamaLayer{}, err
+	}
+	if l.attnKBias, err = readOptionalVector(info, loader, prefix+"attn_k.bias", kDim); err != nil {
+		return llamaLayer{}, err
+	}
+	if l.attnVBias, err = readOptionalVector(info, loader, prefix+"attn_v.bias", vDim); err != nil {
+		return llamaLayer{}, err
+	}
 	if l.attnOut, err = loadLinearWeight(info, loader, prefix+"attn_output.weight", qDim); err != nil {
 		return llamaLayer{}, err
 	}
@@ -1358,7 +1343,7 @@ func loadLlamaLayer(info gguf.ModelInfo, loader *modelTensorLoader, layerIdx int
 	if linearOutputLen(l.ffnDown) != hidden {
 		return llamaLayer{}, fmt.Errorf("%sffn_down.weight output dim=%d want=%d", prefix, linearOutputLen(l.ffnDown), hidden)
 	}
-	if driftQKVRefF32 || driftQKVMatvecAB || driftVProjVariants || debugStrictQF32 || debugStrictKF32 || debugStrictVF32 {
+	if driftQKVRefF32 || driftQKVMatvecAB || driftVProjVariants || loader.opts.StrictQF32 || loader.opts.StrictKF32 || loader.opts.StrictVF32 {
 		if l.debugAttnQF32, err = loader.readTensorAsF32(prefix + "attn_q.weight"); err != nil {
 			return llamaLayer{}, err
 		}
@@ -1442,17 +1427,19 @@ func loadLlamaLayer(info gguf.ModelInfo, loader *modelTensorLoader, layerIdx int
 	return l, nil
 }
 
-func runForwardTensorBlock(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig) {
+func runForwardTensorBlock(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig, opts forwardOptions) error {
+	if block.arch != nil {
+		return runForwardArchitecture(block, seed, promptTokens, out, topk, forceTokens, cfg, opts)
+	}
 	switch block.mode {
 	case tensorBlockModeProjection:
 		runForwardProjectionBlock(block, seed, promptTokens, out, topk, cfg)
 	case tensorBlockModeEmbeddingOutput:
 		runForwardEmbeddingOutputBlock(block, seed, promptTokens, out, topk, cfg)
-	case tensorBlockModeLlamaStack:
-		runForwardLlamaStack(block, seed, promptTokens, out, topk, forceTokens, cfg)
 	default:
-		runForwardStub(uint32(block.vocabDim), seed, promptTokens, out, topk, cfg)
+		runForwardStub(block.opts, uint32(block.vocabDim), seed, promptTokens, out, topk, cfg)
 	}
+	return nil
 }
 
 func runForwardProjectionBlock(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, cfg samplingConfig) {
@@ -1495,7 +1482,7 @@ func runForwardProjectionBlock(block *tensorBlock, seed int64, promptTokens []in
 			topk.append(i, logits)
 		}
 
-		next := sampleLogitsWithScratch(logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
+		next := sampleLogitsWithScratch(block.opts, logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
 		if next < 0 {
 			out[i] = 0
 			continue
@@ -1537,7 +1524,7 @@ func runForwardEmbeddingOutputBlock(block *tensorBlock, seed int64, promptTokens
 	}
 
 	for i := range out {
-		linearApplyIntoWeight(logits, linearWeight{
+		linearApplyIntoWeight(block.opts, logits, linearWeight{
 			data:       block.outputWeight,
 			dataF16:    block.outputWeightF16,
 			rows:       block.outputRows,
@@ -1551,7 +1538,7 @@ func runForwardEmbeddingOutputBlock(block *tensorBlock, seed int64, promptTokens
 			topk.append(i, logits)
 		}
 
-		next := sampleLogitsWithScratch(logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
+		next := sampleLogitsWithScratch(block.opts, logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
 		if next < 0 {
 			out[i] = 0
 			continue
@@ -1587,7 +1574,7 @@ func embedToken(dst []float32, block *tensorBlock, token int32) bool {
 	return true
 }
 
-func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig) {
+func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32, out []int32, topk *topKWriter, forceTokens []int32, cfg samplingConfig, opts forwardOptions) {
 	if len(out) == 0 {
 		return
 	}
@@ -1596,11 +1583,15 @@ func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32,
 			block.attnHeads, block.kvHeads, block.ropeDim, block.ropeFreqBase, block.ropeScale, block.ropeScalingType, block.ropeYarnBetaFast, block.ropeYarnBetaSlow, block.ropeYarnExtFactor, block.ropeYarnAttnFactor)
 	}
 	maxSeq := len(promptTokens) + len(out)
+	shiftCtx := opts.contextShift && opts.contextLength > 0 && maxSeq > opts.contextLength
+	if shiftCtx {
+		maxSeq = opts.contextLength
+	}
 	if maxSeq < 1 {
 		maxSeq = 1
 	}
 
-	scratch := getLlamaRunScratch(block, maxSeq)
+	scratch := getLlamaRunScratch(block, maxSeq, opts.kvCacheType)
 	defer putLlamaRunScratch(scratch)
 	x := scratch.x
 	n1 := scratch.n1
@@ -1609,22 +1600,34 @@ func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32,
 	probs := scratch.sampleProbs
 	idx := scratch.sampleIdx
 	layerStates := scratch.layerState
-
+	opts.stats.acquireKV(layerStates)
+	defer opts.stats.releaseKV()
+
+	shifter := contextShifter{
+		block:   block,
+		states:  layerStates,
+		maxSeq:  maxSeq,
+		keep:    opts.contextKeep,
+		enabled: shiftCtx,
+		// The f32 V layout follows the attention path picked in the step.
+		rowMajorV: block.opts.KVRowMajor && !(block.opts.ParityStrict || block.opts.StrictAttentionRef),
+	}
 	currentToken := seedToken(seed, block.vocabDim)
-	startPos := 0
+	pos := 0
 	if len(promptTokens) > 0 {
 		currentToken = promptTokens[len(promptTokens)-1]
-		for pos := 0; pos < len(promptTokens)-1; pos++ {
-			runLlamaStackStep(block, layerStates, promptTokens[pos], pos, x, n1, n2, logits, false)
+		for _, tok := range promptTokens[:len(promptTokens)-1] {
+			pos = shifter.room(pos)
+			runLlamaStackStep(block, layerStates, tok, pos, x, n1, n2, logits, false)
+			pos++
 		}
-		startPos = len(promptTokens) - 1
 	}
 
 	sampler := newSampler(seed)
 	var topkEntries []TopKEntry
 	var topkProbs []float32
 	var stepProfile *llamaStepProfile
-	if profileStep {
+	if profileStep || opts.stats.observing() {
 		stepProfile = &llamaStepProfile{}
 	}
 	if cfg.topK > 0 {
@@ -1637,11 +1640,15 @@ func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32,
 	}
 	for i := range out {
 		stepStart := time.Time{}
+		var prevProfile llamaStepProfile
 		if stepProfile != nil {
 			stepStart = time.Now()
+			prevProfile = *stepProfile
 		}
-		stepPos := startPos + i
-		fastGreedy := fastGreedyArgmax && cfg.temp <= 0 && topk == nil && i >= len(forceTokens) && !debugStep0
+		pos = shifter.room(pos)
+		stepPos := pos
+		pos++
+		fastGreedy := block.opts.FastGreedyArgmax && cfg.temp <= 0 && topk == nil && i >= len(forceTokens) && !debugStep0
 		var next int
 		if fastGreedy {
 			next = runLlamaStackStepProfile(block, layerStates, currentToken, stepPos, i, x, n1, n2, logits, false, stepProfile)
@@ -1658,15 +1665,16 @@ func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32,
 			}
 			if stepProfile != nil {
 				t := time.Now()
-				next = sampleLogitsWithScratch(logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
+				next = sampleLogitsWithScratch(block.opts, logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
 				stepProfile.sample += time.Since(t)
 			} else {
-				next = sampleLogitsWithScratch(logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
+				next = sampleLogitsWithScratch(block.opts, logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
 			}
 		}
 		if i < len(forceTokens) {
 			next = int(forceTokens[i])
 		}
+		opts.stats.tokenSampled()
 		if next < 0 {
 			out[i] = 0
 			currentToken = 0
@@ -1676,9 +1684,12 @@ func runForwardLlamaStack(block *tensorBlock, seed int64, promptTokens []int32,
 		currentToken = out[i]
 		if stepProfile != nil {
 			stepProfile.addStep(time.Since(stepStart))
+			opts.stats.observeStep(stepProfile.since(prevProfile))
 		}
 	}
-	stepProfile.log(block)
+	if profileStep {
+		stepProfile.log(block)
+	}
 }
 
 type llamaLayerState struct {
@@ -1696,6 +1707,14 @@ type llamaLayerState struct {
 	scores      []float32
 	keys        []float32
 	values      []float32
+	qkv         []float32
+	kvType      KVCacheType
+	keysF16     []uint16
+	valuesF16   []uint16
+	keysQ8      []int8
+	valuesQ8    []int8
+	keyScales   []float32
+	valueScales []float32
 }
 
 type llamaRunScratch struct {
@@ -1784,7 +1803,14 @@ func resizeInt(buf []int, n int) []int {
 	return buf[:n]
 }
 
-func ensureLlamaLayerState(st *llamaLayerState, layer llamaLayer, hiddenDim, maxSeq, heads int) {
+func resizeU16(buf []uint16, n int) []uint16 {
+	if cap(buf) < n {
+		return make([]uint16, n)
+	}
+	return buf[:n]
+}
+
+func ensureLlamaLayerState(st *llamaLayerState, layer llamaLayer, hiddenDim, maxSeq, heads, kvHeads int, kvType KVCacheType) {
 	kdim := linearOutputLen(layer.attnK)
 	vdim := linearOutputLen(layer.attnV)
 	qdim := linearOutputLen(layer.attnQ)
@@ -1804,11 +1830,35 @@ func ensureLlamaLayerState(st *llamaLayerState, layer llamaLayer, hiddenDim, max
 	st.ffnInI8 = resizeI8(st.ffnInI8, hiddenDim)
 	st.ffnDownInI8 = resizeI8(st.ffnDownInI8, linearOutputLen(layer.ffnUp))
 	st.scores = resizeF32(st.scores, maxSeq*heads)
-	st.keys = resizeF32(st.keys, maxSeq*kdim)
-	st.values = resizeF32(st.values, maxSeq*vdim)
+	if kvHeads <= 0 {
+		kvHeads = heads
+	}
+	st.kvType = kvType
+	// Only the active cache format keeps its backing storage so quantized
+	// sessions do not also pin a full f32 cache from a pooled scratch.
+	switch kvType {
+	case KVCacheF16:
+		st.keys, st.values = nil, nil
+		st.keysQ8, st.valuesQ8, st.keyScales, st.valueScales = nil, nil, nil, nil
+		st.keysF16 = resizeU16(st.keysF16, maxSeq*kdim)
+		st.valuesF16 = resizeU16(st.valuesF16, maxSeq*vdim)
+	case KVCacheQ8:
+		st.keys, st.values = nil, nil
+		st.keysF16, st.valuesF16 = nil, nil
+		st.keysQ8 = resizeI8(st.keysQ8, maxSeq*kdim)
+		st.valuesQ8 = resizeI8(st.valuesQ8, maxSeq*vdim)
+		st.keyScales = resizeF32(st.keyScales, maxSeq*kvHeads)
+		st.valueScales = resizeF32(st.valueScales, maxSeq*kvHeads)
+	default:
+		st.kvType = KVCacheF32
+		st.keysF16, st.valuesF16 = nil, nil
+		st.keysQ8, st.valuesQ8, st.keyScales, st.valueScales = nil, nil, nil, nil
+		st.keys = resizeF32(st.keys, maxSeq*kdim)
+		st.values = resizeF32(st.values, maxSeq*vdim)
+	}
 }
 
-func getLlamaRunScratch(block *tensorBlock, maxSeq int) *llamaRunScratch {
+func getLlamaRunScratch(block *tensorBlock, maxSeq int, kvType KVCacheType) *llamaRunScratch {
 	s, _ := llamaRunScratchPool.Get().(*llamaRunScratch)
 	if s == nil {
 		s = &llamaRunScratch{}
@@ -1825,7 +1875,7 @@ func getLlamaRunScratch(block *tensorBlock, maxSeq int) *llamaRunScratch {
 		s.layerState = s.layerState[:len(block.layers)]
 	}
 	for i := range block.layers {
-		ensureLlamaLayerState(&s.layerState[i], block.layers[i], block.hiddenDim, maxSeq, block.attnHeads)
+		ensureLlamaLayerState(&s.layerState[i], block.layers[i], block.hiddenDim, maxSeq, block.attnHeads, block.kvHeads, kvType)
 	}
 	return s
 }
@@ -1839,15 +1889,25 @@ func putLlamaRunScratch(s *llamaRunScratch) {
 
 func makeLlamaLayerState(layer llamaLayer, hiddenDim, maxSeq, heads int) llamaLayerState {
 	var st llamaLayerState
-	ensureLlamaLayerState(&st, layer, hiddenDim, maxSeq, heads)
+	ensureLlamaLayerState(&st, layer, hiddenDim, maxSeq, heads, heads, KVCacheF32)
 	return st
 }
 
+// layerOptions returns the options for layer i of the llama stack, with the
+// per-layer strict flags resolved.
+func (b *tensorBlock) layerOptions(i int) *RuntimeOptions {
+	if i < len(b.layerOpts) {
+		return &b.layerOpts[i]
+	}
+	return b.opts
+}
+
 func runLlamaStackStep(block *tensorBlock, layerStates []llamaLayerState, token int32, pos int, x, n1, n2, logits []float32, computeLogits bool) int {
 	return runLlamaStackStepProfile(block, layerStates, token, pos, -1, x, n1, n2, logits, computeLogits, nil)
 }
 
 func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState, token int32, pos int, decodeStep int, x, n1, n2, logits []float32, computeLogits bool, prof *llamaStepProfile) int {
+	opts := block.opts
 	traceDrift := driftTraceStep >= 0 && decodeStep == driftTraceStep
 	if traceDrift {
 		fmt.Fprintf(os.Stderr, "drift_trace step=%d pos=%d token=%d layers=%d\n", decodeStep, pos, token, len(block.layers))
@@ -1863,6 +1923,8 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 	if prof != nil {
 		prof.embed += time.Since(embedStart)
 	}
+	trace := newTraceStep(opts, pos, token)
+	trace.emit(TraceEmbed, -1, x)
 
 	var stageNormBuf []float32
 	if debugStages && shouldDebug(pos) {
@@ -1925,7 +1987,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			i2sScale:   block.outputWeightScale,
 		}
 		if computeLogits {
-			linearApplyIntoWeight(logits, w, n1)
+			linearApplyIntoWeight(opts, logits, w, n1)
 		} else {
 			return linearArgmaxWeight(w, n1)
 		}
@@ -1938,6 +2000,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 	}
 
 	for i := range block.layers {
+		opts := block.layerOptions(i)
 		layer := block.layers[i]
 		st := &layerStates[i]
 		layerXBeforeAttn := float32(0)
@@ -1951,6 +2014,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 		}
 		if !disableAttn {
 			rmsNormInto(n1, x, layer.attnNorm, block.rmsEps)
+			trace.emit(TraceAttnNorm, i, n1)
 			if debugStages && shouldDebug(pos) && i == 0 {
 				debugStage("stage.attn_norm", n1, block, stageNormBuf, false)
 			}
@@ -1962,9 +2026,13 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				debugVecValues("inp_embd", x, debugValuesN)
 				debugVecValues("attn_norm", n1, debugValuesN)
 			}
-			strictKQCurrentLayer.Store(int32(i))
-			linearApplyQKV(st.q, st.k, st.v, layer.attnQ, layer.attnK, layer.attnV, n1, block.attnHeads, layer.debugAttnQF32, layer.debugAttnKF32, layer.debugAttnVF32)
-			strictKQCurrentLayer.Store(-1)
+			linearApplyQKV(opts, st.q, st.k, st.v, layer.attnQ, layer.attnK, layer.attnV, n1, block.attnHeads, layer.debugAttnQF32, layer.debugAttnKF32, layer.debugAttnVF32)
+			addBiasInPlace(st.q, layer.attnQBias)
+			addBiasInPlace(st.k, layer.attnKBias)
+			addBiasInPlace(st.v, layer.attnVBias)
+			trace.emit(TraceQ, i, st.q)
+			trace.emit(TraceK, i, st.k)
+			trace.emit(TraceV, i, st.v)
 			if debugAttnMeta && shouldDebug(pos) && i == 0 {
 				qHead := 0
 				kHead := 0
@@ -2013,9 +2081,9 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					transposed: layer.attnV.transposed,
 					qtype:      gguf.GGMLTypeF32,
 				}
-				linearApplyIntoWeight(refQ, wQRef, n1)
-				linearApplyIntoWeight(refK, wKRef, n1)
-				linearApplyIntoWeight(refV, wVRef, n1)
+				linearApplyIntoWeight(opts, refQ, wQRef, n1)
+				linearApplyIntoWeight(opts, refK, wKRef, n1)
+				linearApplyIntoWeight(opts, refV, wVRef, n1)
 				qMean, qMax := vecAbsDiffStats(st.q, refQ)
 				kMean, kMax := vecAbsDiffStats(st.k, refK)
 				vMean, vMax := vecAbsDiffStats(st.v, refV)
@@ -2035,9 +2103,9 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				qKernelRef := make([]float32, len(st.q))
 				kKernelRef := make([]float32, len(st.k))
 				vKernelRef := make([]float32, len(st.v))
-				linearApplyIntoWeightI2SRef(qKernelRef, layer.attnQ, n1)
-				linearApplyIntoWeightI2SRef(kKernelRef, layer.attnK, n1)
-				linearApplyIntoWeightI2SRef(vKernelRef, layer.attnV, n1)
+				linearApplyIntoWeightI2SRef(opts, qKernelRef, layer.attnQ, n1)
+				linearApplyIntoWeightI2SRef(opts, kKernelRef, layer.attnK, n1)
+				linearApplyIntoWeightI2SRef(opts, vKernelRef, layer.attnV, n1)
 				qCurRefMean, qCurRefMax := vecAbsDiffStats(st.q, qKernelRef)
 				kCurRefMean, kCurRefMax := vecAbsDiffStats(st.k, kKernelRef)
 				vCurRefMean, vCurRefMax := vecAbsDiffStats(st.v, vKernelRef)
@@ -2066,9 +2134,9 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 						transposed: layer.attnV.transposed,
 						qtype:      gguf.GGMLTypeF32,
 					}
-					linearApplyIntoWeight(qF32Ref, wQF32, n1)
-					linearApplyIntoWeight(kF32Ref, wKF32, n1)
-					linearApplyIntoWeight(vF32Ref, wVF32, n1)
+					linearApplyIntoWeight(opts, qF32Ref, wQF32, n1)
+					linearApplyIntoWeight(opts, kF32Ref, wKF32, n1)
+					linearApplyIntoWeight(opts, vF32Ref, wVF32, n1)
 					qCurF32Mean, qCurF32Max := vecAbsDiffStats(st.q, qF32Ref)
 					kCurF32Mean, kCurF32Max := vecAbsDiffStats(st.k, kF32Ref)
 					vCurF32Mean, vCurF32Max := vecAbsDiffStats(st.v, vF32Ref)
@@ -2133,7 +2201,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				wVFlip.transposed = !wVFlip.transposed
 				if linearOutputLen(wVFlip) == len(st.v) {
 					vAlt := make([]float32, len(st.v))
-					linearApplyIntoWeight(vAlt, wVFlip, n1)
+					linearApplyIntoWeight(opts, vAlt, wVFlip, n1)
 					meanAbs, maxAbs := vecAbsDiffStats(st.v, vAlt)
 					fmt.Fprintf(
 						os.Stderr,
@@ -2163,7 +2231,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					wVF32Flip.transposed = !wVF32Flip.transposed
 					if linearOutputLen(wVF32Flip) == len(st.v) {
 						vAlt := make([]float32, len(st.v))
-						linearApplyIntoWeight(vAlt, wVF32Flip, n1)
+						linearApplyIntoWeight(opts, vAlt, wVF32Flip, n1)
 						meanAbs, maxAbs := vecAbsDiffStats(st.v, vAlt)
 						fmt.Fprintf(
 							os.Stderr,
@@ -2185,7 +2253,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			}
 			if traceDrift && driftVMatvecAB && (driftTraceLayer < 0 || driftTraceLayer == i) {
 				vKernelRef := make([]float32, len(st.v))
-				linearApplyIntoWeightI2SRef(vKernelRef, layer.attnV, n1)
+				linearApplyIntoWeightI2SRef(opts, vKernelRef, layer.attnV, n1)
 				curRefMean, curRefMax := vecAbsDiffStats(st.v, vKernelRef)
 				if len(layer.debugAttnVF32) > 0 {
 					vF32Ref := make([]float32, len(st.v))
@@ -2196,7 +2264,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 						transposed: layer.attnV.transposed,
 						qtype:      gguf.GGMLTypeF32,
 					}
-					linearApplyIntoWeight(vF32Ref, wVF32, n1)
+					linearApplyIntoWeight(opts, vF32Ref, wVF32, n1)
 					curF32Mean, curF32Max := vecAbsDiffStats(st.v, vF32Ref)
 					refF32Mean, refF32Max := vecAbsDiffStats(vKernelRef, vF32Ref)
 					fmt.Fprintf(
@@ -2253,6 +2321,8 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			}
 			applyRoPEInPlace(st.q, pos, block.attnHeads, block.ropeFreqBase, block.ropeScale, block.ropeScalingType, block.ropeDim, block.ropeNeox, block.ropeYarnBetaFast, block.ropeYarnBetaSlow, block.ropeYarnExtFactor, block.ropeYarnAttnFactor)
 			applyRoPEInPlace(st.k, pos, block.kvHeads, block.ropeFreqBase, block.ropeScale, block.ropeScalingType, block.ropeDim, block.ropeNeox, block.ropeYarnBetaFast, block.ropeYarnBetaSlow, block.ropeYarnExtFactor, block.ropeYarnAttnFactor)
+			trace.emit(TraceQRoPE, i, st.q)
+			trace.emit(TraceKRoPE, i, st.k)
 			if len(qPreRoPE) > 0 && len(kPreRoPE) > 0 {
 				qRef := append([]float32(nil), qPreRoPE...)
 				kRef := append([]float32(nil), kPreRoPE...)
@@ -2278,29 +2348,36 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				debugVecStats("q-0", st.q)
 			}
 
-			storeCacheVector(st.keys, pos, st.k)
 			attnPath := ""
-			if debugParityStrict || debugStrictAttnRef {
+			if st.kvType == KVCacheF16 {
+				attnPath = "f16"
+				storeCacheVectorF16(st.keysF16, pos, st.k)
+				storeCacheVectorF16(st.valuesF16, pos, st.v)
+				causalAttentionMultiHeadIntoF16(opts, st.attnAcc, st.scores, st.q, st.keysF16, st.valuesF16, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v))
+			} else if st.kvType == KVCacheQ8 {
+				attnPath = "q8"
+				storeCacheVectorQ8(st.keysQ8, st.keyScales, pos, st.k, block.kvHeads)
+				storeCacheVectorQ8(st.valuesQ8, st.valueScales, pos, st.v, block.kvHeads)
+				causalAttentionMultiHeadIntoQ8(opts, st.attnAcc, st.scores, st.q, st.keysQ8, st.valuesQ8, st.keyScales, st.valueScales, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v))
+			} else if opts.ParityStrict || opts.StrictAttentionRef {
+				storeCacheVector(st.keys, pos, st.k)
 				attnPath = "ref"
 				// Match ggml accumulation order in parity-strict mode.
 				storeCacheVectorV(st.values, pos, st.v, block.kvHeads)
-				strictKQCurrentLayer.Store(int32(i))
-				causalAttentionMultiHeadIntoReference(st.attnAcc, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v))
-				strictKQCurrentLayer.Store(-1)
-			} else if debugKVRowMajor {
+				causalAttentionMultiHeadIntoReference(opts, st.attnAcc, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v))
+			} else if opts.KVRowMajor {
+				storeCacheVector(st.keys, pos, st.k)
 				attnPath = "rowmajor"
 				storeCacheVectorVRowMajor(st.values, pos, st.v, block.kvHeads)
-				strictKQCurrentLayer.Store(int32(i))
-				causalAttentionMultiHeadIntoRowMajor(st.attnAcc, st.scores, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v), pos)
-				strictKQCurrentLayer.Store(-1)
+				causalAttentionMultiHeadIntoRowMajor(opts, st.attnAcc, st.scores, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v), pos)
 			} else {
+				storeCacheVector(st.keys, pos, st.k)
 				attnPath = "opt"
 				storeCacheVectorV(st.values, pos, st.v, block.kvHeads)
-				strictKQCurrentLayer.Store(int32(i))
-				causalAttentionMultiHeadInto(st.attnAcc, st.scores, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v), pos)
-				strictKQCurrentLayer.Store(-1)
+				causalAttentionMultiHeadInto(opts, st.attnAcc, st.scores, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v), pos)
 			}
-			if traceDrift && (driftTraceLayer < 0 || driftTraceLayer == i) {
+			kvF32 := st.kvType == KVCacheF32
+			if traceDrift && kvF32 && (driftTraceLayer < 0 || driftTraceLayer == i) {
 				rowMajor := attnPath == "rowmajor"
 				cacheV := loadCacheVectorV(st.values, pos, len(st.v), block.kvHeads, rowMajor)
 				vMean, vMax := vecAbsDiffStats(st.v, cacheV)
@@ -2318,11 +2395,11 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				}
 			}
 			if traceDrift && driftAttnAccRef && (driftTraceLayer < 0 || driftTraceLayer == i) {
-				if attnPath == "rowmajor" {
+				if attnPath != "opt" && attnPath != "ref" {
 					fmt.Fprintf(os.Stderr, "drift_trace attn_acc_ref layer=%d path=%s skipped=1\n", i, attnPath)
 				} else {
 					refAttnAcc := make([]float32, len(st.attnAcc))
-					causalAttentionMultiHeadIntoReference(refAttnAcc, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v))
+					causalAttentionMultiHeadIntoReference(opts, refAttnAcc, st.q, st.keys, st.values, pos+1, block.attnHeads, block.kvHeads, len(st.k), len(st.v))
 					meanAbs, maxAbs := vecAbsDiffStats(st.attnAcc, refAttnAcc)
 					fmt.Fprintf(
 						os.Stderr,
@@ -2336,7 +2413,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					)
 				}
 			}
-			if traceDrift && driftTraceValuesN > 0 && (driftTraceLayer < 0 || driftTraceLayer == i) {
+			if traceDrift && kvF32 && driftTraceValuesN > 0 && (driftTraceLayer < 0 || driftTraceLayer == i) {
 				headsToTrace := driftTraceSoftmaxHeads
 				if headsToTrace <= 0 {
 					headsToTrace = 1
@@ -2345,7 +2422,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					headsToTrace = block.attnHeads
 				}
 				for h := 0; h < headsToTrace; h++ {
-					if w := attnHeadWeightsFromQK(st.q, st.keys, pos+1, block.attnHeads, block.kvHeads, len(st.k), h); len(w) > 0 {
+					if w := attnHeadWeightsFromQK(opts, st.q, st.keys, pos+1, block.attnHeads, block.kvHeads, len(st.k), h); len(w) > 0 {
 						fmt.Fprintf(os.Stderr, "drift_trace values layer=%d name=attn_softmax_h%d values=%s\n", i, h, vecValuesCSV(w, driftTraceValuesN))
 					}
 				}
@@ -2375,7 +2452,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 						start := h * headDim
 						end := start + headDim
 						copy(headIn[start:end], n2[start:end])
-						linearApplyIntoWeight(headOut, layer.attnOut, headIn)
+						linearApplyIntoWeight(opts, headOut, layer.attnOut, headIn)
 						fmt.Fprintf(
 							os.Stderr,
 							"drift_trace attn_out_head layer=%d head=%d subnorm_l2=%g proj_l2=%g\n",
@@ -2414,7 +2491,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					}
 				}
 			}
-			linearApplyIntoWeight(st.attnOut, layer.attnOut, n2)
+			linearApplyIntoWeight(opts, st.attnOut, layer.attnOut, n2)
 			if traceDrift && driftAttnOutRefF32 && (driftTraceLayer < 0 || driftTraceLayer == i) && len(layer.debugAttnOutF32) > 0 {
 				refAttnOut := make([]float32, len(st.attnOut))
 				wRef := linearWeight{
@@ -2424,7 +2501,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					transposed: layer.attnOut.transposed,
 					qtype:      gguf.GGMLTypeF32,
 				}
-				linearApplyIntoWeight(refAttnOut, wRef, n2)
+				linearApplyIntoWeight(opts, refAttnOut, wRef, n2)
 				meanAbs, maxAbs := vecAbsDiffStats(st.attnOut, refAttnOut)
 				fmt.Fprintf(
 					os.Stderr,
@@ -2436,6 +2513,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					maxAbs,
 				)
 			}
+			trace.emit(TraceAttnOut, i, st.attnOut)
 			kernels.AddScaled(x, st.attnOut, 1.0)
 			if debugStages && shouldDebug(pos) && i == 0 {
 				debugStage("stage.post_attn", x, block, stageNormBuf, false)
@@ -2481,7 +2559,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			layerXBeforeFFN = vecL2Norm(x)
 		}
 		if !disableFFN {
-			if debugStrictFFNRef {
+			if opts.StrictFFNRef {
 				ffnNormStart := time.Time{}
 				if prof != nil {
 					ffnNormStart = time.Now()
@@ -2490,15 +2568,18 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				if prof != nil {
 					prof.ffnNorm += time.Since(ffnNormStart)
 				}
+				trace.emit(TraceFFNNorm, i, n2)
 				gateUpStart := time.Time{}
 				if prof != nil {
 					gateUpStart = time.Now()
 				}
-				linearApplyIntoWeight(st.gate, layer.ffnGate, n2)
-				linearApplyIntoWeight(st.up, layer.ffnUp, n2)
+				linearApplyIntoWeight(opts, st.gate, layer.ffnGate, n2)
+				linearApplyIntoWeight(opts, st.up, layer.ffnUp, n2)
 				if prof != nil {
 					prof.ffnGateUp += time.Since(gateUpStart)
 				}
+				trace.emit(TraceFFNGate, i, st.gate)
+				trace.emit(TraceFFNUp, i, st.up)
 				driftGateL2 := float32(0)
 				driftUpL2 := float32(0)
 				if traceDrift {
@@ -2529,10 +2610,11 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				if prof != nil {
 					downStart = time.Now()
 				}
-				linearApplyIntoWeight(st.ffnDown, layer.ffnDown, st.up)
+				linearApplyIntoWeight(opts, st.ffnDown, layer.ffnDown, st.up)
 				if prof != nil {
 					prof.ffnDown += time.Since(downStart)
 				}
+				trace.emit(TraceFFNDown, i, st.ffnDown)
 				kernels.AddScaled(x, st.ffnDown, 1.0)
 				if debugStages && shouldDebug(pos) && i == 0 {
 					debugStage("stage.ffn_sub_norm", st.up, block, stageNormBuf, false)
@@ -2580,6 +2662,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			if prof != nil {
 				prof.ffnNorm += time.Since(ffnNormStart)
 			}
+			trace.emit(TraceFFNNorm, i, n2)
 			if debugStages && shouldDebug(pos) && i == 0 {
 				debugStage("stage.ffn_norm", n2, block, stageNormBuf, false)
 			}
@@ -2608,43 +2691,34 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 						transposed: layer.ffnUp.transposed,
 						qtype:      gguf.GGMLTypeF32,
 					}
-					linearApplyIntoWeight(st.gate, wGate, n2)
-					linearApplyIntoWeight(st.up, wUp, n2)
+					linearApplyIntoWeight(opts, st.gate, wGate, n2)
+					linearApplyIntoWeight(opts, st.up, wUp, n2)
 				}
 			} else if debugFFNTranspose {
-				linearApplyIntoWeightTransposed(st.gate, layer.ffnGate, n2, !layer.ffnGate.transposed)
-				linearApplyIntoWeightTransposed(st.up, layer.ffnUp, n2, !layer.ffnUp.transposed)
+				linearApplyIntoWeightTransposed(opts, st.gate, layer.ffnGate, n2, !layer.ffnGate.transposed)
+				linearApplyIntoWeightTransposed(opts, st.up, layer.ffnUp, n2, !layer.ffnUp.transposed)
 			} else {
-				if ffnShareI2SQuant &&
+				if opts.FFNShareI2SQuant &&
 					layer.ffnGate.qtype == gguf.GGMLTypeI2_S && len(layer.ffnGate.i2sPacked) > 0 &&
 					layer.ffnUp.qtype == gguf.GGMLTypeI2_S && len(layer.ffnUp.i2sPacked) > 0 &&
-					!debugI2SFloat {
+					!opts.I2SFloat {
 					scratch := st.ffnInI8
 					if len(scratch) != len(n2) {
 						scratch = resizeI8(scratch, len(n2))
 						st.ffnInI8 = scratch
 					}
 					actScale, actSum := kernels.QuantizeRowI8S(scratch, n2)
-					if debugI2SDisableActSum {
-						actSum = 0
-					}
-					if debugI2SInvertActScale && actScale != 0 {
-						actScale = 1 / actScale
-					}
-					linearApplyIntoWeightI2SQuantized(st.gate, layer.ffnGate, scratch, actScale, actSum)
-					linearApplyIntoWeightI2SQuantized(st.up, layer.ffnUp, scratch, actScale, actSum)
-				} else if ffnParGateUp {
-					var wg sync.WaitGroup
-					wg.Add(1)
-					go func() {
-						defer wg.Done()
-						linearApplyIntoWeight(st.gate, layer.ffnGate, n2)
-					}()
-					linearApplyIntoWeight(st.up, layer.ffnUp, n2)
-					wg.Wait()
+					actScale, actSum = opts.i2sActAdjust(actScale, actSum)
+					linearApplyIntoWeightI2SQuantized(opts, st.gate, layer.ffnGate, scratch, actScale, actSum)
+					linearApplyIntoWeightI2SQuantized(opts, st.up, layer.ffnUp, scratch, actScale, actSum)
+				} else if opts.FFNParGateUp {
+					kernels.DefaultScheduler().Do(
+						func() { linearApplyIntoWeight(opts, st.gate, layer.ffnGate, n2) },
+						func() { linearApplyIntoWeight(opts, st.up, layer.ffnUp, n2) },
+					)
 				} else {
-					linearApplyIntoWeight(st.gate, layer.ffnGate, n2)
-					linearApplyIntoWeight(st.up, layer.ffnUp, n2)
+					linearApplyIntoWeight(opts, st.gate, layer.ffnGate, n2)
+					linearApplyIntoWeight(opts, st.up, layer.ffnUp, n2)
 				}
 			}
 			if prof != nil {
@@ -2674,8 +2748,8 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 						transposed: layer.ffnUp.transposed,
 						qtype:      gguf.GGMLTypeF32,
 					}
-					linearApplyIntoWeight(gateRef, wGate, n2)
-					linearApplyIntoWeight(upRef, wUp, n2)
+					linearApplyIntoWeight(opts, gateRef, wGate, n2)
+					linearApplyIntoWeight(opts, upRef, wUp, n2)
 					debugVecDiff("ffn_gate.ref.diff", st.gate, gateRef)
 					debugVecDiff("ffn_up.ref.diff", st.up, upRef)
 					haveFfnRef = true
@@ -2696,11 +2770,13 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				debugVecStats("ffn_gate", st.gate)
 				debugVecStats("ffn_up", st.up)
 			}
+			trace.emit(TraceFFNGate, i, st.gate)
+			trace.emit(TraceFFNUp, i, st.up)
 			actStart := time.Time{}
 			if prof != nil {
 				actStart = time.Now()
 			}
-			ffnActivateInto(st.ffnAct, st.gate, st.up, block.ffnUseSilu)
+			ffnActivateInto(opts, st.ffnAct, st.gate, st.up, block.ffnUseSilu)
 			if prof != nil {
 				prof.ffnAct += time.Since(actStart)
 			}
@@ -2711,7 +2787,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			}
 			if haveFfnRef {
 				actRef = make([]float32, len(st.ffnAct))
-				ffnActivateInto(actRef, gateRef, upRef, block.ffnUseSilu)
+				ffnActivateInto(opts, actRef, gateRef, upRef, block.ffnUseSilu)
 				debugVecDiff("ffn_act.ref.diff", st.ffnAct, actRef)
 			}
 			if debugValues && shouldDebug(pos) && i == 0 {
@@ -2746,25 +2822,20 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 				downStart = time.Now()
 			}
 			if debugFFNTranspose {
-				linearApplyIntoWeightTransposed(st.ffnDown, layer.ffnDown, st.up, !layer.ffnDown.transposed)
-			} else if ffnShareI2SDown &&
+				linearApplyIntoWeightTransposed(opts, st.ffnDown, layer.ffnDown, st.up, !layer.ffnDown.transposed)
+			} else if opts.FFNShareI2SDown &&
 				layer.ffnDown.qtype == gguf.GGMLTypeI2_S && len(layer.ffnDown.i2sPacked) > 0 &&
-				!debugI2SFloat {
+				!opts.I2SFloat {
 				scratch := st.ffnDownInI8
 				if len(scratch) != len(st.up) {
 					scratch = resizeI8(scratch, len(st.up))
 					st.ffnDownInI8 = scratch
 				}
 				actScale, actSum := kernels.QuantizeRowI8S(scratch, st.up)
-				if debugI2SDisableActSum {
-					actSum = 0
-				}
-				if debugI2SInvertActScale && actScale != 0 {
-					actScale = 1 / actScale
-				}
-				linearApplyIntoWeightI2SQuantized(st.ffnDown, layer.ffnDown, scratch, actScale, actSum)
+				actScale, actSum = opts.i2sActAdjust(actScale, actSum)
+				linearApplyIntoWeightI2SQuantized(opts, st.ffnDown, layer.ffnDown, scratch, actScale, actSum)
 			} else {
-				linearApplyIntoWeight(st.ffnDown, layer.ffnDown, st.up)
+				linearApplyIntoWeight(opts, st.ffnDown, layer.ffnDown, st.up)
 			}
 			if prof != nil {
 				prof.ffnDown += time.Since(downStart)
@@ -2778,7 +2849,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 					transposed: layer.ffnDown.transposed,
 					qtype:      gguf.GGMLTypeF32,
 				}
-				linearApplyIntoWeight(downRef, wDown, st.up)
+				linearApplyIntoWeight(opts, downRef, wDown, st.up)
 				debugVecDiff("ffn_down.ref.diff", st.ffnDown, downRef)
 			}
 			if debugFFNLoad && shouldDebug(pos) && i == 0 {
@@ -2787,6 +2858,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 			if debugValues && shouldDebug(pos) && i == 0 {
 				debugVecValues("ffn_down", st.ffnDown, debugValuesN)
 			}
+			trace.emit(TraceFFNDown, i, st.ffnDown)
 			kernels.AddScaled(x, st.ffnDown, 1.0)
 			if debugStages && shouldDebug(pos) && i == 0 {
 				debugStage("stage.post_ffn", x, block, stageNormBuf, false)
@@ -2828,6 +2900,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 		outputStart = time.Now()
 	}
 	rmsNormInto(n1, x, block.outputNorm, block.rmsEps)
+	trace.emit(TraceOutputNorm, -1, n1)
 	if debugStages && shouldDebug(pos) {
 		debugStage("stage.output_norm", n1, block, stageNormBuf, true)
 	}
@@ -2845,7 +2918,8 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 		i2sScale:   block.outputWeightScale,
 	}
 	if computeLogits {
-		linearApplyIntoWeight(logits, w, n1)
+		linearApplyIntoWeight(opts, logits, w, n1)
+		trace.emit(TraceLogits, -1, logits)
 		if traceDrift {
 			bestTok, bestLogit := argmaxLogit(logits)
 			fmt.Fprintf(os.Stderr, "drift_trace output_norm_l2=%g\n", vecL2Norm(n1))
@@ -2891,7 +2965,7 @@ func runLlamaStackStepProfile(block *tensorBlock, layerStates []llamaLayerState,
 	return linearArgmaxWeight(w, n1)
 }
 
-func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
+func causalAttentionMultiHeadIntoGeneric(opts *RuntimeOptions, dst, scores, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int, pos int) {
 	for i := range dst {
 		dst[i] = 0
 	}
@@ -2940,11 +3014,11 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 		for i := 0; i < steps; i++ {
 			kb := i*kStepDim + kBase
 			var sum float32
-			if strictKQEnabledForCurrentLayer() {
-				sum = dotKQStrictForCurrentLayer(qh, keys[kb:kb+headDim])
-			} else if debugFastKQ {
+			if opts.StrictKQ {
+				sum = opts.dotKQStrict(qh, keys[kb:kb+headDim])
+			} else if opts.FastKQDot {
 				sum = dotF32FastN(keys, kb, qh, 0, headDim)
-			} else if debugAttnF64 {
+			} else if opts.AttnF64 {
 				var sum64 float64
 				for j := 0; j < headDim; j++ {
 					sum64 += float64(qh[j]) * float64(keys[kb+j])
@@ -2962,12 +3036,12 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 			}
 		}
 
-		sum := softmaxInPlace(scores[h*steps:h*steps+steps], maxScore)
+		sum := softmaxInPlace(opts, scores[h*steps:h*steps+steps], maxScore)
 		if sum == 0 {
 			continue
 		}
 		inv := 1 / sum
-		if debugStrictAttention {
+		if opts.StrictAttention {
 			for i := 0; i < steps; i++ {
 				idx := h*steps + i
 				scores[idx] *= inv
@@ -2985,7 +3059,7 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 						fmt.Fprint(os.Stderr, ",")
 					}
 					val := scores[i]
-					if !debugStrictAttention {
+					if !opts.StrictAttention {
 						val *= inv
 					}
 					fmt.Fprintf(os.Stderr, "%.9g", val)
@@ -2994,7 +3068,7 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 				debugSoftmaxPrinted = true
 			}
 		}
-		if debugStrictAttention {
+		if opts.StrictAttention {
 			vHeadBase := kvHead * headDim * maxSeq
 			weights := scores[h*steps : h*steps+steps]
 			for j := 0; j < headDim; j++ {
@@ -3004,7 +3078,7 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 			continue
 		}
 		vHeadBase := kvHead * headDim * maxSeq
-		if debugFastV && !debugAttnF64 {
+		if opts.FastVDot && !opts.AttnF64 {
 			weights := scores[h*steps : h*steps+steps]
 			for i := 0; i < steps; i++ {
 				weights[i] *= inv
@@ -3015,7 +3089,7 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 			}
 			continue
 		}
-		if debugAttnF64 {
+		if opts.AttnF64 {
 			for j := 0; j < headDim; j++ {
 				var sum64 float64
 				rowBase := vHeadBase + j*maxSeq
@@ -3037,7 +3111,7 @@ func causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values []float32,
 	}
 }
 
-func causalAttentionMultiHeadIntoReference(dst, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int) {
+func causalAttentionMultiHeadIntoReference(opts *RuntimeOptions, dst, q, keys, values []float32, steps, qHeads, kvHeads, kStepDim, vStepDim int) {
 	for i := range dst {
 		dst[i] = 0
 	}
@@ -3095,7 +3169,7 @@ func causalAttentionMultiHeadIntoReference(dst, q, keys, values []float32, steps
 		for i := 0; i < steps; i++ {
 			diff := scores[i] - maxScore
 			var w float32
-			if strictExpfEnabledForCurrentLayer() || debugFastExpf {
+			if opts.StrictExpf || opts.FastExpf {
 				w = expf32(diff)
 			} else {
 				w = float32(math.Exp(float64(diff)))
@@ -3233,8 +3307,8 @@ func mulSiluF64(dst, gate, up []float32) {
 	}
 }
 
-func ffnActivateInto(dst, gate, up []float32, useSilu bool) {
-	if debugStrictFFNActF64 {
+func ffnActivateInto(opts *RuntimeOptions, dst, gate, up []float32, useSilu bool) {
+	if opts.StrictFFNActF64 {
 		if useSilu {
 			mulSiluF64(dst, gate, up)
 			return
@@ -3558,6 +3632,16 @@ func rmsNormInto(dst, x, weight []float32, eps float32) {
 	kernels.RMSNormInto(dst, x, weight, eps)
 }
 
+// addBiasInPlace adds bias to dst; a nil bias is a no-op.
+func addBiasInPlace(dst, bias []float32) {
+	for i := range bias {
+		if i >= len(dst) {
+			break
+		}
+		dst[i] += bias[i]
+	}
+}
+
 func applySubNormOrIdentity(dst, x, weight []float32, eps float32) {
 	if len(weight) == len(x) && len(x) > 0 {
 		rmsNormInto(dst, x, weight, eps)
@@ -3599,7 +3683,7 @@ func vecAbsDiffStats(a, b []float32) (meanAbs, maxAbs float32) {
 	return sum / float32(n), maxAbs
 }
 
-func attnHeadWeightsFromQK(q, keys []float32, steps, qHeads, kvHeads, kStepDim, head int) []float32 {
+func attnHeadWeightsFromQK(opts *RuntimeOptions, q, keys []float32, steps, qHeads, kvHeads, kStepDim, head int) []float32 {
 	if steps <= 0 || len(q) == 0 || len(keys) == 0 {
 		return nil
 	}
@@ -3637,7 +3721,7 @@ func attnHeadWeightsFromQK(q, keys []float32, steps, qHeads, kvHeads, kStepDim,
 			maxScore = s
 		}
 	}
-	sum := softmaxInPlace(out, maxScore)
+	sum := softmaxInPlace(opts, out, maxScore)
 	if sum == 0 {
 		return out
 	}
@@ -4136,9 +4220,9 @@ func linearOutputLen(w linearWeight) int {
 	return w.rows
 }
 
-func linearApplyIntoWeight(dst []float32, w linearWeight, x []float32) {
+func linearApplyIntoWeight(opts *RuntimeOptions, dst []float32, w linearWeight, x []float32) {
 	if w.qtype == gguf.GGMLTypeI2_S && len(w.i2sPacked) > 0 {
-		if debugI2SFloat && !debugI2SForceQuant {
+		if opts.I2SFloat && !opts.I2SForceQuant {
 			if w.transposed {
 				kernels.MatVecTI2S(dst, w.i2sPacked, w.rows, w.cols, x, w.i2sScale)
 			} else {
@@ -4153,13 +4237,24 @@ func linearApplyIntoWeight(dst []float32, w linearWeight, x []float32) {
 			scratch = scratch[:len(x)]
 		}
 		actScale, actSum := kernels.QuantizeRowI8S(scratch, x)
-		if debugI2SDisableActSum {
-			actSum = 0
+		actScale, actSum = opts.i2sActAdjust(actScale, actSum)
+		linearApplyIntoWeightI2SQuantized(opts, dst, w, scratch, actScale, actSum)
+		i8ScratchPool.Put(scratch[:0])
+		return
+	}
+	if len(w.tqPacked) > 0 && w.transposed {
+		scratch := i8ScratchPool.Get().([]int8)
+		if cap(scratch) < len(x) {
+			scratch = make([]int8, len(x))
+		} else {
+			scratch = scratch[:len(x)]
 		}
-		if debugI2SInvertActScale && actScale != 0 {
-			actScale = 1 / actScale
+		actScale, _ := kernels.QuantizeRowI8S(scratch, x)
+		if w.qtype == gguf.GGMLTypeTQ1_0 {
+			kernels.MatVecTTQ1I8S(dst, w.tqPacked, w.rows, w.cols, scratch, actScale)
+		} else {
+			kernels.MatVecTTQ2I8S(dst, w.tqPacked, w.rows, w.cols, scratch, actScale)
 		}
-		linearApplyIntoWeightI2SQuantized(dst, w, scratch, actScale, actSum)
 		i8ScratchPool.Put(scratch[:0])
 		return
 	}
@@ -4187,8 +4282,8 @@ func linearApplyIntoWeight(dst []float32, w linearWeight, x []float32) {
 	kernels.MatVec(dst, w.data, w.rows, w.cols, x)
 }
 
-func linearApplyIntoWeightI2SQuantized(dst []float32, w linearWeight, scratch []int8, actScale float32, actSum int32) {
-	if i2sQuantFastPath {
+func linearApplyIntoWeightI2SQuantized(opts *RuntimeOptions, dst []float32, w linearWeight, scratch []int8, actScale float32, actSum int32) {
+	if opts.i2sQuantFastPath() {
 		if w.transposed {
 			kernels.MatVecTI2SI8S(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
 		} else {
@@ -4196,7 +4291,7 @@ func linearApplyIntoWeightI2SQuantized(dst []float32, w linearWeight, scratch []
 		}
 		return
 	}
-	if debugI2SRefOnce && !debugI2SRefOncePrinted {
+	if opts.I2SRefOnce && !debugI2SRefOncePrinted {
 		ref := make([]float32, len(dst))
 		if w.transposed {
 			kernels.MatVecTI2SI8SRef(ref, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale)
@@ -4218,7 +4313,7 @@ func linearApplyIntoWeightI2SQuantized(dst []float32, w linearWeight, scratch []
 		fmt.Fprintf(os.Stderr, "debug i2s_ref_once max_abs=%g max_rel=%g act_sum=%d act_scale=%g\n", maxAbs, maxRel, actSum, actScale)
 		debugI2SRefOncePrinted = true
 	}
-	if debugI2SRefDot {
+	if opts.I2SRefDot {
 		if w.transposed {
 			kernels.MatVecTI2SI8SRef(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale)
 		} else {
@@ -4226,28 +4321,28 @@ func linearApplyIntoWeightI2SQuantized(dst []float32, w linearWeight, scratch []
 		}
 	} else {
 		if w.transposed {
-			if debugI2SMap3To1 {
+			if opts.I2SMap3To1 {
 				kernels.MatVecTI2SI8SMap(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
-			} else if debugI2SAltLayout {
+			} else if opts.I2SAltLayout {
 				kernels.MatVecTI2SI8SAlt(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
-			} else if debugI2SScalar {
+			} else if opts.I2SScalar {
 				kernels.MatVecTI2SI8SScalar(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
 			} else {
 				kernels.MatVecTI2SI8S(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
 			}
 		} else {
-			if debugI2SMap3To1 {
+			if opts.I2SMap3To1 {
 				kernels.MatVecI2SI8SMap(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
-			} else if debugI2SAltLayout {
+			} else if opts.I2SAltLayout {
 				kernels.MatVecI2SI8SAlt(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
-			} else if debugI2SScalar {
+			} else if opts.I2SScalar {
 				kernels.MatVecI2SI8SScalar(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
 			} else {
 				kernels.MatVecI2SI8S(dst, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale, actSum)
 			}
 		}
 	}
-	if debugI2SMatvecRef && !debugI2SMatvecPrinted {
+	if opts.I2SMatvecRef && !debugI2SMatvecPrinted {
 		ref := make([]float32, len(dst))
 		if w.transposed {
 			kernels.MatVecTI2SI8SRef(ref, w.i2sPacked, w.rows, w.cols, scratch, w.i2sScale, actScale)
@@ -4259,9 +4354,9 @@ func linearApplyIntoWeightI2SQuantized(dst []float32, w linearWeight, scratch []
 	}
 }
 
-func linearApplyIntoWeightI2SRef(dst []float32, w linearWeight, x []float32) {
+func linearApplyIntoWeightI2SRef(opts *RuntimeOptions, dst []float32, w linearWeight, x []float32) {
 	if w.qtype != gguf.GGMLTypeI2_S || len(w.i2sPacked) == 0 {
-		linearApplyIntoWeight(dst, w, x)
+		linearApplyIntoWeight(opts, dst, w, x)
 		return
 	}
 	scratch := i8ScratchPool.Get().([]int8)
@@ -4272,9 +4367,7 @@ func linearApplyIntoWeightI2SRef(dst []float32, w linearWeight, x []float32) {
 		scratch = scratch[:len(x)]
 	}
 	actScale, _ := kernels.QuantizeRowI8S(scratch, x)
-	if debugI2SInvertActScale && actScale != 0 {
-		actScale = 1 / actScale
-	}
+	actScale, _ = opts.i2sActAdjust(actScale, 0)
 	if actScale == 0 {
 		for i := range dst {
 			dst[i] = 0
@@ -4290,12 +4383,12 @@ func linearApplyIntoWeightI2SRef(dst []float32, w linearWeight, x []float32) {
 	i8ScratchPool.Put(scratch[:0])
 }
 
-func linearApplyIntoWeightTransposed(dst []float32, w linearWeight, x []float32, transposed bool) {
+func linearApplyIntoWeightTransposed(opts *RuntimeOptions, dst []float32, w linearWeight, x []float32, transposed bool) {
 	w.transposed = transposed
-	linearApplyIntoWeight(dst, w, x)
+	linearApplyIntoWeight(opts, dst, w, x)
 }
 
-func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []float32, attnHeads int, qF32, kF32, vF32 []float32) {
+func linearApplyQKV(opts *RuntimeOptions, dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []float32, attnHeads int, qF32, kF32, vF32 []float32) {
 	if wQ.qtype == gguf.GGMLTypeF32 && wK.qtype == gguf.GGMLTypeF32 && wV.qtype == gguf.GGMLTypeF32 &&
 		!wQ.transposed && !wK.transposed && !wV.transposed &&
 		wQ.rows == wK.rows && wQ.rows == wV.rows &&
@@ -4303,10 +4396,10 @@ func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []flo
 		len(dstQ) >= wQ.rows && len(dstK) >= wQ.rows && len(dstV) >= wQ.rows &&
 		len(x) >= wQ.cols &&
 		len(wQ.data) >= wQ.rows*wQ.cols && len(wK.data) >= wQ.rows*wQ.cols && len(wV.data) >= wQ.rows*wQ.cols &&
-		!debugParityStrict {
+		!opts.ParityStrict {
 		size := wQ.rows * wQ.cols
-		if debugQKVFusedMax > 0 && size <= debugQKVFusedMax {
-			if debugFastQKVCol {
+		if opts.QKVFusedMax > 0 && size <= opts.QKVFusedMax {
+			if opts.FastQKVCol {
 				matVec3F32Col(dstQ, dstK, dstV, wQ.data, wK.data, wV.data, wQ.rows, wQ.cols, x)
 			} else {
 				matVec3F32(dstQ, dstK, dstV, wQ.data, wK.data, wV.data, wQ.rows, wQ.cols, x)
@@ -4321,11 +4414,11 @@ func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []flo
 		len(wQ.i2sPacked) > 0 &&
 		len(wK.i2sPacked) > 0 &&
 		len(wV.i2sPacked) > 0 &&
-		!(debugI2SFloat && !debugI2SForceQuant) &&
-		!strictQF32EnabledForCurrentLayer() &&
-		!strictKF32EnabledForCurrentLayer() &&
-		!strictVF32EnabledForCurrentLayer() &&
-		!strictVRefEnabledForCurrentLayer() {
+		!(opts.I2SFloat && !opts.I2SForceQuant) &&
+		!opts.StrictQF32 &&
+		!opts.StrictKF32 &&
+		!opts.StrictVF32 &&
+		!opts.StrictVRef {
 		scratch := i8ScratchPool.Get().([]int8)
 		if cap(scratch) < len(x) {
 			scratch = make([]int8, len(x))
@@ -4333,19 +4426,14 @@ func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []flo
 			scratch = scratch[:len(x)]
 		}
 		actScale, actSum := kernels.QuantizeRowI8S(scratch, x)
-		if debugI2SDisableActSum {
-			actSum = 0
-		}
-		if debugI2SInvertActScale && actScale != 0 {
-			actScale = 1 / actScale
-		}
-		linearApplyIntoWeightI2SQuantized(dstQ, wQ, scratch, actScale, actSum)
-		linearApplyIntoWeightI2SQuantized(dstK, wK, scratch, actScale, actSum)
-		linearApplyIntoWeightI2SQuantized(dstV, wV, scratch, actScale, actSum)
+		actScale, actSum = opts.i2sActAdjust(actScale, actSum)
+		linearApplyIntoWeightI2SQuantized(opts, dstQ, wQ, scratch, actScale, actSum)
+		linearApplyIntoWeightI2SQuantized(opts, dstK, wK, scratch, actScale, actSum)
+		linearApplyIntoWeightI2SQuantized(opts, dstV, wV, scratch, actScale, actSum)
 		i8ScratchPool.Put(scratch[:0])
 		return
 	}
-	if strictQF32EnabledForCurrentLayer() && len(qF32) >= wQ.rows*wQ.cols {
+	if opts.StrictQF32 && len(qF32) >= wQ.rows*wQ.cols {
 		wQF32 := linearWeight{
 			data:       qF32,
 			rows:       wQ.rows,
@@ -4353,33 +4441,27 @@ func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []flo
 			transposed: wQ.transposed,
 			qtype:      gguf.GGMLTypeF32,
 		}
-		if attnHeads > 0 && len(dstQ)%attnHeads == 0 && (strictQF32Head >= 0 || len(strictQF32Heads) > 0) {
-			// Start from the current path output and overwrite only one head from f32.
-			linearApplyIntoWeight(dstQ, wQ, x)
+		if attnHeads > 0 && len(dstQ)%attnHeads == 0 && len(opts.StrictQF32Heads) > 0 {
+			// Start from the current path output and overwrite only the selected heads from f32.
+			linearApplyIntoWeight(opts, dstQ, wQ, x)
 			headDim := len(dstQ) / attnHeads
 			tmpQ := make([]float32, len(dstQ))
-			linearApplyIntoWeight(tmpQ, wQF32, x)
-			if len(strictQF32Heads) > 0 {
-				for h := range strictQF32Heads {
-					if h < 0 || h >= attnHeads {
-						continue
-					}
-					start := h * headDim
-					end := start + headDim
-					copy(dstQ[start:end], tmpQ[start:end])
+			linearApplyIntoWeight(opts, tmpQ, wQF32, x)
+			for _, h := range opts.StrictQF32Heads {
+				if h < 0 || h >= attnHeads {
+					continue
 				}
-			} else if strictQF32Head < attnHeads {
-				start := strictQF32Head * headDim
+				start := h * headDim
 				end := start + headDim
 				copy(dstQ[start:end], tmpQ[start:end])
 			}
 		} else {
-			linearApplyIntoWeight(dstQ, wQF32, x)
+			linearApplyIntoWeight(opts, dstQ, wQF32, x)
 		}
 	} else {
-		linearApplyIntoWeight(dstQ, wQ, x)
+		linearApplyIntoWeight(opts, dstQ, wQ, x)
 	}
-	if strictKF32EnabledForCurrentLayer() && len(kF32) >= wK.rows*wK.cols {
+	if opts.StrictKF32 && len(kF32) >= wK.rows*wK.cols {
 		wKF32 := linearWeight{
 			data:       kF32,
 			rows:       wK.rows,
@@ -4387,11 +4469,11 @@ func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []flo
 			transposed: wK.transposed,
 			qtype:      gguf.GGMLTypeF32,
 		}
-		linearApplyIntoWeight(dstK, wKF32, x)
+		linearApplyIntoWeight(opts, dstK, wKF32, x)
 	} else {
-		linearApplyIntoWeight(dstK, wK, x)
+		linearApplyIntoWeight(opts, dstK, wK, x)
 	}
-	if strictVF32EnabledForCurrentLayer() && len(vF32) >= wV.rows*wV.cols {
+	if opts.StrictVF32 && len(vF32) >= wV.rows*wV.cols {
 		wVF32 := linearWeight{
 			data:       vF32,
 			rows:       wV.rows,
@@ -4399,14 +4481,14 @@ func linearApplyQKV(dstQ, dstK, dstV []float32, wQ, wK, wV linearWeight, x []flo
 			transposed: wV.transposed,
 			qtype:      gguf.GGMLTypeF32,
 		}
-		linearApplyIntoWeight(dstV, wVF32, x)
+		linearApplyIntoWeight(opts, dstV, wVF32, x)
 		return
 	}
-	if strictVRefEnabledForCurrentLayer() {
-		linearApplyIntoWeightI2SRef(dstV, wV, x)
+	if opts.StrictVRef {
+		linearApplyIntoWeightI2SRef(opts, dstV, wV, x)
 		return
 	}
-	linearApplyIntoWeight(dstV, wV, x)
+	linearApplyIntoWeight(opts, dstV, wV, x)
 }
 
 func matVec3F32(dstA, dstB, dstC []float32, matA, matB, matC []float32, rows, cols int, vec []float32) {
@@ -4517,6 +4599,9 @@ func matVec3F32Col(dstA, dstB, dstC []float32, matA, matB, matC []float32, rows,
 }
 
 func loadLinearWeight(info gguf.ModelInfo, loader *modelTensorLoader, name string, inDim int) (linearWeight, error) {
+	if w, ok, err := loadLinearWeightTQ(info, loader, name, inDim); ok || err != nil {
+		return w, err
+	}
 	data, rows, cols, transposed, err := loadLinearTensor(info, loader, name, inDim)
 	if err != nil {
 		return linearWeight{}, err
@@ -4534,18 +4619,54 @@ func loadLinearWeight(info gguf.ModelInfo, loader *modelTensorLoader, name strin
 		if err != nil {
 			return linearWeight{}, err
 		}
-		if transposed && debugI2SPretransposeMax > 0 && rows*cols <= debugI2SPretransposeMax {
+		if transposed && loader.opts.I2SPretransposeMax > 0 && rows*cols <= loader.opts.I2SPretransposeMax {
 			if repacked := transposeI2SPacked(packed, rows, cols); len(repacked) > 0 {
 				packed = repacked
 				transposed = false
 			}
 		}
+		if transposed {
+			// Column chunks of a transposed matvec are split across NUMA
+			// nodes in the same proportions the scheduler uses, so first
+			// touch each node's share from that node.
+			if len(loader.mmapData) > 0 {
+				kernels.DefaultScheduler().Prefault(packed)
+			} else {
+				packed = kernels.DefaultScheduler().PlaceByNode(packed)
+			}
+		}
 		w.i2sPacked = packed
 		w.i2sScale = scale
 	}
 	return w, nil
 }
 
+// loadLinearWeightTQ keeps tq1_0/tq2_0 weights packed when their 256-element
+// blocks run along the input dim, which is the layout the TQ kernels need.
+// Other layouts, and TQFloat, fall back to the f32 dequant path.
+func loadLinearWeightTQ(info gguf.ModelInfo, loader *modelTensorLoader, name string, inDim int) (linearWeight, bool, error) {
+	ti, ok := info.TensorByName(name)
+	if !ok || loader.opts.TQFloat || gguf.TQBlockBytes(ti.Type) == 0 || len(ti.Dimensions) != 2 {
+		return linearWeight{}, false, nil
+	}
+	rows := int(ti.Dimensions[0])
+	cols := int(ti.Dimensions[1])
+	if rows != inDim || rows%kernels.TQBlockElems != 0 || cols <= 0 {
+		return linearWeight{}, false, nil
+	}
+	packed, err := loader.readTensorTQPacked(name)
+	if err != nil {
+		return linearWeight{}, false, err
+	}
+	return linearWeight{
+		rows:       rows,
+		cols:       cols,
+		transposed: true,
+		qtype:      ti.Type,
+		tqPacked:   packed,
+	}, true, nil
+}
+
 func loadLinearTensor(info gguf.ModelInfo, loader *modelTensorLoader, name string, inDim int) ([]float32, int, int, bool, error) {
 	ti, ok := info.TensorByName(name)
 	if !ok {
@@ -4623,7 +4744,7 @@ func firstString(values ...any) string {
 	return ""
 }
 
-func runForwardStub(vocabSize uint32, seed int64, promptTokens []int32, out []int32, topk *topKWriter, cfg samplingConfig) {
+func runForwardStub(opts *RuntimeOptions, vocabSize uint32, seed int64, promptTokens []int32, out []int32, topk *topKWriter, cfg samplingConfig) {
 	const hiddenDim = 32
 	state := make([]float32, hiddenDim)
 	tokenVec := make([]float32, hiddenDim)
@@ -4666,7 +4787,7 @@ func runForwardStub(vocabSize uint32, seed int64, promptTokens []int32, out []in
 			topk.append(i, logits)
 		}
 
-		next := sampleLogitsWithScratch(logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
+		next := sampleLogitsWithScratch(opts, logits, cfg, sampler, probs, idx, topkEntries, topkProbs)
 		if next < 0 {
 			out[i] = 0
 			continue
@@ -4796,7 +4917,7 @@ func fillTopK(entries []TopKEntry, logits []float32, k int) int {
 	return count
 }
 
-func sampleLogitsWithScratch(logits []float32, cfg samplingConfig, rng *sampler, probs []float32, idx []int, topkEntries []TopKEntry, topkProbs []float32) int {
+func sampleLogitsWithScratch(opts *RuntimeOptions, logits []float32, cfg samplingConfig, rng *sampler, probs []float32, idx []int, topkEntries []TopKEntry, topkProbs []float32) int {
 	if len(logits) == 0 {
 		return -1
 	}
@@ -4820,15 +4941,15 @@ func sampleLogitsWithScratch(logits []float32, cfg samplingConfig, rng *sampler,
 		if len(probsTopK) < n {
 			probsTopK = make([]float32, n)
 		}
-		return sampleFromTopK(entries[:n], cfg.temp, cfg.topP, rng, probsTopK[:n])
+		return sampleFromTopK(opts, entries[:n], cfg.temp, cfg.topP, rng, probsTopK[:n])
 	}
 	if cfg.topP < 1 {
-		return sampleFromTopP(logits, cfg.temp, cfg.topP, rng, probs, idx)
+		return sampleFromTopP(opts, logits, cfg.temp, cfg.topP, rng, probs, idx)
 	}
-	return sampleFromFull(logits, cfg.temp, rng, probs)
+	return sampleFromFull(opts, logits, cfg.temp, rng, probs)
 }
 
-func sampleFromTopK(entries []TopKEntry, temp float32, topP float32, rng *sampler, probs []float32) int {
+func sampleFromTopK(opts *RuntimeOptions, entries []TopKEntry, temp float32, topP float32, rng *sampler, probs []float32) int {
 	if len(entries) == 0 {
 		return -1
 	}
@@ -4846,7 +4967,7 @@ func sampleFromTopK(entries []TopKEntry, temp float32, topP float32, rng *sample
 	var sum float32
 	for i := range entries {
 		val := entries[i].Logit/temp - maxLogit
-		p := expForSampling(val)
+		p := expForSampling(opts, val)
 		probs[i] = p
 		sum += p
 	}
@@ -4891,7 +5012,7 @@ func sampleFromTopK(entries []TopKEntry, temp float32, topP float32, rng *sample
 	return int(entries[limit-1].TokenID)
 }
 
-func sampleFromFull(logits []float32, temp float32, rng *sampler, probs []float32) int {
+func sampleFromFull(opts *RuntimeOptions, logits []float32, temp float32, rng *sampler, probs []float32) int {
 	if len(logits) == 0 {
 		return -1
 	}
@@ -4905,7 +5026,7 @@ func sampleFromFull(logits []float32, temp float32, rng *sampler, probs []float3
 	var sum float32
 	for i := range logits {
 		val := logits[i]/temp - maxLogit
-		p := expForSampling(val)
+		p := expForSampling(opts, val)
 		probs[i] = p
 		sum += p
 	}
@@ -4927,24 +5048,24 @@ func sampleFromFull(logits []float32, temp float32, rng *sampler, probs []float3
 	return len(probs) - 1
 }
 
-func sampleFromTopP(logits []float32, temp float32, topP float32, rng *sampler, probs []float32, idx []int) int {
+func sampleFromTopP(opts *RuntimeOptions, logits []float32, temp float32, topP float32, rng *sampler, probs []float32, idx []int) int {
 	if len(logits) == 0 {
 		return -1
 	}
-	if topPPrefilterK > 0 && topPPrefilterK < len(logits) {
-		if id, ok := sampleFromTopPPrefilter(logits, temp, topP, rng, probs, idx, topPPrefilterK); ok {
+	if opts.TopPPrefilterK > 0 && opts.TopPPrefilterK < len(logits) {
+		if id, ok := sampleFromTopPPrefilter(opts, logits, temp, topP, rng, probs, idx, opts.TopPPrefilterK); ok {
 			return id
 		}
 	}
-	if topPHeapCap > 0 && topPHeapCap < len(logits) {
-		if id, ok := sampleFromTopPHeap(logits, temp, topP, rng, topPHeapCap); ok {
+	if opts.TopPHeapCap > 0 && opts.TopPHeapCap < len(logits) {
+		if id, ok := sampleFromTopPHeap(opts, logits, temp, topP, rng, opts.TopPHeapCap); ok {
 			return id
 		}
 	}
-	return sampleFromTopPSort(logits, temp, topP, rng, probs, idx)
+	return sampleFromTopPSort(opts, logits, temp, topP, rng, probs, idx)
 }
 
-func sampleFromTopPPrefilter(logits []float32, temp float32, topP float32, rng *sampler, probs []float32, idx []int, k int) (int, bool) {
+func sampleFromTopPPrefilter(opts *RuntimeOptions, logits []float32, temp float32, topP float32, rng *sampler, probs []float32, idx []int, k int) (int, bool) {
 	n := len(logits)
 	if n == 0 || len(idx) < n || k <= 0 || k >= n {
 		return -1, false
@@ -4964,7 +5085,7 @@ func sampleFromTopPPrefilter(logits []float32, temp float32, topP float32, rng *
 
 	var total float32
 	for i := 0; i < n; i++ {
-		total += expForSampling(logits[i]/temp - maxLogit)
+		total += expForSampling(opts, logits[i]/temp-maxLogit)
 	}
 	target := topP * total
 
@@ -4972,7 +5093,7 @@ func sampleFromTopPPrefilter(logits []float32, temp float32, topP float32, rng *
 	limit := k
 	for i := 0; i < k; i++ {
 		id := idx[i]
-		p := expForSampling(logits[id]/temp - maxLogit)
+		p := expForSampling(opts, logits[id]/temp-maxLogit)
 		probs[id] = p
 		prefixSum += p
 		if prefixSum >= target {
@@ -5033,7 +5154,7 @@ func topPHeapDown(h topPMinHeap, i int) {
 	}
 }
 
-func sampleFromTopPHeap(logits []float32, temp float32, topP float32, rng *sampler, capN int) (int, bool) {
+func sampleFromTopPHeap(opts *RuntimeOptions, logits []float32, temp float32, topP float32, rng *sampler, capN int) (int, bool) {
 	if len(logits) == 0 || capN <= 0 {
 		return -1, false
 	}
@@ -5047,7 +5168,7 @@ func sampleFromTopPHeap(logits []float32, temp float32, topP float32, rng *sampl
 	h := make(topPMinHeap, 0, capN)
 	var total, topSum float32
 	for id := range logits {
-		p := expForSampling(logits[id]/temp - maxLogit)
+		p := expForSampling(opts, logits[id]/temp-maxLogit)
 		total += p
 		if len(h) < capN {
 			h = append(h, topPEntry{id: id, p: p})
@@ -5090,18 +5211,18 @@ func sampleFromTopPHeap(logits []float32, temp float32, topP float32, rng *sampl
 	return h[limit-1].id, true
 }
 
-func sampleFromTopPSort(logits []float32, temp float32, topP float32, rng *sampler, probs []float32, idx []int) int {
+func sampleFromTopPSort(opts *RuntimeOptions, logits []float32, temp float32, topP float32, rng *sampler, probs []float32, idx []int) int {
 	if len(logits) == 0 {
 		return -1
 	}
 	if len(idx) < len(logits) {
-		return sampleFromFull(logits, temp, rng, probs)
+		return sampleFromFull(opts, logits, temp, rng, probs)
 	}
 	n := len(logits)
 	for i := 0; i < n; i++ {
 		idx[i] = i
 	}
-	k := topPSortPrefix
+	k := opts.TopPSortPrefix
 	if k <= 0 {
 		k = n
 	}
@@ -5125,7 +5246,7 @@ func sampleFromTopPSort(logits []float32, temp float32, topP float32, rng *sampl
 		for i := 0; i < k; i++ {
 			id := idx[i]
 			val := logits[id]/temp - maxLogit
-			p := expForSampling(val)
+			p := expForSampling(opts, val)
 			probs[id] = p
 			cum += p
 			if cum >= topP {
@@ -5220,8 +5341,8 @@ func sortTopPEntriesDesc(h topPMinHeap) {
 	})
 }
 
-func expForSampling(x float32) float32 {
-	if debugStrictExpf || debugFastExpf {
+func expForSampling(opts *RuntimeOptions, x float32) float32 {
+	if opts.StrictExpf || opts.FastExpf {
 		return expf32(x)
 	}
 	return float32(math.Exp(float64(x)))
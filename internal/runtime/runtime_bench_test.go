@@ -63,6 +63,7 @@ func BenchmarkApplyRoPEInPlace(b *testing.B) {
 }
 
 func BenchmarkCausalAttentionMultiHeadInto(b *testing.B) {
+	opts := testRuntimeOptions()
 	type cfg struct {
 		steps int
 		heads int
@@ -95,13 +96,14 @@ func BenchmarkCausalAttentionMultiHeadInto(b *testing.B) {
 			b.SetBytes(int64((len(q) + len(keys) + len(values) + len(scores) + len(dst)) * 4))
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				causalAttentionMultiHeadInto(dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
+				causalAttentionMultiHeadInto(opts, dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
 			}
 		})
 	}
 }
 
 func BenchmarkCausalAttentionMultiHeadIntoCompare(b *testing.B) {
+	opts := testRuntimeOptions()
 	type cfg struct {
 		steps int
 		heads int
@@ -145,7 +147,7 @@ func BenchmarkCausalAttentionMultiHeadIntoCompare(b *testing.B) {
 				b.SetBytes(int64((len(q) + len(keys) + len(values) + len(scores) + len(dst)) * 4))
 				b.ResetTimer()
 				for i := 0; i < b.N; i++ {
-					causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
+					causalAttentionMultiHeadIntoGeneric(opts, dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
 				}
 			})
 
@@ -154,7 +156,7 @@ func BenchmarkCausalAttentionMultiHeadIntoCompare(b *testing.B) {
 				b.SetBytes(int64((len(q) + len(keys) + len(valuesRow) + len(scores) + len(dst)) * 4))
 				b.ResetTimer()
 				for i := 0; i < b.N; i++ {
-					causalAttentionMultiHeadIntoRowMajor(dst, scores, q, keys, valuesRow, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
+					causalAttentionMultiHeadIntoRowMajor(opts, dst, scores, q, keys, valuesRow, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
 				}
 			})
 
@@ -163,7 +165,7 @@ func BenchmarkCausalAttentionMultiHeadIntoCompare(b *testing.B) {
 				b.SetBytes(int64((len(q) + len(keys) + len(values) + len(scores) + len(dst)) * 4))
 				b.ResetTimer()
 				for i := 0; i < b.N; i++ {
-					causalAttentionMultiHeadInto(dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
+					causalAttentionMultiHeadInto(opts, dst, scores, q, keys, values, c.steps, c.heads, c.heads, kStepDim, vStepDim, 0)
 				}
 			})
 		})
@@ -275,6 +277,7 @@ func BenchmarkKQVAccumulation(b *testing.B) {
 }
 
 func BenchmarkSoftmaxInPlace(b *testing.B) {
+	opts := testRuntimeOptions()
 	steps := 256
 	scores := make([]float32, steps)
 	for i := range scores {
@@ -290,7 +293,7 @@ func BenchmarkSoftmaxInPlace(b *testing.B) {
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			copy(tmp, scores)
-			softmaxInPlaceGeneric(tmp, maxScore)
+			softmaxInPlaceGeneric(opts, tmp, maxScore)
 		}
 	})
 
@@ -300,7 +303,7 @@ func BenchmarkSoftmaxInPlace(b *testing.B) {
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			copy(tmp, scores)
-			softmaxInPlace(tmp, maxScore)
+			softmaxInPlace(opts, tmp, maxScore)
 		}
 	})
 }
@@ -323,6 +326,7 @@ func BenchmarkAppendTopKStep(b *testing.B) {
 }
 
 func BenchmarkSampleFromTopP(b *testing.B) {
+	opts := testRuntimeOptions()
 	const vocab = 128256
 	logits := make([]float32, vocab)
 	for i := range logits {
@@ -344,7 +348,7 @@ func BenchmarkSampleFromTopP(b *testing.B) {
 	b.SetBytes(int64(vocab * 4))
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = sampleFromTopP(logits, temp, topP, rng, probs, idx)
+		_ = sampleFromTopP(opts, logits, temp, topP, rng, probs, idx)
 	}
 }
 
@@ -367,8 +371,13 @@ func BenchmarkGenerateTopKToggle(b *testing.B) {
 	})
 
 	b.Run("no-topk", func(b *testing.B) {
+		opts := DefaultRuntimeOptions()
+		opts.DisableTopK = true
+		rt, err := NewWithOptions(context.Background(), modelPath, Options{Runtime: &opts})
+		if err != nil {
+			b.Fatalf("NewWithOptions() error: %v", err)
+		}
 		b.ReportAllocs()
-		b.Setenv("BITNET_DISABLE_TOPK", "1")
 		for i := 0; i < b.N; i++ {
 			_, _ = rt.Generate(context.Background(), req)
 		}
@@ -380,10 +389,6 @@ func BenchmarkGenerateTopPCompare(b *testing.B) {
 	if modelPath == "" {
 		b.Skip("set BITNET_BENCH_MODEL to run Generate benchmark")
 	}
-	rt, err := New(context.Background(), modelPath)
-	if err != nil {
-		b.Fatalf("New() error: %v", err)
-	}
 	req := GenerateRequest{
 		Prompt:             "Hello",
 		Seed:               1,
@@ -394,23 +399,26 @@ func BenchmarkGenerateTopPCompare(b *testing.B) {
 		DisableTopKCapture: true,
 	}
 
-	b.Run("default_prefix", func(b *testing.B) {
-		restore := setTopPSortPrefixForTest(256)
-		defer restore()
-		b.ReportAllocs()
-		for i := 0; i < b.N; i++ {
-			_, _ = rt.Generate(context.Background(), req)
-		}
-	})
-
-	b.Run("full_sort", func(b *testing.B) {
-		restore := setTopPSortPrefixForTest(0)
-		defer restore()
-		b.ReportAllocs()
-		for i := 0; i < b.N; i++ {
-			_, _ = rt.Generate(context.Background(), req)
-		}
-	})
+	for _, tc := range []struct {
+		name   string
+		prefix int
+	}{
+		{"default_prefix", 256},
+		{"full_sort", 0},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			opts := DefaultRuntimeOptions()
+			opts.TopPSortPrefix = tc.prefix
+			rt, err := NewWithOptions(context.Background(), modelPath, Options{Runtime: &opts})
+			if err != nil {
+				b.Fatalf("NewWithOptions() error: %v", err)
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = rt.Generate(context.Background(), req)
+			}
+		})
+	}
 }
 
 func BenchmarkOutputProjectionF32(b *testing.B) {
@@ -438,6 +446,7 @@ func BenchmarkOutputProjectionF32(b *testing.B) {
 }
 
 func BenchmarkQKVMatVecCompare(b *testing.B) {
+	opts := testRuntimeOptions()
 	type cfg struct {
 		rows int
 		cols int
@@ -476,9 +485,9 @@ func BenchmarkQKVMatVecCompare(b *testing.B) {
 				wB := linearWeight{data: matB, rows: rows, cols: cols, qtype: gguf.GGMLTypeF32}
 				wC := linearWeight{data: matC, rows: rows, cols: cols, qtype: gguf.GGMLTypeF32}
 				for i := 0; i < b.N; i++ {
-					linearApplyIntoWeight(dstA, wA, vec)
-					linearApplyIntoWeight(dstB, wB, vec)
-					linearApplyIntoWeight(dstC, wC, vec)
+					linearApplyIntoWeight(opts, dstA, wA, vec)
+					linearApplyIntoWeight(opts, dstB, wB, vec)
+					linearApplyIntoWeight(opts, dstC, wC, vec)
 				}
 			})
 
@@ -490,7 +499,7 @@ func BenchmarkQKVMatVecCompare(b *testing.B) {
 				wB := linearWeight{data: matB, rows: rows, cols: cols, qtype: gguf.GGMLTypeF32}
 				wC := linearWeight{data: matC, rows: rows, cols: cols, qtype: gguf.GGMLTypeF32}
 				for i := 0; i < b.N; i++ {
-					linearApplyQKV(dstA, dstB, dstC, wA, wB, wC, vec, 0, nil, nil, nil)
+					linearApplyQKV(opts, dstA, dstB, dstC, wA, wB, wC, vec, 0, nil, nil, nil)
 				}
 			})
 
@@ -507,6 +516,7 @@ func BenchmarkQKVMatVecCompare(b *testing.B) {
 }
 
 func BenchmarkLinearApplyInto(b *testing.B) {
+	opts := testRuntimeOptions()
 	type cfg struct {
 		rows int
 		cols int
@@ -542,7 +552,7 @@ func BenchmarkLinearApplyInto(b *testing.B) {
 			b.ResetTimer()
 			w := linearWeight{data: data, rows: c.rows, cols: c.cols, transposed: c.tr, qtype: gguf.GGMLTypeF32}
 			for i := 0; i < b.N; i++ {
-				linearApplyIntoWeight(dst, w, x)
+				linearApplyIntoWeight(opts, dst, w, x)
 			}
 		})
 	}
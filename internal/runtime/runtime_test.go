@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"bitnet-go/internal/gguf"
+	"bitnet-go/internal/kernels"
+	"bitnet-go/internal/tokenizer"
 )
 
 func unsetEnvForTest(t *testing.T, key string) {
@@ -32,195 +39,144 @@ func TestParityProfileDefaultsCPUV1(t *testing.T) {
 	unsetEnvForTest(t, "BITNET_STRICT_EXPF")
 	unsetEnvForTest(t, "BITNET_STRICT_KQ_LAYER_MAX")
 	unsetEnvForTest(t, "BITNET_STRICT_EXPF_LAYER_MAX")
+	t.Setenv("BITNET_PARITY_PROFILE", PresetCPUParityV1)
 
-	oldProfile := parityProfile
-	parityProfile = parityProfileCPUV1
-	t.Cleanup(func() { parityProfile = oldProfile })
-
-	if !parityProfileBool("BITNET_STRICT_KQ", false) {
-		t.Fatalf("BITNET_STRICT_KQ profile default = false, want true")
+	o := DefaultRuntimeOptions()
+	if !o.StrictKQ {
+		t.Fatalf("StrictKQ profile default = false, want true")
 	}
-	if !parityProfileBool("BITNET_STRICT_EXPF", false) {
-		t.Fatalf("BITNET_STRICT_EXPF profile default = false, want true")
+	if !o.StrictExpf {
+		t.Fatalf("StrictExpf profile default = false, want true")
 	}
-	if got := parityProfileInt("BITNET_STRICT_KQ_LAYER_MAX", -1); got != 12 {
-		t.Fatalf("BITNET_STRICT_KQ_LAYER_MAX profile default = %d, want 12", got)
+	if o.StrictKQLayerMax != 12 {
+		t.Fatalf("StrictKQLayerMax profile default = %d, want 12", o.StrictKQLayerMax)
 	}
-	if got := parityProfileInt("BITNET_STRICT_EXPF_LAYER_MAX", -1); got != 0 {
-		t.Fatalf("BITNET_STRICT_EXPF_LAYER_MAX profile default = %d, want 0", got)
+	if o.StrictExpfLayerMax != 0 {
+		t.Fatalf("StrictExpfLayerMax profile default = %d, want 0", o.StrictExpfLayerMax)
 	}
-}
-
-func TestStrictKQEnabledForCurrentLayer(t *testing.T) {
-	oldStrict := debugStrictKQ
-	oldMax := strictKQLayerMax
-	oldLayer := strictKQCurrentLayer.Load()
-	t.Cleanup(func() {
-		debugStrictKQ = oldStrict
-		strictKQLayerMax = oldMax
-		strictKQCurrentLayer.Store(oldLayer)
-	})
-
-	debugStrictKQ = true
-	strictKQLayerMax = 14
 
-	strictKQCurrentLayer.Store(7)
-	if !strictKQEnabledForCurrentLayer() {
-		t.Fatalf("strictKQEnabledForCurrentLayer() = false, want true for layer 7 <= 14")
-	}
-	strictKQCurrentLayer.Store(20)
-	if strictKQEnabledForCurrentLayer() {
-		t.Fatalf("strictKQEnabledForCurrentLayer() = true, want false for layer 20 > 14")
-	}
-	strictKQCurrentLayer.Store(-1)
-	if !strictKQEnabledForCurrentLayer() {
-		t.Fatalf("strictKQEnabledForCurrentLayer() = false, want true for unknown layer")
+	// Explicit env vars still win over the profile.
+	t.Setenv("BITNET_STRICT_KQ_LAYER_MAX", "3")
+	if got := DefaultRuntimeOptions().StrictKQLayerMax; got != 3 {
+		t.Fatalf("StrictKQLayerMax with env override = %d, want 3", got)
 	}
 }
 
-func TestStrictExpfEnabledForCurrentLayer(t *testing.T) {
-	oldStrict := debugStrictExpf
-	oldMax := strictExpfLayerMax
-	oldLayer := strictKQCurrentLayer.Load()
-	t.Cleanup(func() {
-		debugStrictExpf = oldStrict
-		strictExpfLayerMax = oldMax
-		strictKQCurrentLayer.Store(oldLayer)
-	})
-
-	debugStrictExpf = true
-	strictExpfLayerMax = 0
-
-	strictKQCurrentLayer.Store(0)
-	if !strictExpfEnabledForCurrentLayer() {
-		t.Fatalf("strictExpfEnabledForCurrentLayer() = false, want true for layer 0 <= 0")
+func TestPresetRuntimeOptions(t *testing.T) {
+	o, err := PresetRuntimeOptions(PresetCPUParityV1)
+	if err != nil {
+		t.Fatalf("PresetRuntimeOptions() error = %v", err)
 	}
-	strictKQCurrentLayer.Store(1)
-	if strictExpfEnabledForCurrentLayer() {
-		t.Fatalf("strictExpfEnabledForCurrentLayer() = true, want false for layer 1 > 0")
+	if o.Preset != PresetCPUParityV1 || !o.StrictKQ || o.StrictKQLayerMax != 12 || !o.StrictExpf {
+		t.Fatalf("unexpected cpu_parity_v1 preset: %+v", o)
 	}
-}
-
-func TestStrictVRefEnabledForCurrentLayer(t *testing.T) {
-	oldStrict := debugStrictVRef
-	oldMax := strictVRefLayerMax
-	oldLayer := strictKQCurrentLayer.Load()
-	t.Cleanup(func() {
-		debugStrictVRef = oldStrict
-		strictVRefLayerMax = oldMax
-		strictKQCurrentLayer.Store(oldLayer)
-	})
-
-	debugStrictVRef = true
-	strictVRefLayerMax = 3
 
-	strictKQCurrentLayer.Store(2)
-	if !strictVRefEnabledForCurrentLayer() {
-		t.Fatalf("strictVRefEnabledForCurrentLayer() = false, want true for layer 2 <= 3")
+	strict, err := PresetRuntimeOptions(PresetParityStrict)
+	if err != nil {
+		t.Fatalf("PresetRuntimeOptions() error = %v", err)
 	}
-	strictKQCurrentLayer.Store(4)
-	if strictVRefEnabledForCurrentLayer() {
-		t.Fatalf("strictVRefEnabledForCurrentLayer() = true, want false for layer 4 > 3")
+	if !strict.ParityStrict || !strict.StrictKQ || !strict.I2SFloat || strict.FastKQDot || strict.FastExpf {
+		t.Fatalf("parity_strict preset did not resolve implied settings: %+v", strict)
 	}
-	strictKQCurrentLayer.Store(-1)
-	if !strictVRefEnabledForCurrentLayer() {
-		t.Fatalf("strictVRefEnabledForCurrentLayer() = false, want true for unknown layer")
+
+	if _, err := PresetRuntimeOptions("nope"); err == nil {
+		t.Fatal("expected error for unknown preset")
 	}
 }
 
-func TestStrictVF32EnabledForCurrentLayer(t *testing.T) {
-	oldStrict := debugStrictVF32
-	oldMax := strictVF32LayerMax
-	oldLayer := strictKQCurrentLayer.Load()
-	t.Cleanup(func() {
-		debugStrictVF32 = oldStrict
-		strictVF32LayerMax = oldMax
-		strictKQCurrentLayer.Store(oldLayer)
-	})
-
-	debugStrictVF32 = true
-	strictVF32LayerMax = 5
+func TestRuntimeOptionsPerSession(t *testing.T) {
+	path := buildLlamaBlock0Model(t, false)
+	strict, _ := PresetRuntimeOptions(PresetParityStrict)
+	fast, _ := PresetRuntimeOptions(PresetDefault)
+	fast.PromptCacheCap = 7
 
-	strictKQCurrentLayer.Store(5)
-	if !strictVF32EnabledForCurrentLayer() {
-		t.Fatalf("strictVF32EnabledForCurrentLayer() = false, want true for layer 5 <= 5")
+	a, err := NewWithOptions(context.Background(), path, Options{Runtime: &strict})
+	if err != nil {
+		t.Fatalf("NewWithOptions(strict) error = %v", err)
 	}
-	strictKQCurrentLayer.Store(6)
-	if strictVF32EnabledForCurrentLayer() {
-		t.Fatalf("strictVF32EnabledForCurrentLayer() = true, want false for layer 6 > 5")
+	b, err := NewWithOptions(context.Background(), path, Options{Runtime: &fast})
+	if err != nil {
+		t.Fatalf("NewWithOptions(fast) error = %v", err)
 	}
-	strictKQCurrentLayer.Store(-1)
-	if !strictVF32EnabledForCurrentLayer() {
-		t.Fatalf("strictVF32EnabledForCurrentLayer() = false, want true for unknown layer")
+	if !a.Options().ParityStrict || b.Options().ParityStrict {
+		t.Fatalf("sessions share options: a=%v b=%v", a.Options().ParityStrict, b.Options().ParityStrict)
 	}
-}
-
-func TestStrictQF32EnabledForCurrentLayer(t *testing.T) {
-	oldStrict := debugStrictQF32
-	oldMax := strictQF32LayerMax
-	oldLayer := strictKQCurrentLayer.Load()
-	t.Cleanup(func() {
-		debugStrictQF32 = oldStrict
-		strictQF32LayerMax = oldMax
-		strictKQCurrentLayer.Store(oldLayer)
-	})
-
-	debugStrictQF32 = true
-	strictQF32LayerMax = 2
-
-	strictKQCurrentLayer.Store(2)
-	if !strictQF32EnabledForCurrentLayer() {
-		t.Fatalf("strictQF32EnabledForCurrentLayer() = false, want true for layer 2 <= 2")
+	if a.block.opts == b.block.opts || !a.block.opts.ParityStrict || b.block.opts.ParityStrict {
+		t.Fatal("blocks should carry their own session options")
 	}
-	strictKQCurrentLayer.Store(3)
-	if strictQF32EnabledForCurrentLayer() {
-		t.Fatalf("strictQF32EnabledForCurrentLayer() = true, want false for layer 3 > 2")
+	if b.promptCacheCap != 7 {
+		t.Fatalf("promptCacheCap = %d, want 7", b.promptCacheCap)
 	}
-	strictKQCurrentLayer.Store(-1)
-	if !strictQF32EnabledForCurrentLayer() {
-		t.Fatalf("strictQF32EnabledForCurrentLayer() = false, want true for unknown layer")
+
+	req := GenerateRequest{Prompt: "hello", Seed: 3, MaxTokens: 4}
+	for _, rt := range []*Runtime{a, b} {
+		if _, err := rt.Generate(context.Background(), req); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
 	}
 }
 
-func TestParseStrictQF32Heads(t *testing.T) {
-	got := parseStrictQF32Heads("0, 2, x, -1, 3")
-	if len(got) != 3 {
-		t.Fatalf("len(parseStrictQF32Heads) = %d, want 3", len(got))
+func TestRuntimeOptionsForLayer(t *testing.T) {
+	opts := &RuntimeOptions{
+		StrictKQ: true, StrictKQLayerMax: 14,
+		StrictExpf: true, StrictExpfLayerMax: 0,
+		StrictVRef: true, StrictVRefLayerMax: 3,
+		StrictQF32: true, StrictQF32LayerMax: 2,
+		StrictKF32: true, StrictKF32LayerMax: 4,
+		StrictVF32: true, StrictVF32LayerMax: -1,
 	}
-	for _, h := range []int{0, 2, 3} {
-		if _, ok := got[h]; !ok {
-			t.Fatalf("missing head %d in parsed set", h)
+	cases := []struct {
+		name string
+		get  func(*RuntimeOptions) bool
+		on   int
+		off  int // -1: on for every layer
+	}{
+		{"StrictKQ", func(o *RuntimeOptions) bool { return o.StrictKQ }, 14, 15},
+		{"StrictExpf", func(o *RuntimeOptions) bool { return o.StrictExpf }, 0, 1},
+		{"StrictVRef", func(o *RuntimeOptions) bool { return o.StrictVRef }, 3, 4},
+		{"StrictQF32", func(o *RuntimeOptions) bool { return o.StrictQF32 }, 2, 3},
+		{"StrictKF32", func(o *RuntimeOptions) bool { return o.StrictKF32 }, 4, 5},
+		{"StrictVF32", func(o *RuntimeOptions) bool { return o.StrictVF32 }, 40, -1},
+	}
+	for _, tc := range cases {
+		on := opts.forLayer(tc.on)
+		if !tc.get(&on) {
+			t.Fatalf("%s off at layer %d, want on", tc.name, tc.on)
+		}
+		if tc.off >= 0 {
+			off := opts.forLayer(tc.off)
+			if tc.get(&off) {
+				t.Fatalf("%s on at layer %d, want off", tc.name, tc.off)
+			}
+		}
+		if !tc.get(opts) {
+			t.Fatalf("%s cleared on the session options", tc.name)
 		}
 	}
-	if _, ok := got[-1]; ok {
-		t.Fatalf("unexpected negative head in parsed set")
+
+	perLayer := opts.perLayer(3)
+	if len(perLayer) != 3 || !perLayer[0].StrictExpf || perLayer[1].StrictExpf {
+		t.Fatalf("perLayer StrictExpf = %v, %v", perLayer[0].StrictExpf, perLayer[1].StrictExpf)
 	}
 }
 
-func TestStrictKF32EnabledForCurrentLayer(t *testing.T) {
-	oldStrict := debugStrictKF32
-	oldMax := strictKF32LayerMax
-	oldLayer := strictKQCurrentLayer.Load()
-	t.Cleanup(func() {
-		debugStrictKF32 = oldStrict
-		strictKF32LayerMax = oldMax
-		strictKQCurrentLayer.Store(oldLayer)
-	})
-
-	debugStrictKF32 = true
-	strictKF32LayerMax = 4
-
-	strictKQCurrentLayer.Store(1)
-	if !strictKF32EnabledForCurrentLayer() {
-		t.Fatalf("strictKF32EnabledForCurrentLayer() = false, want true for layer 1 <= 4")
+func TestLayerOptionsArePerSession(t *testing.T) {
+	a := &tensorBlock{opts: &RuntimeOptions{StrictKQ: true, StrictKQLayerMax: 0}, layers: make([]llamaLayer, 2)}
+	b := &tensorBlock{opts: &RuntimeOptions{StrictKQ: true, StrictKQLayerMax: 1}, layers: make([]llamaLayer, 2)}
+	a.layerOpts = a.opts.perLayer(len(a.layers))
+	b.layerOpts = b.opts.perLayer(len(b.layers))
+	if a.layerOptions(1).StrictKQ || !b.layerOptions(1).StrictKQ {
+		t.Fatalf("layer 1 StrictKQ a=%v b=%v, want false true", a.layerOptions(1).StrictKQ, b.layerOptions(1).StrictKQ)
 	}
-	strictKQCurrentLayer.Store(5)
-	if strictKF32EnabledForCurrentLayer() {
-		t.Fatalf("strictKF32EnabledForCurrentLayer() = true, want false for layer 5 > 4")
+	if a.layerOptions(5) != a.opts {
+		t.Fatal("layerOptions past the stack should return the session options")
 	}
-	strictKQCurrentLayer.Store(-1)
-	if !strictKF32EnabledForCurrentLayer() {
-		t.Fatalf("strictKF32EnabledForCurrentLayer() = false, want true for unknown layer")
+}
+
+func TestParseStrictQF32Heads(t *testing.T) {
+	got := parseStrictQF32Heads("0, 2, x, -1, 3, 2")
+	if !slices.Equal(got, []int{0, 2, 3}) {
+		t.Fatalf("parseStrictQF32Heads = %v, want [0 2 3]", got)
 	}
 }
 
@@ -243,10 +199,11 @@ func TestParseStrictKQMode(t *testing.T) {
 }
 
 func TestRunForwardStubDeterministic(t *testing.T) {
+	opts := testRuntimeOptions()
 	a := make([]int32, 8)
 	b := make([]int32, 8)
-	runForwardStub(32000, 42, []int32{1, 2, 3}, a, nil, samplingConfig{})
-	runForwardStub(32000, 42, []int32{1, 2, 3}, b, nil, samplingConfig{})
+	runForwardStub(opts, 32000, 42, []int32{1, 2, 3}, a, nil, samplingConfig{})
+	runForwardStub(opts, 32000, 42, []int32{1, 2, 3}, b, nil, samplingConfig{})
 	for i := range a {
 		if a[i] != b[i] {
 			t.Fatalf("token[%d] mismatch: %d vs %d", i, a[i], b[i])
@@ -255,10 +212,11 @@ func TestRunForwardStubDeterministic(t *testing.T) {
 }
 
 func TestRunForwardStubPromptAffectsOutput(t *testing.T) {
+	opts := testRuntimeOptions()
 	a := make([]int32, 8)
 	b := make([]int32, 8)
-	runForwardStub(32000, 42, []int32{1, 2, 3}, a, nil, samplingConfig{})
-	runForwardStub(32000, 42, []int32{1, 2, 4}, b, nil, samplingConfig{})
+	runForwardStub(opts, 32000, 42, []int32{1, 2, 3}, a, nil, samplingConfig{})
+	runForwardStub(opts, 32000, 42, []int32{1, 2, 4}, b, nil, samplingConfig{})
 	same := true
 	for i := range a {
 		if a[i] != b[i] {
@@ -322,8 +280,8 @@ func TestRunForwardTensorBlockDeterministic(t *testing.T) {
 	}
 	a := make([]int32, 8)
 	b := make([]int32, 8)
-	runForwardTensorBlock(block, 123, []int32{9, 10, 11}, a, nil, nil, samplingConfig{})
-	runForwardTensorBlock(block, 123, []int32{9, 10, 11}, b, nil, nil, samplingConfig{})
+	runForwardTensorBlock(block, 123, []int32{9, 10, 11}, a, nil, nil, samplingConfig{}, forwardOptions{})
+	runForwardTensorBlock(block, 123, []int32{9, 10, 11}, b, nil, nil, samplingConfig{}, forwardOptions{})
 	for i := range a {
 		if a[i] != b[i] {
 			t.Fatalf("token[%d] mismatch: %d vs %d", i, a[i], b[i])
@@ -421,8 +379,8 @@ func TestGenerateUsesLlamaStackWhenPresent(t *testing.T) {
 	if rt.block == nil {
 		t.Fatal("expected tensor block to be loaded")
 	}
-	if rt.block.mode != tensorBlockModeLlamaStack {
-		t.Fatalf("block mode = %d, want llama stack", rt.block.mode)
+	if rt.block.arch == nil {
+		t.Fatal("block has no architecture, want llama stack")
 	}
 	if rt.block.attnHeads != 2 {
 		t.Fatalf("attnHeads = %d, want 2", rt.block.attnHeads)
@@ -467,8 +425,8 @@ func TestGenerateUsesLlamaStackWhenPresent(t *testing.T) {
 
 	pa := make([]int32, 4)
 	pb := make([]int32, 4)
-	runForwardLlamaStack(rt.block, 5, []int32{1, 2, 3}, pa, nil, nil, samplingConfig{})
-	runForwardLlamaStack(rt.block, 5, []int32{1, 2, 4}, pb, nil, nil, samplingConfig{})
+	runForwardLlamaStack(rt.block, 5, []int32{1, 2, 3}, pa, nil, nil, samplingConfig{}, forwardOptions{})
+	runForwardLlamaStack(rt.block, 5, []int32{1, 2, 4}, pb, nil, nil, samplingConfig{}, forwardOptions{})
 	same := true
 	for i := range pa {
 		if pa[i] != pb[i] {
@@ -491,8 +449,8 @@ func TestGenerateUsesLlamaStackWithoutSubNormTensors(t *testing.T) {
 	if rt.block == nil {
 		t.Fatal("expected tensor block to be loaded")
 	}
-	if rt.block.mode != tensorBlockModeLlamaStack {
-		t.Fatalf("block mode = %d, want llama stack", rt.block.mode)
+	if rt.block.arch == nil {
+		t.Fatal("block has no architecture, want llama stack")
 	}
 	if len(rt.block.layers) == 0 {
 		t.Fatal("expected at least one layer")
@@ -1005,6 +963,7 @@ func TestRoPEScaledPosition(t *testing.T) {
 }
 
 func TestCausalAttentionMultiHeadIntoRandomized(t *testing.T) {
+	opts := testRuntimeOptions()
 	steps := 8
 	heads := 2
 	dim := 4
@@ -1022,7 +981,7 @@ func TestCausalAttentionMultiHeadIntoRandomized(t *testing.T) {
 		values[i] = float32((i%13)-6) * 0.05
 	}
 
-	causalAttentionMultiHeadIntoGeneric(dst, scores, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
+	causalAttentionMultiHeadIntoGeneric(opts, dst, scores, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
 	for i := range dst {
 		if math.IsNaN(float64(dst[i])) || math.IsInf(float64(dst[i]), 0) {
 			t.Fatalf("non-finite attention output at %d: %v", i, dst[i])
@@ -1031,6 +990,7 @@ func TestCausalAttentionMultiHeadIntoRandomized(t *testing.T) {
 }
 
 func TestCausalAttentionMultiHeadIntoMatchesOptimized(t *testing.T) {
+	opts := testRuntimeOptions()
 	steps := 16
 	heads := 4
 	dim := 8
@@ -1050,8 +1010,8 @@ func TestCausalAttentionMultiHeadIntoMatchesOptimized(t *testing.T) {
 		values[i] = float32((i%19)-9) * 0.05
 	}
 
-	causalAttentionMultiHeadIntoGeneric(dstA, scoresA, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
-	causalAttentionMultiHeadIntoOptimized(dstB, scoresB, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
+	causalAttentionMultiHeadIntoGeneric(opts, dstA, scoresA, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
+	causalAttentionMultiHeadIntoOptimized(opts, dstB, scoresB, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
 	for i := range dstA {
 		diff := math.Abs(float64(dstA[i] - dstB[i]))
 		if diff > 1e-5 {
@@ -1061,6 +1021,7 @@ func TestCausalAttentionMultiHeadIntoMatchesOptimized(t *testing.T) {
 }
 
 func TestCausalAttentionMultiHeadIntoRowMajorMatchesGeneric(t *testing.T) {
+	opts := testRuntimeOptions()
 	steps := 16
 	heads := 4
 	dim := 8
@@ -1091,8 +1052,8 @@ func TestCausalAttentionMultiHeadIntoRowMajorMatchesGeneric(t *testing.T) {
 		}
 	}
 
-	causalAttentionMultiHeadIntoGeneric(dstA, scoresA, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
-	causalAttentionMultiHeadIntoRowMajor(dstB, scoresB, q, keys, valuesRow, steps, heads, heads, heads*dim, heads*dim, 0)
+	causalAttentionMultiHeadIntoGeneric(opts, dstA, scoresA, q, keys, values, steps, heads, heads, heads*dim, heads*dim, 0)
+	causalAttentionMultiHeadIntoRowMajor(opts, dstB, scoresB, q, keys, valuesRow, steps, heads, heads, heads*dim, heads*dim, 0)
 	for i := range dstA {
 		diff := math.Abs(float64(dstA[i] - dstB[i]))
 		if diff > 1e-5 {
@@ -1136,8 +1097,8 @@ func TestFFNActivateDispatchMatchesReference(t *testing.T) {
 	wantRelu2 := make([]float32, len(gate))
 	wantSilu := make([]float32, len(gate))
 
-	ffnActivateInto(gotRelu2, gate, up, false)
-	ffnActivateInto(gotSilu, gate, up, true)
+	ffnActivateInto(&RuntimeOptions{}, gotRelu2, gate, up, false)
+	ffnActivateInto(&RuntimeOptions{}, gotSilu, gate, up, true)
 	ffnActivateReference(wantRelu2, gate, up, false)
 	ffnActivateReference(wantSilu, gate, up, true)
 
@@ -1196,14 +1157,15 @@ func TestMatVec3F32ColMatches(t *testing.T) {
 }
 
 func TestSoftmaxInPlaceMatchesOpt(t *testing.T) {
+	opts := testRuntimeOptions()
 	scoresA := make([]float32, 16)
 	for i := range scoresA {
 		scoresA[i] = float32((i%9)-4) * 0.13
 	}
 	scoresB := append([]float32(nil), scoresA...)
 	maxScore := float32(0.2)
-	sumA := softmaxInPlaceGeneric(scoresA, maxScore)
-	sumB := softmaxInPlaceOpt(scoresB, maxScore)
+	sumA := softmaxInPlaceGeneric(opts, scoresA, maxScore)
+	sumB := softmaxInPlaceOpt(opts, scoresB, maxScore)
 	if math.Abs(float64(sumA-sumB)) > 1e-6 {
 		t.Fatalf("sum mismatch: %f vs %f", sumA, sumB)
 	}
@@ -1218,7 +1180,55 @@ func TestSoftmaxInPlaceMatchesOpt(t *testing.T) {
 	}
 }
 
+func TestTQ2LinearWeightLoadsPacked(t *testing.T) {
+	const rows, cols = 256, 3
+	// One TQ2_0 block per column: 64 bytes of 2-bit codes then an f16 scale.
+	raw := make([]byte, 0, cols*66)
+	for c := 0; c < cols; c++ {
+		blk := make([]byte, 66)
+		for i := 0; i < 64; i++ {
+			blk[i] = byte((i*7 + c*5) % 256)
+		}
+		blk[64], blk[65] = 0x00, 0x38 // 0.5
+		raw = append(raw, blk...)
+	}
+	path := rtWriteF32Model(t, "tq2.gguf", nil, []rtTensor{
+		{name: "w", dims: []uint64{rows, cols}, raw: raw, qtype: gguf.GGMLTypeTQ2_0},
+	})
+	info, err := gguf.ReadModelInfo(path)
+	if err != nil {
+		t.Fatalf("ReadModelInfo() error = %v", err)
+	}
+
+	x := rtPatternF32(rows, 3)
+	var outs [2][]float32
+	for i, tqFloat := range []bool{false, true} {
+		opts := testRuntimeOptions()
+		opts.TQFloat = tqFloat
+		loader, err := newModelTensorLoader(path, info, opts)
+		if err != nil {
+			t.Fatalf("newModelTensorLoader() error = %v", err)
+		}
+		w, err := loadLinearWeight(info, loader, "w", rows)
+		loader.close()
+		if err != nil {
+			t.Fatalf("loadLinearWeight(TQFloat=%v) error = %v", tqFloat, err)
+		}
+		if packed := len(w.tqPacked) > 0; packed == tqFloat || !w.transposed {
+			t.Fatalf("TQFloat=%v: packed=%v transposed=%v", tqFloat, packed, w.transposed)
+		}
+		outs[i] = make([]float32, cols)
+		linearApplyIntoWeight(opts, outs[i], w, x)
+	}
+	for c := 0; c < cols; c++ {
+		if diff := math.Abs(float64(outs[0][c] - outs[1][c])); diff > 0.02*math.Max(1, math.Abs(float64(outs[1][c]))) {
+			t.Fatalf("col %d packed = %f, f32 = %f", c, outs[0][c], outs[1][c])
+		}
+	}
+}
+
 func TestI2SLinearApplyUsesPacked(t *testing.T) {
+	opts := testRuntimeOptions()
 	rows, cols := 2, 3
 	vals := []int{1, -1, 0, 1, 1, 0}
 	packed := rtPackI2S(vals)
@@ -1232,7 +1242,7 @@ func TestI2SLinearApplyUsesPacked(t *testing.T) {
 	}
 	vec := []float32{2, -1, 0.5}
 	dst := make([]float32, rows)
-	linearApplyIntoWeight(dst, w, vec)
+	linearApplyIntoWeight(opts, dst, w, vec)
 	if math.Abs(float64(dst[0]-2.503937)) > 1e-6 {
 		t.Fatalf("dst[0] = %f, want 2.503937", dst[0])
 	}
@@ -1314,3 +1324,886 @@ func rtPadTo(t *testing.T, buf *bytes.Buffer, align int) {
 		t.Fatalf("Write(padding) error = %v", err)
 	}
 }
+
+func TestQuantizedKVAttentionMatchesF32(t *testing.T) {
+	opts := testRuntimeOptions()
+	const (
+		steps   = 5
+		qHeads  = 4
+		kvHeads = 2
+		headDim = 8
+	)
+	kdim := kvHeads * headDim
+	q := make([]float32, qHeads*headDim)
+	for i := range q {
+		q[i] = float32((i*7)%11-5) * 0.1
+	}
+	keys := make([]float32, steps*kdim)
+	valuesRow := make([]float32, steps*kdim)
+	keysF16 := make([]uint16, steps*kdim)
+	valuesF16 := make([]uint16, steps*kdim)
+	keysQ8 := make([]int8, steps*kdim)
+	valuesQ8 := make([]int8, steps*kdim)
+	keyScales := make([]float32, steps*kvHeads)
+	valueScales := make([]float32, steps*kvHeads)
+	k := make([]float32, kdim)
+	v := make([]float32, kdim)
+	for pos := 0; pos < steps; pos++ {
+		for i := range k {
+			k[i] = float32((pos*13+i*5)%17-8) * 0.05
+			v[i] = float32((pos*3+i*11)%19-9) * 0.07
+		}
+		storeCacheVector(keys, pos, k)
+		storeCacheVectorVRowMajor(valuesRow, pos, v, kvHeads)
+		storeCacheVectorF16(keysF16, pos, k)
+		storeCacheVectorF16(valuesF16, pos, v)
+		storeCacheVectorQ8(keysQ8, keyScales, pos, k, kvHeads)
+		storeCacheVectorQ8(valuesQ8, valueScales, pos, v, kvHeads)
+	}
+
+	want := make([]float32, len(q))
+	scores := make([]float32, steps*qHeads)
+	causalAttentionMultiHeadIntoRowMajor(opts, want, scores, q, keys, valuesRow, steps, qHeads, kvHeads, kdim, kdim, steps-1)
+
+	gotF16 := make([]float32, len(q))
+	causalAttentionMultiHeadIntoF16(opts, gotF16, scores, q, keysF16, valuesF16, steps, qHeads, kvHeads, kdim, kdim)
+	gotQ8 := make([]float32, len(q))
+	causalAttentionMultiHeadIntoQ8(opts, gotQ8, scores, q, keysQ8, valuesQ8, keyScales, valueScales, steps, qHeads, kvHeads, kdim, kdim)
+
+	for i := range want {
+		if d := math.Abs(float64(gotF16[i] - want[i])); d > 1e-3 {
+			t.Fatalf("f16 out[%d] = %f, want %f", i, gotF16[i], want[i])
+		}
+		if d := math.Abs(float64(gotQ8[i] - want[i])); d > 2e-2 {
+			t.Fatalf("q8 out[%d] = %f, want %f", i, gotQ8[i], want[i])
+		}
+	}
+}
+
+func TestNewWithOptionsKVCacheType(t *testing.T) {
+	modelPath := buildLlamaBlock0Model(t, true)
+
+	if _, err := NewWithOptions(context.Background(), modelPath, Options{KVCacheType: "bogus"}); err == nil {
+		t.Fatal("expected error for unknown kv cache type")
+	}
+	for _, kv := range []KVCacheType{KVCacheF32, KVCacheF16, KVCacheQ8} {
+		rt, err := NewWithOptions(context.Background(), modelPath, Options{KVCacheType: kv})
+		if err != nil {
+			t.Fatalf("NewWithOptions(%s) error = %v", kv, err)
+		}
+		if rt.kvCacheType != kv {
+			t.Fatalf("kvCacheType = %q, want %q", rt.kvCacheType, kv)
+		}
+		out, err := rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 5, MaxTokens: 4})
+		if err != nil {
+			t.Fatalf("Generate(%s) error = %v", kv, err)
+		}
+		if len(out.TokenIDs) != 4 {
+			t.Fatalf("Generate(%s) tokens = %d, want 4", kv, len(out.TokenIDs))
+		}
+	}
+
+	scratch := getLlamaRunScratch(&tensorBlock{
+		hiddenDim: 4,
+		attnHeads: 2,
+		kvHeads:   1,
+		layers: []llamaLayer{{
+			attnQ: linearWeight{rows: 4},
+			attnK: linearWeight{rows: 2},
+			attnV: linearWeight{rows: 2},
+		}},
+	}, 8, KVCacheQ8)
+	defer putLlamaRunScratch(scratch)
+	st := &scratch.layerState[0]
+	if st.keys != nil || len(st.keysQ8) == 0 {
+		t.Fatalf("q8 scratch kept f32 cache: keys=%d keysQ8=%d", len(st.keys), len(st.keysQ8))
+	}
+	if f32 := 4 * 8 * (2 + 2); kvCacheBytes(st) >= f32 {
+		t.Fatalf("kvCacheBytes = %d, want < %d", kvCacheBytes(st), f32)
+	}
+}
+
+func TestGenerateEnforcesContextLength(t *testing.T) {
+	modelPath := buildLlamaBlock0Model(t, true)
+
+	rt, err := NewWithOptions(context.Background(), modelPath, Options{ContextLength: 4})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	_, err = rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 5, MaxTokens: 8})
+	var ctxErr *ErrContextLength
+	if !errors.As(err, &ctxErr) {
+		t.Fatalf("Generate() error = %v, want ErrContextLength", err)
+	}
+	if ctxErr.ContextLength != 4 || ctxErr.MaxTokens != 8 {
+		t.Fatalf("unexpected context error: %+v", ctxErr)
+	}
+
+	shift := true
+	rt, err = NewWithOptions(context.Background(), modelPath, Options{ContextLength: 4, ContextShift: &shift, ContextKeep: 1})
+	if err != nil {
+		t.Fatalf("NewWithOptions(shift) error = %v", err)
+	}
+	out, err := rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 5, MaxTokens: 8})
+	if err != nil {
+		t.Fatalf("Generate(shift) error = %v", err)
+	}
+	if len(out.TokenIDs) != 8 {
+		t.Fatalf("Generate(shift) tokens = %d, want 8", len(out.TokenIDs))
+	}
+}
+
+func TestContextShiftOptionOverridesEnv(t *testing.T) {
+	modelPath := buildLlamaBlock0Model(t, true)
+	t.Setenv("BITNET_CONTEXT_SHIFT", "1")
+
+	off, on := false, true
+	for _, tc := range []struct {
+		name  string
+		shift *bool
+		want  bool
+	}{
+		{name: "env", shift: nil, want: true},
+		{name: "off", shift: &off, want: false},
+		{name: "on", shift: &on, want: true},
+	} {
+		rt, err := NewWithOptions(context.Background(), modelPath, Options{ContextLength: 4, ContextShift: tc.shift})
+		if err != nil {
+			t.Fatalf("%s: NewWithOptions() error = %v", tc.name, err)
+		}
+		if rt.contextShift != tc.want {
+			t.Fatalf("%s: contextShift = %v, want %v", tc.name, rt.contextShift, tc.want)
+		}
+	}
+
+	rt, err := NewWithOptions(context.Background(), modelPath, Options{ContextLength: 4, ContextShift: &off})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	_, err = rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 5, MaxTokens: 8})
+	var ctxErr *ErrContextLength
+	if !errors.As(err, &ctxErr) {
+		t.Fatalf("Generate() error = %v, want ErrContextLength despite BITNET_CONTEXT_SHIFT=1", err)
+	}
+}
+
+func TestShiftLlamaKVCacheRerotatesKeys(t *testing.T) {
+	const (
+		kvHeads = 2
+		headDim = 4
+		maxSeq  = 6
+		used    = 6
+		keep    = 1
+		discard = 2
+	)
+	kdim := kvHeads * headDim
+	block := &tensorBlock{kvHeads: kvHeads, ropeFreqBase: 10000, ropeScale: 1}
+	raw := func(p int) []float32 {
+		v := make([]float32, kdim)
+		for i := range v {
+			v[i] = float32((p*5+i*3)%7-3) * 0.25
+		}
+		return v
+	}
+	rope := func(v []float32, p int) []float32 {
+		out := append([]float32(nil), v...)
+		applyRoPEInPlace(out, p, kvHeads, block.ropeFreqBase, block.ropeScale, "", 0, false, 0, 0, 0, 0)
+		return out
+	}
+	for _, rowMajor := range []bool{true, false} {
+		st := llamaLayerState{
+			k:      make([]float32, kdim),
+			v:      make([]float32, kdim),
+			keys:   make([]float32, maxSeq*kdim),
+			values: make([]float32, maxSeq*kdim),
+			kvType: KVCacheF32,
+		}
+		for p := 0; p < used; p++ {
+			storeCacheVector(st.keys, p, rope(raw(p), p))
+			if rowMajor {
+				storeCacheVectorVRowMajor(st.values, p, raw(p), kvHeads)
+			} else {
+				storeCacheVectorVGeneric(st.values, p, raw(p), kvHeads)
+			}
+		}
+		shiftLlamaKVCache(block, &st, keep, discard, used, rowMajor)
+		for p := 0; p < used-discard; p++ {
+			src := p
+			if p >= keep {
+				src = p + discard
+			}
+			want := rope(raw(src), p)
+			got := st.keys[p*kdim : (p+1)*kdim]
+			for i := range want {
+				if math.Abs(float64(got[i]-want[i])) > 1e-5 {
+					t.Fatalf("rowMajor=%v key[%d][%d] = %f, want %f", rowMajor, p, i, got[i], want[i])
+				}
+			}
+			gotV := loadCacheVectorV(st.values, p, kdim, kvHeads, rowMajor)
+			for i, w := range raw(src) {
+				if gotV[i] != w {
+					t.Fatalf("rowMajor=%v value[%d][%d] = %f, want %f", rowMajor, p, i, gotV[i], w)
+				}
+			}
+		}
+	}
+}
+
+type rtTensor struct {
+	name string
+	dims []uint64
+	data []float32
+	// raw, if set, is written as-is with type qtype instead of data.
+	raw   []byte
+	qtype uint32
+}
+
+// rtWriteF32Model writes a GGUF v3 file with f32 tensors, or raw tensors of
+// another type. kvs values may be string, uint32, float32 or []string.
+func rtWriteF32Model(t *testing.T, name string, kvs [][2]any, tensors []rtTensor) string {
+	t.Helper()
+	const alignBytes = 32
+
+	buf := bytes.NewBuffer(nil)
+	rtWriteString(t, buf, "GGUF")
+	rtWriteU32(t, buf, 3)
+	rtWriteU64(t, buf, uint64(len(tensors)))
+	rtWriteU64(t, buf, uint64(len(kvs)+1))
+
+	rtWriteGGUFString(t, buf, "general.alignment")
+	rtWriteU32(t, buf, 4)
+	rtWriteU32(t, buf, alignBytes)
+	for _, kv := range kvs {
+		rtWriteGGUFString(t, buf, kv[0].(string))
+		switch v := kv[1].(type) {
+		case string:
+			rtWriteU32(t, buf, 8)
+			rtWriteGGUFString(t, buf, v)
+		case uint32:
+			rtWriteU32(t, buf, 4)
+			rtWriteU32(t, buf, v)
+		case float32:
+			rtWriteU32(t, buf, 6)
+			rtWriteF32(t, buf, v)
+		case []string:
+			rtWriteU32(t, buf, 9)
+			rtWriteU32(t, buf, 8)
+			rtWriteU64(t, buf, uint64(len(v)))
+			for _, e := range v {
+				rtWriteGGUFString(t, buf, e)
+			}
+		default:
+			t.Fatalf("unsupported kv type %T for %v", kv[1], kv[0])
+		}
+	}
+
+	offset := uint64(0)
+	for _, ts := range tensors {
+		rtWriteGGUFString(t, buf, ts.name)
+		rtWriteU32(t, buf, uint32(len(ts.dims)))
+		for _, d := range ts.dims {
+			rtWriteU64(t, buf, d)
+		}
+		if ts.raw != nil {
+			rtWriteU32(t, buf, ts.qtype)
+			rtWriteU64(t, buf, offset)
+			offset += uint64(len(ts.raw))
+			continue
+		}
+		rtWriteU32(t, buf, 0) // f32
+		rtWriteU64(t, buf, offset)
+		offset += uint64(len(ts.data) * 4)
+	}
+	rtPadTo(t, buf, alignBytes)
+	for _, ts := range tensors {
+		if ts.raw != nil {
+			buf.Write(ts.raw)
+			continue
+		}
+		for _, v := range ts.data {
+			rtWriteF32(t, buf, v)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+// rtPatternF32 returns n deterministic small values.
+func rtPatternF32(n, seed int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float32((i*7+seed*13)%11-5) * 0.07
+	}
+	return out
+}
+
+func buildFalconModel(t *testing.T) string {
+	t.Helper()
+	const (
+		hidden = 4
+		vocab  = 8
+		ffn    = 8
+		kvDim  = 2 // 2 heads of dim 2, 1 kv head
+	)
+	ones := []float32{1, 1, 1, 1}
+	tensors := []rtTensor{
+		{name: "token_embd.weight", dims: []uint64{hidden, vocab}, data: rtPatternF32(hidden*vocab, 1)},
+		{name: "output_norm.weight", dims: []uint64{hidden}, data: ones},
+		{name: "output_norm.bias", dims: []uint64{hidden}, data: []float32{0.1, 0, -0.1, 0}},
+		{name: "output.weight", dims: []uint64{hidden, vocab}, data: rtPatternF32(hidden*vocab, 2)},
+	}
+	for l := 0; l < 2; l++ {
+		p := fmt.Sprintf("blk.%d.", l)
+		tensors = append(tensors,
+			rtTensor{name: p + "attn_norm.weight", dims: []uint64{hidden}, data: ones},
+			rtTensor{name: p + "attn_norm.bias", dims: []uint64{hidden}, data: []float32{0, 0.05, 0, -0.05}},
+			rtTensor{name: p + "attn_qkv.weight", dims: []uint64{hidden, hidden + 2*kvDim}, data: rtPatternF32(hidden*(hidden+2*kvDim), 3+l)},
+			rtTensor{name: p + "attn_output.weight", dims: []uint64{hidden, hidden}, data: rtPatternF32(hidden*hidden, 5+l)},
+			rtTensor{name: p + "ffn_up.weight", dims: []uint64{hidden, ffn}, data: rtPatternF32(hidden*ffn, 7+l)},
+			rtTensor{name: p + "ffn_down.weight", dims: []uint64{ffn, hidden}, data: rtPatternF32(ffn*hidden, 9+l)},
+		)
+	}
+	return rtWriteF32Model(t, "falcon.gguf", [][2]any{
+		{"general.architecture", "falcon"},
+		{"falcon.context_length", uint32(64)},
+		{"falcon.attention.head_count", uint32(2)},
+		{"falcon.attention.head_count_kv", uint32(1)},
+		{"falcon.attention.layer_norm_epsilon", float32(1e-5)},
+	}, tensors)
+}
+
+func TestGenerateUsesFalconStack(t *testing.T) {
+	modelPath := buildFalconModel(t)
+
+	rt, err := New(context.Background(), modelPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if rt.block == nil || rt.block.arch != (falconArchitecture{}) {
+		t.Fatalf("expected falcon block, got %+v", rt.block)
+	}
+	if len(rt.block.falconLayers) != 2 {
+		t.Fatalf("len(falconLayers) = %d, want 2", len(rt.block.falconLayers))
+	}
+	if rt.meta.ContextLength != 64 {
+		t.Fatalf("ContextLength = %d, want 64", rt.meta.ContextLength)
+	}
+
+	a, err := rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 3, MaxTokens: 5})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	b, err := rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 3, MaxTokens: 5})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !slices.Equal(a.TokenIDs, b.TokenIDs) {
+		t.Fatalf("falcon generation not deterministic: %v vs %v", a.TokenIDs, b.TokenIDs)
+	}
+}
+
+func TestFalconStepMatchesReference(t *testing.T) {
+	opts := testRuntimeOptions()
+	rt, err := New(context.Background(), buildFalconModel(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	block := rt.block
+	states := make([]llamaLayerState, len(block.falconLayers))
+	for i := range states {
+		ensureFalconLayerState(&states[i], block.falconLayers[i], block.hiddenDim, 4, block.attnHeads, block.kvHeads, KVCacheF32)
+	}
+	h := block.hiddenDim
+	x := make([]float32, h)
+	logits := make([]float32, block.vocabDim)
+	const token = 3
+	runFalconStep(block, states, token, 0, x, make([]float32, h), make([]float32, h), logits, true)
+
+	// At position 0 attention returns V for every query head sharing the kv head.
+	ref := make([]float32, h)
+	embedToken(ref, block, token)
+	for _, layer := range block.falconLayers {
+		n := make([]float32, h)
+		kernels.LayerNormInto(n, ref, layer.attnNorm, layer.attnNormBias, block.rmsEps)
+		qkv := make([]float32, layer.qDim+2*layer.kvDim)
+		linearApplyIntoWeight(opts, qkv, layer.attnQKV, n)
+		v := qkv[layer.qDim+layer.kvDim:]
+		attn := append(append([]float32(nil), v...), v...)
+		attnOut := make([]float32, h)
+		linearApplyIntoWeight(opts, attnOut, layer.attnOut, attn)
+		up := make([]float32, linearOutputLen(layer.ffnUp))
+		linearApplyIntoWeight(opts, up, layer.ffnUp, n)
+		kernels.GeluInto(up, up)
+		down := make([]float32, h)
+		linearApplyIntoWeight(opts, down, layer.ffnDown, up)
+		for i := range ref {
+			ref[i] += attnOut[i] + down[i]
+		}
+	}
+	for i := range ref {
+		if math.Abs(float64(ref[i]-x[i])) > 1e-5 {
+			t.Fatalf("hidden[%d] = %f, want %f", i, x[i], ref[i])
+		}
+	}
+}
+
+func buildQwen2Model(t *testing.T) string {
+	t.Helper()
+	return rtWriteF32Model(t, "qwen2.gguf", qwen2ModelKVs, qwen2ModelTensors())
+}
+
+var qwen2ModelKVs = [][2]any{
+	{"general.architecture", "qwen2"},
+	{"qwen2.context_length", uint32(128)},
+	{"qwen2.attention.head_count", uint32(2)},
+	{"qwen2.attention.head_count_kv", uint32(1)},
+	{"qwen2.attention.layer_norm_rms_epsilon", float32(1e-6)},
+	{"qwen2.rope.freq_base", float32(1000000)},
+}
+
+func qwen2ModelTensors() []rtTensor {
+	const (
+		hidden = 4
+		vocab  = 8
+		ffn    = 6
+		kvDim  = 2 // 2 heads of dim 2, 1 kv head
+	)
+	ones := []float32{1, 1, 1, 1}
+	// No output.weight: small Qwen2 variants tie the LM head to token_embd.
+	tensors := []rtTensor{
+		{name: "token_embd.weight", dims: []uint64{hidden, vocab}, data: rtPatternF32(hidden*vocab, 1)},
+		{name: "output_norm.weight", dims: []uint64{hidden}, data: ones},
+	}
+	for l := 0; l < 2; l++ {
+		p := fmt.Sprintf("blk.%d.", l)
+		tensors = append(tensors,
+			rtTensor{name: p + "attn_norm.weight", dims: []uint64{hidden}, data: ones},
+			rtTensor{name: p + "attn_q.weight", dims: []uint64{hidden, hidden}, data: rtPatternF32(hidden*hidden, 2+l)},
+			rtTensor{name: p + "attn_q.bias", dims: []uint64{hidden}, data: []float32{0.3, -0.2, 0.1, 0.4}},
+			rtTensor{name: p + "attn_k.weight", dims: []uint64{hidden, kvDim}, data: rtPatternF32(hidden*kvDim, 3+l)},
+			rtTensor{name: p + "attn_k.bias", dims: []uint64{kvDim}, data: []float32{-0.5, 0.25}},
+			rtTensor{name: p + "attn_v.weight", dims: []uint64{hidden, kvDim}, data: rtPatternF32(hidden*kvDim, 4+l)},
+			rtTensor{name: p + "attn_v.bias", dims: []uint64{kvDim}, data: []float32{0.6, -0.3}},
+			rtTensor{name: p + "attn_output.weight", dims: []uint64{hidden, hidden}, data: rtPatternF32(hidden*hidden, 5+l)},
+			rtTensor{name: p + "ffn_norm.weight", dims: []uint64{hidden}, data: ones},
+			rtTensor{name: p + "ffn_gate.weight", dims: []uint64{hidden, ffn}, data: rtPatternF32(hidden*ffn, 6+l)},
+			rtTensor{name: p + "ffn_up.weight", dims: []uint64{hidden, ffn}, data: rtPatternF32(hidden*ffn, 7+l)},
+			rtTensor{name: p + "ffn_down.weight", dims: []uint64{ffn, hidden}, data: rtPatternF32(ffn*hidden, 8+l)},
+		)
+	}
+	return tensors
+}
+
+func TestGenerateUsesQwen2Stack(t *testing.T) {
+	rt, err := New(context.Background(), buildQwen2Model(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	b := rt.block
+	if qwen2, _ := lookupArchitecture("qwen2"); b == nil || b.arch != qwen2 {
+		t.Fatalf("expected llama stack block for qwen2, got %+v", b)
+	}
+	if !b.ropeNeox || !b.ffnUseSilu {
+		t.Fatalf("qwen2 should use NEOX RoPE and SiLU FFN: neox=%v silu=%v", b.ropeNeox, b.ffnUseSilu)
+	}
+	if b.ropeFreqBase != 1000000 || b.rmsEps != 1e-6 || b.attnHeads != 2 || b.kvHeads != 1 {
+		t.Fatalf("qwen2 metadata not applied: base=%g eps=%g heads=%d kv=%d", b.ropeFreqBase, b.rmsEps, b.attnHeads, b.kvHeads)
+	}
+	if rt.meta.ContextLength != 128 {
+		t.Fatalf("ContextLength = %d, want 128", rt.meta.ContextLength)
+	}
+	if !b.outputTransposed || len(b.outputWeight) != len(b.tokenEmbd) {
+		t.Fatal("expected output weights tied to token_embd")
+	}
+	if len(b.layers[0].attnQBias) != 4 || len(b.layers[0].attnKBias) != 2 || len(b.layers[0].attnVBias) != 2 {
+		t.Fatal("expected q/k/v biases to be loaded")
+	}
+
+	logits := func() []float32 {
+		states := make([]llamaLayerState, len(b.layers))
+		for i := range states {
+			ensureLlamaLayerState(&states[i], b.layers[i], b.hiddenDim, 2, b.attnHeads, b.kvHeads, KVCacheF32)
+		}
+		h := b.hiddenDim
+		out := make([]float32, b.vocabDim)
+		runLlamaStackStep(b, states, 2, 0, make([]float32, h), make([]float32, h), make([]float32, h), nil, false)
+		runLlamaStackStep(b, states, 5, 1, make([]float32, h), make([]float32, h), make([]float32, h), out, true)
+		return out
+	}
+	withBias := logits()
+	for i := range b.layers {
+		b.layers[i].attnKBias = nil
+		b.layers[i].attnVBias = nil
+	}
+	if slices.Equal(withBias, logits()) {
+		t.Fatal("q/k/v biases should affect qwen2 logits")
+	}
+
+	if _, err := rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 1, MaxTokens: 3}); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+}
+
+func TestNewRejectsTLWeights(t *testing.T) {
+	for _, typ := range []uint32{gguf.GGMLTypeTL1, gguf.GGMLTypeTL2} {
+		tensors := qwen2ModelTensors()
+		for i := range tensors {
+			if tensors[i].name == "blk.0.attn_q.weight" {
+				// Upstream's converter writes the tiled 2-bit codes followed
+				// by one f32 scale.
+				raw := bytes.Repeat([]byte{0x5a}, len(tensors[i].data)/4)
+				tensors[i] = rtTensor{name: tensors[i].name, dims: tensors[i].dims, raw: binary.LittleEndian.AppendUint32(raw, math.Float32bits(0.5)), qtype: typ}
+			}
+		}
+		name := gguf.TensorTypeString(typ)
+		_, err := New(context.Background(), rtWriteF32Model(t, "qwen2-"+name+".gguf", qwen2ModelKVs, tensors))
+		if err == nil || !strings.Contains(err.Error(), "blk.0.attn_q.weight") || !strings.Contains(err.Error(), "tile sizes") {
+			t.Fatalf("New(%s model) error = %v, want tensor type rejection", name, err)
+		}
+	}
+}
+
+func TestNewRejectsUnknownPreTokenizer(t *testing.T) {
+	withVocab := func(pre string) [][2]any {
+		return append(slices.Clone(qwen2ModelKVs),
+			[2]any{"tokenizer.ggml.model", "gpt2"},
+			[2]any{"tokenizer.ggml.pre", pre},
+			[2]any{"tokenizer.ggml.tokens", []string{"h", "e", "l", "o", "Ġ", "he", "ll", "hell"}},
+		)
+	}
+
+	_, err := New(context.Background(), rtWriteF32Model(t, "qwen2-bad-pre.gguf", withVocab("no-such-pre"), qwen2ModelTensors()))
+	if err == nil || !strings.Contains(err.Error(), `unsupported tokenizer.ggml.pre "no-such-pre"`) {
+		t.Fatalf("New() error = %v, want unsupported tokenizer.ggml.pre", err)
+	}
+
+	rt, err := New(context.Background(), rtWriteF32Model(t, "qwen2-pre.gguf", withVocab("qwen2"), qwen2ModelTensors()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if rt.tokenizer == nil {
+		t.Fatal("expected tokenizer to be loaded")
+	}
+	if got := rt.tokenizer.Tokenize("hello"); len(got) == 0 {
+		t.Fatal("Tokenize(hello) = empty")
+	}
+}
+
+func buildGPT2Model(t *testing.T) string {
+	t.Helper()
+	const (
+		hidden = 4
+		vocab  = 8
+		ffn    = 8
+		nPos   = 16
+	)
+	ones := []float32{1, 1, 1, 1}
+	tensors := []rtTensor{
+		{name: "token_embd.weight", dims: []uint64{hidden, vocab}, data: rtPatternF32(hidden*vocab, 1)},
+		{name: "position_embd.weight", dims: []uint64{hidden, nPos}, data: rtPatternF32(hidden*nPos, 2)},
+		{name: "output_norm.weight", dims: []uint64{hidden}, data: ones},
+		{name: "output_norm.bias", dims: []uint64{hidden}, data: []float32{0, 0.1, 0, -0.1}},
+	}
+	for l := 0; l < 2; l++ {
+		p := fmt.Sprintf("blk.%d.", l)
+		tensors = append(tensors,
+			rtTensor{name: p + "attn_norm.weight", dims: []uint64{hidden}, data: ones},
+			rtTensor{name: p + "attn_norm.bias", dims: []uint64{hidden}, data: rtPatternF32(hidden, 3+l)},
+			rtTensor{name: p + "attn_qkv.weight", dims: []uint64{hidden, 3 * hidden}, data: rtPatternF32(hidden*3*hidden, 4+l)},
+			rtTensor{name: p + "attn_qkv.bias", dims: []uint64{3 * hidden}, data: rtPatternF32(3*hidden, 5+l)},
+			rtTensor{name: p + "attn_output.weight", dims: []uint64{hidden, hidden}, data: rtPatternF32(hidden*hidden, 6+l)},
+			rtTensor{name: p + "attn_output.bias", dims: []uint64{hidden}, data: rtPatternF32(hidden, 7+l)},
+			rtTensor{name: p + "ffn_norm.weight", dims: []uint64{hidden}, data: ones},
+			rtTensor{name: p + "ffn_norm.bias", dims: []uint64{hidden}, data: rtPatternF32(hidden, 8+l)},
+			rtTensor{name: p + "ffn_up.weight", dims: []uint64{hidden, ffn}, data: rtPatternF32(hidden*ffn, 9+l)},
+			rtTensor{name: p + "ffn_up.bias", dims: []uint64{ffn}, data: rtPatternF32(ffn, 10+l)},
+			rtTensor{name: p + "ffn_down.weight", dims: []uint64{ffn, hidden}, data: rtPatternF32(ffn*hidden, 11+l)},
+			rtTensor{name: p + "ffn_down.bias", dims: []uint64{hidden}, data: rtPatternF32(hidden, 12+l)},
+		)
+	}
+	return rtWriteF32Model(t, "gpt2.gguf", [][2]any{
+		{"general.architecture", "gpt2"},
+		{"gpt2.context_length", uint32(nPos)},
+		{"gpt2.attention.head_count", uint32(2)},
+		{"gpt2.attention.layer_norm_epsilon", float32(1e-5)},
+	}, tensors)
+}
+
+func TestGenerateUsesGPT2Stack(t *testing.T) {
+	opts := testRuntimeOptions()
+	modelPath := buildGPT2Model(t)
+	rt, err := New(context.Background(), modelPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	b := rt.block
+	if b == nil || b.arch != (gpt2Architecture{}) {
+		t.Fatalf("expected gpt2 block, got %+v", b)
+	}
+	if len(b.gpt2Layers) != 2 || b.positionEmbdRows != 16 {
+		t.Fatalf("gpt2 layers=%d position rows=%d", len(b.gpt2Layers), b.positionEmbdRows)
+	}
+
+	// At position 0 attention returns V, so one step is fully determined by
+	// the embeddings, LayerNorms and biased projections.
+	states := make([]llamaLayerState, len(b.gpt2Layers))
+	for i, l := range b.gpt2Layers {
+		ensureFusedQKVLayerState(&states[i], l.qDim, l.qDim, linearOutputLen(l.ffnUp), b.hiddenDim, 4, b.attnHeads, b.kvHeads, KVCacheF32)
+	}
+	h := b.hiddenDim
+	x := make([]float32, h)
+	if err := runGPT2Step(b, states, 3, 0, x, make([]float32, h), make([]float32, h), nil, false); err != nil {
+		t.Fatalf("runGPT2Step() error = %v", err)
+	}
+
+	ref := make([]float32, h)
+	embedToken(ref, b, 3)
+	kernels.AddScaled(ref, b.positionEmbd[:h], 1)
+	for _, l := range b.gpt2Layers {
+		n := make([]float32, h)
+		kernels.LayerNormInto(n, ref, l.attnNorm, l.attnNormBias, b.rmsEps)
+		qkv := make([]float32, 3*h)
+		linearApplyIntoWeight(opts, qkv, l.attnQKV, n)
+		addBiasInPlace(qkv, l.attnQKVBias)
+		o := make([]float32, h)
+		linearApplyIntoWeight(opts, o, l.attnOut, qkv[2*h:])
+		addBiasInPlace(o, l.attnOutBias)
+		kernels.AddScaled(ref, o, 1)
+		kernels.LayerNormInto(n, ref, l.ffnNorm, l.ffnNormBias, b.rmsEps)
+		up := make([]float32, linearOutputLen(l.ffnUp))
+		linearApplyIntoWeight(opts, up, l.ffnUp, n)
+		addBiasInPlace(up, l.ffnUpBias)
+		kernels.GeluInto(up, up)
+		down := make([]float32, h)
+		linearApplyIntoWeight(opts, down, l.ffnDown, up)
+		addBiasInPlace(down, l.ffnDownBias)
+		kernels.AddScaled(ref, down, 1)
+	}
+	for i := range ref {
+		if math.Abs(float64(ref[i]-x[i])) > 1e-5 {
+			t.Fatalf("hidden[%d] = %f, want %f", i, x[i], ref[i])
+		}
+	}
+
+	_, err = rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 1, MaxTokens: 32})
+	var ctxErr *ErrContextLength
+	if !errors.As(err, &ctxErr) || ctxErr.ContextLength != 16 {
+		t.Fatalf("Generate() past position_embd rows error = %v, want ErrContextLength of 16", err)
+	}
+	shift := true
+	if _, err := NewWithOptions(context.Background(), modelPath, Options{ContextShift: &shift, ContextKeep: 1}); err == nil || !strings.Contains(err.Error(), "context shift") {
+		t.Fatalf("NewWithOptions(shift) error = %v, want context shift refusal", err)
+	}
+	if _, err := NewWithOptions(context.Background(), modelPath, Options{ContextLength: 32}); err == nil {
+		t.Fatal("NewWithOptions(ContextLength past position_embd rows) error = nil")
+	}
+	// Without the window check in front, the step stops at the first position
+	// past the table instead of reading out of range.
+	out := make([]int32, 20)
+	if err := runForwardTensorBlock(b, 1, []int32{3}, out, nil, nil, samplingConfig{}, forwardOptions{}); err == nil || !strings.Contains(err.Error(), "position 16") {
+		t.Fatalf("runForwardTensorBlock() past position_embd rows error = %v, want position 16 error", err)
+	}
+}
+
+func TestNewRejectsUnsupportedArchitecture(t *testing.T) {
+	path := rtWriteF32Model(t, "mamba.gguf", [][2]any{
+		{"general.architecture", "mamba"},
+	}, []rtTensor{
+		{name: "token_embd.weight", dims: []uint64{2, 3}, data: rtPatternF32(6, 1)},
+	})
+	_, err := New(context.Background(), path)
+	var unsupported *ErrUnsupportedArchitecture
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("New() error = %v, want ErrUnsupportedArchitecture", err)
+	}
+	if unsupported.Architecture != "mamba" || !slices.Contains(unsupported.Supported, "llama") {
+		t.Fatalf("unexpected error contents: %+v", unsupported)
+	}
+}
+
+func TestNewRejectsArchitectureWithoutLayers(t *testing.T) {
+	for _, arch := range []string{"llama", "falcon", "gpt2"} {
+		path := rtWriteF32Model(t, arch+"-nolayers.gguf", [][2]any{
+			{"general.architecture", arch},
+		}, []rtTensor{
+			{name: "token_embd.weight", dims: []uint64{2, 3}, data: rtPatternF32(6, 1)},
+			{name: "output.weight", dims: []uint64{2, 3}, data: rtPatternF32(6, 2)},
+		})
+		if _, err := New(context.Background(), path); err == nil || !strings.Contains(err.Error(), "missing blk.0") {
+			t.Fatalf("New(%s) error = %v, want missing layer tensors", arch, err)
+		}
+	}
+}
+
+func TestArchitectureRegistry(t *testing.T) {
+	for _, name := range []string{"llama", "bitnet-b1.58", "bitnet", "bitnet-25", "qwen2", "falcon", "gpt2"} {
+		if _, ok := lookupArchitecture(name); !ok {
+			t.Fatalf("architecture %q not registered", name)
+		}
+	}
+	if _, ok := lookupArchitecture("mamba"); ok {
+		t.Fatal("unexpected mamba registration")
+	}
+	names := registeredArchitectures()
+	if !slices.IsSorted(names) {
+		t.Fatalf("registeredArchitectures() not sorted: %v", names)
+	}
+
+	rt, err := New(context.Background(), buildGPT2Model(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, ok := rt.block.arch.(gpt2Architecture); !ok {
+		t.Fatalf("block.arch = %T, want gpt2Architecture", rt.block.arch)
+	}
+}
+
+func testRuntimeOptions() *RuntimeOptions {
+	o := DefaultRuntimeOptions()
+	return &o
+}
+
+func TestTracerReceivesForwardStages(t *testing.T) {
+	rec := &TraceRecorder{}
+	opts := DefaultRuntimeOptions()
+	opts.Tracer = rec
+	rt, err := NewWithOptions(context.Background(), buildLlamaBlock0Model(t, false), Options{Runtime: &opts})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	out, err := rt.Generate(context.Background(), GenerateRequest{Prompt: "hello", Seed: 1, MaxTokens: 2, DisableTopKCapture: true})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	for _, stage := range []TraceStage{TraceAttnNorm, TraceQ, TraceK, TraceV, TraceQRoPE, TraceKRoPE, TraceAttnOut, TraceFFNNorm, TraceFFNGate, TraceFFNUp, TraceFFNDown} {
+		ev, ok := rec.Find(stage, 0, 0)
+		if !ok {
+			t.Fatalf("missing %s event for layer 0 pos 0", stage)
+		}
+		if len(ev.Values) == 0 {
+			t.Fatalf("%s event has no values", stage)
+		}
+	}
+	if _, ok := rec.Find(TraceEmbed, -1, 0); !ok {
+		t.Fatal("missing embed event")
+	}
+
+	// The last logits event drives the final greedy token.
+	var last TraceEvent
+	for _, ev := range rec.Events() {
+		if ev.Stage == TraceLogits {
+			last = ev
+		}
+	}
+	if len(last.Values) != rt.block.vocabDim {
+		t.Fatalf("logits values len = %d, want %d", len(last.Values), rt.block.vocabDim)
+	}
+	if got := int32(kernels.Argmax(last.Values)); got != out.TokenIDs[len(out.TokenIDs)-1] {
+		t.Fatalf("traced logits argmax = %d, want %d", got, out.TokenIDs[len(out.TokenIDs)-1])
+	}
+}
+
+func TestJSONLTracerRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewJSONLTracer(&buf)
+	tr.Trace(TraceEvent{Stage: TraceQ, Layer: 2, Pos: 5, Token: 9, Values: []float32{1.5, -2}})
+	tr.Trace(TraceEvent{Stage: TraceLogits, Layer: -1, Pos: 5, Token: 9, Values: []float32{0.25}})
+	if err := tr.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 2 {
+		t.Fatalf("lines = %d, want 2", n)
+	}
+	events, err := ReadTraceJSONL(&buf)
+	if err != nil {
+		t.Fatalf("ReadTraceJSONL() error = %v", err)
+	}
+	if len(events) != 2 || events[0].Stage != TraceQ || events[0].Layer != 2 || events[0].Pos != 5 || events[0].Token != 9 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if !slices.Equal(events[0].Values, []float32{1.5, -2}) {
+		t.Fatalf("values = %v", events[0].Values)
+	}
+}
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	generate []GenerateStats
+	steps    []StepStats
+	kvInUse  int64
+	kvPeak   int64
+}
+
+func (o *recordingObserver) ObserveGenerate(s GenerateStats) {
+	o.mu.Lock()
+	o.generate = append(o.generate, s)
+	o.mu.Unlock()
+}
+
+func (o *recordingObserver) ObserveStep(s StepStats) {
+	o.mu.Lock()
+	o.steps = append(o.steps, s)
+	o.mu.Unlock()
+}
+
+func (o *recordingObserver) ObserveKVCache(delta int64) {
+	o.mu.Lock()
+	o.kvInUse += delta
+	if o.kvInUse > o.kvPeak {
+		o.kvPeak = o.kvInUse
+	}
+	o.mu.Unlock()
+}
+
+func TestObserverReceivesGenerateStats(t *testing.T) {
+	obs := &recordingObserver{}
+	opts := DefaultRuntimeOptions()
+	opts.Observer = obs
+	opts.PromptCacheCap = 4
+	rt, err := NewWithOptions(context.Background(), buildLlamaBlock0Model(t, false), Options{Runtime: &opts})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	tok, err := tokenizer.NewFromModelInfo(gguf.ModelInfo{KeyValues: map[string]any{
+		"tokenizer.ggml.model":  "llama",
+		"tokenizer.ggml.tokens": []string{"<unk>", "h", "e", "l", "o", "\u2581"},
+	}})
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	rt.tokenizer = tok
+	req := GenerateRequest{Prompt: "hello", Seed: 1, MaxTokens: 3, DisableTopKCapture: true}
+	for i := 0; i < 2; i++ {
+		if _, err := rt.Generate(context.Background(), req); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+	}
+
+	if len(obs.generate) != 2 {
+		t.Fatalf("generate stats = %d, want 2", len(obs.generate))
+	}
+	first, second := obs.generate[0], obs.generate[1]
+	if first.GeneratedTokens != 3 || first.PromptTokens == 0 {
+		t.Fatalf("first stats tokens = %d/%d", first.PromptTokens, first.GeneratedTokens)
+	}
+	if first.PromptCache != CacheMiss || second.PromptCache != CacheHit {
+		t.Fatalf("prompt cache = %v then %v, want miss then hit", first.PromptCache, second.PromptCache)
+	}
+	if first.KVCacheBytes <= 0 || obs.kvPeak != first.KVCacheBytes {
+		t.Fatalf("kv bytes = %d peak = %d", first.KVCacheBytes, obs.kvPeak)
+	}
+	if obs.kvInUse != 0 {
+		t.Fatalf("kv in use after requests = %d, want 0", obs.kvInUse)
+	}
+	if first.FirstToken < first.Prefill || first.Total < first.FirstToken+first.Decode {
+		t.Fatalf("inconsistent timings: %+v", first)
+	}
+	if len(obs.steps) != 2*req.MaxTokens {
+		t.Fatalf("step stats = %d, want %d", len(obs.steps), 2*req.MaxTokens)
+	}
+}
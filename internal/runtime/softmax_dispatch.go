@@ -4,16 +4,19 @@ import "math"
 
 var softmaxInPlaceImpl = softmaxInPlaceGeneric
 
-func softmaxInPlace(scores []float32, maxScore float32) float32 {
-	return softmaxInPlaceImpl(scores, maxScore)
+func softmaxInPlace(opts *RuntimeOptions, scores []float32, maxScore float32) float32 {
+	if opts.ParityStrict {
+		return softmaxInPlaceGeneric(opts, scores, maxScore)
+	}
+	return softmaxInPlaceImpl(opts, scores, maxScore)
 }
 
-func softmaxInPlaceGeneric(scores []float32, maxScore float32) float32 {
+func softmaxInPlaceGeneric(opts *RuntimeOptions, scores []float32, maxScore float32) float32 {
 	var sum float32
 	for i := range scores {
 		diff := scores[i] - maxScore
 		var w float32
-		if strictExpfEnabledForCurrentLayer() || debugFastExpf {
+		if opts.StrictExpf || opts.FastExpf {
 			w = expf32(diff)
 		} else {
 			w = float32(math.Exp(float64(diff)))
@@ -2,9 +2,9 @@ package runtime
 
 import "math"
 
-func softmaxInPlaceOpt(scores []float32, maxScore float32) float32 {
+func softmaxInPlaceOpt(opts *RuntimeOptions, scores []float32, maxScore float32) float32 {
 	n := len(scores)
-	if debugStrictExpf || debugFastExpf {
+	if opts.StrictExpf || opts.FastExpf {
 		var sum0, sum1, sum2, sum3 float32
 		var sum4, sum5, sum6, sum7 float32
 		i := 0
@@ -0,0 +1,153 @@
+package runtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// TraceStage names a point in the forward pass reported to a Tracer.
+type TraceStage string
+
+const (
+	TraceEmbed      TraceStage = "embed"
+	TraceAttnNorm   TraceStage = "attn_norm"
+	TraceQ          TraceStage = "q"
+	TraceK          TraceStage = "k"
+	TraceV          TraceStage = "v"
+	TraceQRoPE      TraceStage = "q_rope"
+	TraceKRoPE      TraceStage = "k_rope"
+	TraceAttnOut    TraceStage = "attn_out"
+	TraceFFNNorm    TraceStage = "ffn_norm"
+	TraceFFNGate    TraceStage = "ffn_gate"
+	TraceFFNUp      TraceStage = "ffn_up"
+	TraceFFNDown    TraceStage = "ffn_down"
+	TraceOutputNorm TraceStage = "output_norm"
+	TraceLogits     TraceStage = "logits"
+)
+
+// TraceEvent is one tensor observed at a stage. Layer is -1 for stages outside
+// the layer stack. Values aliases forward-pass scratch and is only valid for
+// the duration of the Trace call; sinks that keep it must copy.
+type TraceEvent struct {
+	Stage  TraceStage `json:"stage"`
+	Layer  int        `json:"layer"`
+	Pos    int        `json:"pos"`
+	Token  int32      `json:"token"`
+	Values []float32  `json:"values"`
+}
+
+// Tracer receives TraceEvents from the forward pass. It is called
+// synchronously on the generation goroutine.
+type Tracer interface {
+	Trace(ev TraceEvent)
+}
+
+// TracerFunc adapts a function to the Tracer interface.
+type TracerFunc func(ev TraceEvent)
+
+func (f TracerFunc) Trace(ev TraceEvent) { f(ev) }
+
+// TraceRecorder keeps copies of every event in memory, for tests and tools.
+type TraceRecorder struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+func (r *TraceRecorder) Trace(ev TraceEvent) {
+	ev.Values = append([]float32(nil), ev.Values...)
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+// Events returns the recorded events in arrival order.
+func (r *TraceRecorder) Events() []TraceEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TraceEvent(nil), r.events...)
+}
+
+// Find returns the first event matching stage, layer and pos.
+func (r *TraceRecorder) Find(stage TraceStage, layer, pos int) (TraceEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if ev.Stage == stage && ev.Layer == layer && ev.Pos == pos {
+			return ev, true
+		}
+	}
+	return TraceEvent{}, false
+}
+
+// JSONLTracer writes one JSON object per event. Call Flush when done.
+type JSONLTracer struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONLTracer(w io.Writer) *JSONLTracer {
+	bw := bufio.NewWriter(w)
+	return &JSONLTracer{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (t *JSONLTracer) Trace(ev TraceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	t.err = t.enc.Encode(ev)
+}
+
+// Flush writes buffered events and reports the first write error, if any.
+func (t *JSONLTracer) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.err = t.w.Flush()
+	return t.err
+}
+
+// ReadTraceJSONL decodes events written by JSONLTracer.
+func ReadTraceJSONL(r io.Reader) ([]TraceEvent, error) {
+	dec := json.NewDecoder(r)
+	var events []TraceEvent
+	for {
+		var ev TraceEvent
+		if err := dec.Decode(&ev); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+// traceStep binds a tracer to the token being processed so the forward pass
+// can emit with one short call per stage.
+type traceStep struct {
+	t     Tracer
+	pos   int
+	token int32
+}
+
+func newTraceStep(opts *RuntimeOptions, pos int, token int32) traceStep {
+	var t Tracer
+	if opts != nil {
+		t = opts.Tracer
+	}
+	return traceStep{t: t, pos: pos, token: token}
+}
+
+func (s traceStep) emit(stage TraceStage, layer int, values []float32) {
+	if s.t == nil {
+		return
+	}
+	s.t.Trace(TraceEvent{Stage: stage, Layer: layer, Pos: s.pos, Token: s.token, Values: values})
+}
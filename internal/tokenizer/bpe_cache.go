@@ -1,50 +1,140 @@
 package tokenizer
 
-type bpeChunkCache struct {
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// chunkCacheShards is the most shards a chunkCache splits into. Each shard
+// has its own lock, so concurrent encodes only contend on the same shard.
+const chunkCacheShards = 16
+
+// chunkCache is a sharded LRU from a pre-tokenized (BPE) or normalized
+// (SPM) chunk to its token IDs. Cached slices are shared between callers and
+// must not be modified. A nil *chunkCache caches nothing.
+type chunkCache struct {
+	seed   maphash.Seed
+	shards []chunkCacheShard
+}
+
+type chunkCacheShard struct {
+	mu       sync.Mutex
 	capacity int
-	order    []string
-	index    int
-	values   map[string][]int32
+	entries  map[string]*chunkCacheEntry
+	// root is the sentinel of a circular list; root.next is the most
+	// recently used entry and root.prev the least.
+	root chunkCacheEntry
+}
+
+type chunkCacheEntry struct {
+	key        string
+	val        []int32
+	prev, next *chunkCacheEntry
 }
 
-func newBPEChunkCache(capacity int) *bpeChunkCache {
+func newChunkCache(capacity int) *chunkCache {
 	if capacity <= 0 {
 		capacity = 128
 	}
-	return &bpeChunkCache{
-		capacity: capacity,
-		order:    make([]string, capacity),
-		values:   make(map[string][]int32, capacity),
+	n := min(chunkCacheShards, capacity)
+	c := &chunkCache{seed: maphash.MakeSeed(), shards: make([]chunkCacheShard, n)}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.capacity = capacity / n
+		if i < capacity%n {
+			s.capacity++
+		}
+		s.entries = make(map[string]*chunkCacheEntry)
+		s.root.prev, s.root.next = &s.root, &s.root
 	}
+	return c
 }
 
-func (c *bpeChunkCache) get(key string) []int32 {
+func (c *chunkCache) shard(key string) *chunkCacheShard {
+	return &c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *chunkCache) get(key string) []int32 {
 	if c == nil {
 		return nil
 	}
-	if v, ok := c.values[key]; ok {
-		return v
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
 	}
-	return nil
+	s.moveToFront(e)
+	return e.val
 }
 
-func (c *bpeChunkCache) add(key string, val []int32) {
+func (c *chunkCache) add(key string, val []int32) {
 	if c == nil {
 		return
 	}
-	if _, ok := c.values[key]; ok {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; ok {
 		return
 	}
-	if c.capacity <= 0 {
+	e := &chunkCacheEntry{key: key, val: cloneInt32(val)}
+	if len(s.entries) >= s.capacity {
+		// Reuse the least recently used entry rather than allocating.
+		e = s.root.prev
+		s.unlink(e)
+		delete(s.entries, e.key)
+		e.key, e.val = key, cloneInt32(val)
+	}
+	s.entries[key] = e
+	s.pushFront(e)
+}
+
+func (s *chunkCacheShard) unlink(e *chunkCacheEntry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+}
+
+func (s *chunkCacheShard) pushFront(e *chunkCacheEntry) {
+	e.prev = &s.root
+	e.next = s.root.next
+	s.root.next.prev = e
+	s.root.next = e
+}
+
+func (s *chunkCacheShard) moveToFront(e *chunkCacheEntry) {
+	if s.root.next == e {
 		return
 	}
-	slot := c.index % c.capacity
-	if old := c.order[slot]; old != "" {
-		delete(c.values, old)
+	s.unlink(e)
+	s.pushFront(e)
+}
+
+// scratch holds the buffers one encode call reuses. Tokenizers hand them
+// out from a sync.Pool, so concurrent calls never share one.
+type scratch struct {
+	bpeSyms    []string
+	bpeRanks   []int
+	bpeRunes   []rune
+	bpeBytes   []byte
+	bpeKey     []byte
+	bpeMerged  map[bpePair]string
+	spmSymbols []spmSymbol
+	spmHeap    spmBigramHeap
+	spmMerges  map[string][2]int
+	spmStack   []int
+}
+
+func (t *Tokenizer) getScratch() *scratch {
+	if s, ok := t.scratchPool.Get().(*scratch); ok {
+		return s
 	}
-	c.order[slot] = key
-	c.values[key] = cloneInt32(val)
-	c.index++
+	return &scratch{}
+}
+
+func (t *Tokenizer) putScratch(s *scratch) {
+	t.scratchPool.Put(s)
 }
 
 func cloneInt32(src []int32) []int32 {
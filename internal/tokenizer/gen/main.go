@@ -0,0 +1,138 @@
+// Command gen writes nfd_table.go from the Unicode Character Database.
+//
+// It reads UnicodeData.txt, keeps the canonical decompositions (field 5
+// without a <tag>), follows each one to the first code point of the full
+// NFD form and emits the table sorted by code point. Hangul syllables are
+// not listed in UnicodeData.txt; nfdBase decomposes them algorithmically.
+//
+//	go generate ./internal/tokenizer
+//	go run ./internal/tokenizer/gen -ucd UnicodeData.txt -o internal/tokenizer/nfd_table.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const unicodeVersion = "14.0.0"
+
+func main() {
+	ucd := flag.String("ucd", "https://www.unicode.org/Public/"+unicodeVersion+"/ucd/UnicodeData.txt", "UnicodeData.txt path or URL")
+	out := flag.String("o", "nfd_table.go", "output Go file")
+	flag.Parse()
+
+	r, err := open(*ucd)
+	if err != nil {
+		log.Fatalf("open %s: %v", *ucd, err)
+	}
+	decomp, err := parseCanonical(r)
+	r.Close()
+	if err != nil {
+		log.Fatalf("parse %s: %v", *ucd, err)
+	}
+	src, err := render(firstOfNFD(decomp))
+	if err != nil {
+		log.Fatalf("format table: %v", err)
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatalf("write %s: %v", *out, err)
+	}
+}
+
+func open(name string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(name, "http://") && !strings.HasPrefix(name, "https://") {
+		return os.Open(name)
+	}
+	resp, err := http.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parseCanonical returns each code point's one-level canonical
+// decomposition. Compatibility mappings start with a <tag> and are skipped.
+func parseCanonical(r io.Reader) (map[rune][]rune, error) {
+	decomp := map[rune][]rune{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Split(sc.Text(), ";")
+		if len(fields) < 6 {
+			continue
+		}
+		if fields[5] == "" || strings.HasPrefix(fields[5], "<") {
+			continue
+		}
+		cp, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: code point %q: %v", line, fields[0], err)
+		}
+		var d []rune
+		for _, f := range strings.Fields(fields[5]) {
+			v, err := strconv.ParseUint(f, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: decomposition %q: %v", line, fields[5], err)
+			}
+			d = append(d, rune(v))
+		}
+		decomp[rune(cp)] = d
+	}
+	return decomp, sc.Err()
+}
+
+// firstOfNFD maps every decomposable code point to the first code point of
+// its full decomposition, sorted by code point. Only the leading code point
+// of a decomposition can itself decompose further into the leading position.
+func firstOfNFD(decomp map[rune][]rune) [][2]rune {
+	table := make([][2]rune, 0, len(decomp))
+	for cp, d := range decomp {
+		first := d[0]
+		for {
+			next, ok := decomp[first]
+			if !ok {
+				break
+			}
+			first = next[0]
+		}
+		table = append(table, [2]rune{cp, first})
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i][0] < table[j][0] })
+	return table
+}
+
+func render(table [][2]rune) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by go run ./gen from the Unicode %s canonical decompositions. DO NOT EDIT.\n\n", unicodeVersion)
+	b.WriteString("package tokenizer\n\n")
+	b.WriteString("// nfdFirst maps each code point with a canonical decomposition, other than\n")
+	b.WriteString("// Hangul syllables, to the first code point of its NFD form. Sorted by code\n")
+	b.WriteString("// point.\n")
+	b.WriteString("var nfdFirst = [...][2]rune{\n")
+	for i, e := range table {
+		if i%6 == 0 {
+			b.WriteString("\t")
+		} else {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "{0x%04X, 0x%04X},", e[0], e[1])
+		if i%6 == 5 || i == len(table)-1 {
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("}\n")
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,419 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"bitnet-go/internal/gguf"
+)
+
+// hfTokenizer is the subset of a HuggingFace tokenizer.json the converters
+// in llama.cpp (convert_hf_to_gguf.py) read.
+type hfTokenizer struct {
+	AddedTokens []struct {
+		ID      int32  `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+	Normalizer    *hfComponent `json:"normalizer"`
+	PreTokenizer  *hfComponent `json:"pre_tokenizer"`
+	PostProcessor *hfComponent `json:"post_processor"`
+	Decoder       *hfComponent `json:"decoder"`
+	Model         struct {
+		Type         string          `json:"type"`
+		Vocab        json.RawMessage `json:"vocab"`
+		Merges       json.RawMessage `json:"merges"`
+		UnkToken     *string         `json:"unk_token"`
+		UnkID        *int32          `json:"unk_id"`
+		ByteFallback bool            `json:"byte_fallback"`
+	} `json:"model"`
+}
+
+// hfComponent is any normalizer, pre-tokenizer, post-processor or decoder;
+// only the fields of the kinds that affect encoding are decoded.
+type hfComponent struct {
+	Type          string         `json:"type"`
+	Normalizers   []*hfComponent `json:"normalizers"`
+	Pretokenizers []*hfComponent `json:"pretokenizers"`
+	Processors    []*hfComponent `json:"processors"`
+	Decoders      []*hfComponent `json:"decoders"`
+
+	Pattern struct {
+		String *string `json:"String"`
+		Regex  *string `json:"Regex"`
+	} `json:"pattern"`
+	UseRegex            *bool  `json:"use_regex"`
+	IndividualDigits    bool   `json:"individual_digits"`
+	AddPrefixSpace      *bool  `json:"add_prefix_space"`
+	PrependScheme       string `json:"prepend_scheme"`
+	PrecompiledCharsmap string `json:"precompiled_charsmap"`
+
+	Single        []map[string]struct{ ID string } `json:"single"`
+	SpecialTokens map[string]struct{ IDs []int32 } `json:"special_tokens"`
+	Cls           []any                            `json:"cls"`
+	Sep           []any                            `json:"sep"`
+}
+
+// leaves flattens Sequence components into the steps they run, in order.
+func (c *hfComponent) leaves() []*hfComponent {
+	if c == nil {
+		return nil
+	}
+	if c.Type != "Sequence" {
+		return []*hfComponent{c}
+	}
+	var out []*hfComponent
+	for _, list := range [][]*hfComponent{c.Normalizers, c.Pretokenizers, c.Processors, c.Decoders} {
+		for _, sub := range list {
+			out = append(out, sub.leaves()...)
+		}
+	}
+	return out
+}
+
+// NewFromTokenizerJSON builds a tokenizer from a HuggingFace tokenizer.json.
+func NewFromTokenizerJSON(data []byte) (*Tokenizer, error) {
+	info, err := ModelInfoFromTokenizerJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromModelInfo(info)
+}
+
+// ModelInfoFromTokenizerJSON translates a HuggingFace tokenizer.json into
+// the tokenizer.ggml.* metadata llama.cpp's converter writes, so the
+// result tokenizes exactly like a GGUF converted from the same model:
+// byte-level BPE becomes "gpt2" (with tokenizer.ggml.pre detected from the
+// pre-tokenizer regexes), SentencePiece-style BPE "llama", WordPiece
+// "bert" and Unigram "t5".
+func ModelInfoFromTokenizerJSON(data []byte) (gguf.ModelInfo, error) {
+	var hf hfTokenizer
+	if err := json.Unmarshal(data, &hf); err != nil {
+		return gguf.ModelInfo{}, fmt.Errorf("parse tokenizer.json: %w", err)
+	}
+	kv := map[string]any{}
+	var tokens []string
+	var scores []float32
+	switch hf.Model.Type {
+	case "BPE", "WordPiece", "":
+		var vocab map[string]int32
+		if err := json.Unmarshal(hf.Model.Vocab, &vocab); err != nil {
+			return gguf.ModelInfo{}, fmt.Errorf("parse tokenizer.json %s vocab: %w", hf.Model.Type, err)
+		}
+		for piece, id := range vocab {
+			if id < 0 {
+				return gguf.ModelInfo{}, fmt.Errorf("tokenizer.json vocab %q has negative id %d", piece, id)
+			}
+			tokens = growTokens(tokens, id)
+			tokens[id] = piece
+		}
+		if hf.Model.UnkToken != nil {
+			if id, ok := vocab[*hf.Model.UnkToken]; ok {
+				kv["tokenizer.ggml.unknown_token_id"] = uint32(id)
+			}
+		}
+	case "Unigram":
+		var vocab [][2]any
+		if err := json.Unmarshal(hf.Model.Vocab, &vocab); err != nil {
+			return gguf.ModelInfo{}, fmt.Errorf("parse tokenizer.json Unigram vocab: %w", err)
+		}
+		tokens = make([]string, len(vocab))
+		scores = make([]float32, len(vocab))
+		for i, e := range vocab {
+			piece, ok1 := e[0].(string)
+			score, ok2 := e[1].(float64)
+			if !ok1 || !ok2 {
+				return gguf.ModelInfo{}, fmt.Errorf("tokenizer.json Unigram vocab[%d] is not [piece, score]", i)
+			}
+			tokens[i], scores[i] = piece, float32(score)
+		}
+		if hf.Model.UnkID != nil {
+			kv["tokenizer.ggml.unknown_token_id"] = uint32(*hf.Model.UnkID)
+		}
+	default:
+		return gguf.ModelInfo{}, fmt.Errorf("unsupported tokenizer.json model type %q", hf.Model.Type)
+	}
+	for _, at := range hf.AddedTokens {
+		if at.ID < 0 {
+			return gguf.ModelInfo{}, fmt.Errorf("tokenizer.json added token %q has negative id %d", at.Content, at.ID)
+		}
+		tokens = growTokens(tokens, at.ID)
+		tokens[at.ID] = at.Content
+	}
+	if len(tokens) == 0 {
+		return gguf.ModelInfo{}, fmt.Errorf("tokenizer.json has an empty vocabulary")
+	}
+
+	types := make([]int32, len(tokens))
+	for i, piece := range tokens {
+		switch {
+		case piece == "":
+			// Holes in the ID space, as the converter pads them.
+			tokens[i] = fmt.Sprintf("[PAD%d]", i)
+			types[i] = TokenTypeUnused
+		case hf.Model.ByteFallback && isByteTokenPiece(piece):
+			types[i] = TokenTypeByte
+		default:
+			types[i] = TokenTypeNormal
+		}
+	}
+	if v, ok := kv["tokenizer.ggml.unknown_token_id"].(uint32); ok && int(v) < len(types) {
+		types[v] = TokenTypeUnknown
+	}
+	for _, at := range hf.AddedTokens {
+		if at.Special {
+			types[at.ID] = TokenTypeControl
+		} else {
+			types[at.ID] = TokenTypeUserDefined
+		}
+	}
+
+	switch hf.Model.Type {
+	case "WordPiece":
+		kv["tokenizer.ggml.model"] = "bert"
+	case "Unigram":
+		kv["tokenizer.ggml.model"] = "t5"
+		kv["tokenizer.ggml.scores"] = scores
+		hfUnigramNormalizer(&hf, kv)
+	default:
+		merges, err := hfMerges(hf.Model.Merges)
+		if err != nil {
+			return gguf.ModelInfo{}, err
+		}
+		if hfByteLevel(&hf) {
+			pre, err := hfPreType(hf.PreTokenizer)
+			if err != nil {
+				return gguf.ModelInfo{}, err
+			}
+			kv["tokenizer.ggml.model"] = "gpt2"
+			kv["tokenizer.ggml.pre"] = pre
+			kv["tokenizer.ggml.merges"] = merges
+		} else {
+			// SentencePiece BPE saved by HF: the SPM path merges by score,
+			// so each merged piece scores minus its merge rank.
+			kv["tokenizer.ggml.model"] = "llama"
+			kv["tokenizer.ggml.scores"] = spmScoresFromMerges(tokens, merges)
+		}
+	}
+	kv["tokenizer.ggml.tokens"] = tokens
+	kv["tokenizer.ggml.token_type"] = types
+	hfSpecialTokens(&hf, tokens, kv)
+	return gguf.ModelInfo{KeyValues: kv}, nil
+}
+
+func growTokens(tokens []string, id int32) []string {
+	if int(id) >= len(tokens) {
+		tokens = append(tokens, make([]string, int(id)+1-len(tokens))...)
+	}
+	return tokens
+}
+
+func isByteTokenPiece(piece string) bool {
+	_, ok := parseByteToken(piece)
+	return ok
+}
+
+// hfMerges accepts both the "a b" and the ["a", "b"] merge spellings.
+func hfMerges(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var merges []string
+	if err := json.Unmarshal(raw, &merges); err == nil {
+		return merges, nil
+	}
+	var pairs [][2]string
+	if err := json.Unmarshal(raw, &pairs); err != nil {
+		return nil, fmt.Errorf("parse tokenizer.json merges: %w", err)
+	}
+	merges = make([]string, len(pairs))
+	for i, p := range pairs {
+		merges[i] = p[0] + " " + p[1]
+	}
+	return merges, nil
+}
+
+// spmScoresFromMerges scores each piece a merge produces by minus the rank
+// of its first merge. Pieces no merge produces can only come from single
+// characters or byte fallback, so every other piece scores lowest.
+func spmScoresFromMerges(tokens []string, merges []string) []float32 {
+	rank := make(map[string]int, len(merges))
+	for i, m := range merges {
+		if a, b, ok := strings.Cut(m, " "); ok {
+			if _, seen := rank[a+b]; !seen {
+				rank[a+b] = i
+			}
+		}
+	}
+	scores := make([]float32, len(tokens))
+	for i, piece := range tokens {
+		if r, ok := rank[piece]; ok {
+			scores[i] = -float32(r)
+		} else if utf8.RuneCountInString(piece) > 1 {
+			scores[i] = -math.MaxFloat32
+		}
+	}
+	return scores
+}
+
+// hfByteLevel reports whether the vocabulary is byte-level (GPT-2 style).
+func hfByteLevel(hf *hfTokenizer) bool {
+	for _, c := range append(hf.PreTokenizer.leaves(), hf.Decoder.leaves()...) {
+		if c.Type == "ByteLevel" {
+			return true
+		}
+	}
+	return false
+}
+
+// hfPreTypes names one tokenizer.ggml.pre per distinct regex list in
+// bpePreRegexes; the other names are aliases.
+var hfPreTypes = []string{"gpt-2", "llama3", "falcon", "qwen2", "starcoder", "deepseek-llm", "deepseek-coder", "tekken", "gpt-4o"}
+
+// hfPreType finds the tokenizer.ggml.pre whose regex list matches the
+// pre-tokenizer's Split, Digits and ByteLevel steps.
+func hfPreType(pre *hfComponent) (string, error) {
+	var regexes []string
+	for _, c := range pre.leaves() {
+		switch c.Type {
+		case "Split":
+			if c.Pattern.Regex != nil {
+				regexes = append(regexes, hfRegexToPre(*c.Pattern.Regex))
+			} else if c.Pattern.String != nil {
+				regexes = append(regexes, regexp.QuoteMeta(*c.Pattern.String))
+			}
+		case "Punctuation":
+			regexes = append(regexes, bpePreRegexes["falcon"][0])
+		case "Digits":
+			if c.IndividualDigits {
+				regexes = append(regexes, `\p{N}`)
+			}
+		case "ByteLevel":
+			if c.UseRegex == nil || *c.UseRegex {
+				regexes = append(regexes, gpt2PreRegex)
+			}
+		}
+	}
+	if len(regexes) == 0 {
+		regexes = []string{gpt2PreRegex}
+	}
+	for _, name := range hfPreTypes {
+		if slices.Equal(bpePreRegexes[name], regexes) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("tokenizer.json pre_tokenizer regexes %q match no known tokenizer.ggml.pre", regexes)
+}
+
+// hfRegexToPre rewrites the case-insensitive contraction group HF regexes
+// use into the form llama.cpp (and bpePreRegexes) spells out.
+func hfRegexToPre(re string) string {
+	return strings.ReplaceAll(re, `(?i:'s|'t|'re|'ve|'m|'ll|'d)`, `(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])`)
+}
+
+// hfUnigramNormalizer maps the Precompiled charsmap, the " {2,}" collapse
+// and Metaspace's prefix setting onto the GGUF unigram keys.
+func hfUnigramNormalizer(hf *hfTokenizer, kv map[string]any) {
+	removeExtra := false
+	for _, c := range hf.Normalizer.leaves() {
+		switch c.Type {
+		case "Precompiled":
+			if b, err := base64.StdEncoding.DecodeString(c.PrecompiledCharsmap); err == nil && len(b) > 0 {
+				kv["tokenizer.ggml.precompiled_charsmap"] = b
+			}
+		case "Replace":
+			if c.Pattern.Regex != nil && *c.Pattern.Regex == " {2,}" {
+				removeExtra = true
+			}
+		}
+	}
+	kv["tokenizer.ggml.remove_extra_whitespaces"] = removeExtra
+	for _, c := range hf.PreTokenizer.leaves() {
+		if c.Type != "Metaspace" {
+			continue
+		}
+		add := c.PrependScheme == "always" || c.PrependScheme == "first"
+		if c.AddPrefixSpace != nil {
+			add = *c.AddPrefixSpace
+		}
+		kv["tokenizer.ggml.add_space_prefix"] = add
+	}
+}
+
+// hfSpecialTokens reads BOS/EOS (CLS/SEP for BERT) and whether they are
+// added from the post-processor's single-sequence template.
+func hfSpecialTokens(hf *hfTokenizer, tokens []string, kv map[string]any) {
+	idOf := func(name string) (uint32, bool) {
+		for _, c := range hf.PostProcessor.leaves() {
+			if st, ok := c.SpecialTokens[name]; ok && len(st.IDs) > 0 {
+				return uint32(st.IDs[0]), true
+			}
+		}
+		for i, piece := range tokens {
+			if piece == name {
+				return uint32(i), true
+			}
+		}
+		return 0, false
+	}
+	for _, c := range hf.PostProcessor.leaves() {
+		switch c.Type {
+		case "BertProcessing", "RobertaProcessing":
+			if len(c.Cls) == 2 && len(c.Sep) == 2 {
+				cls, _ := c.Cls[1].(float64)
+				sep, _ := c.Sep[1].(float64)
+				kv["tokenizer.ggml.bos_token_id"] = uint32(cls)
+				kv["tokenizer.ggml.eos_token_id"] = uint32(sep)
+				kv["tokenizer.ggml.add_bos_token"] = true
+				kv["tokenizer.ggml.add_eos_token"] = true
+			}
+		case "TemplateProcessing":
+			seen := false
+			addBOS, addEOS := false, false
+			for _, piece := range c.Single {
+				if _, ok := piece["Sequence"]; ok {
+					seen = true
+					continue
+				}
+				st, ok := piece["SpecialToken"]
+				if !ok {
+					continue
+				}
+				id, ok := idOf(st.ID)
+				if !ok {
+					continue
+				}
+				if !seen && !addBOS {
+					kv["tokenizer.ggml.bos_token_id"] = id
+					addBOS = true
+				} else if seen {
+					kv["tokenizer.ggml.eos_token_id"] = id
+					addEOS = true
+				}
+			}
+			kv["tokenizer.ggml.add_bos_token"] = addBOS
+			kv["tokenizer.ggml.add_eos_token"] = addEOS
+		}
+	}
+	// Without a template, fall back to the usual special token names.
+	for key, names := range map[string][]string{
+		"tokenizer.ggml.bos_token_id": {"<s>", "<|begin_of_text|>", "<bos>", "[CLS]"},
+		"tokenizer.ggml.eos_token_id": {"</s>", "<|end_of_text|>", "<|endoftext|>", "<eos>", "[SEP]"},
+	} {
+		if _, ok := kv[key]; ok {
+			continue
+		}
+		for _, name := range names {
+			if id, ok := idOf(name); ok {
+				kv[key] = id
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitnet-go/internal/gguf"
+)
+
+var loaderCorpus = []string{
+	"",
+	"hello",
+	"hello hello\n",
+	" hello  world 123",
+	"hole helo",
+	"he'll<|eot|> Hello",
+	"Aello  A",
+	"Café, HELLO\tworlds中 xyz",
+}
+
+// assertSameIDs checks that two tokenizers encode every text alike, with the
+// model's defaults and with special-token parsing.
+func assertSameIDs(t *testing.T, got, want *Tokenizer, texts []string) {
+	t.Helper()
+	if got.AddBOS() != want.AddBOS() || got.AddEOS() != want.AddEOS() || got.BOS() != want.BOS() {
+		t.Fatalf("AddBOS/AddEOS/BOS = %v/%v/%d, want %v/%v/%d", got.AddBOS(), got.AddEOS(), got.BOS(), want.AddBOS(), want.AddEOS(), want.BOS())
+	}
+	for _, text := range texts {
+		if g, w := got.Tokenize(text), want.Tokenize(text); !equalIDs(g, w) {
+			t.Fatalf("Tokenize(%q) = %v, want %v", text, g, w)
+		}
+		opts := EncodeOptions{ParseSpecial: true}
+		if g, w := got.Encode(text, opts), want.Encode(text, opts); !equalIDs(g, w) {
+			t.Fatalf("Encode(%q, ParseSpecial) = %v, want %v", text, g, w)
+		}
+	}
+}
+
+func newTokenizerFromJSON(t *testing.T, js string) (*Tokenizer, gguf.ModelInfo) {
+	t.Helper()
+	info, err := ModelInfoFromTokenizerJSON([]byte(js))
+	if err != nil {
+		t.Fatalf("ModelInfoFromTokenizerJSON() error = %v", err)
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo(tokenizer.json) error = %v", err)
+	}
+	return tok, info
+}
+
+func newTokenizerFromGGUFKV(t *testing.T, kv map[string]any) *Tokenizer {
+	t.Helper()
+	tok, err := NewFromModelInfo(gguf.ModelInfo{KeyValues: kv})
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	return tok
+}
+
+func TestTokenizerJSONByteLevelBPE(t *testing.T) {
+	js := `{
+  "added_tokens": [
+    {"id": 18, "content": "<|begin_of_text|>", "special": true},
+    {"id": 19, "content": "<|eot|>", "special": true}
+  ],
+  "normalizer": null,
+  "pre_tokenizer": {"type": "Sequence", "pretokenizers": [
+    {"type": "Split", "pattern": {"Regex": "(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\\r\\n\\p{L}\\p{N}]?\\p{L}+|\\p{N}{1,3}| ?[^\\s\\p{L}\\p{N}]+[\\r\\n]*|\\s*[\\r\\n]+|\\s+(?!\\S)|\\s+"}, "behavior": "Isolated", "invert": false},
+    {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": false}
+  ]},
+  "post_processor": {"type": "TemplateProcessing",
+    "single": [{"SpecialToken": {"id": "<|begin_of_text|>", "type_id": 0}}, {"Sequence": {"id": "A", "type_id": 0}}],
+    "special_tokens": {"<|begin_of_text|>": {"id": "<|begin_of_text|>", "ids": [18], "tokens": ["<|begin_of_text|>"]}}},
+  "decoder": {"type": "ByteLevel"},
+  "model": {"type": "BPE", "byte_fallback": false,
+    "vocab": {"Ġ": 0, "h": 1, "e": 2, "l": 3, "o": 4, "Ġh": 5, "Ġhe": 6, "Ġhel": 7, "Ġhell": 8, "Ġhello": 9,
+              "1": 10, "2": 11, "3": 12, "12": 13, "'": 14, "w": 15, "r": 16, "d": 17, "Ġ1": 20},
+    "merges": [["Ġ", "h"], ["Ġh", "e"], ["Ġhe", "l"], ["Ġhel", "l"], ["Ġhell", "o"], ["Ġ", "1"], ["1", "2"]]}
+}`
+	got, info := newTokenizerFromJSON(t, js)
+	if pre := info.KeyValues["tokenizer.ggml.pre"]; pre != "llama3" {
+		t.Fatalf("tokenizer.ggml.pre = %v, want llama3", pre)
+	}
+	want := newTokenizerFromGGUFKV(t, map[string]any{
+		"tokenizer.ggml.model":         "gpt2",
+		"tokenizer.ggml.pre":           "llama-bpe",
+		"tokenizer.ggml.tokens":        []string{"Ġ", "h", "e", "l", "o", "Ġh", "Ġhe", "Ġhel", "Ġhell", "Ġhello", "1", "2", "3", "12", "'", "w", "r", "d", "<|begin_of_text|>", "<|eot|>", "Ġ1"},
+		"tokenizer.ggml.token_type":    []int32{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 3, 3, 1},
+		"tokenizer.ggml.merges":        []string{"Ġ h", "Ġh e", "Ġhe l", "Ġhel l", "Ġhell o", "Ġ 1", "1 2"},
+		"tokenizer.ggml.bos_token_id":  uint32(18),
+		"tokenizer.ggml.add_bos_token": true,
+	})
+	assertSameIDs(t, got, want, loaderCorpus)
+}
+
+func TestTokenizerJSONSentencePieceBPE(t *testing.T) {
+	// A Llama-2 style tokenizer.json: "▁" pieces, byte fallback, merges
+	// instead of scores.
+	js := `{
+  "added_tokens": [
+    {"id": 0, "content": "<unk>", "special": true},
+    {"id": 1, "content": "<s>", "special": true},
+    {"id": 2, "content": "</s>", "special": true}
+  ],
+  "normalizer": {"type": "Sequence", "normalizers": [
+    {"type": "Prepend", "prepend": "▁"},
+    {"type": "Replace", "pattern": {"String": " "}, "content": "▁"}
+  ]},
+  "pre_tokenizer": null,
+  "post_processor": {"type": "TemplateProcessing",
+    "single": [{"SpecialToken": {"id": "<s>", "type_id": 0}}, {"Sequence": {"id": "A", "type_id": 0}}],
+    "special_tokens": {"<s>": {"id": "<s>", "ids": [1], "tokens": ["<s>"]}}},
+  "decoder": {"type": "Sequence", "decoders": [
+    {"type": "Replace", "pattern": {"String": "▁"}, "content": " "},
+    {"type": "ByteFallback"}, {"type": "Fuse"}, {"type": "Strip", "content": " ", "start": 1, "stop": 0}
+  ]},
+  "model": {"type": "BPE", "byte_fallback": true, "unk_token": "<unk>",
+    "vocab": {"<unk>": 0, "<s>": 1, "</s>": 2, "<0x0A>": 3, "▁": 4, "h": 5, "e": 6, "l": 7, "o": 8,
+              "▁h": 9, "▁he": 10, "ll": 11, "llo": 12, "▁hello": 13, "▁hol": 14, "ol": 15},
+    "merges": ["▁ h", "▁h e", "l l", "ll o", "▁he llo", "o l", "▁h ol"]}
+}`
+	got, info := newTokenizerFromJSON(t, js)
+	if model := info.KeyValues["tokenizer.ggml.model"]; model != "llama" {
+		t.Fatalf("tokenizer.ggml.model = %v, want llama", model)
+	}
+	// The same vocabulary as SentencePiece scores it.
+	want := newTokenizerFromGGUFKV(t, map[string]any{
+		"tokenizer.ggml.model":            "llama",
+		"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", "</s>", "<0x0A>", "▁", "h", "e", "l", "o", "▁h", "▁he", "ll", "llo", "▁hello", "▁hol", "ol"},
+		"tokenizer.ggml.scores":           []float32{0, 0, 0, 0, -100, -100, -100, -100, -100, -1, -2, -3, -4, -5, -7, -6},
+		"tokenizer.ggml.token_type":       []int32{3, 3, 3, 6, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+		"tokenizer.ggml.bos_token_id":     uint32(1),
+		"tokenizer.ggml.eos_token_id":     uint32(2),
+		"tokenizer.ggml.unknown_token_id": uint32(0),
+	})
+	assertSameIDs(t, got, want, loaderCorpus)
+	if got.EOS() != 2 {
+		t.Fatalf("EOS() = %d, want 2", got.EOS())
+	}
+}
+
+func TestTokenizerJSONWordPiece(t *testing.T) {
+	js := `{
+  "added_tokens": [
+    {"id": 0, "content": "[PAD]", "special": true},
+    {"id": 1, "content": "[UNK]", "special": true},
+    {"id": 2, "content": "[CLS]", "special": true},
+    {"id": 3, "content": "[SEP]", "special": true}
+  ],
+  "normalizer": {"type": "BertNormalizer", "clean_text": true, "handle_chinese_chars": true, "strip_accents": null, "lowercase": true},
+  "pre_tokenizer": {"type": "BertPreTokenizer"},
+  "post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 3], "cls": ["[CLS]", 2]},
+  "decoder": {"type": "WordPiece", "prefix": "##", "cleanup": true},
+  "model": {"type": "WordPiece", "unk_token": "[UNK]", "continuing_subword_prefix": "##", "max_input_chars_per_word": 100,
+    "vocab": {"[PAD]": 0, "[UNK]": 1, "[CLS]": 2, "[SEP]": 3, "cafe": 4, "hello": 5, ",": 6, "world": 7, "##s": 8, "中": 9}}
+}`
+	got, _ := newTokenizerFromJSON(t, js)
+	want := newTokenizerFromGGUFKV(t, map[string]any{
+		"tokenizer.ggml.model":              "bert",
+		"tokenizer.ggml.tokens":             []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "cafe", "hello", ",", "world", "##s", "中"},
+		"tokenizer.ggml.token_type":         []int32{3, 3, 3, 3, 1, 1, 1, 1, 1, 1},
+		"tokenizer.ggml.unknown_token_id":   uint32(1),
+		"tokenizer.ggml.cls_token_id":       uint32(2),
+		"tokenizer.ggml.seperator_token_id": uint32(3),
+	})
+	assertSameIDs(t, got, want, loaderCorpus)
+}
+
+func TestTokenizerJSONUnigram(t *testing.T) {
+	js := `{
+  "added_tokens": [
+    {"id": 0, "content": "<pad>", "special": true},
+    {"id": 1, "content": "</s>", "special": true},
+    {"id": 2, "content": "<unk>", "special": true},
+    {"id": 11, "content": "<x>", "special": false}
+  ],
+  "normalizer": {"type": "Sequence", "normalizers": [
+    {"type": "Precompiled", "precompiled_charsmap": "CHARSMAP"},
+    {"type": "Replace", "pattern": {"Regex": " {2,}"}, "content": " "}
+  ]},
+  "pre_tokenizer": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "always", "split": true},
+  "post_processor": {"type": "TemplateProcessing",
+    "single": [{"Sequence": {"id": "A", "type_id": 0}}, {"SpecialToken": {"id": "</s>", "type_id": 0}}],
+    "special_tokens": {"</s>": {"id": "</s>", "ids": [1], "tokens": ["</s>"]}}},
+  "decoder": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "always", "split": true},
+  "model": {"type": "Unigram", "unk_id": 2, "byte_fallback": false, "vocab": [
+    ["<pad>", 0.0], ["</s>", 0.0], ["<unk>", 0.0], ["▁", -2.0], ["▁he", -3.0], ["llo", -3.0], ["▁hello", -7.0],
+    ["h", -4.0], ["e", -4.0], ["l", -4.0], ["o", -4.0], ["<x>", -100.0]]}
+}`
+	blob := testCharsMap()
+	js = strings.Replace(js, "CHARSMAP", base64.StdEncoding.EncodeToString(blob), 1)
+	got, _ := newTokenizerFromJSON(t, js)
+	assertSameIDs(t, got, newUnigramTokenizer(t, blob), append(loaderCorpus, "he<x>"))
+}
+
+func TestTokenizerJSONErrors(t *testing.T) {
+	for name, js := range map[string]string{
+		"malformed":     `{"model": `,
+		"unknown model": `{"model": {"type": "Char", "vocab": {}}}`,
+		"empty vocab":   `{"model": {"type": "BPE", "vocab": {}, "merges": []}}`,
+		"unknown pre": `{"pre_tokenizer": {"type": "Split", "pattern": {"Regex": "\\d+"}},
+			"decoder": {"type": "ByteLevel"}, "model": {"type": "BPE", "vocab": {"a": 0}, "merges": []}}`,
+	} {
+		if _, err := NewFromTokenizerJSON([]byte(js)); err == nil {
+			t.Errorf("%s: NewFromTokenizerJSON() error = nil", name)
+		}
+	}
+}
+
+// TestTokenizerLoadersMatchGGUFFixtures compares testdata/tokenizer.<name>.json
+// and testdata/tokenizer.<name>.model, when present, against
+// testdata/ggml-vocab-<name>.gguf on the decode corpus. Run
+// scripts/fetch_testdata_gguf.sh to download both.
+func TestTokenizerLoadersMatchGGUFFixtures(t *testing.T) {
+	root := filepath.Join("..", "..", "testdata")
+	corpus, err := os.ReadFile(filepath.Join(root, "decode_corpus.txt"))
+	if err != nil {
+		t.Fatalf("read decode corpus: %v", err)
+	}
+	texts := strings.Split(string(corpus), "\n")
+	sources, _ := filepath.Glob(filepath.Join(root, "tokenizer.*.json"))
+	models, _ := filepath.Glob(filepath.Join(root, "tokenizer.*.model"))
+	sources = append(sources, models...)
+	if len(sources) == 0 {
+		t.Skip("no testdata/tokenizer.<name>.{json,model} fixtures; run scripts/fetch_testdata_gguf.sh")
+	}
+	for _, src := range sources {
+		base := filepath.Base(src)
+		name := strings.TrimSuffix(strings.TrimPrefix(base, "tokenizer."), filepath.Ext(base))
+		t.Run(base, func(t *testing.T) {
+			info, err := gguf.ReadModelInfo(filepath.Join(root, "ggml-vocab-"+name+".gguf"))
+			if err != nil {
+				t.Skipf("no matching GGUF vocab: %v", err)
+			}
+			want, err := NewFromModelInfo(info)
+			if err != nil {
+				t.Fatalf("NewFromModelInfo(gguf) error = %v", err)
+			}
+			data, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatalf("read %s: %v", src, err)
+			}
+			var got *Tokenizer
+			if filepath.Ext(src) == ".json" {
+				got, err = NewFromTokenizerJSON(data)
+			} else {
+				got, err = NewFromSentencePiece(data, firstString(info.KeyValues["tokenizer.ggml.model"]))
+			}
+			if err != nil {
+				t.Fatalf("load %s: %v", base, err)
+			}
+			assertSameIDs(t, got, want, texts)
+		})
+	}
+}
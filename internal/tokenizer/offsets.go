@@ -0,0 +1,213 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Offset is the half-open byte span [Start, End) of the input a token covers.
+type Offset struct {
+	Start int
+	End   int
+}
+
+// TokenizeWithOffsets is Tokenize that also returns, for each token, the
+// byte span of prompt it was produced from. BOS and the space prefix that
+// SentencePiece and the greedy path insert get empty spans. A token holding
+// only some bytes of a multi-byte character covers just those bytes, so the
+// spans of consecutive tokens always tile the input in order.
+func (t *Tokenizer) TokenizeWithOffsets(prompt string) ([]int32, []Offset) {
+	return t.EncodeWithOffsets(prompt, EncodeOptions{AddBOS: t.addBOS, AddEOS: t.addEOS})
+}
+
+// EncodeWithOffsets is Encode with per-token byte spans, as described on
+// TokenizeWithOffsets. It returns the same tokens as Encode.
+func (t *Tokenizer) EncodeWithOffsets(text string, opts EncodeOptions) ([]int32, []Offset) {
+	if t.trie == nil {
+		return nil, nil
+	}
+	ids := make([]int32, 0, len(text)+1)
+	offs := make([]Offset, 0, len(text)+1)
+	if opts.AddBOS && t.bosTokenID >= 0 {
+		ids = append(ids, t.bosTokenID)
+		offs = append(offs, Offset{})
+	}
+	appendText := func(start, end int) {
+		fragIDs, fragOffs := t.encodeTextOffsets(text[start:end])
+		ids = append(ids, fragIDs...)
+		for _, o := range fragOffs {
+			offs = append(offs, Offset{Start: start + o.Start, End: start + o.End})
+		}
+	}
+	start := 0
+	if !opts.ParseSpecial || t.specialTrie == nil {
+		start = len(text)
+		appendText(0, len(text))
+	}
+	for i := start; i < len(text); {
+		id, n := t.matchSpecial(text, i)
+		if n == 0 {
+			i++
+			continue
+		}
+		if start < i {
+			appendText(start, i)
+		}
+		ids = append(ids, id)
+		offs = append(offs, Offset{Start: i, End: i + n})
+		i += n
+		start = i
+	}
+	if start < len(text) {
+		appendText(start, len(text))
+	}
+	if end := t.endTokenID(); opts.AddEOS && end >= 0 {
+		ids = append(ids, end)
+		offs = append(offs, Offset{Start: len(text), End: len(text)})
+	}
+	return ids, offs
+}
+
+// encodeTextOffsets mirrors encodeText, with spans relative to prompt.
+func (t *Tokenizer) encodeTextOffsets(prompt string) ([]int32, []Offset) {
+	if t.model == "llama" {
+		normalized := normalizeSPM(prompt)
+		ids, spans := t.tokenizeSPMSpans(normalized, true)
+		origin := spmOrigins(prompt)
+		for i, sp := range spans {
+			spans[i] = Offset{Start: origin[sp.Start], End: origin[sp.End]}
+		}
+		return ids, spans
+	}
+	if t.model == "gpt2" && len(t.bpeRanks) > 0 {
+		return t.tokenizeBPEOffsets(prompt)
+	}
+	if t.model == "t5" {
+		normalized, inputEnds := t.normalizeUGM(prompt)
+		ids, ends := t.tokenizeUGM(normalized)
+		return ids, spansFromInputEnds(ends, inputEnds, len(prompt))
+	}
+	if t.model == "bert" {
+		words, wordEnds, inputEnds := wpmWords(prompt)
+		ids, ends := t.tokenizeWPM(words, wordEnds)
+		return ids, spansFromInputEnds(ends, inputEnds, len(prompt))
+	}
+
+	text := prompt
+	shift := 0
+	if !strings.HasPrefix(text, " ") {
+		text = " " + text
+		shift = 1
+	}
+	ids := t.tokenizeGreedy(text)
+	offs := make([]Offset, len(ids))
+	pos := 0
+	for i := range ids {
+		n := t.greedyTokenLen(text, pos)
+		offs[i] = Offset{Start: max(pos-shift, 0), End: max(pos+n-shift, 0)}
+		pos += n
+	}
+	return ids, offs
+}
+
+// spansFromInputEnds turns token end offsets in normalized text into spans
+// of the original, where inputEnds holds the input end offset behind each
+// normalized byte. Input the normalizer dropped, such as whitespace
+// between words, joins the following token, or the last one at the end.
+func spansFromInputEnds(ends, inputEnds []int, n int) []Offset {
+	offs := make([]Offset, len(ends))
+	prev := 0
+	for i, e := range ends {
+		end := prev
+		if e > 0 {
+			end = max(inputEnds[e-1], prev)
+		}
+		if i == len(ends)-1 {
+			end = n
+		}
+		offs[i] = Offset{Start: prev, End: end}
+		prev = end
+	}
+	return offs
+}
+
+// spmOrigins maps each byte of normalizeSPM(prompt), plus the end, to the
+// prompt byte it came from. The inserted prefix maps to 0 and every byte of
+// a "▁" standing in for a space maps to that space's offset, so a token
+// ending inside such a "▁" does not cover the space.
+func spmOrigins(prompt string) []int {
+	const marker = len("▁")
+	origin := make([]int, 0, marker+len(prompt)*marker+1)
+	for i := 0; i < marker; i++ {
+		origin = append(origin, 0)
+	}
+	for i := 0; i < len(prompt); i++ {
+		if prompt[i] == ' ' {
+			for j := 0; j < marker; j++ {
+				origin = append(origin, i)
+			}
+			continue
+		}
+		origin = append(origin, i)
+	}
+	return append(origin, len(prompt))
+}
+
+// greedyTokenLen returns the byte length tokenizeGreedy consumed at pos.
+func (t *Tokenizer) greedyTokenLen(text string, pos int) int {
+	n := 0
+	for _, m := range t.trie.match(text, pos) {
+		if m.length > n {
+			n = m.length
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+func (t *Tokenizer) tokenizeBPEOffsets(prompt string) ([]int32, []Offset) {
+	if prompt == "" {
+		return nil, nil
+	}
+	chunks := t.splitBPEPieces(prompt)
+	if len(chunks) == 0 {
+		chunks = []string{prompt}
+	}
+	s := t.getScratch()
+	defer t.putScratch(s)
+	ids := make([]int32, 0, len(prompt))
+	offs := make([]Offset, 0, len(prompt))
+	pos := 0
+	for _, chunk := range chunks {
+		// Chunks are substrings of prompt in order; search forward in case
+		// a splitter ever drops text between them.
+		if idx := strings.Index(prompt[pos:], chunk); idx >= 0 {
+			pos += idx
+		}
+		for _, id := range t.encodeBPEChunk(s, chunk) {
+			n := t.bpePieceBytes(s, id, prompt[pos:])
+			end := min(pos+n, len(prompt))
+			ids = append(ids, id)
+			offs = append(offs, Offset{Start: pos, End: end})
+			pos = end
+		}
+	}
+	return ids, offs
+}
+
+// bpePieceBytes returns how many bytes of rest a byte-level BPE token
+// encodes. Pieces hold one mapped rune per input byte; the unknown token,
+// emitted for a single unmatched byte, counts as one unless rest really
+// starts with its text.
+func (t *Tokenizer) bpePieceBytes(s *scratch, id int32, rest string) int {
+	if id < 0 || int(id) >= len(t.tokens) {
+		return 1
+	}
+	n := utf8.RuneCountInString(t.tokens[id])
+	if id == t.unkTokenID && (n > len(rest) || t.bpeByteMap(s, rest[:n]) != t.tokens[id]) {
+		return 1
+	}
+	return n
+}
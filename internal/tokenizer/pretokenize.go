@@ -0,0 +1,217 @@
+package tokenizer
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	gpt2PreRegex   = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)`
+	llama3PreRegex = `(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	cjkPreRegex    = `[一-龥ࠀ-一가-퟿]+`
+)
+
+// bpePreRegexes holds, per tokenizer.ggml.pre, the regexes llama.cpp's
+// llm_tokenizer_bpe applies in sequence. The strings are llama.cpp's; the
+// GPT-2 and Llama 3 ones are split by splitGPT2 and splitLlama3 instead.
+var bpePreRegexes = map[string][]string{
+	"":       {gpt2PreRegex},
+	"gpt-2":  {gpt2PreRegex},
+	"mpt":    {gpt2PreRegex},
+	"olmo":   {gpt2PreRegex},
+	"jais":   {gpt2PreRegex},
+	"falcon": {`[\p{P}\$\+<=>\^~\|` + "`" + `]+`, gpt2PreRegex, `[0-9][0-9][0-9]`},
+
+	"llama3":      {llama3PreRegex},
+	"llama-v3":    {llama3PreRegex},
+	"llama-bpe":   {llama3PreRegex},
+	"dbrx":        {llama3PreRegex},
+	"smaug":       {llama3PreRegex},
+	"chatglm-bpe": {llama3PreRegex},
+
+	"starcoder":  {`\p{N}`, gpt2PreRegex},
+	"refact":     {`\p{N}`, gpt2PreRegex},
+	"command-r":  {`\p{N}`, gpt2PreRegex},
+	"smollm":     {`\p{N}`, gpt2PreRegex},
+	"codeshell":  {`\p{N}`, gpt2PreRegex},
+	"exaone":     {`\p{N}`, gpt2PreRegex},
+	"minerva-7b": {`\p{N}`, gpt2PreRegex},
+
+	"qwen2":     {`(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+	"stablelm2": {`(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+
+	"deepseek-llm": {
+		`[\r\n]`,
+		// Cased letters, escaped: several (U+1F7D, U+2126, U+212A, ...) are
+		// compatibility characters that editors silently normalize.
+		`\s?[A-Za-z\x{B5}\x{C0}-\x{D6}\x{D8}-\x{F6}\x{F8}-\x{1BA}\x{1BC}-\x{1BF}\x{1C4}-\x{293}\x{295}-\x{2AF}\x{370}-\x{373}\x{376}\x{377}\x{37B}-\x{37D}\x{37F}\x{386}\x{388}-\x{38A}\x{38C}\x{38E}-\x{3A1}\x{3A3}-\x{3F5}\x{3F7}-\x{481}\x{48A}-\x{52F}\x{531}-\x{556}\x{10A0}-\x{10C5}\x{13A0}-\x{13F5}\x{13F8}-\x{13FD}\x{1C90}-\x{1CBA}\x{1CBD}-\x{1CBF}\x{1D00}-\x{1D2B}\x{1D6B}-\x{1D77}\x{1D79}-\x{1D9A}\x{1E00}-\x{1F15}\x{1F18}-\x{1F1D}\x{1F20}-\x{1F45}\x{1F48}-\x{1F4D}\x{1F50}-\x{1F57}\x{1F59}\x{1F5B}\x{1F5D}\x{1F5F}-\x{1F7D}\x{1F80}-\x{1FB4}\x{1FB6}-\x{1FBC}\x{1FBE}\x{1FC2}-\x{1FC4}\x{1FC6}-\x{1FCC}\x{1FD0}-\x{1FD3}\x{1FD6}-\x{1FDB}\x{1FE0}-\x{1FEC}\x{1FF2}-\x{1FF4}\x{1FF6}-\x{1FFC}\x{2102}\x{2107}\x{210A}-\x{2113}\x{2115}\x{2119}-\x{211D}\x{2124}\x{2126}\x{2128}\x{212A}-\x{212D}\x{212F}-\x{2134}\x{2139}\x{213C}-\x{213F}\x{2145}-\x{2149}\x{214E}\x{2183}\x{2184}\x{2C00}-\x{2C7B}\x{2C7E}-\x{2CE4}\x{2CEB}-\x{2CEE}\x{2CF2}\x{2CF3}\x{A640}-\x{A66D}\x{A680}-\x{A69B}\x{A722}-\x{A76F}\x{A771}-\x{A787}\x{A78B}-\x{A78E}\x{AB70}-\x{ABBF}\x{FB00}-\x{FB06}\x{FB13}-\x{FB17}\x{FF21}-\x{FF3A}\x{FF41}-\x{FF5A}\x{10400}-\x{1044F}\x{104B0}-\x{104D3}\x{104D8}-\x{104FB}\x{10C80}-\x{10CB2}\x{10CC0}-\x{10CF2}\x{118A0}-\x{118DF}\x{1E900}-\x{1E943}]+`,
+		`\s?[!-/:-~！-／：-～‘-‟　-。]+`,
+		`\s+$`,
+		cjkPreRegex,
+		`\p{N}+`,
+	},
+	"deepseek-coder": {
+		`[\r\n]`,
+		`\s?\p{L}+`,
+		`\s?\p{P}+`,
+		cjkPreRegex,
+		`\p{N}`,
+	},
+
+	// llama.cpp's approximation of the tokenizer.json regexes, whose
+	// \p{Lu}/\p{Ll} classes std::regex cannot express.
+	"tekken": {`[^\r\n\p{L}\p{N}]?((?=[\p{L}])([^a-z]))*((?=[\p{L}])([^A-Z]))+|[^\r\n\p{L}\p{N}]?((?=[\p{L}])([^a-z]))+((?=[\p{L}])([^A-Z]))*|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+	"gpt-4o": {`[^\r\n\p{L}\p{N}]?((?=[\p{L}])([^a-z]))*((?=[\p{L}])([^A-Z]))+(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])?|[^\r\n\p{L}\p{N}]?((?=[\p{L}])([^a-z]))+((?=[\p{L}])([^A-Z]))*(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+}
+
+// bpePreSplitters holds the compiled form of every bpePreRegexes entry that
+// has no hand-written splitter.
+var bpePreSplitters = func() map[string][]*preRegex {
+	m := make(map[string][]*preRegex)
+	for pre, exprs := range bpePreRegexes {
+		if len(exprs) == 1 && (exprs[0] == gpt2PreRegex || exprs[0] == llama3PreRegex) {
+			continue
+		}
+		res := make([]*preRegex, len(exprs))
+		for i, expr := range exprs {
+			res[i] = compilePreRegex(expr)
+		}
+		m[pre] = res
+	}
+	return m
+}()
+
+// preWhitespace is \s as llama.cpp applies it: ASCII whitespace including
+// \v, plus every Unicode White_Space code point.
+const preWhitespace = `\t\n\v\f\r \x{85}\x{A0}\x{1680}\x{2000}-\x{200A}\x{2028}\x{2029}\x{202F}\x{205F}\x{3000}`
+
+// preRegex is one pre-tokenizer regex translated to Go syntax. RE2 has no
+// lookahead, so a trailing `\s+(?!\S)|rest` alternative is matched as a
+// plain \s+ group that gives back its last character when more text
+// follows, falling back to rest when that leaves nothing.
+type preRegex struct {
+	re   *regexp.Regexp
+	ws   int            // submatch index of the \s+(?!\S) group, or -1
+	rest *regexp.Regexp // alternatives after \s+(?!\S), or nil
+}
+
+func compilePreRegex(expr string) *preRegex {
+	// (?=[\p{L}])([^a-z]) is "a letter other than a-z".
+	expr = strings.ReplaceAll(expr, `((?=[\p{L}])([^a-z]))`, `[^\P{L}a-z]`)
+	expr = strings.ReplaceAll(expr, `((?=[\p{L}])([^A-Z]))`, `[^\P{L}A-Z]`)
+
+	r := &preRegex{ws: -1}
+	const lookahead = `\s+(?!\S)`
+	if i := strings.Index(expr, lookahead); i >= 0 {
+		head := strings.TrimSuffix(expr[:i], "|")
+		rest := strings.TrimPrefix(expr[i+len(lookahead):], "|")
+		alts := `(?P<ws>\s+)`
+		if head != "" {
+			alts = head + "|" + alts
+		}
+		r.re = regexp.MustCompile(`^(?:` + expandPreWhitespace(alts) + `)`)
+		r.ws = r.re.SubexpIndex("ws")
+		if rest != "" {
+			r.rest = regexp.MustCompile(`^(?:` + expandPreWhitespace(rest) + `)`)
+		}
+		return r
+	}
+	r.re = regexp.MustCompile(`^(?:` + expandPreWhitespace(expr) + `)`)
+	return r
+}
+
+// expandPreWhitespace replaces \s, inside or outside a bracket expression,
+// with preWhitespace; Go's \s is ASCII-only and omits \v.
+func expandPreWhitespace(expr string) string {
+	var b strings.Builder
+	inside := false
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\\' && i+1 < len(expr) && expr[i+1] == 's':
+			if inside {
+				b.WriteString(preWhitespace)
+			} else {
+				b.WriteString("[" + preWhitespace + "]")
+			}
+			i++
+		case c == '\\' && i+1 < len(expr):
+			b.WriteByte(c)
+			b.WriteByte(expr[i+1])
+			i++
+		case c == '[' && !inside:
+			inside = true
+			b.WriteByte(c)
+		case c == ']' && inside:
+			inside = false
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// matchAt returns the end of the match starting at s[pos:], or pos if the
+// regex does not match there.
+func (r *preRegex) matchAt(s string, pos int) int {
+	loc := r.re.FindStringSubmatchIndex(s[pos:])
+	if loc == nil {
+		return pos
+	}
+	end := pos + loc[1]
+	if r.ws < 0 || loc[2*r.ws] < 0 {
+		return end
+	}
+	if end < len(s) {
+		// Followed by a non-space: (?!\S) backtracks one character.
+		_, n := utf8.DecodeLastRuneInString(s[pos:end])
+		end -= n
+	}
+	if end > pos {
+		return end
+	}
+	if r.rest != nil {
+		if loc := r.rest.FindStringIndex(s[pos:]); loc != nil {
+			return pos + loc[1]
+		}
+	}
+	return pos
+}
+
+// split applies r to each piece the way llama.cpp's unicode_regex_split
+// does: matches become pieces, and each run of text between matches stays
+// a piece of its own.
+func (r *preRegex) split(pieces []string) []string {
+	out := make([]string, 0, len(pieces))
+	for _, p := range pieces {
+		gap := 0
+		for pos := 0; pos < len(p); {
+			end := r.matchAt(p, pos)
+			if end == pos {
+				_, n := utf8.DecodeRuneInString(p[pos:])
+				pos += n
+				continue
+			}
+			if gap < pos {
+				out = append(out, p[gap:pos])
+			}
+			out = append(out, p[pos:end])
+			pos, gap = end, end
+		}
+		if gap < len(p) {
+			out = append(out, p[gap:])
+		}
+	}
+	return out
+}
+
+// splitPreRegexes runs text through each regex in turn.
+func splitPreRegexes(text string, res []*preRegex) []string {
+	pieces := []string{text}
+	for _, r := range res {
+		pieces = r.split(pieces)
+	}
+	return pieces
+}
@@ -0,0 +1,210 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"bitnet-go/internal/gguf"
+)
+
+// SentencePiece ModelProto field numbers (sentencepiece_model.proto).
+const (
+	spModelPieces         = 1
+	spModelTrainerSpec    = 2
+	spModelNormalizerSpec = 3
+
+	spPiecePiece = 1
+	spPieceScore = 2
+	spPieceType  = 3
+
+	spTrainerModelType = 3
+	spTrainerUnkID     = 40
+	spTrainerBosID     = 41
+	spTrainerEosID     = 42
+
+	spNormalizerCharsmap       = 2
+	spNormalizerAddDummyPrefix = 3
+	spNormalizerRemoveExtra    = 4
+
+	spModelTypeUnigram = 1
+)
+
+var errProtoTruncated = errors.New("truncated protobuf")
+
+// protoField is one decoded protobuf field: varint and fixed values in num,
+// length-delimited payloads in data.
+type protoField struct {
+	field int
+	num   uint64
+	data  []byte
+}
+
+// protoFields decodes the top-level fields of a protobuf message.
+func protoFields(b []byte) ([]protoField, error) {
+	var out []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProtoTruncated
+		}
+		b = b[n:]
+		f := protoField{field: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.num, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errProtoTruncated
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return nil, errProtoTruncated
+			}
+			f.num, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return nil, errProtoTruncated
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		case 5:
+			if len(b) < 4 {
+				return nil, errProtoTruncated
+			}
+			f.num, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// NewFromSentencePiece builds a tokenizer from a SentencePiece .model file;
+// model is as for ModelInfoFromSentencePiece.
+func NewFromSentencePiece(data []byte, model string) (*Tokenizer, error) {
+	info, err := ModelInfoFromSentencePiece(data, model)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromModelInfo(info)
+}
+
+// ModelInfoFromSentencePiece translates a SentencePiece ModelProto into the
+// tokenizer.ggml.* metadata llama.cpp's converter writes. model picks the
+// tokenizer.ggml.model: "llama" (what llama-family conversions use for any
+// SentencePiece model) or "t5" (unigram Viterbi with the normalizer's
+// charsmap); empty picks "t5" for unigram models and "llama" otherwise.
+func ModelInfoFromSentencePiece(data []byte, model string) (gguf.ModelInfo, error) {
+	fields, err := protoFields(data)
+	if err != nil {
+		return gguf.ModelInfo{}, fmt.Errorf("parse sentencepiece model: %w", err)
+	}
+	var (
+		tokens      []string
+		scores      []float32
+		types       []int32
+		modelType   uint64 = spModelTypeUnigram
+		unkID       int32  = 0
+		bosID       int32  = 1
+		eosID       int32  = 2
+		charsmap    []byte
+		addPrefix   = true
+		removeExtra = true
+	)
+	for _, f := range fields {
+		switch f.field {
+		case spModelPieces:
+			sub, err := protoFields(f.data)
+			if err != nil {
+				return gguf.ModelInfo{}, fmt.Errorf("parse sentencepiece piece %d: %w", len(tokens), err)
+			}
+			piece, score, typ := "", float32(0), TokenTypeNormal
+			for _, p := range sub {
+				switch p.field {
+				case spPiecePiece:
+					piece = string(p.data)
+				case spPieceScore:
+					score = math.Float32frombits(uint32(p.num))
+				case spPieceType:
+					typ = int32(p.num)
+				}
+			}
+			tokens = append(tokens, piece)
+			scores = append(scores, score)
+			types = append(types, typ)
+		case spModelTrainerSpec:
+			sub, err := protoFields(f.data)
+			if err != nil {
+				return gguf.ModelInfo{}, fmt.Errorf("parse sentencepiece trainer_spec: %w", err)
+			}
+			for _, p := range sub {
+				// int32 fields are sign-extended to 64 bits on the wire.
+				switch p.field {
+				case spTrainerModelType:
+					modelType = p.num
+				case spTrainerUnkID:
+					unkID = int32(p.num)
+				case spTrainerBosID:
+					bosID = int32(p.num)
+				case spTrainerEosID:
+					eosID = int32(p.num)
+				}
+			}
+		case spModelNormalizerSpec:
+			sub, err := protoFields(f.data)
+			if err != nil {
+				return gguf.ModelInfo{}, fmt.Errorf("parse sentencepiece normalizer_spec: %w", err)
+			}
+			for _, p := range sub {
+				switch p.field {
+				case spNormalizerCharsmap:
+					charsmap = p.data
+				case spNormalizerAddDummyPrefix:
+					addPrefix = p.num != 0
+				case spNormalizerRemoveExtra:
+					removeExtra = p.num != 0
+				}
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return gguf.ModelInfo{}, fmt.Errorf("sentencepiece model has no pieces")
+	}
+	if model == "" {
+		model = "llama"
+		if modelType == spModelTypeUnigram {
+			model = "t5"
+		}
+	}
+
+	kv := map[string]any{
+		"tokenizer.ggml.model":      model,
+		"tokenizer.ggml.tokens":     tokens,
+		"tokenizer.ggml.scores":     scores,
+		"tokenizer.ggml.token_type": types,
+	}
+	for key, id := range map[string]int32{
+		"tokenizer.ggml.unknown_token_id": unkID,
+		"tokenizer.ggml.bos_token_id":     bosID,
+		"tokenizer.ggml.eos_token_id":     eosID,
+	} {
+		if id >= 0 && int(id) < len(tokens) {
+			kv[key] = uint32(id)
+		}
+	}
+	switch model {
+	case "llama":
+	case "t5":
+		if len(charsmap) > 0 {
+			kv["tokenizer.ggml.precompiled_charsmap"] = charsmap
+		}
+		kv["tokenizer.ggml.add_space_prefix"] = addPrefix
+		kv["tokenizer.ggml.remove_extra_whitespaces"] = removeExtra
+	default:
+		return gguf.ModelInfo{}, fmt.Errorf("unsupported tokenizer model %q for a sentencepiece model", model)
+	}
+	return gguf.ModelInfo{KeyValues: kv}, nil
+}
@@ -0,0 +1,125 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendProtoFloat(b []byte, field int, f float32) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|5)
+	return binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+}
+
+// sentencePieceModel encodes a minimal ModelProto. Negative IDs are
+// sign-extended the way protobuf encodes int32.
+func sentencePieceModel(pieces []string, scores []float32, types []int32, modelType uint64, unk, bos, eos int32, charsmap []byte, addDummyPrefix bool) []byte {
+	var out []byte
+	for i, p := range pieces {
+		var sp []byte
+		sp = appendProtoBytes(sp, spPiecePiece, []byte(p))
+		sp = appendProtoFloat(sp, spPieceScore, scores[i])
+		if types[i] != TokenTypeNormal {
+			sp = appendProtoVarint(sp, spPieceType, uint64(types[i]))
+		}
+		out = appendProtoBytes(out, spModelPieces, sp)
+	}
+	var trainer []byte
+	trainer = appendProtoVarint(trainer, spTrainerModelType, modelType)
+	trainer = appendProtoVarint(trainer, spTrainerUnkID, uint64(int64(unk)))
+	trainer = appendProtoVarint(trainer, spTrainerBosID, uint64(int64(bos)))
+	trainer = appendProtoVarint(trainer, spTrainerEosID, uint64(int64(eos)))
+	out = appendProtoBytes(out, spModelTrainerSpec, trainer)
+	var norm []byte
+	norm = appendProtoBytes(norm, 1, []byte("custom"))
+	if len(charsmap) > 0 {
+		norm = appendProtoBytes(norm, spNormalizerCharsmap, charsmap)
+	}
+	if !addDummyPrefix {
+		norm = appendProtoVarint(norm, spNormalizerAddDummyPrefix, 0)
+	}
+	return appendProtoBytes(out, spModelNormalizerSpec, norm)
+}
+
+func TestTokenizerSentencePieceBPE(t *testing.T) {
+	pieces := []string{"<unk>", "<s>", "</s>", "<0x0A>", "▁", "h", "e", "l", "o", "▁h", "▁he", "ll", "llo", "▁hello", "▁hol", "ol"}
+	scores := []float32{0, 0, 0, 0, -100, -100, -100, -100, -100, -1, -2, -3, -4, -5, -7, -6}
+	types := []int32{2, 3, 3, 6, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	data := sentencePieceModel(pieces, scores, types, 2, 0, 1, 2, nil, true)
+
+	info, err := ModelInfoFromSentencePiece(data, "")
+	if err != nil {
+		t.Fatalf("ModelInfoFromSentencePiece() error = %v", err)
+	}
+	if model := info.KeyValues["tokenizer.ggml.model"]; model != "llama" {
+		t.Fatalf("tokenizer.ggml.model = %v, want llama", model)
+	}
+	got, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	want := newTokenizerFromGGUFKV(t, map[string]any{
+		"tokenizer.ggml.model":            "llama",
+		"tokenizer.ggml.tokens":           pieces,
+		"tokenizer.ggml.scores":           scores,
+		"tokenizer.ggml.token_type":       types,
+		"tokenizer.ggml.bos_token_id":     uint32(1),
+		"tokenizer.ggml.eos_token_id":     uint32(2),
+		"tokenizer.ggml.unknown_token_id": uint32(0),
+	})
+	assertSameIDs(t, got, want, loaderCorpus)
+	if got.EOS() != 2 || got.TokenType(3) != TokenTypeByte {
+		t.Fatalf("EOS() = %d, TokenType(3) = %d, want 2 and byte", got.EOS(), got.TokenType(3))
+	}
+}
+
+func TestTokenizerSentencePieceUnigram(t *testing.T) {
+	pieces := []string{"<pad>", "</s>", "<unk>", "▁", "▁he", "llo", "▁hello", "h", "e", "l", "o", "<x>"}
+	scores := []float32{0, 0, 0, -2, -3, -3, -7, -4, -4, -4, -4, -100}
+	types := []int32{3, 3, 2, 1, 1, 1, 1, 1, 1, 1, 1, 4}
+	blob := testCharsMap()
+	// T5 models have no BOS: bos_id is -1.
+	data := sentencePieceModel(pieces, scores, types, 1, 2, -1, 1, blob, true)
+
+	got, err := NewFromSentencePiece(data, "")
+	if err != nil {
+		t.Fatalf("NewFromSentencePiece() error = %v", err)
+	}
+	assertSameIDs(t, got, newUnigramTokenizer(t, blob), append(loaderCorpus, "he<x>"))
+
+	data = sentencePieceModel(pieces, scores, types, 1, 2, -1, 1, blob, false)
+	info, err := ModelInfoFromSentencePiece(data, "t5")
+	if err != nil {
+		t.Fatalf("ModelInfoFromSentencePiece(no dummy prefix) error = %v", err)
+	}
+	if v := info.KeyValues["tokenizer.ggml.add_space_prefix"]; v != false {
+		t.Fatalf("tokenizer.ggml.add_space_prefix = %v, want false", v)
+	}
+	if _, ok := info.KeyValues["tokenizer.ggml.bos_token_id"]; ok {
+		t.Fatalf("bos_id -1 should leave tokenizer.ggml.bos_token_id unset")
+	}
+}
+
+func TestTokenizerSentencePieceErrors(t *testing.T) {
+	data := sentencePieceModel([]string{"<unk>"}, []float32{0}, []int32{2}, 1, 0, -1, -1, nil, true)
+	if _, err := NewFromSentencePiece(data[:len(data)-1], ""); err == nil {
+		t.Fatalf("NewFromSentencePiece(truncated) error = nil")
+	}
+	if _, err := NewFromSentencePiece(data, "gpt2"); err == nil {
+		t.Fatalf("NewFromSentencePiece(gpt2) error = nil")
+	}
+	if _, err := NewFromSentencePiece(nil, ""); err == nil {
+		t.Fatalf("NewFromSentencePiece(empty) error = nil")
+	}
+}
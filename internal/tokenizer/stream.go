@@ -0,0 +1,143 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// StreamDecoder detokenizes one token at a time. Byte-fallback tokens
+// (<0xE2>) and byte-level BPE pieces can split a character across tokens,
+// so bytes are held back until they form complete UTF-8; every string Add
+// returns is valid UTF-8. A StreamDecoder is not safe for concurrent use.
+type StreamDecoder struct {
+	t       *Tokenizer
+	opts    DecodeOptions
+	pending []byte
+	started bool
+}
+
+// NewStreamDecoder returns a decoder for a new token sequence.
+func (t *Tokenizer) NewStreamDecoder(opts DecodeOptions) *StreamDecoder {
+	return &StreamDecoder{t: t, opts: opts}
+}
+
+// Add appends token id and returns the text it completes, which may be
+// empty while a multi-byte character is still partial.
+func (d *StreamDecoder) Add(id int32) string {
+	start := len(d.pending)
+	d.pending = d.t.appendTokenBytes(d.pending, id, !d.opts.SkipSpecial)
+	if !d.started && len(d.pending) > start {
+		d.started = true
+		if d.opts.TrimPrefixSpace && d.t.insertsPrefixSpace() && d.pending[start] == ' ' {
+			d.pending = append(d.pending[:start], d.pending[start+1:]...)
+		}
+	}
+	n := completeUTF8Prefix(d.pending)
+	if n == 0 {
+		return ""
+	}
+	out := strings.ToValidUTF8(string(d.pending[:n]), "�")
+	d.pending = append(d.pending[:0], d.pending[n:]...)
+	return out
+}
+
+// Flush returns any held-back bytes, with an incomplete trailing character
+// replaced by U+FFFD, and leaves the decoder ready to continue.
+func (d *StreamDecoder) Flush() string {
+	if len(d.pending) == 0 {
+		return ""
+	}
+	out := strings.ToValidUTF8(string(d.pending), "�")
+	d.pending = d.pending[:0]
+	return out
+}
+
+// Reset discards held-back bytes and starts a new sequence.
+func (d *StreamDecoder) Reset() {
+	d.pending = d.pending[:0]
+	d.started = false
+}
+
+// completeUTF8Prefix returns the length of b without a trailing, possibly
+// still valid, incomplete UTF-8 sequence.
+func completeUTF8Prefix(b []byte) int {
+	// A sequence is at most 4 bytes, so only the last 3 can be incomplete.
+	for i := len(b) - 1; i >= 0 && i >= len(b)-3; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// insertsPrefixSpace reports whether encoding prepends a space (the
+// SentencePiece "▁" prefix or the greedy path's leading space).
+func (t *Tokenizer) insertsPrefixSpace() bool {
+	return !(t.hasBPEMerges || t.model == "gpt2")
+}
+
+// appendTokenBytes appends the raw bytes token id decodes to, following
+// llama.cpp's token_to_piece: control and unknown tokens are written
+// verbatim only when special is set, user-defined tokens always verbatim,
+// unused tokens never, and byte tokens as their byte.
+func (t *Tokenizer) appendTokenBytes(dst []byte, id int32, special bool) []byte {
+	if id < 0 || int(id) >= len(t.tokens) {
+		return dst
+	}
+	piece := t.tokens[id]
+	switch t.TokenType(id) {
+	case TokenTypeControl, TokenTypeUnknown:
+		if !special {
+			return dst
+		}
+		return append(dst, piece...)
+	case TokenTypeUserDefined:
+		return append(dst, piece...)
+	case TokenTypeUnused:
+		return dst
+	case TokenTypeByte:
+		if b, ok := parseByteToken(piece); ok {
+			return append(dst, b)
+		}
+	}
+	if t.hasBPEMerges || t.model == "gpt2" {
+		for _, r := range piece {
+			if b, ok := t.byteDecodeRune[r]; ok {
+				dst = append(dst, b)
+			} else {
+				dst = utf8.AppendRune(dst, r)
+			}
+		}
+		return dst
+	}
+	if t.model == "bert" && t.wpmContPrefix != "" {
+		// "##" pieces continue a word; any other piece starts one.
+		if rest, ok := strings.CutPrefix(piece, t.wpmContPrefix); ok {
+			return append(dst, rest...)
+		}
+		dst = append(dst, ' ')
+		return append(dst, piece...)
+	}
+	if t.model == "llama" || t.hasSPMPrefix {
+		// Vocabularies without token types still mark byte fallback by name.
+		if len(t.tokenTypes) == 0 {
+			if b, ok := parseByteToken(piece); ok {
+				return append(dst, b)
+			}
+		}
+		for len(piece) > 0 {
+			i := strings.Index(piece, "▁")
+			if i < 0 {
+				return append(dst, piece...)
+			}
+			dst = append(dst, piece[:i]...)
+			dst = append(dst, ' ')
+			piece = piece[i+len("▁"):]
+		}
+		return dst
+	}
+	return append(dst, piece...)
+}
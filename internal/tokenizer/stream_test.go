@@ -0,0 +1,83 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"bitnet-go/internal/gguf"
+)
+
+func TestStreamDecoderSPMByteFallback(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "llama",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", "</s>", "▁", "▁hi", "<0xE2>", "<0x82>", "<0xAC>", "<0x0A>"},
+			"tokenizer.ggml.token_type":       []int32{2, 3, 3, 1, 1, 6, 6, 6, 6},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.eos_token_id":     uint32(2),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	d := tok.NewStreamDecoder(DecodeOptions{SkipSpecial: true, TrimPrefixSpace: true})
+	var got []string
+	for _, id := range []int32{1, 4, 3, 5, 6, 7, 8, 4, 2} {
+		got = append(got, d.Add(id))
+	}
+	want := []string{"", "hi", " ", "", "", "€", "\n", " hi", ""}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Add outputs = %q, want %q", got, want)
+		}
+	}
+	if rest := d.Flush(); rest != "" {
+		t.Fatalf("Flush() = %q, want empty", rest)
+	}
+
+	d.Reset()
+	if s := d.Add(4); s != "hi" {
+		t.Fatalf("after Reset Add = %q, want %q", s, "hi")
+	}
+	d = tok.NewStreamDecoder(DecodeOptions{})
+	if s := d.Add(4) + d.Add(5) + d.Add(6); s != " hi" {
+		t.Fatalf("partial = %q, want %q", s, " hi")
+	}
+	if s := d.Flush(); s != "�" {
+		t.Fatalf("Flush() of incomplete rune = %q, want U+FFFD", s)
+	}
+}
+
+func TestStreamDecoderBPEMatchesDecode(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "gpt2",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "Ġ", "h", "e", "l", "o", "Ġh", "Ġhe", "Ġhel", "Ġhell", "Ġhello", "Ã", "©", "ĠÃ"},
+			"tokenizer.ggml.merges":           []string{"Ġ h", "Ġh e", "Ġhe l", "Ġhel l", "Ġhell o", "Ġ Ã"},
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	ids := tok.Tokenize(" hello é hello")
+	if !containsID(ids, 13) {
+		t.Fatalf("ids %v do not split é across tokens", ids)
+	}
+	d := tok.NewStreamDecoder(DecodeOptions{TrimPrefixSpace: true})
+	var out string
+	for _, id := range ids {
+		s := d.Add(id)
+		if id == 13 && s != " " {
+			// "ĠÃ" completes the space but not "é".
+			t.Fatalf("Add(ĠÃ) = %q, want %q", s, " ")
+		}
+		out += s
+	}
+	out += d.Flush()
+	if want := tok.Decode(ids); out != want {
+		t.Fatalf("streamed %q, Decode %q", out, want)
+	}
+}
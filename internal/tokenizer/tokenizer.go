@@ -11,14 +11,20 @@ import (
 	"bitnet-go/internal/gguf"
 )
 
+// Tokenizer encodes and decodes text for a GGUF vocabulary. It is safe for
+// concurrent use.
 type Tokenizer struct {
-	mu               sync.Mutex
 	addBOS           bool
+	addEOS           bool
 	bosTokenID       int32
+	eosTokenID       int32
+	sepTokenID       int32
 	unkTokenID       int32
 	model            string
 	preType          string
 	tokens           []string
+	tokenTypes       []int32
+	specialTrie      *trieNode
 	hasSPMPrefix     bool
 	hasBPEMerges     bool
 	vocab            map[string]int32
@@ -30,24 +36,25 @@ type Tokenizer struct {
 	byteDecodeRune   map[rune]byte
 	trie             *trieNode
 	byteTok          [256]int32
-	bpeBuf           []string
-	bpeWork          []string
-	bpeWork2         []string
-	bpeRankBuf       []int
-	bpeByteBuf       []byte
-	bpeRuneBuf       []rune
 	bpeByteSym       [256]string
-	bpeKeyBuf        []byte
-	bpeChunkCache    *bpeChunkCache
-	bpeChunkCacheCap int
-	bpeMergeCache    map[bpePair]string
 	bpeMergeCacheCap int
-	spmChunkCache    *bpeChunkCache
-	spmChunkCacheCap int
-	spmSymbolPool    []spmSymbol
-	spmHeapPool      spmBigramHeap
-	spmMergePool     map[string][2]int
-	spmIndexStack    []int
+
+	// Encoding is safe for concurrent use: chunkCache is sharded and each
+	// call takes its buffers from scratchPool.
+	chunkCache  *chunkCache
+	scratchPool sync.Pool
+
+	// Unigram ("t5") state.
+	charsMap          *charsMap
+	ugmTrie           *trieNode
+	userDefinedTrie   *trieNode
+	ugmUnknownScore   float32
+	addSpacePrefix    bool
+	removeExtraSpaces bool
+
+	// WordPiece ("bert") piece spelling.
+	wpmWordPrefix string
+	wpmContPrefix string
 }
 
 type bpePair struct {
@@ -76,6 +83,8 @@ func NewFromModelInfo(info gguf.ModelInfo) (*Tokenizer, error) {
 	t := &Tokenizer{
 		addBOS:           defaultAddBOS,
 		bosTokenID:       int32(firstUint32(info.KeyValues["tokenizer.ggml.bos_token_id"])),
+		eosTokenID:       -1,
+		sepTokenID:       -1,
 		unkTokenID:       int32(firstUint32(info.KeyValues["tokenizer.ggml.unknown_token_id"])),
 		model:            model,
 		preType:          firstString(info.KeyValues["tokenizer.ggml.pre"]),
@@ -85,15 +94,20 @@ func NewFromModelInfo(info gguf.ModelInfo) (*Tokenizer, error) {
 		bpeRanks:         make(map[string]int),
 		bpeRanksPair:     make(map[bpePair]int),
 		trie:             newTrieNode(),
-		bpeChunkCacheCap: 256,
 		bpeMergeCacheCap: 4096,
-		spmChunkCacheCap: 256,
-	}
-	if v, ok := info.KeyValues["bitnet.tokenizer.bpe_cache_size"].(uint32); ok {
-		t.bpeChunkCacheCap = int(v)
 	}
-	if v, ok := info.KeyValues["bitnet.tokenizer.spm_cache_size"].(uint32); ok {
-		t.spmChunkCacheCap = int(v)
+	switch model {
+	case "llama", "gpt2":
+		// Only the SPM and BPE paths cache whole chunks.
+		capacity := 256
+		key := "bitnet.tokenizer.spm_cache_size"
+		if model == "gpt2" {
+			key = "bitnet.tokenizer.bpe_cache_size"
+		}
+		if v, ok := info.KeyValues[key].(uint32); ok {
+			capacity = int(v)
+		}
+		t.chunkCache = newChunkCache(capacity)
 	}
 	if v, ok := info.KeyValues["bitnet.tokenizer.bpe_merge_cache_size"].(uint32); ok {
 		t.bpeMergeCacheCap = int(v)
@@ -104,9 +118,42 @@ func NewFromModelInfo(info gguf.ModelInfo) (*Tokenizer, error) {
 	for i := range t.byteTok {
 		t.byteTok[i] = t.unkTokenID
 	}
+	if v, ok := info.KeyValues["tokenizer.ggml.eos_token_id"]; ok {
+		t.eosTokenID = int32(firstUint32(v))
+	}
+	switch model {
+	case "t5":
+		// Unigram models append EOS rather than prepend BOS.
+		t.addEOS = true
+		if _, ok := info.KeyValues["tokenizer.ggml.bos_token_id"]; !ok {
+			t.bosTokenID = -1
+		}
+	case "bert":
+		// WordPiece wraps text in [CLS] ... [SEP].
+		t.addBOS, t.addEOS = true, true
+		if v, ok := info.KeyValues["tokenizer.ggml.cls_token_id"]; ok {
+			t.bosTokenID = int32(firstUint32(v))
+		}
+		t.sepTokenID = t.eosTokenID
+		if v, ok := info.KeyValues["tokenizer.ggml.seperator_token_id"]; ok {
+			t.sepTokenID = int32(firstUint32(v))
+		}
+	}
 	if v, ok := info.KeyValues["tokenizer.ggml.add_bos_token"].(bool); ok {
 		t.addBOS = v
 	}
+	if v, ok := info.KeyValues["tokenizer.ggml.add_eos_token"].(bool); ok {
+		t.addEOS = v
+	}
+	if v, ok := info.KeyValues["tokenizer.ggml.add_space_prefix"].(bool); ok {
+		t.addSpacePrefix = v
+	}
+	if v, ok := info.KeyValues["tokenizer.ggml.remove_extra_whitespaces"].(bool); ok {
+		t.removeExtraSpaces = v
+	}
+	if types, ok := info.KeyValues["tokenizer.ggml.token_type"].([]int32); ok && len(types) == len(tokens) {
+		t.tokenTypes = types
+	}
 
 	for i, piece := range tokens {
 		id := int32(i)
@@ -116,6 +163,7 @@ func NewFromModelInfo(info gguf.ModelInfo) (*Tokenizer, error) {
 			t.byteTok[b] = id
 		}
 	}
+	t.specialTrie = t.buildSpecialTrie()
 	t.byteEncode = buildByteEncoder()
 	t.byteDecode = buildByteDecoder(t.byteEncode[:])
 	t.byteDecodeRune = buildByteRuneDecoder(t.byteEncode[:])
@@ -143,158 +191,211 @@ func NewFromModelInfo(info gguf.ModelInfo) (*Tokenizer, error) {
 			break
 		}
 	}
+	switch t.model {
+	case "t5":
+		charsmap, _ := info.KeyValues["tokenizer.ggml.precompiled_charsmap"].([]byte)
+		if err := t.initUGM(charsmap); err != nil {
+			return nil, err
+		}
+	case "bert":
+		t.initWPM()
+	}
+	if t.model == "gpt2" && !isKnownBPEPreType(t.preType) {
+		// Splitting with the wrong regex silently yields different IDs.
+		return nil, fmt.Errorf("unsupported tokenizer.ggml.pre %q", t.preType)
+	}
 	return t, nil
 }
 
+// Tokenize encodes prompt with the model's add-BOS/EOS settings and
+// without parsing special tokens.
 func (t *Tokenizer) Tokenize(prompt string) []int32 {
+	return t.Encode(prompt, EncodeOptions{AddBOS: t.addBOS, AddEOS: t.addEOS})
+}
+
+// EncodeOptions controls Encode.
+type EncodeOptions struct {
+	// AddBOS prepends the BOS token ([CLS] for WordPiece models), if the
+	// vocabulary has one.
+	AddBOS bool
+	// AddEOS appends the EOS token ([SEP] for WordPiece models), if the
+	// vocabulary has one.
+	AddEOS bool
+	// ParseSpecial maps the text of control and user-defined tokens (e.g.
+	// "<|eot_id|>") to their IDs instead of tokenizing it as plain text.
+	ParseSpecial bool
+}
+
+// Encode tokenizes text. With ParseSpecial the text is split around special
+// tokens and each plain fragment is tokenized on its own, as llama.cpp does.
+func (t *Tokenizer) Encode(text string, opts EncodeOptions) []int32 {
 	if t.trie == nil {
 		return nil
 	}
-
-	out := make([]int32, 0, len(prompt))
-	if t.addBOS {
+	out := make([]int32, 0, len(text)+1)
+	if opts.AddBOS && t.bosTokenID >= 0 {
 		out = append(out, t.bosTokenID)
 	}
+	if !opts.ParseSpecial || t.specialTrie == nil {
+		out = append(out, t.encodeText(text)...)
+	} else {
+		start := 0
+		for i := 0; i < len(text); {
+			id, n := t.matchSpecial(text, i)
+			if n == 0 {
+				i++
+				continue
+			}
+			if start < i {
+				out = append(out, t.encodeText(text[start:i])...)
+			}
+			out = append(out, id)
+			i += n
+			start = i
+		}
+		if start < len(text) {
+			out = append(out, t.encodeText(text[start:])...)
+		}
+	}
+	if end := t.endTokenID(); opts.AddEOS && end >= 0 {
+		out = append(out, end)
+	}
+	return out
+}
 
+// endTokenID is the token AddEOS appends, or -1 if there is none.
+func (t *Tokenizer) endTokenID() int32 {
+	if t.model == "bert" && t.sepTokenID >= 0 {
+		return t.sepTokenID
+	}
+	return t.eosTokenID
+}
+
+func (t *Tokenizer) encodeText(prompt string) []int32 {
 	if t.model == "llama" {
 		normalized := normalizeSPM(prompt)
-		t.mu.Lock()
-		if t.spmChunkCache == nil {
-			t.spmChunkCache = newBPEChunkCache(t.spmChunkCacheCap)
-		}
-		cached := t.spmChunkCache.get(normalized)
-		t.mu.Unlock()
-		if cached != nil {
-			out = append(out, cached...)
-			return out
+		if cached := t.chunkCache.get(normalized); cached != nil {
+			return cached
 		}
 		encoded := t.tokenizeSPM(normalized)
-		t.mu.Lock()
-		t.spmChunkCache.add(normalized, encoded)
-		t.mu.Unlock()
-		out = append(out, encoded...)
-		return out
+		t.chunkCache.add(normalized, encoded)
+		return encoded
 	}
 	if t.model == "gpt2" && len(t.bpeRanks) > 0 {
-		out = append(out, t.tokenizeBPE(prompt)...)
-		return out
+		return t.tokenizeBPE(prompt)
+	}
+	if t.model == "t5" {
+		normalized, _ := t.normalizeUGM(prompt)
+		ids, _ := t.tokenizeUGM(normalized)
+		return ids
+	}
+	if t.model == "bert" {
+		words, ends, _ := wpmWords(prompt)
+		ids, _ := t.tokenizeWPM(words, ends)
+		return ids
 	}
 
 	text := prompt
 	if !strings.HasPrefix(text, " ") {
 		text = " " + text
 	}
-	out = append(out, t.tokenizeGreedy(text)...)
-	return out
+	return t.tokenizeGreedy(text)
 }
 
-func (t *Tokenizer) Decode(tokens []int32) string {
+// DecodeOptions controls DecodeWith.
+type DecodeOptions struct {
+	// SkipSpecial drops control and unknown tokens such as BOS and EOS;
+	// otherwise their text is written verbatim.
+	SkipSpecial bool
+	// TrimPrefixSpace drops the space SentencePiece and greedy encoding put
+	// in front of the text, for decoding a sequence from its start rather
+	// than a continuation.
+	TrimPrefixSpace bool
+}
+
+// DecodeWith converts tokens back to text. Byte tokens are reassembled
+// into raw bytes, so the result is only valid UTF-8 if the tokens are.
+func (t *Tokenizer) DecodeWith(tokens []int32, opts DecodeOptions) string {
 	if len(tokens) == 0 || len(t.tokens) == 0 {
 		return ""
 	}
-	if t.hasBPEMerges || t.model == "gpt2" {
-		out := make([]byte, 0, len(tokens)*4)
-		for _, id := range tokens {
-			if id < 0 || int(id) >= len(t.tokens) {
-				continue
-			}
-			piece := t.tokens[id]
-			for _, r := range piece {
-				if b, ok := t.byteDecodeRune[r]; ok {
-					out = append(out, b)
-				} else {
-					out = utf8.AppendRune(out, r)
-				}
-			}
-		}
-		return string(out)
-	}
-	if t.model == "llama" || t.hasSPMPrefix {
-		var b strings.Builder
-		for _, id := range tokens {
-			if id < 0 || int(id) >= len(t.tokens) {
-				continue
-			}
-			piece := t.tokens[id]
-			if piece == "▁" {
-				b.WriteByte(' ')
-				continue
-			}
-			if strings.HasPrefix(piece, "▁") {
-				b.WriteByte(' ')
-				b.WriteString(piece[3:])
-				continue
-			}
-			b.WriteString(piece)
-		}
-		return b.String()
-	}
-	var b strings.Builder
+	out := make([]byte, 0, len(tokens)*4)
 	for _, id := range tokens {
-		if id < 0 || int(id) >= len(t.tokens) {
-			continue
-		}
-		b.WriteString(t.tokens[id])
+		out = t.appendTokenBytes(out, id, !opts.SkipSpecial)
+	}
+	if opts.TrimPrefixSpace && t.insertsPrefixSpace() && len(out) > 0 && out[0] == ' ' {
+		out = out[1:]
 	}
-	return b.String()
+	return string(out)
+}
+
+// Decode converts tokens back to text the way llama.cpp renders them by
+// default: control, unknown and unused tokens produce nothing.
+func (t *Tokenizer) Decode(tokens []int32) string {
+	return t.DecodeWith(tokens, DecodeOptions{SkipSpecial: true})
 }
 
 func (t *Tokenizer) tokenizeBPE(prompt string) []int32 {
 	if prompt == "" {
 		return nil
 	}
-	t.mu.Lock()
-	if t.bpeChunkCache == nil {
-		t.bpeChunkCache = newBPEChunkCache(t.bpeChunkCacheCap)
-	}
-	t.mu.Unlock()
 	chunks := t.splitBPEPieces(prompt)
 	if len(chunks) == 0 {
 		chunks = []string{prompt}
 	}
+	s := t.getScratch()
+	defer t.putScratch(s)
 	out := make([]int32, 0, len(prompt))
 	for _, chunk := range chunks {
-		t.mu.Lock()
-		encoded := t.bpeChunkCache.get(chunk)
-		t.mu.Unlock()
-		if encoded == nil {
-			encoded = t.encodeBPEWord(t.bpeByteMap(chunk))
-			t.mu.Lock()
-			t.bpeChunkCache.add(chunk, encoded)
-			t.mu.Unlock()
-		}
-		out = append(out, encoded...)
+		out = append(out, t.encodeBPEChunk(s, chunk)...)
 	}
 	return out
 }
 
+// encodeBPEChunk encodes one pre-tokenized chunk through the chunk cache.
+func (t *Tokenizer) encodeBPEChunk(s *scratch, chunk string) []int32 {
+	encoded := t.chunkCache.get(chunk)
+	if encoded == nil {
+		encoded = t.encodeBPEWord(s, t.bpeByteMap(s, chunk))
+		t.chunkCache.add(chunk, encoded)
+	}
+	return encoded
+}
+
+// splitBPEPieces pre-tokenizes text for the model's tokenizer.ggml.pre.
 func (t *Tokenizer) splitBPEPieces(text string) []string {
-	if isLlama3PreType(t.preType) {
+	return splitBPEText(t.preType, text)
+}
+
+// splitBPEText pre-tokenizes text for tokenizer.ggml.pre type pre. Unknown
+// types, which NewFromModelInfo rejects, split like GPT-2.
+func splitBPEText(pre, text string) []string {
+	pre = normalizePreType(pre)
+	if res, ok := bpePreSplitters[pre]; ok {
+		return splitPreRegexes(text, res)
+	}
+	if isLlama3PreType(pre) {
 		return splitLlama3(text)
 	}
 	return splitGPT2(text)
 }
 
 func normalizePreType(pre string) string {
-	return strings.ToLower(strings.TrimSpace(pre))
+	pre = strings.ToLower(strings.TrimSpace(pre))
+	if pre == "default" {
+		return ""
+	}
+	return pre
 }
 
 func isKnownBPEPreType(pre string) bool {
-	switch normalizePreType(pre) {
-	case "", "gpt-2", "falcon", "qwen2", "smollm", "llama3", "dbrx", "smaug":
-		return true
-	default:
-		return false
-	}
+	_, ok := bpePreRegexes[normalizePreType(pre)]
+	return ok
 }
 
 func isLlama3PreType(pre string) bool {
-	switch normalizePreType(pre) {
-	case "llama3", "dbrx", "smaug":
-		return true
-	default:
-		return false
-	}
+	exprs := bpePreRegexes[normalizePreType(pre)]
+	return len(exprs) == 1 && exprs[0] == llama3PreRegex
 }
 
 func splitGPT2(s string) []string {
@@ -342,9 +443,16 @@ func splitByRules(s string, llama3 bool) []string {
 			j++
 		}
 
-		// letters
-		if j < len(rs) && unicode.IsLetter(rs[j]) {
-			k := j
+		// letters; llama3 allows any one leading character but \r, \n or a number
+		lj := j
+		if llama3 {
+			lj = i
+			if i+1 < len(rs) && rs[i] != '\r' && rs[i] != '\n' && !unicode.IsLetter(rs[i]) && !unicode.IsNumber(rs[i]) {
+				lj = i + 1
+			}
+		}
+		if lj < len(rs) && unicode.IsLetter(rs[lj]) {
+			k := lj
 			for k < len(rs) && unicode.IsLetter(rs[k]) {
 				k++
 			}
@@ -352,14 +460,15 @@ func splitByRules(s string, llama3 bool) []string {
 			i = k
 			continue
 		}
-		// numbers
-		if j < len(rs) && unicode.IsNumber(rs[j]) {
+		// numbers; llama3's \p{N}{1,3} takes no leading space
+		if llama3 && unicode.IsNumber(rs[i]) || !llama3 && j < len(rs) && unicode.IsNumber(rs[j]) {
 			k := j
 			if llama3 {
+				k = i
 				for k < len(rs) && unicode.IsNumber(rs[k]) {
-					step := k + 3
-					if step > len(rs) {
-						step = len(rs)
+					step := k + 1
+					for step < len(rs) && step-k < 3 && unicode.IsNumber(rs[step]) {
+						step++
 					}
 					out = append(out, string(rs[k:step]))
 					k = step
@@ -458,8 +567,15 @@ func splitByRulesASCII(s string, llama3 bool) []string {
 			j++
 		}
 
-		if j < len(s) && isASCIILetter(s[j]) {
-			k := j
+		lj := j
+		if llama3 {
+			lj = i
+			if i+1 < len(s) && s[i] != '\r' && s[i] != '\n' && !isASCIILetter(s[i]) && !isASCIIDigit(s[i]) {
+				lj = i + 1
+			}
+		}
+		if lj < len(s) && isASCIILetter(s[lj]) {
+			k := lj
 			for k < len(s) && isASCIILetter(s[k]) {
 				k++
 			}
@@ -467,13 +583,14 @@ func splitByRulesASCII(s string, llama3 bool) []string {
 			i = k
 			continue
 		}
-		if j < len(s) && isASCIIDigit(s[j]) {
+		if llama3 && isASCIIDigit(s[i]) || !llama3 && j < len(s) && isASCIIDigit(s[j]) {
 			k := j
 			if llama3 {
+				k = i
 				for k < len(s) && isASCIIDigit(s[k]) {
-					step := k + 3
-					if step > len(s) {
-						step = len(s)
+					step := k + 1
+					for step < len(s) && step-k < 3 && isASCIIDigit(s[step]) {
+						step++
 					}
 					out = append(out, s[k:step])
 					k = step
@@ -583,26 +700,26 @@ func isASCIISpace(b byte) bool {
 	return asciiClassTable[b]&asciiClassSpace != 0
 }
 
-func (t *Tokenizer) encodeBPEWord(word string) []int32 {
+func (t *Tokenizer) encodeBPEWord(s *scratch, word string) []int32 {
 	if word == "" {
 		return nil
 	}
-	syms := t.bpeBuf[:0]
+	syms := s.bpeSyms[:0]
 	if isASCII(word) {
 		for i := 0; i < len(word); i++ {
 			syms = append(syms, t.bpeByteSym[word[i]])
 		}
 	} else {
-		runes := t.bpeRuneBuf[:0]
+		runes := s.bpeRunes[:0]
 		for _, r := range word {
 			runes = append(runes, r)
 		}
-		t.bpeRuneBuf = runes[:0]
+		s.bpeRunes = runes[:0]
 		for i := range runes {
 			syms = append(syms, string(runes[i]))
 		}
 	}
-	ranks := t.bpeRankBuf[:0]
+	ranks := s.bpeRanks[:0]
 	if cap(ranks) < len(syms)-1 {
 		ranks = make([]int, len(syms)-1)
 	} else {
@@ -615,7 +732,7 @@ func (t *Tokenizer) encodeBPEWord(word string) []int32 {
 			}
 			return -1
 		}
-		key := t.pairKey(a, b)
+		key := s.pairKey(a, b)
 		if r, ok := t.bpeRanks[key]; ok {
 			return r
 		}
@@ -640,7 +757,7 @@ func (t *Tokenizer) encodeBPEWord(word string) []int32 {
 		if bestIdx < 0 {
 			break
 		}
-		merged := t.mergePair(syms[bestIdx], syms[bestIdx+1])
+		merged := t.mergePair(s, syms[bestIdx], syms[bestIdx+1])
 		syms[bestIdx] = merged
 		copy(syms[bestIdx+1:], syms[bestIdx+2:])
 		syms = syms[:len(syms)-1]
@@ -653,15 +770,15 @@ func (t *Tokenizer) encodeBPEWord(word string) []int32 {
 			ranks[bestIdx] = rankFor(syms[bestIdx], syms[bestIdx+1])
 		}
 	}
-	t.bpeRankBuf = ranks[:0]
+	s.bpeRanks = ranks[:0]
 
 	out := make([]int32, 0, len(syms))
-	for _, s := range syms {
-		if id, ok := t.vocab[s]; ok {
+	for _, sym := range syms {
+		if id, ok := t.vocab[sym]; ok {
 			out = append(out, id)
 			continue
 		}
-		for _, r := range s {
+		for _, r := range sym {
 			if id, ok := t.vocab[string(r)]; ok {
 				out = append(out, id)
 			} else {
@@ -669,55 +786,53 @@ func (t *Tokenizer) encodeBPEWord(word string) []int32 {
 			}
 		}
 	}
-	t.bpeBuf = syms[:0]
+	s.bpeSyms = syms[:0]
 	return out
 }
 
-func (t *Tokenizer) pairKey(left, right string) string {
-	keyBuf := t.bpeKeyBuf
-	keyBuf = keyBuf[:0]
+func (s *scratch) pairKey(left, right string) string {
+	keyBuf := s.bpeKey[:0]
 	keyBuf = append(keyBuf, left...)
 	keyBuf = append(keyBuf, 0)
 	keyBuf = append(keyBuf, right...)
 	key := string(keyBuf)
-	t.bpeKeyBuf = keyBuf[:0]
+	s.bpeKey = keyBuf[:0]
 	return key
 }
 
-func (t *Tokenizer) mergePair(left, right string) string {
+// mergePair concatenates a merged pair, memoized per scratch so repeated
+// merges reuse one string without any locking.
+func (t *Tokenizer) mergePair(s *scratch, left, right string) string {
 	if t.bpeMergeCacheCap <= 0 {
 		return left + right
 	}
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.bpeMergeCache == nil {
-		t.bpeMergeCache = make(map[bpePair]string, t.bpeMergeCacheCap)
-	} else if len(t.bpeMergeCache) >= t.bpeMergeCacheCap {
-		for k := range t.bpeMergeCache {
-			delete(t.bpeMergeCache, k)
-		}
+	if s.bpeMerged == nil {
+		// Grown on demand: every pooled scratch carries its own memo.
+		s.bpeMerged = make(map[bpePair]string)
+	} else if len(s.bpeMerged) >= t.bpeMergeCacheCap {
+		clear(s.bpeMerged)
 	}
 	key := bpePair{a: left, b: right}
-	if v, ok := t.bpeMergeCache[key]; ok {
+	if v, ok := s.bpeMerged[key]; ok {
 		return v
 	}
 	merged := left + right
-	t.bpeMergeCache[key] = merged
+	s.bpeMerged[key] = merged
 	return merged
 }
 
-func (t *Tokenizer) bpeByteMap(s string) string {
-	n := len(s)
-	buf := t.bpeByteBuf
+func (t *Tokenizer) bpeByteMap(s *scratch, text string) string {
+	n := len(text)
+	buf := s.bpeBytes
 	if cap(buf) < n*2 {
 		buf = make([]byte, 0, n*2)
 	} else {
 		buf = buf[:0]
 	}
 	for i := 0; i < n; i++ {
-		buf = append(buf, t.byteEncode[s[i]]...)
+		buf = append(buf, t.byteEncode[text[i]]...)
 	}
-	t.bpeByteBuf = buf[:0]
+	s.bpeBytes = buf[:0]
 	return string(buf)
 }
 
@@ -744,7 +859,16 @@ func (t *Tokenizer) tokenizeGreedy(text string) []int32 {
 }
 
 func (t *Tokenizer) tokenizeSPM(text string) []int32 {
-	syms := t.spmSymbolPool[:0]
+	out, _ := t.tokenizeSPMSpans(text, false)
+	return out
+}
+
+// tokenizeSPMSpans is tokenizeSPM that can also return the byte span of text
+// each token covers; byte-fallback tokens cover a single byte.
+func (t *Tokenizer) tokenizeSPMSpans(text string, withSpans bool) ([]int32, []Offset) {
+	s := t.getScratch()
+	defer t.putScratch(s)
+	syms := s.spmSymbols[:0]
 	if cap(syms) < len(text) {
 		syms = make([]spmSymbol, 0, len(text))
 	}
@@ -757,20 +881,17 @@ func (t *Tokenizer) tokenizeSPM(text string) []int32 {
 		i += size
 	}
 	if len(syms) == 0 {
-		return nil
+		return nil, nil
 	}
 	syms[len(syms)-1].next = -1
 
-	q := t.spmHeapPool[:0]
-	t.spmHeapPool = q[:0]
+	q := s.spmHeap[:0]
 	heap.Init(&q)
-	revMerge := t.spmMergePool
+	revMerge := s.spmMerges
 	if revMerge == nil {
 		revMerge = make(map[string][2]int, len(syms))
 	} else {
-		for k := range revMerge {
-			delete(revMerge, k)
-		}
+		clear(revMerge)
 	}
 
 	tryAddBigram := func(left, right int) {
@@ -811,7 +932,11 @@ func (t *Tokenizer) tokenizeSPM(text string) []int32 {
 	}
 
 	out := make([]int32, 0, len(text))
-	intStack := t.spmIndexStack[:0]
+	var spans []Offset
+	if withSpans {
+		spans = make([]Offset, 0, len(text))
+	}
+	intStack := s.spmStack[:0]
 	if cap(intStack) < len(text) {
 		intStack = make([]int, 0, len(text))
 	}
@@ -826,13 +951,16 @@ func (t *Tokenizer) tokenizeSPM(text string) []int32 {
 			if idx < 0 || idx >= len(syms) {
 				continue
 			}
-			s := syms[idx]
-			if s.n == 0 {
+			sym := syms[idx]
+			if sym.n == 0 {
 				continue
 			}
-			piece := text[s.start : s.start+s.n]
+			piece := text[sym.start : sym.start+sym.n]
 			if id, ok := t.vocab[piece]; ok {
 				out = append(out, id)
+				if withSpans {
+					spans = append(spans, Offset{Start: sym.start, End: sym.start + sym.n})
+				}
 				continue
 			}
 			if pair, ok := revMerge[piece]; ok {
@@ -841,16 +969,19 @@ func (t *Tokenizer) tokenizeSPM(text string) []int32 {
 				intStack = append(intStack, pair[0])
 				continue
 			}
-			for i := s.start; i < s.start+s.n; i++ {
+			for i := sym.start; i < sym.start+sym.n; i++ {
 				out = append(out, t.byteTok[text[i]])
+				if withSpans {
+					spans = append(spans, Offset{Start: i, End: i + 1})
+				}
 			}
 		}
 	}
-	t.spmSymbolPool = syms[:0]
-	t.spmHeapPool = q[:0]
-	t.spmMergePool = revMerge
-	t.spmIndexStack = intStack[:0]
-	return out
+	s.spmSymbols = syms[:0]
+	s.spmHeap = q[:0]
+	s.spmMerges = revMerge
+	s.spmStack = intStack[:0]
+	return out, spans
 }
 
 func normalizeSPM(prompt string) string {
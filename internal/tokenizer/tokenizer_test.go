@@ -1,11 +1,16 @@
 package tokenizer
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"bitnet-go/internal/gguf"
@@ -173,7 +178,9 @@ func TestTokenizerPreTypeSplitAliases(t *testing.T) {
 	wantGPT2 := splitByRules(input, false)
 	wantLlama3 := splitByRules(input, true)
 
-	gptAliases := []string{"", "gpt-2", "falcon", "qwen2", "smollm"}
+	// Falcon and smollm split punctuation and digits further, and qwen2
+	// matches contractions case-insensitively, but none differ here.
+	gptAliases := []string{"", "gpt-2", "falcon", "smollm", "olmo", "jais"}
 	for _, pre := range gptAliases {
 		tok := &Tokenizer{preType: pre}
 		got := tok.splitBPEPieces(input)
@@ -182,7 +189,7 @@ func TestTokenizerPreTypeSplitAliases(t *testing.T) {
 		}
 	}
 
-	llamaAliases := []string{"llama3", "dbrx", "smaug"}
+	llamaAliases := []string{"llama3", "llama-bpe", "dbrx", "smaug", "chatglm-bpe", "qwen2"}
 	for _, pre := range llamaAliases {
 		tok := &Tokenizer{preType: pre}
 		got := tok.splitBPEPieces(input)
@@ -197,7 +204,7 @@ func TestTokenizerPreTypeNormalization(t *testing.T) {
 	wantGPT2 := splitByRules(input, false)
 	wantLlama3 := splitByRules(input, true)
 
-	gptNormalized := []string{"  FALCON  ", " QWEN2", "SmOlLm "}
+	gptNormalized := []string{"  FALCON  ", "SmOlLm ", "Default"}
 	for _, pre := range gptNormalized {
 		tok := &Tokenizer{preType: pre}
 		got := tok.splitBPEPieces(input)
@@ -206,7 +213,7 @@ func TestTokenizerPreTypeNormalization(t *testing.T) {
 		}
 	}
 
-	llamaNormalized := []string{" LLAMA3 ", "dBrX", " SmAug"}
+	llamaNormalized := []string{" LLAMA3 ", "dBrX", " SmAug", " QWEN2"}
 	for _, pre := range llamaNormalized {
 		tok := &Tokenizer{preType: pre}
 		got := tok.splitBPEPieces(input)
@@ -216,10 +223,80 @@ func TestTokenizerPreTypeNormalization(t *testing.T) {
 	}
 }
 
+func TestTokenizerPreTypeSplits(t *testing.T) {
+	cases := []struct {
+		pre   string
+		input string
+		want  []string
+	}{
+		{"falcon", "Hello, world! 123456 it's me.", []string{"Hello", ",", " world", "!", " ", "123", "456", " it", "'", "s", " me", "."}},
+		{"qwen2", "Hello, world! 123456 it's me.", []string{"Hello", ",", " world", "!", " ", "1", "2", "3", "4", "5", "6", " it", "'s", " me", "."}},
+		{"stablelm2", "It'S 42", []string{"It", "'S", " ", "4", "2"}},
+		{"starcoder", "abc 123 it's", []string{"abc", " ", "1", "2", "3", " it", "'s"}},
+		{"refact", "x1", []string{"x", "1"}},
+		{"command-r", "a  b", []string{"a", " ", " b"}},
+		{"olmo", "it's 42", []string{"it", "'s", " 42"}},
+		{"jais", "it's 42", []string{"it", "'s", " 42"}},
+		{"chatglm-bpe", "(hello) 2024", []string{"(hello", ")", " ", "202", "4"}},
+		{"deepseek-coder", "def f(x):\n  return 42", []string{"def", " f", "(", "x", "):", "\n", " ", " return", " ", "4", "2"}},
+		{"deepseek-llm", "Hello 世界 123!", []string{"Hello", " ", "世界", " ", "123", "!"}},
+		{"tekken", "HelloWorld 3.14 ABCdef", []string{"Hello", "World", " ", "3", ".", "1", "4", " ABCdef"}},
+		{"gpt-4o", "I'm 12345 OK", []string{"I'm", " ", "123", "45", " OK"}},
+	}
+	for _, tc := range cases {
+		got := (&Tokenizer{preType: tc.pre}).splitBPEPieces(tc.input)
+		if !equalStringSlices(got, tc.want) {
+			t.Errorf("pre=%q split(%q) = %q, want %q", tc.pre, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestTokenizerPreRegexMatchesHandSplitters(t *testing.T) {
+	gpt2 := []*preRegex{compilePreRegex(gpt2PreRegex)}
+	llama3 := []*preRegex{compilePreRegex(llama3PreRegex)}
+	inputs := []string{
+		"Hello, world! 1234",
+		"  spaces   here \n\n tabs\t\tend  ",
+		"it's They'RE we'll",
+		"(héllo) 日本語 123456 x1y",
+		"a\r\n\r\nb",
+		"x  \n y",
+		"\thello ...\n\nok",
+	}
+	for _, in := range inputs {
+		if got, want := splitGPT2(in), splitPreRegexes(in, gpt2); !equalStringSlices(got, want) {
+			t.Errorf("splitGPT2(%q) = %q, regex gives %q", in, got, want)
+		}
+		if got, want := splitLlama3(in), splitPreRegexes(in, llama3); !equalStringSlices(got, want) {
+			t.Errorf("splitLlama3(%q) = %q, regex gives %q", in, got, want)
+		}
+	}
+}
+
+func TestTokenizerUnknownPreTypeRejected(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":  "gpt2",
+			"tokenizer.ggml.pre":    "not-a-real-pre",
+			"tokenizer.ggml.tokens": []string{"a", "b", "ab"},
+			"tokenizer.ggml.merges": []string{"a b"},
+		},
+	}
+	if _, err := NewFromModelInfo(info); err == nil || !strings.Contains(err.Error(), "not-a-real-pre") {
+		t.Fatalf("NewFromModelInfo() error = %v, want unsupported tokenizer.ggml.pre", err)
+	}
+}
+
 func TestTokenizerKnownPreTypesForFixtures(t *testing.T) {
 	root := filepath.Join("..", "..", "testdata")
-	wantGPT2 := splitByRules("'S test 1234", false)
-	wantLlama3 := splitByRules("'S test 1234", true)
+	wantByPre := map[string][]string{
+		"":          {"'", "S", " test", " 1234"},
+		"gpt-2":     {"'", "S", " test", " 1234"},
+		"falcon":    {"'", "S", " test", " ", "123", "4"},
+		"qwen2":     {"'S", " test", " ", "1", "2", "3", "4"},
+		"llama3":    {"'S", " test", " ", "123", "4"},
+		"llama-bpe": {"'S", " test", " ", "123", "4"},
+	}
 
 	fixtures := []struct {
 		name      string
@@ -254,11 +331,11 @@ func TestTokenizerKnownPreTypesForFixtures(t *testing.T) {
 				t.Fatalf("unknown tokenizer.ggml.pre=%q in fixture %s; add explicit alias mapping and tests", pre, tc.modelFile)
 			}
 
-			got := (&Tokenizer{preType: pre}).splitBPEPieces("'S test 1234")
-			want := wantGPT2
-			if isLlama3PreType(pre) {
-				want = wantLlama3
+			want, ok := wantByPre[normalizePreType(pre)]
+			if !ok {
+				t.Fatalf("no expected split for tokenizer.ggml.pre=%q in fixture %s; add one", pre, tc.modelFile)
 			}
+			got := (&Tokenizer{preType: pre}).splitBPEPieces("'S test 1234")
 			if !equalStringSlices(got, want) {
 				t.Fatalf("fixture %s pre=%q split mismatch: got=%v want=%v", tc.modelFile, pre, got, want)
 			}
@@ -266,6 +343,72 @@ func TestTokenizerKnownPreTypesForFixtures(t *testing.T) {
 	}
 }
 
+// TestTokenizerPreTypeVocabFixtures checks each BPE pre-tokenizer against
+// the test corpora llama.cpp ships next to its vocab-only models
+// (ggml-vocab-<name>.gguf.inp/.out, token IDs from the HF tokenizer).
+func TestTokenizerPreTypeVocabFixtures(t *testing.T) {
+	root := filepath.Join("..", "..", "testdata")
+	vocabs := []struct{ name, pre string }{
+		{"gpt-2", "gpt-2"},
+		{"falcon", "falcon"},
+		{"qwen2", "qwen2"},
+		{"llama-bpe", "llama-bpe"},
+		{"mpt", "mpt"},
+		{"deepseek-llm", "deepseek-llm"},
+		{"deepseek-coder", "deepseek-coder"},
+		{"starcoder", "starcoder"},
+		{"refact", "refact"},
+		{"command-r", "command-r"},
+		{"smollm", "smollm"},
+		{"olmo", "olmo"},
+		{"stablelm2", "stablelm2"},
+		{"jais", "jais"},
+		{"tekken", "tekken"},
+		{"chatglm-bpe", "chatglm-bpe"},
+		{"gpt-4o", "gpt-4o"},
+	}
+	for _, v := range vocabs {
+		t.Run(v.name, func(t *testing.T) {
+			modelPath := filepath.Join(root, "ggml-vocab-"+v.name+".gguf")
+			inp, errInp := os.ReadFile(modelPath + ".inp")
+			out, errOut := os.ReadFile(modelPath + ".out")
+			if _, err := os.Stat(modelPath); err != nil || errInp != nil || errOut != nil {
+				t.Skipf("vocab fixture missing: %s; run scripts/fetch_bpe_vocab_fixtures.sh", modelPath)
+			}
+			info, err := gguf.ReadModelInfo(modelPath)
+			if err != nil {
+				t.Fatalf("ReadModelInfo(%s) error = %v", modelPath, err)
+			}
+			if pre := firstString(info.KeyValues["tokenizer.ggml.pre"]); normalizePreType(pre) != v.pre {
+				t.Fatalf("tokenizer.ggml.pre = %q, want %q", pre, v.pre)
+			}
+			tok, err := NewFromModelInfo(info)
+			if err != nil {
+				t.Fatalf("NewFromModelInfo() error = %v", err)
+			}
+			texts := strings.Split(string(inp), "\n__ggml_vocab_test__\n")
+			texts = texts[:len(texts)-1]
+			lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+			if len(lines) != len(texts) {
+				t.Fatalf("%d expected lines for %d inputs", len(lines), len(texts))
+			}
+			for i, text := range texts {
+				var want []int32
+				for _, f := range strings.Fields(lines[i]) {
+					id, err := strconv.ParseInt(f, 10, 32)
+					if err != nil {
+						t.Fatalf("line %d: %v", i+1, err)
+					}
+					want = append(want, int32(id))
+				}
+				if got := tok.Encode(text, EncodeOptions{}); !equalIDs(got, want) {
+					t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestTokenizerGPT2FixturePrompt(t *testing.T) {
 	assertFixturePromptTokens(
 		t,
@@ -434,3 +577,467 @@ func readModelFixture(t *testing.T, root, fixtureFile string) string {
 	}
 	return strings.TrimSpace(string(b))
 }
+
+func TestTokenizerEncodeParseSpecial(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "llama",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", "</s>", "▁", "▁hi", "<|eot|>", "<0x41>", "hi"},
+			"tokenizer.ggml.token_type":       []int32{2, 3, 3, 1, 1, 3, 6, 1},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.eos_token_id":     uint32(2),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+			"tokenizer.ggml.add_bos_token":    true,
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	got := tok.Encode("hi<|eot|>hi", EncodeOptions{AddBOS: true, ParseSpecial: true})
+	want := []int32{1, 4, 5, 4}
+	if !equalIDs(got, want) {
+		t.Fatalf("ParseSpecial: got %v, want %v", got, want)
+	}
+	got = tok.Encode("hi<|eot|>", EncodeOptions{})
+	if len(got) < 3 || got[0] != 4 || containsID(got, 5) {
+		t.Fatalf("plain encode: got %v, want special text tokenized as text", got)
+	}
+	if text := tok.DecodeWith([]int32{1, 4, 5, 2}, DecodeOptions{SkipSpecial: true}); text != " hi" {
+		t.Fatalf("SkipSpecial decode = %q, want %q", text, " hi")
+	}
+	if text := tok.DecodeWith([]int32{4, 5}, DecodeOptions{}); text != " hi<|eot|>" {
+		t.Fatalf("decode keeping special = %q", text)
+	}
+	if id, ok := tok.ByteToken('A'); !ok || id != 6 {
+		t.Fatalf("ByteToken('A') = %d, %v", id, ok)
+	}
+	if _, ok := tok.ByteToken('B'); ok {
+		t.Fatal("ByteToken('B') found a missing byte token")
+	}
+	if piece, ok := tok.Piece(5); !ok || piece != "<|eot|>" {
+		t.Fatalf("Piece(5) = %q, %v", piece, ok)
+	}
+	if tok.VocabSize() != 8 || tok.EOS() != 2 || tok.BOS() != 1 || !tok.AddBOS() {
+		t.Fatal("unexpected vocab accessors")
+	}
+}
+
+func equalIDs(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsID(ids []int32, id int32) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func checkOffsets(t *testing.T, tok *Tokenizer, text string, wantIDs []int32, want []Offset) {
+	t.Helper()
+	ids, offs := tok.TokenizeWithOffsets(text)
+	if !equalIDs(ids, tok.Tokenize(text)) {
+		t.Fatalf("TokenizeWithOffsets ids %v differ from Tokenize %v", ids, tok.Tokenize(text))
+	}
+	if !equalIDs(ids, wantIDs) {
+		t.Fatalf("ids = %v, want %v", ids, wantIDs)
+	}
+	if len(offs) != len(want) {
+		t.Fatalf("offsets = %v, want %v", offs, want)
+	}
+	for i := range want {
+		if offs[i] != want[i] {
+			t.Fatalf("offsets = %v, want %v", offs, want)
+		}
+	}
+}
+
+func TestTokenizeWithOffsetsSPM(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "llama",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", "▁", "▁hi", "hi", "<0xC3>", "<0xA9>"},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+			"tokenizer.ggml.add_bos_token":    true,
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	// "é" is not in the vocab and falls back to its two UTF-8 bytes.
+	checkOffsets(t, tok, "hi hi é",
+		[]int32{1, 3, 3, 2, 5, 6},
+		[]Offset{{0, 0}, {0, 2}, {2, 5}, {5, 6}, {6, 7}, {7, 8}})
+}
+
+func TestTokenizeWithOffsetsBPE(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "gpt2",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "Ġ", "h", "e", "l", "o", "Ġh", "Ġhe", "Ġhel", "Ġhell", "Ġhello", "Ã", "©"},
+			"tokenizer.ggml.merges":           []string{"Ġ h", "Ġh e", "Ġhe l", "Ġhel l", "Ġhell o"},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+			"tokenizer.ggml.add_bos_token":    false,
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	// "é" is byte-mapped to "Ã©"; each half covers one UTF-8 byte. "x" is
+	// not in the vocab and becomes a one-byte <unk>.
+	checkOffsets(t, tok, " hello éx",
+		[]int32{10, 1, 11, 12, 0},
+		[]Offset{{0, 6}, {6, 7}, {7, 8}, {8, 9}, {9, 10}})
+}
+
+func TestEncodeWithoutEOSToken(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "gpt2",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "Ġ", "h", "i", "Ġh", "Ġhi"},
+			"tokenizer.ggml.merges":           []string{"Ġ h", "Ġh i"},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	if tok.EOS() >= 0 {
+		t.Fatalf("EOS() = %d, want none", tok.EOS())
+	}
+	opts := EncodeOptions{AddEOS: true}
+	if got := tok.Encode(" hi", opts); !equalIDs(got, []int32{5}) {
+		t.Fatalf("Encode(AddEOS) = %v, want [5]", got)
+	}
+	ids, offs := tok.EncodeWithOffsets(" hi", opts)
+	if !equalIDs(ids, []int32{5}) || len(offs) != 1 {
+		t.Fatalf("EncodeWithOffsets(AddEOS) = %v %v, want [5] and one offset", ids, offs)
+	}
+}
+
+func TestTokenizeWithOffsetsGreedy(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", " ", " Hello", " Bit", "Net", "H", "ello"},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+			"tokenizer.ggml.add_bos_token":    true,
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	checkOffsets(t, tok, "Hello BitNet!",
+		[]int32{1, 3, 4, 5, 0},
+		[]Offset{{0, 0}, {0, 5}, {5, 9}, {9, 12}, {12, 13}})
+}
+
+func TestTokenizerDecodeTokenTypes(t *testing.T) {
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":            "llama",
+			"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", "</s>", "▁a▁b", "<0x0A>", "<0xE2>", "<0x82>", "<0xAC>", "<tool>", "<unused0>", "<0x41>"},
+			"tokenizer.ggml.token_type":       []int32{2, 3, 3, 1, 6, 6, 6, 6, 4, 5, 1},
+			"tokenizer.ggml.bos_token_id":     uint32(1),
+			"tokenizer.ggml.eos_token_id":     uint32(2),
+			"tokenizer.ggml.unknown_token_id": uint32(0),
+		},
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	// Byte pieces become raw bytes, "▁" becomes a space everywhere, control,
+	// unknown and unused tokens vanish, user-defined tokens stay verbatim,
+	// and a NORMAL token spelled like a byte piece is not a byte.
+	ids := []int32{1, 3, 4, 5, 6, 7, 0, 8, 9, 10, 2}
+	if got, want := tok.Decode(ids), " a b\n€<tool><0x41>"; got != want {
+		t.Fatalf("Decode() = %q, want %q", got, want)
+	}
+	if got, want := tok.DecodeWith(ids, DecodeOptions{TrimPrefixSpace: true}), "<s> a b\n€<unk><tool><0x41></s>"; got != want {
+		t.Fatalf("DecodeWith(special) = %q, want %q", got, want)
+	}
+
+	// Without token_type, <0xXX> pieces are still byte fallback.
+	delete(info.KeyValues, "tokenizer.ggml.token_type")
+	tok, err = NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	if got, want := tok.Decode([]int32{1, 3, 4, 10, 2}), " a b\nA"; got != want {
+		t.Fatalf("Decode() without token types = %q, want %q", got, want)
+	}
+}
+
+func TestTokenizerDecodeFixtureCorpus(t *testing.T) {
+	root := filepath.Join("..", "..", "testdata")
+	cases := []struct{ model, expected string }{
+		{"ggml-vocab-gpt-2.gguf", "expected.gpt2_decode.jsonl"},
+		{"ggml-vocab-falcon.gguf", "expected.falcon_decode.jsonl"},
+		{"ggml-vocab-qwen2.gguf", "expected.qwen2_decode.jsonl"},
+		{readModelFixture(t, root, "model_fixture.txt"), "expected.decode.jsonl"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expected, func(t *testing.T) {
+			modelPath := filepath.Join(root, tc.model)
+			if _, err := os.Stat(modelPath); tc.model == "" || err != nil {
+				t.Skipf("skipping decode parity; model missing: %s", modelPath)
+			}
+			// With the vocab present, a missing expectation is a fixture
+			// that was never generated, not an optional check.
+			expectedBytes, err := os.ReadFile(filepath.Join(root, tc.expected))
+			if err != nil {
+				t.Fatalf("%s present but %v; run scripts/run_ref_detokenizer.sh and commit its output", tc.model, err)
+			}
+			info, err := gguf.ReadModelInfo(modelPath)
+			if err != nil {
+				t.Fatalf("ReadModelInfo(%s) error = %v", tc.model, err)
+			}
+			tok, err := NewFromModelInfo(info)
+			if err != nil {
+				t.Fatalf("NewFromModelInfo() error = %v", err)
+			}
+			for i, line := range strings.Split(strings.TrimSpace(string(expectedBytes)), "\n") {
+				var want struct {
+					IDs     []int32 `json:"ids"`
+					TextHex string  `json:"text_hex"`
+				}
+				if err := json.Unmarshal([]byte(line), &want); err != nil {
+					t.Fatalf("line %d: Unmarshal error = %v", i+1, err)
+				}
+				text, err := hex.DecodeString(want.TextHex)
+				if err != nil {
+					t.Fatalf("line %d: bad text_hex: %v", i+1, err)
+				}
+				if got := tok.Decode(want.IDs); got != string(text) {
+					t.Errorf("line %d: Decode(%v) = %q, want %q", i+1, want.IDs, got, text)
+				}
+			}
+		})
+	}
+}
+
+func newUnigramTokenizer(t *testing.T, charsmap []byte) *Tokenizer {
+	t.Helper()
+	info := gguf.ModelInfo{
+		KeyValues: map[string]any{
+			"tokenizer.ggml.model":                    "t5",
+			"tokenizer.ggml.tokens":                   []string{"<pad>", "</s>", "<unk>", "▁", "▁he", "llo", "▁hello", "h", "e", "l", "o", "<x>"},
+			"tokenizer.ggml.scores":                   []float32{0, 0, 0, -2, -3, -3, -7, -4, -4, -4, -4, -100},
+			"tokenizer.ggml.token_type":               []int32{3, 3, 2, 1, 1, 1, 1, 1, 1, 1, 1, 4},
+			"tokenizer.ggml.eos_token_id":             uint32(1),
+			"tokenizer.ggml.unknown_token_id":         uint32(2),
+			"tokenizer.ggml.add_space_prefix":         true,
+			"tokenizer.ggml.remove_extra_whitespaces": true,
+		},
+	}
+	if charsmap != nil {
+		info.KeyValues["tokenizer.ggml.precompiled_charsmap"] = charsmap
+	}
+	tok, err := NewFromModelInfo(info)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	return tok
+}
+
+func TestTokenizerUnigramViterbi(t *testing.T) {
+	tok := newUnigramTokenizer(t, nil)
+	// "▁he"+"llo" (-6) beats the longer "▁hello" (-7); "xyz" has no pieces
+	// and its three unknown code points collapse into one <unk>. Runs of
+	// spaces merge, and EOS is appended by default.
+	if got, want := tok.Tokenize("hello  xyz"), []int32{4, 5, 3, 2, 1}; !equalIDs(got, want) {
+		t.Fatalf("Tokenize() = %v, want %v", got, want)
+	}
+	// User-defined tokens score 0 and survive normalization whole.
+	if got, want := tok.Tokenize("he<x>"), []int32{4, 11, 1}; !equalIDs(got, want) {
+		t.Fatalf("Tokenize(user-defined) = %v, want %v", got, want)
+	}
+	checkOffsets(t, tok, "hello  xyz",
+		[]int32{4, 5, 3, 2, 1},
+		[]Offset{{0, 2}, {2, 5}, {5, 7}, {7, 10}, {10, 10}})
+	if got, want := tok.DecodeWith(tok.Tokenize("hello  hello"), DecodeOptions{SkipSpecial: true, TrimPrefixSpace: true}), "hello hello"; got != want {
+		t.Fatalf("Decode() = %q, want %q", got, want)
+	}
+}
+
+// testCharsMap is a one-entry precompiled charsmap mapping "A" to "h": the
+// root's base is 0, so 'A' lands on node 0x41, a leaf whose base 1 leads to
+// the value node 0x40 holding replacement offset 0.
+func testCharsMap() []byte {
+	xcda := make([]uint32, 0x42)
+	xcda[0x41] = 1<<10 | 1<<8 | 'A'
+	blob := binary.LittleEndian.AppendUint32(nil, uint32(4*len(xcda)))
+	for _, v := range xcda {
+		blob = binary.LittleEndian.AppendUint32(blob, v)
+	}
+	return append(blob, "h\x00"...)
+}
+
+func TestTokenizerUnigramCharsMap(t *testing.T) {
+	blob := testCharsMap()
+	tok := newUnigramTokenizer(t, blob)
+	if got, _ := tok.normalizeUGM("Ae  Bo"); got != "▁he▁Bo" {
+		t.Fatalf("normalizeUGM() = %q, want %q", got, "▁he▁Bo")
+	}
+	if got, want := tok.Tokenize("Aello"), []int32{4, 5, 1}; !equalIDs(got, want) {
+		t.Fatalf("Tokenize() = %v, want %v", got, want)
+	}
+
+	info := gguf.ModelInfo{KeyValues: map[string]any{
+		"tokenizer.ggml.model":                "t5",
+		"tokenizer.ggml.tokens":               []string{"<unk>"},
+		"tokenizer.ggml.precompiled_charsmap": blob[:8],
+	}}
+	if _, err := NewFromModelInfo(info); err == nil || !strings.Contains(err.Error(), "precompiled_charsmap") {
+		t.Fatalf("NewFromModelInfo(truncated charsmap) error = %v", err)
+	}
+}
+
+func TestTokenizerWordPiece(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tokens []string
+	}{
+		// llama.cpp's converter marks word starts with "▁" and drops "##".
+		{"llama.cpp", []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "▁cafe", "▁hello", "▁,", "▁world", "s", "▁中"}},
+		{"bert", []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "cafe", "hello", ",", "world", "##s", "中"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info := gguf.ModelInfo{KeyValues: map[string]any{
+				"tokenizer.ggml.model":              "bert",
+				"tokenizer.ggml.tokens":             tc.tokens,
+				"tokenizer.ggml.token_type":         []int32{3, 2, 3, 3, 1, 1, 1, 1, 1, 1},
+				"tokenizer.ggml.unknown_token_id":   uint32(1),
+				"tokenizer.ggml.cls_token_id":       uint32(2),
+				"tokenizer.ggml.seperator_token_id": uint32(3),
+			}}
+			tok, err := NewFromModelInfo(info)
+			if err != nil {
+				t.Fatalf("NewFromModelInfo() error = %v", err)
+			}
+			// Accents are stripped, text is lowercased, punctuation and CJK
+			// are words of their own, and "xyz" has no pieces at all.
+			text := "Café, HELLO\tworlds中 xyz"
+			want := []int32{2, 4, 6, 5, 7, 8, 9, 1, 3}
+			checkOffsets(t, tok, text, want,
+				[]Offset{{0, 0}, {0, 5}, {5, 6}, {6, 12}, {12, 18}, {18, 19}, {19, 22}, {22, 26}, {26, 26}})
+			if got, want := tok.DecodeWith(want, DecodeOptions{SkipSpecial: true, TrimPrefixSpace: true}), "cafe , hello worlds 中"; got != want {
+				t.Fatalf("Decode() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestChunkCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newChunkCache(2 * chunkCacheShards)
+	// Find three keys that share a shard (two entries per shard).
+	var keys []string
+	for i := 0; len(keys) < 3; i++ {
+		k := "k" + strconv.Itoa(i)
+		if len(keys) == 0 || c.shard(k) == c.shard(keys[0]) {
+			keys = append(keys, k)
+		}
+	}
+	c.add(keys[0], []int32{0})
+	c.add(keys[1], []int32{1})
+	if got := c.get(keys[0]); !equalIDs(got, []int32{0}) {
+		t.Fatalf("get(%q) = %v, want [0]", keys[0], got)
+	}
+	c.add(keys[2], []int32{2})
+	if got := c.get(keys[1]); got != nil {
+		t.Fatalf("get(%q) = %v after eviction, want nil", keys[1], got)
+	}
+	if !equalIDs(c.get(keys[0]), []int32{0}) || !equalIDs(c.get(keys[2]), []int32{2}) {
+		t.Fatalf("recently used entries were evicted")
+	}
+}
+
+// TestTokenizerConcurrentEncode shares tokenizers across goroutines with
+// caches small enough to evict constantly; run it with -race.
+func TestTokenizerConcurrentEncode(t *testing.T) {
+	bpe, err := NewFromModelInfo(gguf.ModelInfo{KeyValues: map[string]any{
+		"tokenizer.ggml.model":            "gpt2",
+		"tokenizer.ggml.tokens":           []string{"<unk>", "Ġ", "h", "e", "l", "o", "Ġh", "Ġhe", "Ġhel", "Ġhell", "Ġhello", "w", "r", "d", "1", "2"},
+		"tokenizer.ggml.merges":           []string{"Ġ h", "Ġh e", "Ġhe l", "Ġhel l", "Ġhell o"},
+		"tokenizer.ggml.unknown_token_id": uint32(0),
+		"bitnet.tokenizer.bpe_cache_size": uint32(4),
+	}})
+	if err != nil {
+		t.Fatalf("NewFromModelInfo(gpt2) error = %v", err)
+	}
+	spm, err := NewFromModelInfo(gguf.ModelInfo{KeyValues: map[string]any{
+		"tokenizer.ggml.model":            "llama",
+		"tokenizer.ggml.tokens":           []string{"<unk>", "<s>", "▁", "h", "e", "l", "o", "▁h", "▁he", "▁hel", "▁hell", "▁hello", "w", "r", "d"},
+		"tokenizer.ggml.scores":           []float32{0, 0, 0, 0, 0, 0, 0, -5, -4, -3, -2, -1, 0, 0, 0},
+		"tokenizer.ggml.bos_token_id":     uint32(1),
+		"tokenizer.ggml.unknown_token_id": uint32(0),
+		"tokenizer.ggml.add_bos_token":    true,
+		"bitnet.tokenizer.spm_cache_size": uint32(4),
+	}})
+	if err != nil {
+		t.Fatalf("NewFromModelInfo(llama) error = %v", err)
+	}
+	toks := []*Tokenizer{bpe, spm, newUnigramTokenizer(t, nil)}
+	inputs := []string{"hello world", " hello hello", "held 12 world", "hole", "oh hello", "wordle", "  hel lo", "héllo"}
+
+	type result struct {
+		ids  []int32
+		offs []Offset
+	}
+	want := make([][]result, len(toks))
+	for i, tok := range toks {
+		for _, in := range inputs {
+			ids, offs := tok.TokenizeWithOffsets(in)
+			want[i] = append(want[i], result{ids, offs})
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 32)
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				i, j := (g+n)%len(toks), (g*7+n)%len(inputs)
+				tok, in := toks[i], inputs[j]
+				ids := tok.Tokenize(in)
+				gotIDs, offs := tok.TokenizeWithOffsets(in)
+				if !equalIDs(ids, want[i][j].ids) || !equalIDs(gotIDs, want[i][j].ids) || len(offs) != len(want[i][j].offs) {
+					errs <- fmt.Sprintf("tokenizer %d on %q: got %v / %v %v, want %v %v", i, in, ids, gotIDs, offs, want[i][j].ids, want[i][j].offs)
+					return
+				}
+				for k := range offs {
+					if offs[k] != want[i][j].offs[k] {
+						errs <- fmt.Sprintf("tokenizer %d on %q: offsets %v, want %v", i, in, offs, want[i][j].offs)
+						return
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+}
@@ -0,0 +1,435 @@
+package tokenizer
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"bitnet-go/internal/gguf"
+)
+
+// TrainOptions configures a Trainer.
+type TrainOptions struct {
+	// Model is the tokenizer.ggml.model to train: "gpt2" for byte-level BPE
+	// or "llama" for SentencePiece-style BPE with byte fallback.
+	Model string
+	// VocabSize is the target vocabulary size, special and byte tokens
+	// included. Training stops early when no pair is frequent enough.
+	VocabSize int
+	// MinFrequency is the fewest occurrences a pair needs to be merged;
+	// zero means 2.
+	MinFrequency int
+	// Pre is the tokenizer.ggml.pre that splits text for "gpt2" models;
+	// empty means "llama3".
+	Pre string
+	// BOS, EOS and UNK name the tokens written as bos_token_id,
+	// eos_token_id and unknown_token_id. Empty picks <s>, </s> and <unk>
+	// for "llama" and <|endoftext|> as both BOS and EOS for "gpt2", which
+	// has no unknown token.
+	BOS, EOS, UNK string
+	// SpecialTokens are extra control tokens, such as chat markers. Corpus
+	// text matching any special token is not trained on.
+	SpecialTokens []string
+}
+
+// Trainer learns a BPE vocabulary from text passed to Add. Words are
+// counted as they are added, so memory grows with the number of distinct
+// words rather than the corpus size.
+type Trainer struct {
+	opts     TrainOptions
+	specials []string
+	byteEnc  [256]string
+	words    map[string]int
+	runes    map[rune]int
+}
+
+// NewTrainer validates opts and returns an empty Trainer.
+func NewTrainer(opts TrainOptions) (*Trainer, error) {
+	if opts.MinFrequency <= 0 {
+		opts.MinFrequency = 2
+	}
+	var specials []string
+	switch opts.Model {
+	case "llama":
+		specials = []string{orDefault(opts.UNK, "<unk>"), orDefault(opts.BOS, "<s>"), orDefault(opts.EOS, "</s>")}
+	case "gpt2":
+		if opts.UNK != "" {
+			return nil, fmt.Errorf("byte-level BPE has no unknown token")
+		}
+		opts.Pre = orDefault(opts.Pre, "llama3")
+		if !isKnownBPEPreType(opts.Pre) {
+			return nil, fmt.Errorf("unsupported tokenizer.ggml.pre %q", opts.Pre)
+		}
+		specials = []string{orDefault(opts.BOS, "<|endoftext|>"), orDefault(opts.EOS, "<|endoftext|>")}
+	default:
+		return nil, fmt.Errorf("unsupported tokenizer model %q for training", opts.Model)
+	}
+	specials = append(specials, opts.SpecialTokens...)
+	seen := make(map[string]bool, len(specials))
+	uniq := specials[:0]
+	for _, s := range specials {
+		if s == "" {
+			return nil, fmt.Errorf("empty special token")
+		}
+		if !seen[s] {
+			seen[s] = true
+			uniq = append(uniq, s)
+		}
+	}
+	base := len(uniq) + 256
+	if opts.VocabSize <= base {
+		return nil, fmt.Errorf("vocab size %d must exceed the %d special and byte tokens", opts.VocabSize, base)
+	}
+	return &Trainer{
+		opts:     opts,
+		specials: uniq,
+		byteEnc:  buildByteEncoder(),
+		words:    make(map[string]int),
+		runes:    make(map[rune]int),
+	}, nil
+}
+
+// orDefault returns s, or def when s is empty.
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// Add counts the words of one sentence or document. SentencePiece-style
+// models see every Add as its own text, with a "▁" prefix, just as Encode
+// treats each call.
+func (tr *Trainer) Add(text string) {
+	for text != "" {
+		end, next := len(text), len(text)
+		for _, sp := range tr.specials {
+			// The first match wins, and the longest at the same offset.
+			if i := strings.Index(text, sp); i >= 0 && (i < end || i == end && i+len(sp) > next) {
+				end, next = i, i+len(sp)
+			}
+		}
+		if end > 0 {
+			tr.addText(text[:end])
+		}
+		text = text[next:]
+	}
+}
+
+func (tr *Trainer) addText(text string) {
+	if tr.opts.Model == "gpt2" {
+		var b strings.Builder
+		for _, chunk := range splitBPEText(tr.opts.Pre, text) {
+			b.Reset()
+			for i := 0; i < len(chunk); i++ {
+				b.WriteString(tr.byteEnc[chunk[i]])
+			}
+			tr.words[b.String()]++
+		}
+		return
+	}
+	// Like SentencePiece, every "▁" starts a new word, so pieces never
+	// span a word boundary.
+	text = normalizeSPM(text)
+	for text != "" {
+		end := strings.Index(text[len("▁"):], "▁")
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += len("▁")
+		}
+		tr.words[text[:end]]++
+		for _, r := range text[:end] {
+			tr.runes[r]++
+		}
+		text = text[end:]
+	}
+}
+
+// trainSymbolPair is two adjacent symbol IDs.
+type trainSymbolPair [2]int32
+
+type trainCandidate struct {
+	pair  trainSymbolPair
+	count int
+}
+
+// trainHeap orders candidates by count, then by the pair's pieces so that
+// ties break the same way on every run.
+type trainHeap struct {
+	items []trainCandidate
+	syms  []string
+}
+
+func (h *trainHeap) Len() int { return len(h.items) }
+func (h *trainHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if a.count != b.count {
+		return a.count > b.count
+	}
+	if l, r := h.syms[a.pair[0]], h.syms[b.pair[0]]; l != r {
+		return l < r
+	}
+	return h.syms[a.pair[1]] < h.syms[b.pair[1]]
+}
+func (h *trainHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *trainHeap) Push(x any)    { h.items = append(h.items, x.(trainCandidate)) }
+func (h *trainHeap) Pop() any {
+	n := len(h.items)
+	x := h.items[n-1]
+	h.items = h.items[:n-1]
+	return x
+}
+
+// ModelInfo learns merges from the words added so far and returns the
+// vocabulary as tokenizer.ggml.* metadata that NewFromModelInfo loads.
+// "gpt2" vocabularies are the 256 byte pieces, then merged pieces in merge
+// order, then special tokens, with tokenizer.ggml.merges. "llama" ones are
+// the special tokens, the <0xXX> byte tokens, merged pieces and then the
+// most frequent characters, with merged pieces scored -rank instead.
+func (tr *Trainer) ModelInfo() (gguf.ModelInfo, error) {
+	var (
+		syms  []string
+		symID = make(map[string]int32)
+	)
+	addSym := func(s string) int32 {
+		if id, ok := symID[s]; ok {
+			return id
+		}
+		id := int32(len(syms))
+		syms = append(syms, s)
+		symID[s] = id
+		return id
+	}
+
+	budget := tr.opts.VocabSize - len(tr.specials)
+	var alphabet []string
+	if tr.opts.Model == "gpt2" {
+		for b := 0; b < 256; b++ {
+			addSym(tr.byteEnc[b])
+		}
+	} else {
+		budget -= 256
+		// Characters outside the budget are left to byte fallback.
+		chars := make([]rune, 0, len(tr.runes))
+		for r := range tr.runes {
+			chars = append(chars, r)
+		}
+		sort.Slice(chars, func(i, j int) bool {
+			if ci, cj := tr.runes[chars[i]], tr.runes[chars[j]]; ci != cj {
+				return ci > cj
+			}
+			return chars[i] < chars[j]
+		})
+		for _, r := range chars[:min(len(chars), budget)] {
+			alphabet = append(alphabet, string(r))
+			addSym(string(r))
+		}
+	}
+
+	// Words become symbol sequences, -1 marking a character that is not
+	// in the alphabet and so never merges.
+	keys := make([]string, 0, len(tr.words))
+	for w := range tr.words {
+		keys = append(keys, w)
+	}
+	sort.Strings(keys)
+	words := make([][]int32, len(keys))
+	freqs := make([]int, len(keys))
+	pairCount := make(map[trainSymbolPair]int)
+	where := make(map[trainSymbolPair][]int32)
+	for i, w := range keys {
+		seq := make([]int32, 0, utf8.RuneCountInString(w))
+		for _, r := range w {
+			id, ok := symID[string(r)]
+			if !ok {
+				id = -1
+			}
+			seq = append(seq, id)
+		}
+		words[i], freqs[i] = seq, tr.words[w]
+		for j := 1; j < len(seq); j++ {
+			if p := (trainSymbolPair{seq[j-1], seq[j]}); p[0] >= 0 && p[1] >= 0 {
+				if n := len(where[p]); n == 0 || where[p][n-1] != int32(i) {
+					where[p] = append(where[p], int32(i))
+				}
+				pairCount[p] += freqs[i]
+			}
+		}
+	}
+
+	h := &trainHeap{syms: syms}
+	for p, c := range pairCount {
+		h.items = append(h.items, trainCandidate{pair: p, count: c})
+	}
+	heap.Init(h)
+
+	var (
+		merged []string
+		merges []string
+		stamp  = make([]int, len(words))
+	)
+	// The budget covers the byte pieces for gpt2 and the alphabet for llama.
+	base := len(alphabet)
+	if tr.opts.Model == "gpt2" {
+		base = 256
+	}
+	touched := make(map[trainSymbolPair]bool)
+	for base+len(merged) < budget && h.Len() > 0 {
+		c := heap.Pop(h).(trainCandidate)
+		if cur := pairCount[c.pair]; cur != c.count {
+			if cur > 0 {
+				heap.Push(h, trainCandidate{pair: c.pair, count: cur})
+			}
+			continue
+		}
+		if c.count < tr.opts.MinFrequency {
+			break
+		}
+		left, right := syms[c.pair[0]], syms[c.pair[1]]
+		piece := left + right
+		_, exists := symID[piece]
+		id := addSym(piece)
+		h.syms = syms
+		merges = append(merges, left+" "+right)
+		if !exists {
+			merged = append(merged, piece)
+		}
+
+		clear(touched)
+		for _, wi := range where[c.pair] {
+			if stamp[wi] == len(merges) {
+				continue
+			}
+			stamp[wi] = len(merges)
+			seq, f := words[wi], freqs[wi]
+			if !hasPair(seq, c.pair) {
+				continue
+			}
+			for j := 1; j < len(seq); j++ {
+				if p := (trainSymbolPair{seq[j-1], seq[j]}); p[0] >= 0 && p[1] >= 0 {
+					pairCount[p] -= f
+					touched[p] = true
+				}
+			}
+			out := seq[:0]
+			for j := 0; j < len(seq); j++ {
+				if j+1 < len(seq) && seq[j] == c.pair[0] && seq[j+1] == c.pair[1] {
+					out = append(out, id)
+					j++
+					continue
+				}
+				out = append(out, seq[j])
+			}
+			words[wi] = out
+			for j := 1; j < len(out); j++ {
+				if p := (trainSymbolPair{out[j-1], out[j]}); p[0] >= 0 && p[1] >= 0 {
+					pairCount[p] += f
+					touched[p] = true
+					if n := len(where[p]); (p[0] == id || p[1] == id) && (n == 0 || where[p][n-1] != wi) {
+						where[p] = append(where[p], wi)
+					}
+				}
+			}
+		}
+		delete(where, c.pair)
+		delete(pairCount, c.pair)
+		for p := range touched {
+			if n := pairCount[p]; n > 0 {
+				heap.Push(h, trainCandidate{pair: p, count: n})
+			} else {
+				delete(pairCount, p)
+			}
+		}
+	}
+
+	if tr.opts.Model == "gpt2" {
+		return tr.gpt2Info(syms, merges), nil
+	}
+	return tr.llamaInfo(merged, alphabet), nil
+}
+
+func hasPair(seq []int32, p trainSymbolPair) bool {
+	for j := 1; j < len(seq); j++ {
+		if seq[j-1] == p[0] && seq[j] == p[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (tr *Trainer) gpt2Info(syms, merges []string) gguf.ModelInfo {
+	n := len(syms) + len(tr.specials)
+	tokens := make([]string, 0, n)
+	scores := make([]float32, 0, n)
+	types := make([]int32, 0, n)
+	for i, s := range syms {
+		tokens = append(tokens, s)
+		types = append(types, TokenTypeNormal)
+		if i < 256 {
+			scores = append(scores, 0)
+		} else {
+			scores = append(scores, -float32(i-256))
+		}
+	}
+	for _, s := range tr.specials {
+		tokens = append(tokens, s)
+		scores = append(scores, 0)
+		types = append(types, TokenTypeControl)
+	}
+	kv := map[string]any{
+		"tokenizer.ggml.model":        "gpt2",
+		"tokenizer.ggml.pre":          tr.opts.Pre,
+		"tokenizer.ggml.tokens":       tokens,
+		"tokenizer.ggml.scores":       scores,
+		"tokenizer.ggml.token_type":   types,
+		"tokenizer.ggml.merges":       merges,
+		"tokenizer.ggml.bos_token_id": uint32(len(syms) + slices.Index(tr.specials, orDefault(tr.opts.BOS, "<|endoftext|>"))),
+		"tokenizer.ggml.eos_token_id": uint32(len(syms) + slices.Index(tr.specials, orDefault(tr.opts.EOS, "<|endoftext|>"))),
+	}
+	return gguf.ModelInfo{KeyValues: kv}
+}
+
+func (tr *Trainer) llamaInfo(merged, alphabet []string) gguf.ModelInfo {
+	n := len(tr.specials) + 256 + len(merged) + len(alphabet)
+	tokens := make([]string, 0, n)
+	scores := make([]float32, 0, n)
+	types := make([]int32, 0, n)
+	for i, s := range tr.specials {
+		typ := TokenTypeControl
+		if i == 0 {
+			typ = TokenTypeUnknown
+		}
+		tokens = append(tokens, s)
+		scores = append(scores, 0)
+		types = append(types, typ)
+	}
+	for b := 0; b < 256; b++ {
+		tokens = append(tokens, byteTokenPiece(byte(b)))
+		scores = append(scores, 0)
+		types = append(types, TokenTypeByte)
+	}
+	for i, s := range merged {
+		tokens = append(tokens, s)
+		scores = append(scores, -float32(i))
+		types = append(types, TokenTypeNormal)
+	}
+	for _, s := range alphabet {
+		tokens = append(tokens, s)
+		scores = append(scores, 0)
+		types = append(types, TokenTypeNormal)
+	}
+	kv := map[string]any{
+		"tokenizer.ggml.model":            "llama",
+		"tokenizer.ggml.tokens":           tokens,
+		"tokenizer.ggml.scores":           scores,
+		"tokenizer.ggml.token_type":       types,
+		"tokenizer.ggml.unknown_token_id": uint32(0),
+		"tokenizer.ggml.bos_token_id":     uint32(slices.Index(tr.specials, orDefault(tr.opts.BOS, "<s>"))),
+		"tokenizer.ggml.eos_token_id":     uint32(slices.Index(tr.specials, orDefault(tr.opts.EOS, "</s>"))),
+	}
+	return gguf.ModelInfo{KeyValues: kv}
+}
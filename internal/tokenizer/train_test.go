@@ -0,0 +1,182 @@
+package tokenizer
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bitnet-go/internal/gguf"
+)
+
+var trainCorpus = []string{
+	"the quick brown fox jumps over the lazy dog",
+	"the lazy dog sleeps while the quick fox runs",
+	"a fox, a dog and the other dog<|eot|>then the fox left",
+	"numbers like 12345 and 2024 appear in the text too",
+	"  indented   lines keep their spaces",
+	"Café crème brûlée is the dessert of the day",
+}
+
+var trainProbes = []string{
+	"the quick fox",
+	"an unseen word: zebra!",
+	"  spaced  out  ",
+	"€ and 漢字 are new characters",
+	"Café 2025",
+}
+
+// trainTokenizer trains on trainCorpus, round-trips the result through a
+// GGUF file and loads it.
+func trainTokenizer(t *testing.T, opts TrainOptions) (*Tokenizer, gguf.ModelInfo) {
+	t.Helper()
+	tr, err := NewTrainer(opts)
+	if err != nil {
+		t.Fatalf("NewTrainer() error = %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		for _, line := range trainCorpus {
+			tr.Add(line)
+		}
+	}
+	info, err := tr.ModelInfo()
+	if err != nil {
+		t.Fatalf("ModelInfo() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := gguf.EncodeKeyValues(&buf, info.KeyValues); err != nil {
+		t.Fatalf("EncodeKeyValues() error = %v", err)
+	}
+	decoded, err := gguf.DecodeModelInfo(&buf)
+	if err != nil {
+		t.Fatalf("DecodeModelInfo() error = %v", err)
+	}
+	tok, err := NewFromModelInfo(decoded)
+	if err != nil {
+		t.Fatalf("NewFromModelInfo() error = %v", err)
+	}
+	return tok, info
+}
+
+// assertSinglePiece checks that text encodes to exactly the token piece.
+func assertSinglePiece(t *testing.T, tok *Tokenizer, text, piece string) {
+	t.Helper()
+	ids := tok.Encode(text, EncodeOptions{})
+	if len(ids) != 1 {
+		t.Fatalf("Encode(%q) = %v, want the single token %q", text, ids, piece)
+	}
+	if got, _ := tok.Piece(ids[0]); got != piece {
+		t.Fatalf("Encode(%q) piece = %q, want %q", text, got, piece)
+	}
+}
+
+func TestTrainerByteLevelBPE(t *testing.T) {
+	tok, info := trainTokenizer(t, TrainOptions{Model: "gpt2", VocabSize: 320, SpecialTokens: []string{"<|eot|>"}})
+	tokens := info.KeyValues["tokenizer.ggml.tokens"].([]string)
+	merges := info.KeyValues["tokenizer.ggml.merges"].([]string)
+	if len(tokens) != 320 {
+		t.Fatalf("len(tokens) = %d, want 320", len(tokens))
+	}
+	if len(merges) < 320-256-2 {
+		t.Fatalf("len(merges) = %d, want at least %d", len(merges), 320-256-2)
+	}
+	if got := info.KeyValues["tokenizer.ggml.pre"]; got != "llama3" {
+		t.Fatalf("tokenizer.ggml.pre = %v, want llama3", got)
+	}
+	assertSinglePiece(t, tok, " the", "Ġthe")
+	assertSinglePiece(t, tok, " fox", "Ġfox")
+
+	eot := tok.Encode("<|eot|>", EncodeOptions{ParseSpecial: true})
+	if len(eot) != 1 || !tok.IsControl(eot[0]) || tokens[eot[0]] != "<|eot|>" {
+		t.Fatalf("Encode(<|eot|>, ParseSpecial) = %v, want the control token", eot)
+	}
+	if tok.BOS() != tok.EOS() || tokens[tok.BOS()] != "<|endoftext|>" {
+		t.Fatalf("BOS/EOS = %d/%d, want <|endoftext|>", tok.BOS(), tok.EOS())
+	}
+	for _, m := range merges {
+		if strings.Contains(m, "<|eot|>") || strings.Contains(m, "|>") {
+			t.Fatalf("merge %q was learned from special-token text", m)
+		}
+	}
+	for _, text := range append(trainProbes, trainCorpus...) {
+		if got := tok.Decode(tok.Encode(text, EncodeOptions{ParseSpecial: true})); got != strings.ReplaceAll(text, "<|eot|>", "") {
+			t.Fatalf("Decode(Encode(%q)) = %q", text, got)
+		}
+	}
+}
+
+func TestTrainerSentencePieceBPE(t *testing.T) {
+	tok, info := trainTokenizer(t, TrainOptions{Model: "llama", VocabSize: 340, SpecialTokens: []string{"<|eot|>"}})
+	tokens := info.KeyValues["tokenizer.ggml.tokens"].([]string)
+	types := info.KeyValues["tokenizer.ggml.token_type"].([]int32)
+	if len(tokens) != 340 {
+		t.Fatalf("len(tokens) = %d, want 340", len(tokens))
+	}
+	if _, ok := info.KeyValues["tokenizer.ggml.merges"]; ok {
+		t.Fatal("SentencePiece-style vocabulary has tokenizer.ggml.merges")
+	}
+	wantHead := []string{"<unk>", "<s>", "</s>", "<|eot|>", "<0x00>"}
+	if !reflect.DeepEqual(tokens[:5], wantHead) {
+		t.Fatalf("tokens[:5] = %q, want %q", tokens[:5], wantHead)
+	}
+	if !reflect.DeepEqual(types[:5], []int32{TokenTypeUnknown, TokenTypeControl, TokenTypeControl, TokenTypeControl, TokenTypeByte}) {
+		t.Fatalf("token types[:5] = %v", types[:5])
+	}
+	if tok.BOS() != 1 || tok.EOS() != 2 || !tok.AddBOS() {
+		t.Fatalf("BOS/EOS/AddBOS = %d/%d/%v, want 1/2/true", tok.BOS(), tok.EOS(), tok.AddBOS())
+	}
+	assertSinglePiece(t, tok, "the", "▁the")
+	assertSinglePiece(t, tok, "fox", "▁fox")
+
+	if euro, ok := tok.ByteToken(0xE2); !ok || !equalIDs(tok.Encode("€", EncodeOptions{})[1:2], []int32{euro}) {
+		t.Fatalf("Encode(€) = %v, want byte fallback", tok.Encode("€", EncodeOptions{}))
+	}
+	decode := DecodeOptions{SkipSpecial: true, TrimPrefixSpace: true}
+	for _, text := range append(trainProbes, trainCorpus...) {
+		// Text after a special token gets its own "▁" prefix.
+		text = strings.ReplaceAll(text, "<|eot|>", " ")
+		if got := tok.DecodeWith(tok.Encode(text, EncodeOptions{}), decode); got != text {
+			t.Fatalf("Decode(Encode(%q)) = %q", text, got)
+		}
+	}
+}
+
+func TestTrainerDeterministic(t *testing.T) {
+	for _, model := range []string{"gpt2", "llama"} {
+		_, a := trainTokenizer(t, TrainOptions{Model: model, VocabSize: 330})
+		_, b := trainTokenizer(t, TrainOptions{Model: model, VocabSize: 330})
+		if !reflect.DeepEqual(a.KeyValues, b.KeyValues) {
+			t.Fatalf("%s: training twice gave different vocabularies", model)
+		}
+	}
+}
+
+func TestTrainerStopsAtMinFrequency(t *testing.T) {
+	tr, err := NewTrainer(TrainOptions{Model: "gpt2", VocabSize: 1000, MinFrequency: 3})
+	if err != nil {
+		t.Fatalf("NewTrainer() error = %v", err)
+	}
+	tr.Add("ab ab cd")
+	info, err := tr.ModelInfo()
+	if err != nil {
+		t.Fatalf("ModelInfo() error = %v", err)
+	}
+	if merges := info.KeyValues["tokenizer.ggml.merges"].([]string); len(merges) != 0 {
+		t.Fatalf("merges = %q, want none below MinFrequency", merges)
+	}
+}
+
+func TestNewTrainerErrors(t *testing.T) {
+	for _, opts := range []TrainOptions{
+		{Model: "t5", VocabSize: 1000},
+		{Model: "gpt2", VocabSize: 257},
+		{Model: "llama", VocabSize: 259},
+		{Model: "gpt2", VocabSize: 1000, Pre: "no-such-pre"},
+		{Model: "gpt2", VocabSize: 1000, UNK: "<unk>"},
+		{Model: "llama", VocabSize: 1000, SpecialTokens: []string{""}},
+	} {
+		if _, err := NewTrainer(opts); err == nil {
+			t.Fatalf("NewTrainer(%+v) error = nil, want error", opts)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package tokenizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ugmUnknownPenalty is how far below the lowest normal token score the
+// unknown token scores, as in llama.cpp and SentencePiece.
+const ugmUnknownPenalty = 10
+
+// charsMap is a SentencePiece precompiled charsmap: an XOR-compressed
+// compact double array (XCDA) trie over input bytes whose leaves index
+// NUL-terminated replacement strings.
+type charsMap struct {
+	xcda         []uint32
+	replacements []byte
+}
+
+// parseCharsMap decodes tokenizer.ggml.precompiled_charsmap: a little-endian
+// uint32 XCDA size in bytes, the XCDA entries, then the replacement strings.
+func parseCharsMap(b []byte) (*charsMap, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("tokenizer.ggml.precompiled_charsmap too short (%d bytes)", len(b))
+	}
+	size := binary.LittleEndian.Uint32(b)
+	if uint64(size)+4 >= uint64(len(b)) {
+		return nil, fmt.Errorf("tokenizer.ggml.precompiled_charsmap XCDA size %d exceeds %d bytes", size, len(b))
+	}
+	m := &charsMap{xcda: make([]uint32, size/4), replacements: b[4+size:]}
+	for i := range m.xcda {
+		m.xcda[i] = binary.LittleEndian.Uint32(b[4+4*i:])
+	}
+	return m, nil
+}
+
+func xcdaBase(node uint32) uint32 {
+	return (node >> 10) << ((node & (1 << 9)) >> 6)
+}
+
+func xcdaLCheck(node uint32) uint32 {
+	return node & (1<<31 | 0xff)
+}
+
+// longestPrefix returns the replacement for the longest prefix of s the
+// charsmap normalizes, and that prefix's length (0 if none).
+func (m *charsMap) longestPrefix(s string) (string, int) {
+	if len(m.xcda) == 0 {
+		return "", 0
+	}
+	n := 0
+	var value uint32
+	node := xcdaBase(m.xcda[0])
+	for i := 0; i < len(s) && s[i] != 0; i++ {
+		c := uint32(s[i])
+		node ^= c
+		if int(node) >= len(m.xcda) || xcdaLCheck(m.xcda[node]) != c {
+			break
+		}
+		leaf := m.xcda[node]>>8&1 != 0
+		node ^= xcdaBase(m.xcda[node])
+		if int(node) >= len(m.xcda) {
+			break
+		}
+		if leaf {
+			n = i + 1
+			value = m.xcda[node] & (1<<31 - 1)
+		}
+	}
+	if n == 0 || int(value) >= len(m.replacements) {
+		return "", 0
+	}
+	repl := m.replacements[value:]
+	if end := bytes.IndexByte(repl, 0); end >= 0 {
+		repl = repl[:end]
+	}
+	return string(repl), n
+}
+
+// initUGM prepares the unigram (tokenizer.ggml.model "t5") state: the trie
+// of tokens Viterbi may pick, the user-defined tokens normalization leaves
+// alone, and the unknown token's score.
+func (t *Tokenizer) initUGM(charsmap []byte) error {
+	if len(charsmap) > 0 {
+		m, err := parseCharsMap(charsmap)
+		if err != nil {
+			return err
+		}
+		t.charsMap = m
+	}
+	t.ugmTrie = newTrieNode()
+	minScore := float32(math.MaxFloat32)
+	for i, piece := range t.tokens {
+		id := int32(i)
+		typ := t.TokenType(id)
+		if len(t.tokenTypes) == 0 && typ == TokenTypeUndefined {
+			typ = TokenTypeNormal
+		}
+		if typ == TokenTypeNormal {
+			minScore = min(minScore, t.scores[i])
+		}
+		switch typ {
+		case TokenTypeNormal, TokenTypeUserDefined, TokenTypeUnused:
+			t.ugmTrie.insert(piece, id)
+		}
+		if typ == TokenTypeUserDefined {
+			if t.userDefinedTrie == nil {
+				t.userDefinedTrie = newTrieNode()
+			}
+			t.userDefinedTrie.insert(piece, id)
+		}
+	}
+	t.ugmUnknownScore = minScore - ugmUnknownPenalty
+	return nil
+}
+
+// normalizeUGM applies the charsmap and SentencePiece's whitespace rules
+// (escape to "▁", optional dummy prefix, optional merging of runs) the way
+// llama.cpp's llm_tokenizer_ugm does. inputEnds holds, for each output byte,
+// the end offset of the input it came from; an inserted "▁" ends where the
+// word after it starts.
+func (t *Tokenizer) normalizeUGM(input string) (string, []int) {
+	const space = "▁"
+	out := make([]byte, 0, len(input)*3)
+	inputEnds := make([]int, 0, len(input)*3)
+	push := func(s string, end int) {
+		out = append(out, s...)
+		for range len(s) {
+			inputEnds = append(inputEnds, end)
+		}
+	}
+	prependSpace := t.addSpacePrefix
+	mergeSpaces := t.removeExtraSpaces
+	prepended, inWord := false, false
+	for off := 0; off < len(input); {
+		repl, consumed := t.normalizeUGMPrefix(input, off)
+		for i := 0; i < len(repl); i++ {
+			if repl[i] != ' ' {
+				if !inWord {
+					inWord = true
+					if (prependSpace && !prepended) || mergeSpaces {
+						push(space, off)
+						prepended = true
+					}
+				}
+				push(repl[i:i+1], off+consumed)
+				continue
+			}
+			inWord = false
+			if !mergeSpaces {
+				push(space, off+consumed)
+			}
+		}
+		off += consumed
+	}
+	return string(out), inputEnds
+}
+
+// normalizeUGMPrefix returns the normalized form of the text at input[off:]
+// and how many input bytes it replaces.
+func (t *Tokenizer) normalizeUGMPrefix(input string, off int) (string, int) {
+	if t.userDefinedTrie != nil {
+		n := 0
+		for _, m := range t.userDefinedTrie.match(input, off) {
+			n = max(n, m.length)
+		}
+		if n > 0 {
+			return input[off : off+n], n
+		}
+	}
+	if t.charsMap != nil {
+		if repl, n := t.charsMap.longestPrefix(input[off:]); n > 0 {
+			return repl, n
+		}
+	}
+	r, n := utf8.DecodeRuneInString(input[off:])
+	if r == utf8.RuneError && n == 1 {
+		return "�", 1
+	}
+	return input[off : off+n], n
+}
+
+type ugmBest struct {
+	id    int32
+	start int
+	score float32
+}
+
+// tokenizeUGM runs Viterbi over normalized text, maximizing the sum of
+// token scores (user-defined tokens score 0, code points no token covers
+// score as unknown). Consecutive unknown tokens collapse into one. It
+// returns the tokens and the end offset in text of each.
+func (t *Tokenizer) tokenizeUGM(text string) ([]int32, []int) {
+	if text == "" {
+		return nil, nil
+	}
+	best := make([]ugmBest, len(text)+1)
+	for i := range best {
+		best[i] = ugmBest{id: t.unkTokenID, score: -math.MaxFloat32}
+	}
+	best[0].score = 0
+	for off := 0; off < len(text); {
+		_, cp := utf8.DecodeRuneInString(text[off:])
+		cur := best[off]
+		whole := false
+		for _, m := range t.ugmTrie.match(text, off) {
+			if m.length == cp {
+				whole = true
+			}
+			score := float64(t.scores[m.id])
+			if t.TokenType(m.id) == TokenTypeUserDefined {
+				score = 0
+			}
+			if s := float64(cur.score) + score; s > float64(best[off+m.length].score) {
+				best[off+m.length] = ugmBest{id: m.id, start: off, score: float32(s)}
+			}
+		}
+		if !whole {
+			if s := float64(cur.score) + float64(t.ugmUnknownScore); s > float64(best[off+cp].score) {
+				best[off+cp] = ugmBest{id: t.unkTokenID, start: off, score: float32(s)}
+			}
+		}
+		off += cp
+	}
+
+	var ids []int32
+	var ends []int
+	prevUnknown := false
+	for end := len(text); end > 0; end = best[end].start {
+		b := best[end]
+		unknown := b.id == t.unkTokenID
+		if unknown && prevUnknown {
+			// The unknown token already emitted (it follows this one) absorbs it.
+			continue
+		}
+		ids = append(ids, b.id)
+		ends = append(ends, end)
+		prevUnknown = unknown
+	}
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+		ends[i], ends[j] = ends[j], ends[i]
+	}
+	return ids, ends
+}
@@ -0,0 +1,127 @@
+package tokenizer
+
+// Token types from tokenizer.ggml.token_type (llama.cpp's llama_token_type).
+const (
+	TokenTypeUndefined   int32 = 0
+	TokenTypeNormal      int32 = 1
+	TokenTypeUnknown     int32 = 2
+	TokenTypeControl     int32 = 3
+	TokenTypeUserDefined int32 = 4
+	TokenTypeUnused      int32 = 5
+	TokenTypeByte        int32 = 6
+)
+
+// VocabSize returns the number of tokens in the vocabulary.
+func (t *Tokenizer) VocabSize() int {
+	return len(t.tokens)
+}
+
+// Piece returns the vocabulary string for id.
+func (t *Tokenizer) Piece(id int32) (string, bool) {
+	if id < 0 || int(id) >= len(t.tokens) {
+		return "", false
+	}
+	return t.tokens[id], true
+}
+
+// TokenType returns the GGUF token type of id, or TokenTypeUndefined when
+// the model has no token_type array. BOS and EOS count as control tokens
+// either way.
+func (t *Tokenizer) TokenType(id int32) int32 {
+	if id < 0 || int(id) >= len(t.tokens) {
+		return TokenTypeUndefined
+	}
+	if len(t.tokenTypes) > 0 {
+		return t.tokenTypes[id]
+	}
+	if id == t.bosTokenID || id == t.eosTokenID {
+		return TokenTypeControl
+	}
+	return TokenTypeUndefined
+}
+
+// IsControl reports whether id is a control token (BOS, EOS, chat markers).
+func (t *Tokenizer) IsControl(id int32) bool {
+	return t.TokenType(id) == TokenTypeControl
+}
+
+// ByteToken returns the token that encodes the single byte b: the <0xXX>
+// token for SentencePiece vocabularies, or the byte-level piece for BPE ones.
+func (t *Tokenizer) ByteToken(b byte) (int32, bool) {
+	if t.hasBPEMerges || t.model == "gpt2" {
+		id, ok := t.vocab[t.byteEncode[b]]
+		return id, ok
+	}
+	id, ok := t.vocab[byteTokenPiece(b)]
+	return id, ok
+}
+
+// BOS returns the beginning-of-sequence token ID.
+func (t *Tokenizer) BOS() int32 {
+	return t.bosTokenID
+}
+
+// EOS returns the end-of-sequence token ID, or -1 if the model has none.
+func (t *Tokenizer) EOS() int32 {
+	return t.eosTokenID
+}
+
+// AddBOS reports whether the model asks for a BOS token on encode.
+func (t *Tokenizer) AddBOS() bool {
+	return t.addBOS
+}
+
+// AddEOS reports whether the model asks for an EOS token on encode.
+func (t *Tokenizer) AddEOS() bool {
+	return t.addEOS
+}
+
+func byteTokenPiece(b byte) string {
+	const hex = "0123456789ABCDEF"
+	return string([]byte{'<', '0', 'x', hex[b>>4], hex[b&15], '>'})
+}
+
+// buildSpecialTrie indexes the tokens Encode matches with ParseSpecial:
+// control, user-defined and unknown tokens, or just BOS/EOS when the model
+// has no token types.
+func (t *Tokenizer) buildSpecialTrie() *trieNode {
+	var root *trieNode
+	for i, piece := range t.tokens {
+		id := int32(i)
+		special := false
+		if len(t.tokenTypes) > 0 {
+			switch t.tokenTypes[i] {
+			case TokenTypeControl, TokenTypeUserDefined, TokenTypeUnknown:
+				special = true
+			}
+		} else {
+			special = id == t.bosTokenID || id == t.eosTokenID
+		}
+		if !special || piece == "" {
+			continue
+		}
+		if root == nil {
+			root = newTrieNode()
+		}
+		root.insert(piece, id)
+	}
+	return root
+}
+
+// matchSpecial returns the longest special token starting at text[start:].
+func (t *Tokenizer) matchSpecial(text string, start int) (int32, int) {
+	var id int32
+	n := 0
+	cur := t.specialTrie
+	for i := start; i < len(text); i++ {
+		child, ok := cur.children[text[i]]
+		if !ok {
+			break
+		}
+		cur = child
+		if cur.hasID {
+			id, n = cur.id, i-start+1
+		}
+	}
+	return id, n
+}
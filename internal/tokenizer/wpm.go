@@ -0,0 +1,133 @@
+package tokenizer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// initWPM picks the WordPiece spelling of the vocabulary. GGUFs converted
+// by llama.cpp mark word starts with "▁" and strip "##" from continuation
+// pieces; vocabularies taken straight from BERT keep "##" instead.
+func (t *Tokenizer) initWPM() {
+	if t.hasSPMPrefix {
+		t.wpmWordPrefix, t.wpmContPrefix = "▁", ""
+	} else {
+		t.wpmWordPrefix, t.wpmContPrefix = "", "##"
+	}
+}
+
+// wpmWords splits text into BERT basic-tokenizer words: code points are
+// reduced to the base of their canonical decomposition and lowercased,
+// whitespace separates words, control characters are dropped, and
+// punctuation, ASCII symbols and CJK ideographs are words of their own.
+// words holds the words back to back with their end offsets in ends, and
+// inputEnds holds, for each byte of words, the end offset of the input code
+// point it came from.
+func wpmWords(text string) (words string, ends []int, inputEnds []int) {
+	var b strings.Builder
+	inputEnds = make([]int, 0, len(text))
+	endWord := func() {
+		if n := b.Len(); n > 0 && (len(ends) == 0 || ends[len(ends)-1] != n) {
+			ends = append(ends, n)
+		}
+	}
+	for off, size := 0, 0; off < len(text); off += size {
+		var r rune
+		r, size = utf8.DecodeRuneInString(text[off:])
+		r = nfdBase(r)
+		if unicode.Is(unicode.White_Space, r) {
+			endWord()
+			continue
+		}
+		if r == 0 || r == utf8.RuneError || unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs) {
+			continue
+		}
+		isolated := unicode.IsPunct(r) || r < 0x7F && unicode.IsSymbol(r) || isChineseChar(r)
+		if isolated {
+			endWord()
+		}
+		n, _ := b.WriteRune(unicode.ToLower(r))
+		for range n {
+			inputEnds = append(inputEnds, off+size)
+		}
+		if isolated {
+			endWord()
+		}
+	}
+	endWord()
+	return b.String(), ends, inputEnds
+}
+
+// tokenizeWPM encodes each word greedily, longest piece first, the way
+// llama.cpp's llm_tokenizer_wpm does; a word any part of which has no
+// piece becomes a single unknown token. It returns the tokens and the end
+// offset in words of each.
+func (t *Tokenizer) tokenizeWPM(words string, wordEnds []int) ([]int32, []int) {
+	ids := make([]int32, 0, len(words))
+	ends := make([]int, 0, len(words))
+	start := 0
+	for _, end := range wordEnds {
+		word := t.wpmWordPrefix + words[start:end]
+		shift := len(t.wpmWordPrefix)
+		n := len(ids)
+		for i := 0; i < len(word); {
+			prefix := ""
+			if i > 0 {
+				prefix = t.wpmContPrefix
+			}
+			j := len(word)
+			for ; j > i; j-- {
+				if id, ok := t.vocab[prefix+word[i:j]]; ok {
+					ids = append(ids, id)
+					ends = append(ends, start+max(j-shift, 0))
+					break
+				}
+			}
+			if j == i {
+				ids, ends = ids[:n], ends[:n]
+				break
+			}
+			i = j
+		}
+		if len(ids) == n {
+			ids = append(ids, t.unkTokenID)
+			ends = append(ends, end)
+		}
+		start = end
+	}
+	return ids, ends
+}
+
+//go:generate go run ./gen -o nfd_table.go
+
+// nfdBase returns the first code point of r's canonical decomposition,
+// which is how llama.cpp applies NFD before WordPiece.
+func nfdBase(r rune) rune {
+	if r < 0xC0 {
+		return r
+	}
+	if r >= 0xAC00 && r <= 0xD7A3 {
+		// Hangul syllables decompose algorithmically; keep the leading jamo.
+		return 0x1100 + (r-0xAC00)/588
+	}
+	i := sort.Search(len(nfdFirst), func(i int) bool { return nfdFirst[i][0] >= r })
+	if i < len(nfdFirst) && nfdFirst[i][0] == r {
+		return nfdFirst[i][1]
+	}
+	return r
+}
+
+// isChineseChar reports whether r is in the CJK blocks BERT isolates, with
+// the same (HF-compatible) ranges as llama.cpp.
+func isChineseChar(r rune) bool {
+	return r >= 0x4E00 && r <= 0x9FFF ||
+		r >= 0x3400 && r <= 0x4DBF ||
+		r >= 0x20000 && r <= 0x2A6DF ||
+		r >= 0x2A700 && r <= 0x2B73F ||
+		r >= 0x2B740 && r <= 0x2B81F ||
+		r >= 0x2B920 && r <= 0x2CEAF ||
+		r >= 0xF900 && r <= 0xFAFF ||
+		r >= 0x2F800 && r <= 0x2FA1F
+}
@@ -3,6 +3,8 @@ package bitnet
 import (
 	"context"
 	"fmt"
+	"io"
+	"time"
 
 	"bitnet-go/internal/runtime"
 )
@@ -44,15 +46,137 @@ type ModelInfo struct {
 }
 
 type Session struct {
-	rt *runtime.Runtime
+	rt      *runtime.Runtime
+	metrics Metrics
+	slots   chan struct{}
 }
 
+// KVCacheType selects the attention K/V cache storage format.
+type KVCacheType = runtime.KVCacheType
+
+const (
+	KVCacheF32 = runtime.KVCacheF32
+	KVCacheF16 = runtime.KVCacheF16
+	KVCacheQ8  = runtime.KVCacheQ8
+)
+
+// LoadOptions configures model loading. Zero values keep the BITNET_* env defaults.
+type LoadOptions struct {
+	// KVCacheType trades attention accuracy for cache memory: f32 (default),
+	// f16 (half the memory) or q8 (roughly a quarter).
+	KVCacheType KVCacheType
+	// ContextLength caps the context window; 0 uses the model's context_length.
+	ContextLength int
+	// ContextShift lets generation run past the context window by discarding
+	// the oldest tokens after the first ContextKeep (e.g. a system prompt).
+	// Without it, requests that do not fit fail with *ErrContextLength. nil
+	// follows BITNET_CONTEXT_SHIFT.
+	ContextShift *bool
+	ContextKeep  int
+	// Runtime overrides the numerics and kernel settings for this session;
+	// nil uses DefaultRuntimeOptions.
+	Runtime *RuntimeOptions
+	// Metrics, if set, receives request timings, per-step profiles, KV cache
+	// usage and queue waits (see PrometheusMetrics).
+	Metrics Metrics
+	// MaxConcurrent caps concurrent Generate calls; extra calls wait for a
+	// slot. 0 means unlimited.
+	MaxConcurrent int
+}
+
+// RuntimeOptions holds per-session numerics, kernel and cache settings.
+type RuntimeOptions = runtime.RuntimeOptions
+
+// Named RuntimeOptions presets.
+const (
+	PresetDefault      = runtime.PresetDefault
+	PresetCPUParityV1  = runtime.PresetCPUParityV1
+	PresetParityStrict = runtime.PresetParityStrict
+)
+
+// DefaultRuntimeOptions returns the settings implied by the BITNET_* env vars.
+func DefaultRuntimeOptions() RuntimeOptions {
+	return runtime.DefaultRuntimeOptions()
+}
+
+// PresetRuntimeOptions returns the named preset, ignoring the environment.
+func PresetRuntimeOptions(name string) (RuntimeOptions, error) {
+	return runtime.PresetRuntimeOptions(name)
+}
+
+// Tracer receives intermediate tensors from the forward pass when set on
+// RuntimeOptions.Tracer.
+type (
+	Tracer        = runtime.Tracer
+	TracerFunc    = runtime.TracerFunc
+	TraceEvent    = runtime.TraceEvent
+	TraceStage    = runtime.TraceStage
+	TraceRecorder = runtime.TraceRecorder
+	JSONLTracer   = runtime.JSONLTracer
+)
+
+// Forward-pass stages reported to a Tracer.
+const (
+	TraceEmbed      = runtime.TraceEmbed
+	TraceAttnNorm   = runtime.TraceAttnNorm
+	TraceQ          = runtime.TraceQ
+	TraceK          = runtime.TraceK
+	TraceV          = runtime.TraceV
+	TraceQRoPE      = runtime.TraceQRoPE
+	TraceKRoPE      = runtime.TraceKRoPE
+	TraceAttnOut    = runtime.TraceAttnOut
+	TraceFFNNorm    = runtime.TraceFFNNorm
+	TraceFFNGate    = runtime.TraceFFNGate
+	TraceFFNUp      = runtime.TraceFFNUp
+	TraceFFNDown    = runtime.TraceFFNDown
+	TraceOutputNorm = runtime.TraceOutputNorm
+	TraceLogits     = runtime.TraceLogits
+)
+
+// NewJSONLTracer writes one JSON event per line to w.
+func NewJSONLTracer(w io.Writer) *JSONLTracer {
+	return runtime.NewJSONLTracer(w)
+}
+
+// ReadTraceJSONL decodes a trace written by a JSONLTracer.
+func ReadTraceJSONL(r io.Reader) ([]TraceEvent, error) {
+	return runtime.ReadTraceJSONL(r)
+}
+
+// ErrContextLength reports a request that does not fit the context window.
+type ErrContextLength = runtime.ErrContextLength
+
 func LoadModel(ctx context.Context, modelPath string) (*Session, error) {
-	rt, err := runtime.New(ctx, modelPath)
+	return LoadModelWithOptions(ctx, modelPath, LoadOptions{})
+}
+
+func LoadModelWithOptions(ctx context.Context, modelPath string, opts LoadOptions) (*Session, error) {
+	rtOpts := opts.Runtime
+	if opts.Metrics != nil {
+		var o RuntimeOptions
+		if rtOpts != nil {
+			o = *rtOpts
+		} else {
+			o = DefaultRuntimeOptions()
+		}
+		o.Observer = opts.Metrics
+		rtOpts = &o
+	}
+	rt, err := runtime.NewWithOptions(ctx, modelPath, runtime.Options{
+		KVCacheType:   opts.KVCacheType,
+		ContextLength: opts.ContextLength,
+		ContextShift:  opts.ContextShift,
+		ContextKeep:   opts.ContextKeep,
+		Runtime:       rtOpts,
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &Session{rt: rt}, nil
+	s := &Session{rt: rt, metrics: opts.Metrics}
+	if opts.MaxConcurrent > 0 {
+		s.slots = make(chan struct{}, opts.MaxConcurrent)
+	}
+	return s, nil
 }
 
 func (s *Session) ModelInfo() ModelInfo {
@@ -72,6 +196,18 @@ func (s *Session) Generate(ctx context.Context, req GenerateRequest) (GenerateRe
 	if req.MaxTokens < 0 {
 		return GenerateResult{}, fmt.Errorf("max tokens must be >= 0")
 	}
+	if s.slots != nil {
+		waitStart := time.Now()
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return GenerateResult{}, ctx.Err()
+		}
+		defer func() { <-s.slots }()
+		if s.metrics != nil {
+			s.metrics.ObserveQueueWait(time.Since(waitStart))
+		}
+	}
 	raw, err := s.rt.Generate(ctx, runtime.GenerateRequest{
 		Prompt:             req.Prompt,
 		Seed:               req.Seed,
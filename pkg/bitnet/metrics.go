@@ -0,0 +1,215 @@
+package bitnet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitnet-go/internal/runtime"
+)
+
+// Metrics receives inference measurements from a Session. Set it on
+// LoadOptions.Metrics; implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveGenerate is called once per completed Generate call.
+	ObserveGenerate(s GenerateStats)
+	// ObserveStep is called after each decode step with its time breakdown.
+	ObserveStep(s StepStats)
+	// ObserveKVCache is called with +bytes when a request acquires its KV
+	// cache and -bytes when it releases it.
+	ObserveKVCache(deltaBytes int64)
+	// ObserveQueueWait reports how long a request waited for a slot when
+	// LoadOptions.MaxConcurrent is set.
+	ObserveQueueWait(d time.Duration)
+}
+
+// GenerateStats summarizes one Generate call.
+type GenerateStats = runtime.GenerateStats
+
+// StepStats is the time one decode step spent in each part of the forward pass.
+type StepStats = runtime.StepStats
+
+// CacheResult reports how a prompt or decode cache lookup went.
+type CacheResult = runtime.CacheResult
+
+const (
+	CacheBypass = runtime.CacheBypass
+	CacheHit    = runtime.CacheHit
+	CacheMiss   = runtime.CacheMiss
+)
+
+var (
+	latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	stepBuckets    = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	rateBuckets    = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+	stepPhases     = []string{"embed", "attn", "ffn", "output", "sample", "topk", "total"}
+)
+
+// PrometheusMetrics is a Metrics implementation that keeps counters, gauges
+// and histograms in memory and serves them in the Prometheus text exposition
+// format. Mount it at /metrics:
+//
+//	m := bitnet.NewPrometheusMetrics()
+//	http.Handle("/metrics", m)
+type PrometheusMetrics struct {
+	mu sync.Mutex
+
+	requests        uint64
+	promptTokens    uint64
+	generatedTokens uint64
+	kvCacheBytes    int64
+	promptCache     [3]uint64 // indexed by CacheResult
+	decodeCache     [3]uint64
+
+	prefill    histogram
+	decode     histogram
+	firstToken histogram
+	queueWait  histogram
+	decodeRate histogram
+	steps      []histogram // indexed like stepPhases
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		prefill:    newHistogram(latencyBuckets),
+		decode:     newHistogram(latencyBuckets),
+		firstToken: newHistogram(latencyBuckets),
+		queueWait:  newHistogram(latencyBuckets),
+		decodeRate: newHistogram(rateBuckets),
+		steps:      make([]histogram, len(stepPhases)),
+	}
+	for i := range m.steps {
+		m.steps[i] = newHistogram(stepBuckets)
+	}
+	return m
+}
+
+func (m *PrometheusMetrics) ObserveGenerate(s GenerateStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	m.promptTokens += uint64(s.PromptTokens)
+	m.generatedTokens += uint64(s.GeneratedTokens)
+	m.promptCache[s.PromptCache]++
+	m.decodeCache[s.DecodeCache]++
+	m.prefill.observe(s.Prefill.Seconds())
+	m.decode.observe(s.Decode.Seconds())
+	m.firstToken.observe(s.FirstToken.Seconds())
+	// The first token is produced by prefill; the rest are decode throughput.
+	if s.GeneratedTokens > 1 && s.Decode > 0 {
+		m.decodeRate.observe(float64(s.GeneratedTokens-1) / s.Decode.Seconds())
+	}
+}
+
+func (m *PrometheusMetrics) ObserveStep(s StepStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range [...]time.Duration{s.Embed, s.Attn, s.FFN, s.Output, s.Sample, s.TopK, s.Total} {
+		m.steps[i].observe(d.Seconds())
+	}
+}
+
+func (m *PrometheusMetrics) ObserveKVCache(deltaBytes int64) {
+	m.mu.Lock()
+	m.kvCacheBytes += deltaBytes
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ObserveQueueWait(d time.Duration) {
+	m.mu.Lock()
+	m.queueWait.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WriteText(w)
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bw := bufio.NewWriter(w)
+
+	writeCounter(bw, "bitnet_requests_total", "Completed Generate calls.", m.requests)
+	writeCounter(bw, "bitnet_prompt_tokens_total", "Prompt tokens processed.", m.promptTokens)
+	writeCounter(bw, "bitnet_generated_tokens_total", "Tokens generated.", m.generatedTokens)
+	writeHeader(bw, "bitnet_kv_cache_bytes", "KV cache memory held by in-flight requests.", "gauge")
+	fmt.Fprintf(bw, "bitnet_kv_cache_bytes %d\n", m.kvCacheBytes)
+	writeCacheCounter(bw, "bitnet_prompt_cache_requests_total", "Prompt token cache lookups by result.", m.promptCache)
+	writeCacheCounter(bw, "bitnet_decode_cache_requests_total", "Decoded text cache lookups by result.", m.decodeCache)
+
+	writeHeader(bw, "bitnet_prefill_seconds", "Time from the start of the forward pass to the first token.", "histogram")
+	m.prefill.write(bw, "bitnet_prefill_seconds", "")
+	writeHeader(bw, "bitnet_decode_seconds", "Time spent generating tokens after the first.", "histogram")
+	m.decode.write(bw, "bitnet_decode_seconds", "")
+	writeHeader(bw, "bitnet_time_to_first_token_seconds", "Time from the Generate call to the first token.", "histogram")
+	m.firstToken.write(bw, "bitnet_time_to_first_token_seconds", "")
+	writeHeader(bw, "bitnet_queue_wait_seconds", "Time requests waited for a MaxConcurrent slot.", "histogram")
+	m.queueWait.write(bw, "bitnet_queue_wait_seconds", "")
+	writeHeader(bw, "bitnet_decode_tokens_per_second", "Per-request decode throughput.", "histogram")
+	m.decodeRate.write(bw, "bitnet_decode_tokens_per_second", "")
+	writeHeader(bw, "bitnet_step_seconds", "Per-step forward pass time by phase.", "histogram")
+	for i, phase := range stepPhases {
+		m.steps[i].write(bw, "bitnet_step_seconds", `phase="`+phase+`",`)
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounter(w io.Writer, name, help string, v uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+func writeCacheCounter(w io.Writer, name, help string, v [3]uint64) {
+	writeHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s{result=\"hit\"} %d\n", name, v[CacheHit])
+	fmt.Fprintf(w, "%s{result=\"miss\"} %d\n", name, v[CacheMiss])
+	fmt.Fprintf(w, "%s{result=\"bypass\"} %d\n", name, v[CacheBypass])
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write emits the buckets; labels, if any, must end with a comma.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, strconv.FormatFloat(b, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
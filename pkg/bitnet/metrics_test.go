@@ -0,0 +1,54 @@
+package bitnet
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetricsExposition(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.ObserveKVCache(4096)
+	m.ObserveQueueWait(3 * time.Millisecond)
+	m.ObserveStep(StepStats{Attn: 40 * time.Microsecond, Total: 100 * time.Microsecond})
+	m.ObserveGenerate(GenerateStats{
+		PromptTokens:    5,
+		GeneratedTokens: 11,
+		Prefill:         20 * time.Millisecond,
+		Decode:          time.Second,
+		FirstToken:      30 * time.Millisecond,
+		PromptCache:     CacheHit,
+		DecodeCache:     CacheMiss,
+	})
+	m.ObserveKVCache(-1024)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE bitnet_requests_total counter\nbitnet_requests_total 1\n",
+		"bitnet_prompt_tokens_total 5\n",
+		"bitnet_generated_tokens_total 11\n",
+		"bitnet_kv_cache_bytes 3072\n",
+		"bitnet_prompt_cache_requests_total{result=\"hit\"} 1\n",
+		"bitnet_decode_cache_requests_total{result=\"miss\"} 1\n",
+		"bitnet_prefill_seconds_bucket{le=\"0.025\"} 1\n",
+		"bitnet_prefill_seconds_bucket{le=\"0.01\"} 0\n",
+		"bitnet_queue_wait_seconds_count 1\n",
+		"bitnet_decode_tokens_per_second_sum 10\n",
+		"bitnet_step_seconds_bucket{phase=\"attn\",le=\"5e-05\"} 1\n",
+		"bitnet_step_seconds_count{phase=\"total\"} 1\n",
+		"bitnet_step_seconds_bucket{phase=\"ffn\",le=\"+Inf\"} 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition missing %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("exposition:\n%s", body)
+	}
+}
@@ -195,6 +195,102 @@ func TestParityAgainstFrozenVectors(t *testing.T) {
 	}
 }
 
+// TestKVCacheQuantAccuracy replays the frozen parity prompt with f16 and q8
+// KV caches, forcing the tokens the f32 cache generated, and checks each
+// step's top-k against the f32 run.
+func TestKVCacheQuantAccuracy(t *testing.T) {
+	root := filepath.Join("..", "..", "testdata")
+
+	modelFixture, err := os.ReadFile(filepath.Join(root, "model_fixture.txt"))
+	if err != nil {
+		t.Fatalf("read model_fixture.txt: %v", err)
+	}
+	modelPath := filepath.Join(root, string(bytesTrimSpace(modelFixture)))
+	if _, err := os.Stat(modelPath); err != nil {
+		t.Skipf("model fixture not available: %v", err)
+	}
+	promptBytes, err := os.ReadFile(filepath.Join(root, "prompt.txt"))
+	if err != nil {
+		t.Fatalf("read prompt.txt: %v", err)
+	}
+	tokenBytes, err := os.ReadFile(filepath.Join(root, "expected.tokens.json"))
+	if err != nil {
+		t.Fatalf("read expected.tokens.json: %v", err)
+	}
+	var frozen []int32
+	if err := json.Unmarshal(tokenBytes, &frozen); err != nil {
+		t.Fatalf("decode expected.tokens.json: %v", err)
+	}
+	req := GenerateRequest{
+		Prompt:    string(bytesTrimSpace(promptBytes)),
+		Seed:      1,
+		MaxTokens: len(frozen),
+	}
+
+	generate := func(kv KVCacheType) GenerateResult {
+		t.Helper()
+		session, err := LoadModelWithOptions(context.Background(), modelPath, LoadOptions{KVCacheType: kv})
+		if err != nil {
+			t.Fatalf("LoadModelWithOptions(%s) error = %v", kv, err)
+		}
+		got, err := session.Generate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Generate(%s) error = %v", kv, err)
+		}
+		return got
+	}
+	ref := generate(KVCacheF32)
+	var forced strings.Builder
+	for i, tok := range ref.TokenIDs {
+		if i > 0 {
+			forced.WriteByte(',')
+		}
+		forced.WriteString(strconv.FormatInt(int64(tok), 10))
+	}
+	t.Setenv("BITNET_PARITY_FORCE_TOKENS", "")
+	t.Setenv("BITNET_FORCE_TOKENS", forced.String())
+
+	for _, tc := range []struct {
+		kv         KVCacheType
+		atol       float32
+		minOverlap float64
+	}{
+		{kv: KVCacheF16, atol: envFloat32("BITNET_KV_F16_LOGIT_ATOL", 5e-2), minOverlap: 0.8},
+		{kv: KVCacheQ8, atol: envFloat32("BITNET_KV_Q8_LOGIT_ATOL", 2.5e-1), minOverlap: 0.6},
+	} {
+		t.Run(string(tc.kv), func(t *testing.T) {
+			got := generate(tc.kv)
+			if len(got.TopK) != len(ref.TopK) {
+				t.Fatalf("topk step mismatch: got=%d want=%d", len(got.TopK), len(ref.TopK))
+			}
+			for i := range ref.TopK {
+				g, w := got.TopK[i].Entries, ref.TopK[i].Entries
+				if len(g) == 0 || len(g) != len(w) {
+					t.Fatalf("topk entry count mismatch at step %d: got=%d want=%d", i, len(g), len(w))
+				}
+				if !closeLogit(g[0].Logit, w[0].Logit, tc.atol, 0) {
+					t.Fatalf("top-1 logit step=%d: %s=%f f32=%f atol=%f", i, tc.kv, g[0].Logit, w[0].Logit, tc.atol)
+				}
+				refLogits := topKEntriesAsMap(w)
+				shared := 0
+				for _, e := range g {
+					want, ok := refLogits[e.TokenID]
+					if !ok {
+						continue
+					}
+					shared++
+					if !closeLogit(e.Logit, want, tc.atol, 0) {
+						t.Fatalf("logit step=%d token=%d: %s=%f f32=%f atol=%f", i, e.TokenID, tc.kv, e.Logit, want, tc.atol)
+					}
+				}
+				if overlap := float64(shared) / float64(len(w)); overlap < tc.minOverlap {
+					t.Fatalf("topk overlap step=%d: %.2f < %.2f\n%s: %s\nf32: %s", i, overlap, tc.minOverlap, tc.kv, formatTopK(g), formatTopK(w))
+				}
+			}
+		})
+	}
+}
+
 func TestParityAgainstYarnVectors(t *testing.T) {
 	if os.Getenv("BITNET_ENFORCE_YARN") != "1" {
 		t.Skip("set BITNET_ENFORCE_YARN=1 to enforce YaRN parity vectors")
@@ -288,6 +384,20 @@ func TestParityAgainstYarnVectors(t *testing.T) {
 	}
 }
 
+func TestParityAgainstFalconVectors(t *testing.T) {
+	if os.Getenv("BITNET_ENFORCE_FALCON") != "1" {
+		t.Skip("set BITNET_ENFORCE_FALCON=1 to enforce Falcon parity vectors")
+	}
+	checkArchFamilyParity(t, "falcon")
+}
+
+func TestParityAgainstQwen2Vectors(t *testing.T) {
+	if os.Getenv("BITNET_ENFORCE_QWEN2") != "1" {
+		t.Skip("set BITNET_ENFORCE_QWEN2=1 to enforce Qwen2 parity vectors")
+	}
+	checkArchFamilyParity(t, "qwen2")
+}
+
 func TestParityAgainstI2SVectors(t *testing.T) {
 	if os.Getenv("BITNET_ENFORCE_I2S") != "1" {
 		t.Skip("set BITNET_ENFORCE_I2S=1 to enforce i2_s parity vectors")
@@ -788,6 +898,100 @@ func TestSeedDeterminismFixtures(t *testing.T) {
 	}
 }
 
+// checkArchFamilyParity compares generation against vectors frozen by
+// scripts/run_ref.sh --fixture <family>: expected.<family>.*.json,
+// <family>.prompt.txt and model_fixture_<family>.txt.
+func checkArchFamilyParity(t *testing.T, family string) {
+	t.Helper()
+
+	root := filepath.Join("..", "..", "testdata")
+
+	tokenBytes, err := os.ReadFile(filepath.Join(root, "expected."+family+".tokens.json"))
+	if err != nil {
+		t.Fatalf("read expected.%s.tokens.json: %v", family, err)
+	}
+	var want []int32
+	if err := json.Unmarshal(tokenBytes, &want); err != nil {
+		t.Fatalf("decode expected.%s.tokens.json: %v", family, err)
+	}
+	if len(want) == 0 {
+		t.Fatalf("expected.%s.tokens.json is empty; run scripts/run_ref.sh --fixture %s to freeze vectors", family, family)
+	}
+
+	promptBytes, err := os.ReadFile(filepath.Join(root, family+".prompt.txt"))
+	if err != nil {
+		t.Fatalf("read %s.prompt.txt: %v", family, err)
+	}
+	promptBytes = bytesTrimSpace(promptBytes)
+
+	modelFixture, err := os.ReadFile(filepath.Join(root, "model_fixture_"+family+".txt"))
+	if err != nil {
+		t.Fatalf("read model_fixture_%s.txt: %v", family, err)
+	}
+	modelPath := filepath.Join(root, string(bytesTrimSpace(modelFixture)))
+
+	session, err := LoadModel(context.Background(), modelPath)
+	if err != nil {
+		t.Fatalf("LoadModel() error = %v", err)
+	}
+
+	got, err := session.Generate(context.Background(), GenerateRequest{
+		Prompt:    string(promptBytes),
+		Seed:      1,
+		MaxTokens: len(want),
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if len(got.TokenIDs) != len(want) {
+		t.Fatalf("token length mismatch: got=%d want=%d", len(got.TokenIDs), len(want))
+	}
+	for i := range want {
+		if got.TokenIDs[i] != want[i] {
+			t.Fatalf("token mismatch at step %d: got=%d want=%d", i, got.TokenIDs[i], want[i])
+		}
+	}
+
+	topkBytes, err := os.ReadFile(filepath.Join(root, "expected."+family+".topk_logits.json"))
+	if err != nil {
+		t.Fatalf("read expected.%s.topk_logits.json: %v", family, err)
+	}
+	var wantTopK []topKStep
+	if err := json.Unmarshal(topkBytes, &wantTopK); err != nil {
+		t.Fatalf("decode expected.%s.topk_logits.json: %v", family, err)
+	}
+	if len(got.TopK) != len(wantTopK) {
+		t.Fatalf("topk step mismatch: got=%d want=%d", len(got.TopK), len(wantTopK))
+	}
+	atol := envFloat32("BITNET_PARITY_LOGIT_ATOL", 1e-3)
+	rtol := envFloat32("BITNET_PARITY_LOGIT_RTOL", 3e-2)
+	strictK := envInt("BITNET_PARITY_TOPK_STRICT", 1)
+	for i := range wantTopK {
+		if got.TopK[i].Step != wantTopK[i].Step {
+			t.Fatalf("topk step id mismatch at index %d: got=%d want=%d", i, got.TopK[i].Step, wantTopK[i].Step)
+		}
+		if len(got.TopK[i].Entries) != len(wantTopK[i].Entries) {
+			t.Fatalf("topk entry count mismatch at step %d: got=%d want=%d", i, len(got.TopK[i].Entries), len(wantTopK[i].Entries))
+		}
+		if strictK < 1 {
+			strictK = 1
+		}
+		if strictK > len(wantTopK[i].Entries) {
+			strictK = len(wantTopK[i].Entries)
+		}
+		for j := 0; j < strictK; j++ {
+			g := got.TopK[i].Entries[j]
+			w := wantTopK[i].Entries[j]
+			if g.TokenID != w.TokenID {
+				t.Fatalf("topk token mismatch step=%d rank=%d: got=%d want=%d", i, j, g.TokenID, w.TokenID)
+			}
+			if !closeLogit(g.Logit, w.Logit, atol, rtol) {
+				t.Fatalf("topk logit mismatch step=%d rank=%d: got=%f want=%f atol=%f rtol=%f", i, j, g.Logit, w.Logit, atol, rtol)
+			}
+		}
+	}
+}
+
 func topKEntriesAsMap(entries []TopKEntry) map[int32]float32 {
 	out := make(map[int32]float32, len(entries))
 	for _, e := range entries {
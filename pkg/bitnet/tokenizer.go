@@ -0,0 +1,188 @@
+package bitnet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bitnet-go/internal/gguf"
+	"bitnet-go/internal/tokenizer"
+)
+
+// ErrNoTokenizer is returned when a model has no usable tokenizer metadata.
+var ErrNoTokenizer = errors.New("bitnet: model has no tokenizer")
+
+// TokenizeOptions controls Tokenize. The zero value matches what Generate
+// does with a prompt.
+type TokenizeOptions struct {
+	// AddBOS overrides whether a BOS token is prepended; nil follows the
+	// model's tokenizer.ggml.add_bos_token.
+	AddBOS *bool
+	// AddEOS overrides whether an EOS token ([SEP] for WordPiece models) is
+	// appended; nil follows the model's tokenizer.ggml.add_eos_token. Nothing
+	// is appended when the vocabulary has no EOS.
+	AddEOS *bool
+	// ParseSpecial maps the text of control and user-defined tokens (e.g.
+	// "<|eot_id|>") to their IDs instead of tokenizing it as plain text.
+	ParseSpecial bool
+}
+
+// DetokenizeOptions controls Detokenize.
+type DetokenizeOptions struct {
+	// SkipSpecial drops control tokens such as BOS and EOS.
+	SkipSpecial bool
+	// TrimPrefixSpace drops the leading space SentencePiece vocabularies
+	// encode before the text; set it when decoding from the start of a
+	// tokenized prompt rather than a continuation.
+	TrimPrefixSpace bool
+}
+
+// Tokenizer is a model's vocabulary and encoder.
+type Tokenizer struct {
+	tok *tokenizer.Tokenizer
+}
+
+// LoadTokenizer reads only the tokenizer of a model, without loading
+// weights. path is a GGUF model, a HuggingFace tokenizer.json (".json") or
+// a SentencePiece model (".model"); all three tokenize identically for the
+// same model.
+func LoadTokenizer(path string) (*Tokenizer, error) {
+	info, err := readTokenizerInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := tokenizer.NewFromModelInfo(info)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoTokenizer, err)
+	}
+	return &Tokenizer{tok: tok}, nil
+}
+
+func readTokenizerInfo(path string) (gguf.ModelInfo, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" && ext != ".model" {
+		return gguf.ReadModelInfo(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return gguf.ModelInfo{}, err
+	}
+	var info gguf.ModelInfo
+	if ext == ".json" {
+		info, err = tokenizer.ModelInfoFromTokenizerJSON(data)
+	} else {
+		info, err = tokenizer.ModelInfoFromSentencePiece(data, "")
+	}
+	if err != nil {
+		return gguf.ModelInfo{}, fmt.Errorf("%w: %v", ErrNoTokenizer, err)
+	}
+	return info, nil
+}
+
+// Tokenizer returns the session's tokenizer.
+func (s *Session) Tokenizer() (*Tokenizer, error) {
+	tok := s.rt.Tokenizer()
+	if tok == nil {
+		return nil, ErrNoTokenizer
+	}
+	return &Tokenizer{tok: tok}, nil
+}
+
+// Tokenize encodes text with the session's tokenizer.
+func (s *Session) Tokenize(text string, opts TokenizeOptions) ([]int32, error) {
+	tok, err := s.Tokenizer()
+	if err != nil {
+		return nil, err
+	}
+	return tok.Tokenize(text, opts), nil
+}
+
+// Detokenize decodes ids with the session's tokenizer.
+func (s *Session) Detokenize(ids []int32, opts DetokenizeOptions) (string, error) {
+	tok, err := s.Tokenizer()
+	if err != nil {
+		return "", err
+	}
+	return tok.Detokenize(ids, opts), nil
+}
+
+// Tokenize encodes text.
+func (t *Tokenizer) Tokenize(text string, opts TokenizeOptions) []int32 {
+	return t.tok.Encode(text, t.encodeOptions(opts))
+}
+
+// TokenOffset is the half-open byte span [Start, End) of the input a token
+// covers.
+type TokenOffset = tokenizer.Offset
+
+// TokenizeWithOffsets is Tokenize that also returns the byte span of text
+// each token came from. BOS and inserted space prefixes get empty spans;
+// tokens for single bytes of a multi-byte character cover just that byte.
+func (t *Tokenizer) TokenizeWithOffsets(text string, opts TokenizeOptions) ([]int32, []TokenOffset) {
+	return t.tok.EncodeWithOffsets(text, t.encodeOptions(opts))
+}
+
+func (t *Tokenizer) encodeOptions(opts TokenizeOptions) tokenizer.EncodeOptions {
+	addBOS := t.tok.AddBOS()
+	if opts.AddBOS != nil {
+		addBOS = *opts.AddBOS
+	}
+	addEOS := t.tok.AddEOS()
+	if opts.AddEOS != nil {
+		addEOS = *opts.AddEOS
+	}
+	return tokenizer.EncodeOptions{AddBOS: addBOS, AddEOS: addEOS, ParseSpecial: opts.ParseSpecial}
+}
+
+// Detokenize decodes ids to text. IDs outside the vocabulary are skipped.
+func (t *Tokenizer) Detokenize(ids []int32, opts DetokenizeOptions) string {
+	return t.tok.DecodeWith(ids, decodeOptions(opts))
+}
+
+// StreamDecoder detokenizes one token at a time and only returns complete
+// UTF-8 text; call Flush at the end of the stream. See NewStreamDecoder.
+type StreamDecoder = tokenizer.StreamDecoder
+
+// NewStreamDecoder returns an incremental detokenizer for a new sequence,
+// for printing generated tokens as they arrive.
+func (t *Tokenizer) NewStreamDecoder(opts DetokenizeOptions) *StreamDecoder {
+	return t.tok.NewStreamDecoder(decodeOptions(opts))
+}
+
+func decodeOptions(opts DetokenizeOptions) tokenizer.DecodeOptions {
+	return tokenizer.DecodeOptions{SkipSpecial: opts.SkipSpecial, TrimPrefixSpace: opts.TrimPrefixSpace}
+}
+
+// VocabSize returns the number of tokens in the vocabulary.
+func (t *Tokenizer) VocabSize() int {
+	return t.tok.VocabSize()
+}
+
+// Piece returns the vocabulary string for id, as stored in the model (e.g.
+// with "▁" or byte-level BPE symbols, not decoded text).
+func (t *Tokenizer) Piece(id int32) (string, bool) {
+	return t.tok.Piece(id)
+}
+
+// ByteToken returns the token that encodes the single byte b, if the
+// vocabulary has one.
+func (t *Tokenizer) ByteToken(b byte) (int32, bool) {
+	return t.tok.ByteToken(b)
+}
+
+// IsSpecial reports whether id is a control token such as BOS or EOS.
+func (t *Tokenizer) IsSpecial(id int32) bool {
+	return t.tok.IsControl(id)
+}
+
+// BOS returns the beginning-of-sequence token ID.
+func (t *Tokenizer) BOS() int32 {
+	return t.tok.BOS()
+}
+
+// EOS returns the end-of-sequence token ID, or -1 if the model has none.
+func (t *Tokenizer) EOS() int32 {
+	return t.tok.EOS()
+}
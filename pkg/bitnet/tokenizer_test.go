@@ -0,0 +1,149 @@
+package bitnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTokenizerGGUF writes a tensor-less GGUF holding only a small llama
+// (SentencePiece) vocabulary.
+func writeTokenizerGGUF(t *testing.T) string {
+	t.Helper()
+	tokens := []string{"<unk>", "<s>", "</s>", "▁", "▁hi", "<|eot|>", "<0x41>", "hi"}
+	types := []int32{2, 3, 3, 1, 1, 3, 6, 1}
+	var buf bytes.Buffer
+	w := func(v any) {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	str := func(s string) {
+		w(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	buf.WriteString("GGUF")
+	w(uint32(3)) // version
+	w(uint64(0)) // tensors
+	w(uint64(6)) // kvs
+	str("tokenizer.ggml.model")
+	w(uint32(8))
+	str("llama")
+	str("tokenizer.ggml.tokens")
+	w(uint32(9))
+	w(uint32(8))
+	w(uint64(len(tokens)))
+	for _, tok := range tokens {
+		str(tok)
+	}
+	str("tokenizer.ggml.token_type")
+	w(uint32(9))
+	w(uint32(5))
+	w(uint64(len(types)))
+	w(types)
+	str("tokenizer.ggml.bos_token_id")
+	w(uint32(4))
+	w(uint32(1))
+	str("tokenizer.ggml.eos_token_id")
+	w(uint32(4))
+	w(uint32(2))
+	str("tokenizer.ggml.add_bos_token")
+	w(uint32(7))
+	w(true)
+	path := filepath.Join(t.TempDir(), "tok.gguf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTokenizer(t *testing.T) {
+	tok, err := LoadTokenizer(writeTokenizerGGUF(t))
+	if err != nil {
+		t.Fatalf("LoadTokenizer() error = %v", err)
+	}
+	if got := tok.Tokenize("hi<|eot|>", TokenizeOptions{ParseSpecial: true}); !equalTokens(got, []int32{1, 4, 5}) {
+		t.Fatalf("Tokenize() = %v, want [1 4 5]", got)
+	}
+	noBOS := false
+	if got := tok.Tokenize("hi", TokenizeOptions{AddBOS: &noBOS}); !equalTokens(got, []int32{4}) {
+		t.Fatalf("Tokenize(no BOS) = %v, want [4]", got)
+	}
+	if got := tok.Detokenize([]int32{1, 4, 5, 2}, DetokenizeOptions{SkipSpecial: true}); got != " hi" {
+		t.Fatalf("Detokenize(skip special) = %q, want %q", got, " hi")
+	}
+	if got := tok.Detokenize([]int32{4, 5}, DetokenizeOptions{}); got != " hi<|eot|>" {
+		t.Fatalf("Detokenize() = %q", got)
+	}
+	if tok.VocabSize() != 8 || tok.BOS() != 1 || tok.EOS() != 2 || !tok.IsSpecial(5) || tok.IsSpecial(4) {
+		t.Fatal("unexpected vocabulary accessors")
+	}
+	if piece, ok := tok.Piece(4); !ok || piece != "▁hi" {
+		t.Fatalf("Piece(4) = %q, %v", piece, ok)
+	}
+	if id, ok := tok.ByteToken('A'); !ok || id != 6 {
+		t.Fatalf("ByteToken('A') = %d, %v", id, ok)
+	}
+	d := tok.NewStreamDecoder(DetokenizeOptions{SkipSpecial: true, TrimPrefixSpace: true})
+	if got := d.Add(1) + d.Add(4) + d.Add(6) + d.Flush(); got != "hiA" {
+		t.Fatalf("StreamDecoder = %q, want %q", got, "hiA")
+	}
+	ids, offs := tok.TokenizeWithOffsets("hi<|eot|>", TokenizeOptions{ParseSpecial: true})
+	want := []TokenOffset{{Start: 0, End: 0}, {Start: 0, End: 2}, {Start: 2, End: 9}}
+	if !equalTokens(ids, []int32{1, 4, 5}) || len(offs) != len(want) || offs[0] != want[0] || offs[1] != want[1] || offs[2] != want[2] {
+		t.Fatalf("TokenizeWithOffsets() = %v %v, want [1 4 5] %v", ids, offs, want)
+	}
+}
+
+func equalTokens(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadTokenizerFromTokenizerJSON(t *testing.T) {
+	// The vocabulary of writeTokenizerGGUF as HuggingFace saves it.
+	js := `{
+  "added_tokens": [
+    {"id": 0, "content": "<unk>", "special": true},
+    {"id": 1, "content": "<s>", "special": true},
+    {"id": 2, "content": "</s>", "special": true},
+    {"id": 5, "content": "<|eot|>", "special": true}
+  ],
+  "post_processor": {"type": "TemplateProcessing",
+    "single": [{"SpecialToken": {"id": "<s>", "type_id": 0}}, {"Sequence": {"id": "A", "type_id": 0}}],
+    "special_tokens": {"<s>": {"id": "<s>", "ids": [1], "tokens": ["<s>"]}}},
+  "model": {"type": "BPE", "byte_fallback": true, "unk_token": "<unk>",
+    "vocab": {"<unk>": 0, "<s>": 1, "</s>": 2, "▁": 3, "▁hi": 4, "<0x41>": 6, "hi": 7},
+    "merges": ["h i", "▁ hi"]}
+}`
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	if err := os.WriteFile(path, []byte(js), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := LoadTokenizer(path)
+	if err != nil {
+		t.Fatalf("LoadTokenizer(tokenizer.json) error = %v", err)
+	}
+	fromGGUF, err := LoadTokenizer(writeTokenizerGGUF(t))
+	if err != nil {
+		t.Fatalf("LoadTokenizer(gguf) error = %v", err)
+	}
+	for _, text := range []string{"hi", "hi hi<|eot|>", "A hi"} {
+		opts := TokenizeOptions{ParseSpecial: true}
+		if got, want := fromJSON.Tokenize(text, opts), fromGGUF.Tokenize(text, opts); !equalTokens(got, want) {
+			t.Fatalf("Tokenize(%q) = %v from tokenizer.json, %v from GGUF", text, got, want)
+		}
+	}
+	if fromJSON.EOS() != fromGGUF.EOS() {
+		t.Fatalf("EOS() = %d from tokenizer.json, %d from GGUF", fromJSON.EOS(), fromGGUF.EOS())
+	}
+}
@@ -28,6 +28,30 @@ copy_fixture() {
     echo "Copied: $dst"
 }
 
+# copy_optional_fixture copies a vocab and its .inp/.out test corpus when the
+# source has them. llama.cpp ships some; generate the rest (olmo, stablelm2,
+# jais, tekken, chatglm-bpe, gpt-4o, ...) with its convert_hf_to_gguf_update.py
+# and point BITNET_REF_MODELS_DIR at the result.
+copy_optional_fixture() {
+    name=$1
+    src_dir="${BITNET_REF_MODELS_DIR:-$REF_SRC/3rdparty/llama.cpp/models}"
+    if [ ! -f "$src_dir/$name" ]; then
+        echo "Skipping optional fixture: $src_dir/$name" >&2
+        return 0
+    fi
+    for ext in "" .inp .out; do
+        if [ -f "$src_dir/$name$ext" ]; then
+            cp "$src_dir/$name$ext" "$TESTDATA_DIR/$name$ext"
+            echo "Copied: $TESTDATA_DIR/$name$ext"
+        fi
+    done
+}
+
 copy_fixture "ggml-vocab-gpt-2.gguf" "ggml-vocab-gpt-2.gguf"
 copy_fixture "ggml-vocab-falcon.gguf" "ggml-vocab-falcon.gguf"
 copy_fixture "ggml-vocab-qwen2.gguf" "ggml-vocab-qwen2.gguf"
+
+for pre in gpt-2 falcon qwen2 llama-bpe mpt deepseek-llm deepseek-coder starcoder refact command-r \
+    smollm olmo stablelm2 jais tekken chatglm-bpe gpt-4o; do
+    copy_optional_fixture "ggml-vocab-$pre.gguf"
+done
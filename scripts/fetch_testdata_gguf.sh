@@ -79,6 +79,22 @@ fetch_url "${BITNET_FALCON_VOCAB_URL:-https://huggingface.co/spaces/Steven10429/
     "$TESTDATA_DIR/ggml-vocab-falcon.gguf" 1000000 "${BITNET_FALCON_VOCAB_SHA256:-}"
 fetch_url "${BITNET_QWEN2_VOCAB_URL:-https://huggingface.co/spaces/Steven10429/apply_lora_and_quantize/resolve/main/llama.cpp/models/ggml-vocab-qwen2.gguf}" \
     "$TESTDATA_DIR/ggml-vocab-qwen2.gguf" 1000000 "${BITNET_QWEN2_VOCAB_SHA256:-}"
+fetch_url "${BITNET_LLAMA_SPM_VOCAB_URL:-https://huggingface.co/spaces/Steven10429/apply_lora_and_quantize/resolve/main/llama.cpp/models/ggml-vocab-llama-spm.gguf}" \
+    "$TESTDATA_DIR/ggml-vocab-llama-spm.gguf" 400000 "${BITNET_LLAMA_SPM_VOCAB_SHA256:-}"
+
+# The HF tokenizer.json / SentencePiece .model each vocab GGUF was converted
+# from, compared against it by TestTokenizerLoadersMatchGGUFFixtures. Set
+# BITNET_SKIP_TOKENIZER_SOURCES=1 to leave them out.
+if [ "${BITNET_SKIP_TOKENIZER_SOURCES:-0}" != "1" ]; then
+    fetch_url "${BITNET_GPT2_TOKENIZER_URL:-https://huggingface.co/openai-community/gpt2/resolve/main/tokenizer.json}" \
+        "$TESTDATA_DIR/tokenizer.gpt-2.json" 400000 "${BITNET_GPT2_TOKENIZER_SHA256:-}"
+    fetch_url "${BITNET_FALCON_TOKENIZER_URL:-https://huggingface.co/tiiuae/falcon-7b/resolve/main/tokenizer.json}" \
+        "$TESTDATA_DIR/tokenizer.falcon.json" 400000 "${BITNET_FALCON_TOKENIZER_SHA256:-}"
+    fetch_url "${BITNET_QWEN2_TOKENIZER_URL:-https://huggingface.co/Qwen/Qwen1.5-7B/resolve/main/tokenizer.json}" \
+        "$TESTDATA_DIR/tokenizer.qwen2.json" 400000 "${BITNET_QWEN2_TOKENIZER_SHA256:-}"
+    fetch_url "${BITNET_LLAMA_SPM_TOKENIZER_URL:-https://huggingface.co/hf-internal-testing/llama-tokenizer/resolve/main/tokenizer.model}" \
+        "$TESTDATA_DIR/tokenizer.llama-spm.model" 400000 "${BITNET_LLAMA_SPM_TOKENIZER_SHA256:-}"
+fi
 
 # Optional YaRN model for parity. Set BITNET_FETCH_YARN=1 to enable.
 if [ "${BITNET_FETCH_YARN:-0}" = "1" ]; then
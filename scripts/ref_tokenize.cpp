@@ -60,6 +60,27 @@ int main() {
         std::printf("PROMPT_TOKEN idx=%d id=%d\n", i, static_cast<int>(tokens[static_cast<size_t>(i)]));
     }
 
+    // Round-trip the tokens through llama_detokenize with its defaults
+    // (special tokens kept in the sequence but not rendered). The text is
+    // hex-encoded so newlines and invalid UTF-8 survive the trace format.
+    std::vector<char> text(static_cast<size_t>(prompt.size()) * 4 + 64);
+    int m = llama_detokenize(model, tokens.data(), n, text.data(), static_cast<int32_t>(text.size()), false, false);
+    if (m < 0) {
+        text.resize(static_cast<size_t>(-m));
+        m = llama_detokenize(model, tokens.data(), n, text.data(), static_cast<int32_t>(text.size()), false, false);
+    }
+    if (m < 0) {
+        std::fprintf(stderr, "detokenize failed\n");
+        llama_free_model(model);
+        llama_backend_free();
+        return 1;
+    }
+    std::printf("DECODED_TEXT hex=");
+    for (int i = 0; i < m; ++i) {
+        std::printf("%02x", static_cast<unsigned char>(text[static_cast<size_t>(i)]));
+    }
+    std::printf("\n");
+
     llama_free_model(model);
     llama_backend_free();
     return 0;
@@ -144,6 +144,18 @@ bool matches_layered_tensor(const char * name, const char * base) {
     return has_numeric_suffix(name + n + 1);
 }
 
+// rope_label turns "Qcur-3" into "Qcur_rope-3". llama.cpp names the rotated
+// Q/K after the projections they come from, so without a label the rotated
+// tensors would be dropped as repeats.
+std::string rope_label(const char * name) {
+    std::string s = name;
+    const size_t dash = s.rfind('-');
+    if (dash == std::string::npos) {
+        return s + "_rope";
+    }
+    return s.substr(0, dash) + "_rope" + s.substr(dash);
+}
+
 bool name_matches(const char * name) {
     if (name == nullptr || name[0] == '\0') {
         return false;
@@ -247,6 +259,8 @@ bool name_values_matches(const char * name) {
         "Qcur",
         "Kcur",
         "Vcur",
+        "Qcur_rope",
+        "Kcur_rope",
         "ffn_inp",
         "ffn_norm",
         "ffn_gate",
@@ -330,10 +344,11 @@ bool eval_callback(struct ggml_tensor * t, bool ask, void * user_data) {
     if (state->target_pos >= 0 && state->current_pos != state->target_pos) {
         return true;
     }
-    if (!state->seen.insert(name).second) {
+    const std::string label = t->op == GGML_OP_ROPE ? rope_label(name) : std::string(name);
+    if (!state->seen.insert(label).second) {
         return true;
     }
-    print_tensor_stats(name, t);
+    print_tensor_stats(label.c_str(), t);
     if (state->i2s_dot && !state->i2s_dot_done && std::strcmp(name, "ffn_norm-0") == 0) {
         if (t->type == GGML_TYPE_F32 && t->ne[0] > 0 && state->model != nullptr) {
             ggml_tensor * w = llama_get_model_tensor(state->model, state->i2s_dot_tensor.c_str());
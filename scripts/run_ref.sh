@@ -1,14 +1,64 @@
 #!/bin/sh
 set -eu
 
+# Freezes reference vectors for a parity fixture.
+#
+#   run_ref.sh [--fixture <name>] [--model <gguf>]
+#
+# Without --fixture it reads testdata/model_fixture.txt and prompt.txt and
+# writes testdata/expected.*.json. --fixture falcon reads
+# model_fixture_falcon.txt and falcon.prompt.txt and writes
+# expected.falcon.*.json instead. --model (or BITNET_REF_MODEL) overrides the
+# model named by the fixture file.
+
+usage() {
+    echo "usage: $0 [--fixture <name>] [--model <gguf>]" >&2
+}
+
+FIXTURE=""
+MODEL_ARG=""
+while [ $# -gt 0 ]; do
+    case "$1" in
+        --fixture|--model)
+            if [ $# -lt 2 ]; then
+                usage
+                exit 2
+            fi
+            if [ "$1" = "--fixture" ]; then
+                FIXTURE=$2
+            else
+                MODEL_ARG=$2
+            fi
+            shift 2
+            ;;
+        -h|--help)
+            usage
+            exit 0
+            ;;
+        *)
+            usage
+            exit 2
+            ;;
+    esac
+done
+
 ROOT_DIR=$(CDPATH= cd -- "$(dirname -- "$0")/.." && pwd)
 REF_DIR="$ROOT_DIR/.ref"
 TESTDATA_DIR="$ROOT_DIR/testdata"
-TRACE_FILE="$REF_DIR/reference.trace"
-TOKENS_OUT="$TESTDATA_DIR/expected.tokens.json"
-TOPK_OUT="$TESTDATA_DIR/expected.topk_logits.json"
-TIMING_OUT="$TESTDATA_DIR/expected.timings.json"
-PROMPT_TOKENS_OUT="$TESTDATA_DIR/expected.prompt_tokens.json"
+if [ "$FIXTURE" = "" ]; then
+    SUFFIX=""
+    FIXTURE_FILE="$TESTDATA_DIR/model_fixture.txt"
+    DEFAULT_PROMPT_FILE="$TESTDATA_DIR/prompt.txt"
+else
+    SUFFIX=".$FIXTURE"
+    FIXTURE_FILE="$TESTDATA_DIR/model_fixture_$FIXTURE.txt"
+    DEFAULT_PROMPT_FILE="$TESTDATA_DIR/$FIXTURE.prompt.txt"
+fi
+TRACE_FILE="$REF_DIR/reference$SUFFIX.trace"
+TOKENS_OUT="$TESTDATA_DIR/expected$SUFFIX.tokens.json"
+TOPK_OUT="$TESTDATA_DIR/expected$SUFFIX.topk_logits.json"
+TIMING_OUT="$TESTDATA_DIR/expected$SUFFIX.timings.json"
+PROMPT_TOKENS_OUT="$TESTDATA_DIR/expected$SUFFIX.prompt_tokens.json"
 
 if [ "${BITNET_SKIP_BUILD:-0}" != "1" ]; then
     "$ROOT_DIR/scripts/build_ref.sh"
@@ -24,10 +74,10 @@ fi
 
 mkdir -p "$REF_DIR" "$TESTDATA_DIR"
 
-MODEL_PATH=${BITNET_REF_MODEL:-}
+MODEL_PATH=${MODEL_ARG:-${BITNET_REF_MODEL:-}}
 if [ "$MODEL_PATH" = "" ]; then
-    if [ -f "$TESTDATA_DIR/model_fixture.txt" ]; then
-        fixture=$(sed -n '1p' "$TESTDATA_DIR/model_fixture.txt")
+    if [ -f "$FIXTURE_FILE" ]; then
+        fixture=$(sed -n '1p' "$FIXTURE_FILE")
         case "$fixture" in
             "") ;;
             /*) MODEL_PATH=$fixture ;;
@@ -37,7 +87,7 @@ if [ "$MODEL_PATH" = "" ]; then
 fi
 
 if [ "$MODEL_PATH" = "" ]; then
-    echo "No model specified. Set BITNET_REF_MODEL or testdata/model_fixture.txt." >&2
+    echo "No model specified. Pass --model, set BITNET_REF_MODEL or write $FIXTURE_FILE." >&2
     exit 1
 fi
 if [ ! -f "$MODEL_PATH" ]; then
@@ -57,7 +107,7 @@ EOM
     exit 1
 fi
 
-PROMPT_FILE=${BITNET_REF_PROMPT_FILE:-$TESTDATA_DIR/prompt.txt}
+PROMPT_FILE=${BITNET_REF_PROMPT_FILE:-$DEFAULT_PROMPT_FILE}
 if [ ! -f "$PROMPT_FILE" ]; then
     echo "Prompt file not found: $PROMPT_FILE" >&2
     exit 1
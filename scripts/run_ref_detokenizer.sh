@@ -0,0 +1,66 @@
+#!/bin/sh
+set -eu
+
+ROOT_DIR=$(CDPATH= cd -- "$(dirname -- "$0")/.." && pwd)
+TESTDATA_DIR="$ROOT_DIR/testdata"
+REF_DIR="$ROOT_DIR/.ref"
+CORPUS_FILE="$TESTDATA_DIR/decode_corpus.txt"
+
+"$ROOT_DIR/scripts/fetch_bpe_vocab_fixtures.sh"
+if [ "${BITNET_SKIP_TOKENIZER_BUILD:-0}" != "1" ]; then
+    "$ROOT_DIR/scripts/build_ref_tokenizer.sh" >/dev/null
+fi
+
+# run_case tokenizes every corpus line with the reference tokenizer and
+# records the IDs plus llama_detokenize's rendering of them, one JSON object
+# per line: {"ids":[...],"text_hex":"..."}.
+run_case() {
+    model_file=$1
+    out_file=$2
+    trace_file=$3
+
+    export BITNET_REF_MODEL="$model_file"
+    : > "$out_file"
+    while IFS= read -r line || [ -n "$line" ]; do
+        export BITNET_REF_PROMPT="$line"
+        "$REF_DIR/bin/ref-tokenize" > "$trace_file"
+        awk '
+BEGIN { n = 0; hex = "" }
+$1 == "PROMPT_TOKEN" {
+    for (i = 1; i <= NF; i++) {
+        if ($i ~ /^id=/) {
+            split($i, a, "=")
+            ids[n++] = a[2]
+        }
+    }
+}
+$1 == "DECODED_TEXT" {
+    split($2, a, "=")
+    hex = a[2]
+}
+END {
+    printf "{\"ids\":["
+    for (i = 0; i < n; i++) {
+        if (i > 0) printf ","
+        printf "%s", ids[i]
+    }
+    printf "],\"text_hex\":\"%s\"}\n", hex
+}
+' "$trace_file" >> "$out_file"
+    done < "$CORPUS_FILE"
+
+    echo "Wrote: $out_file"
+}
+
+run_case "$TESTDATA_DIR/ggml-vocab-gpt-2.gguf" "$TESTDATA_DIR/expected.gpt2_decode.jsonl" "$REF_DIR/detokenizer.gpt2.trace"
+run_case "$TESTDATA_DIR/ggml-vocab-falcon.gguf" "$TESTDATA_DIR/expected.falcon_decode.jsonl" "$REF_DIR/detokenizer.falcon.trace"
+run_case "$TESTDATA_DIR/ggml-vocab-qwen2.gguf" "$TESTDATA_DIR/expected.qwen2_decode.jsonl" "$REF_DIR/detokenizer.qwen2.trace"
+
+if [ -f "$TESTDATA_DIR/model_fixture.txt" ]; then
+    model=$(tr -d '\r\n' < "$TESTDATA_DIR/model_fixture.txt")
+    if [ -n "$model" ] && [ -f "$TESTDATA_DIR/$model" ]; then
+        run_case "$TESTDATA_DIR/$model" "$TESTDATA_DIR/expected.decode.jsonl" "$REF_DIR/detokenizer.trace"
+    else
+        echo "Skipping model fixture decode vectors (model missing: $TESTDATA_DIR/$model)" >&2
+    fi
+fi